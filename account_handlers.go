@@ -0,0 +1,238 @@
+package main
+
+import (
+	"dating-app/models"
+	"dating-app/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// @Summary Appeal suspension or ban
+// @Description Files an appeal against the user's suspension or ban for the moderators to review. Restricted users can't get a token, so they sign the appeal with their username and password. A user can have one appeal pending at a time.
+// @Tags Account
+// @Accept  json
+// @Produce  json
+// @Param username body string true "Username"
+// @Param password body string true "Password"
+// @Param message body string true "Why the account should be restored, up to 2000 characters"
+// @Success 201 {object} models.Appeal
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /appeals [post]
+func AppealHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Message  string `json:"message"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	if request.Username == "" || request.Password == "" {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, err := userService.ValidateUser(request.Username, request.Password)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid username or password"}`, http.StatusUnauthorized)
+		return
+	}
+
+	appeal, err := accountService.Appeal(user.ID, request.Message, time.Now())
+	switch {
+	case errors.Is(err, service.ErrInvalidAppeal):
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrAccountActive):
+		http.Error(w, `{"error": "Account is not suspended or banned"}`, http.StatusConflict)
+		return
+	case errors.Is(err, service.ErrAppealPending):
+		http.Error(w, `{"error": "An appeal is already awaiting review"}`, http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(appeal)
+}
+
+// @Summary Appeal queue
+// @Description Returns appeals with the given status, oldest first. Pending appeals are the moderators' queue.
+// @Tags Moderation
+// @Produce  json
+// @Param Authorization header string true "Bearer token of a moderator"
+// @Param status query string false "pending (default), upheld or lifted"
+// @Param limit query int false "Number of appeals to return (default 20, max 50)"
+// @Success 200 {object} map[string][]models.Appeal
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/appeals [get]
+func ListAppealsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = models.AppealStatusPending
+	case models.AppealStatusPending, models.AppealStatusUpheld, models.AppealStatusLifted:
+	default:
+		http.Error(w, `{"error": "status must be pending, upheld or lifted"}`, http.StatusBadRequest)
+		return
+	}
+	limit, ok := pageSize(w, r)
+	if !ok {
+		return
+	}
+
+	appeals, err := accountService.ListAppeals(status, limit)
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]models.Appeal{"appeals": appeals})
+}
+
+// @Summary Decide appeal
+// @Description Decides a pending appeal. lift makes the account active again, unless the restriction changed or ended since the appeal was filed; uphold keeps the restriction. The response is sent to the user and the decision is written to the audit log.
+// @Tags Moderation
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token of a moderator"
+// @Param Idempotency-Key header string false "Client-generated key that makes retries safe"
+// @Param id path string true "Appeal ID"
+// @Param decision body string true "lift or uphold"
+// @Param response body string true "Explanation for the user"
+// @Success 200 {object} models.Appeal
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/appeals/{id}/decision [post]
+func DecideAppealHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Decision string `json:"decision"`
+		Response string `json:"response"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+	if (request.Decision != models.AppealDecisionLift && request.Decision != models.AppealDecisionUphold) || request.Response == "" {
+		http.Error(w, `{"error": "decision must be lift or uphold, with a response"}`, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	appeal, err := accountService.DecideAppeal(mux.Vars(r)["id"], adminActor(r), request.Decision, request.Response, now)
+	if errors.Is(err, service.ErrAppealNotFound) {
+		http.Error(w, `{"error": "Appeal not found"}`, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, service.ErrAppealDecided) {
+		http.Error(w, `{"error": "Appeal has already been decided"}`, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, service.ErrAppealOutdated) {
+		http.Error(w, `{"error": "The appealed restriction is no longer in place"}`, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	title := "Your appeal was reviewed"
+	if appeal.Status == models.AppealStatusLifted {
+		title = "Your account has been restored"
+	}
+
+	err = notificationService.Notify(appeal.UserID, models.Notification{
+		Type:  models.NotificationAccount,
+		Title: title,
+		Body:  request.Response,
+		Data:  map[string]string{"appealID": appeal.ID, "status": appeal.Status},
+	}, now)
+	if err != nil {
+		log.Printf("failed to notify %s of appeal %s: %v", appeal.UserID, appeal.ID, err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(appeal)
+}
+
+// @Summary Delete account
+// @Description Schedules the user's account for deletion after a 14-day cooling-off period, during which the user is hidden from others and can cancel with DELETE /me/deletion. The account is then erased: profile, photos, swipes, matches, messages and everything else tied to the user are deleted, while purchases are kept for accounting without the link to the user. Asking again keeps the original date.
+// @Tags Account
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 202 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /me/deletion [post]
+func ScheduleDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+	now := time.Now()
+	deleteAt, err := userService.ScheduleDeletion(userID, now.Add(service.DeletionCoolingOff))
+	if errors.Is(err, service.ErrUserNotFound) {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Tell the user on every device, in case it wasn't them
+	err = notificationService.Notify(userID, models.Notification{
+		Type:  models.NotificationAccount,
+		Title: "Your account will be deleted",
+		Body:  "Your account and data will be deleted on " + deleteAt.UTC().Format("January 2, 2006") + ". Log in and cancel before then to keep them.",
+	}, now)
+	if err != nil {
+		log.Printf("failed to notify %s of their account deletion: %v", userID, err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"deleteAt": deleteAt.UTC().Format(time.RFC3339)})
+}
+
+// @Summary Cancel account deletion
+// @Description Cancels a scheduled account deletion during its cooling-off period
+// @Tags Account
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /me/deletion [delete]
+func CancelDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	err := userService.CancelDeletion(authenticatedUserID(r))
+	if errors.Is(err, service.ErrDeletionNotScheduled) {
+		http.Error(w, `{"error": "No deletion scheduled"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Account deletion cancelled"})
+}
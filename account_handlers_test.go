@@ -0,0 +1,561 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"dating-app/models"
+	"dating-app/service"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+)
+
+func init() {
+	// Handler tests run as active users; TestAuthenticate covers the checks
+	accountService = activeAccounts{}
+}
+
+// activeAccounts is an AccountService on which every account is active
+type activeAccounts struct {
+	service.AccountService
+}
+
+func (activeAccounts) GetAccount(userID string) (models.Account, error) {
+	return models.Account{Status: models.AccountStatusActive}, nil
+}
+
+type MockAccountService struct {
+	mock.Mock
+}
+
+func (m *MockAccountService) GetAccount(userID string) (models.Account, error) {
+	args := m.Called(userID)
+	return args.Get(0).(models.Account), args.Error(1)
+}
+
+func (m *MockAccountService) Appeal(userID, message string, now time.Time) (models.Appeal, error) {
+	args := m.Called(userID, message, now)
+	return args.Get(0).(models.Appeal), args.Error(1)
+}
+
+func (m *MockAccountService) ListAppeals(status string, limit int) ([]models.Appeal, error) {
+	args := m.Called(status, limit)
+	return args.Get(0).([]models.Appeal), args.Error(1)
+}
+
+func (m *MockAccountService) DecideAppeal(appealID, moderator, decision, response string, now time.Time) (models.Appeal, error) {
+	args := m.Called(appealID, moderator, decision, response, now)
+	return args.Get(0).(models.Appeal), args.Error(1)
+}
+
+// useAccountService swaps in accounts for the rest of the test
+func useAccountService(t *testing.T, accounts service.AccountService) {
+	accountService = accounts
+	t.Cleanup(func() { accountService = activeAccounts{} })
+}
+
+func TestAuthenticate(t *testing.T) {
+	later := time.Now().Add(time.Hour)
+	earlier := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name           string
+		account        models.Account
+		accountErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "Active account",
+			account:        models.Account{Status: models.AccountStatusActive},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Banned account",
+			account:        models.Account{Status: models.AccountStatusBanned, TokenVersion: 1},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Suspended account",
+			account:        models.Account{Status: models.AccountStatusSuspended, SuspendedUntil: &later, TokenVersion: 1},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Suspension is over",
+			account:        models.Account{Status: models.AccountStatusSuspended, SuspendedUntil: &earlier},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Token issued before a sign-out",
+			account:        models.Account{Status: models.AccountStatusActive, TokenVersion: 1},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Deleted user",
+			accountErr:     service.ErrUserNotFound,
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAccountService := new(MockAccountService)
+			useAccountService(t, mockAccountService)
+			mockAccountService.On("GetAccount", "user1").Return(tt.account, tt.accountErr)
+
+			handler := authenticate(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, authorizedRequest(t, "GET", "/matches", "user1", nil))
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockAccountService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAppealHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		validateErr    error
+		appealErr      error
+		expectedStatus int
+	}{
+		{
+			name:           "Appeal filed",
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Wrong password",
+			validateErr:    errors.New("invalid password"),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Account in good standing",
+			appealErr:      service.ErrAccountActive,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Appeal already pending",
+			appealErr:      service.ErrAppealPending,
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserService := new(MockUserService)
+			userService = mockUserService
+			mockAccountService := new(MockAccountService)
+			useAccountService(t, mockAccountService)
+
+			mockUserService.On("ValidateUser", "banneduser", "secret").Return(models.User{ID: "user1"}, tt.validateErr)
+			if tt.validateErr == nil {
+				appeal := models.Appeal{ID: "a1", UserID: "user1", AccountStatus: models.AccountStatusBanned, Status: models.AppealStatusPending}
+				mockAccountService.On("Appeal", "user1", "It was a misunderstanding", mock.AnythingOfType("time.Time")).Return(appeal, tt.appealErr)
+			}
+
+			body, _ := json.Marshal(map[string]string{"username": "banneduser", "password": "secret", "message": "It was a misunderstanding"})
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(AppealHandler)
+			handler.ServeHTTP(rr, httptest.NewRequest("POST", "/appeals", bytes.NewReader(body)))
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockUserService.AssertExpectations(t)
+			mockAccountService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDecideAppealHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		request        map[string]string
+		appeal         models.Appeal
+		serviceErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "Lifting restores the account",
+			request:        map[string]string{"decision": "lift", "response": "Sorry, our mistake"},
+			appeal:         models.Appeal{ID: "a1", UserID: "user2", Status: models.AppealStatusLifted},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Upholding keeps the ban",
+			request:        map[string]string{"decision": "uphold", "response": "The ban stands"},
+			appeal:         models.Appeal{ID: "a1", UserID: "user2", Status: models.AppealStatusUpheld},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Decision needs a response",
+			request:        map[string]string{"decision": "lift"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Already decided",
+			request:        map[string]string{"decision": "lift", "response": "Sorry"},
+			serviceErr:     service.ErrAppealDecided,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Restriction changed since the appeal",
+			request:        map[string]string{"decision": "lift", "response": "Sorry"},
+			serviceErr:     service.ErrAppealOutdated,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Decision that can't be audited isn't taken",
+			request:        map[string]string{"decision": "lift", "response": "Sorry"},
+			serviceErr:     errors.New("audit_log: connection reset"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAccountService := new(MockAccountService)
+			mockNotificationService := new(MockNotificationService)
+			notificationService = mockNotificationService
+
+			if tt.expectedStatus != http.StatusBadRequest {
+				mockAccountService.On("DecideAppeal", "a1", "admin:staff1", tt.request["decision"], tt.request["response"], mock.AnythingOfType("time.Time")).Return(tt.appeal, tt.serviceErr)
+			}
+			if tt.expectedStatus == http.StatusOK {
+				mockNotificationService.On("Notify", "user2", mock.MatchedBy(func(notification models.Notification) bool {
+					return notification.Type == models.NotificationAccount && notification.Data["status"] == tt.appeal.Status
+				}), mock.AnythingOfType("time.Time")).Return(nil)
+			}
+
+			body, _ := json.Marshal(tt.request)
+			req := staffRequest(t, "POST", "/admin/appeals/a1/decision", "staff1", []string{models.RoleModerator}, body)
+			req = mux.SetURLVars(req, map[string]string{"id": "a1"})
+			rr := httptest.NewRecorder()
+			// The staff member's own account check passes before the mock is in place
+			handler := authenticate(func(w http.ResponseWriter, r *http.Request) {
+				useAccountService(t, mockAccountService)
+				DecideAppealHandler(w, r)
+			})
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockAccountService.AssertExpectations(t)
+			mockNotificationService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestScheduleDeletionHandler(t *testing.T) {
+	earlier := time.Date(2025, 2, 10, 12, 0, 0, 0, time.UTC)
+
+	mockUserService := new(MockUserService)
+	userService = mockUserService
+	mockNotificationService := new(MockNotificationService)
+	notificationService = mockNotificationService
+
+	// Asking again keeps the date of the first request
+	mockUserService.On("ScheduleDeletion", "user1", mock.AnythingOfType("time.Time")).Return(earlier, nil)
+	mockNotificationService.On("Notify", "user1", mock.MatchedBy(func(notification models.Notification) bool {
+		return notification.Type == models.NotificationAccount && strings.Contains(notification.Body, "February 10, 2025")
+	}), mock.AnythingOfType("time.Time")).Return(nil)
+
+	rr := httptest.NewRecorder()
+	handler := authenticate(ScheduleDeletionHandler)
+	handler.ServeHTTP(rr, authorizedRequest(t, "POST", "/me/deletion", "user1", nil))
+
+	if status := rr.Code; status != http.StatusAccepted {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusAccepted)
+	}
+	var response map[string]string
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response["deleteAt"] != "2025-02-10T12:00:00Z" {
+		t.Errorf("handler returned unexpected deleteAt: %q", response["deleteAt"])
+	}
+
+	mockUserService.AssertExpectations(t)
+	mockNotificationService.AssertExpectations(t)
+}
+
+func TestCancelDeletionHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		serviceErr     error
+		expectedStatus int
+	}{
+		{name: "Deletion cancelled", expectedStatus: http.StatusOK},
+		{name: "Nothing scheduled", serviceErr: service.ErrDeletionNotScheduled, expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserService := new(MockUserService)
+			userService = mockUserService
+			mockUserService.On("CancelDeletion", "user1").Return(tt.serviceErr)
+
+			rr := httptest.NewRecorder()
+			handler := authenticate(CancelDeletionHandler)
+			handler.ServeHTTP(rr, authorizedRequest(t, "DELETE", "/me/deletion", "user1", nil))
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockUserService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAccountDeletionWorker(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	blobs := &service.LocalBlobStore{Dir: t.TempDir()}
+	photoKey := service.PhotoBlobKey("photos/p1", models.PhotoSizeLarge)
+	for _, key := range []string{photoKey, "verifications/s1.jpg"} {
+		if err := blobs.Put(key, "image/jpeg", strings.NewReader("jpeg")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	memoryBroker := service.NewMemoryBroker()
+	events, unsubscribe := memoryBroker.Subscribe("user1")
+	defer unsubscribe()
+
+	mockUserService := new(MockUserService)
+	mockAuditService := new(MockAuditService)
+	mockUserService.On("DueDeletions", now, mock.AnythingOfType("int")).Return([]string{"user1", "user2"}, nil)
+	mockUserService.On("Delete", "user1", now).Return([]string{photoKey, "verifications/s1.jpg"}, nil)
+	// user2 cancelled after being listed
+	mockUserService.On("Delete", "user2", now).Return(nil, service.ErrDeletionNotScheduled)
+	mockAuditService.On("Record", mock.MatchedBy(func(entry models.AuditEntry) bool {
+		return entry.Action == models.AuditActionUserDeleted && entry.TargetID == "user1"
+	})).Return(nil).Once()
+
+	worker := &service.AccountDeletionWorker{
+		Users:    mockUserService,
+		Blobs:    blobs,
+		Audit:    mockAuditService,
+		Broker:   memoryBroker,
+		Interval: time.Hour,
+	}
+	worker.RunOnce(now)
+
+	mockUserService.AssertExpectations(t)
+	mockAuditService.AssertExpectations(t)
+
+	for _, key := range []string{photoKey, "verifications/s1.jpg"} {
+		if _, err := blobs.Get(key); !errors.Is(err, service.ErrBlobNotFound) {
+			t.Errorf("%s was not deleted: %v", key, err)
+		}
+	}
+	select {
+	case event := <-events:
+		if event.Type != models.EventSignedOut {
+			t.Errorf("deleted user got unexpected event: %v", event)
+		}
+	default:
+		t.Error("deleted user's connections were not signed out")
+	}
+}
+
+func TestRoutesEnforceAccountStatus(t *testing.T) {
+	devEndpoints = true
+	defer func() { devEndpoints = false }()
+	later := time.Now().Add(time.Hour)
+	mockAccountService := new(MockAccountService)
+	useAccountService(t, mockAccountService)
+	mockAccountService.On("GetAccount", "user1").Return(models.Account{Status: models.AccountStatusSuspended, SuspendedUntil: &later}, nil)
+
+	// Open routes: the signed webhooks and download links carry their own
+	// proof, and a suspended user has to be able to appeal
+	open := map[string]bool{
+		"POST /signup":              true,
+		"POST /login":               true,
+		"POST /appeals":             true,
+		"GET /products":             true,
+		"GET /profile/catalog":      true,
+		"GET /media/{key:.+}":       true,
+		"GET /exports/{token}":      true,
+		"POST /webhooks/payments":   true,
+		"POST /webhooks/appstore":   true,
+		"POST /webhooks/googleplay": true,
+		"GET /swagger/doc.json":     true,
+	}
+	pathVar := regexp.MustCompile(`\{[^}]+\}`)
+
+	router := newRouter()
+	checked := 0
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			if open[method+" "+template] {
+				continue
+			}
+			checked++
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, authorizedRequest(t, method, pathVar.ReplaceAllString(template, "1"), "user1", []byte(`{}`)))
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("%s %s let a suspended user through with %d", method, template, rr.Code)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked == 0 {
+		t.Fatal("no routes checked")
+	}
+}
+
+func TestDecideAppeal(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	appealColumns := []string{"id", "user_id", "account_status", "message", "status", "response", "decided_by", "created_at", "decided_at"}
+	pending := []driver.Value{"a1", "user2", models.AccountStatusBanned, "It wasn't me", models.AppealStatusPending, "", "", now, nil}
+	lifted := []driver.Value{"a1", "user2", models.AccountStatusBanned, "It wasn't me", models.AppealStatusLifted, "Sorry", "admin:staff1", now, now}
+	accountColumns := []string{"status", "suspended_until", "token_version"}
+	banned := []driver.Value{models.AccountStatusBanned, nil, int64(3)}
+	auditErr := errors.New("connection reset")
+
+	tests := []struct {
+		name    string
+		script  func(script *sqlScript)
+		wantErr error
+	}{
+		{
+			name: "Lifting is audited in its transaction",
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM appeals WHERE id=$1 FOR UPDATE", appealColumns, [][]driver.Value{pending}, "a1")
+				script.query("FROM users WHERE id=$1 FOR UPDATE", accountColumns, [][]driver.Value{banned}, "user2")
+				script.exec("UPDATE users SET status=$1, suspended_until=NULL", 1, models.AccountStatusActive, "user2")
+				script.query("UPDATE appeals SET status=$1", appealColumns, [][]driver.Value{lifted})
+				script.exec("INSERT INTO audit_log", 1, "admin:staff1", models.AuditActionAppealLifted, "user", "user2", anyArg)
+				script.commit()
+			},
+		},
+		{
+			name: "Failed audit leaves the account restricted",
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM appeals WHERE id=$1 FOR UPDATE", appealColumns, [][]driver.Value{pending}, "a1")
+				script.query("FROM users WHERE id=$1 FOR UPDATE", accountColumns, [][]driver.Value{banned}, "user2")
+				script.exec("UPDATE users SET status=$1", 1)
+				script.query("UPDATE appeals SET status=$1", appealColumns, [][]driver.Value{lifted})
+				script.exec("INSERT INTO audit_log", 0).fails(auditErr)
+				script.rollback()
+			},
+			wantErr: auditErr,
+		},
+		{
+			// The ban was turned into a suspension after the appeal was filed
+			name: "Restriction changed since the appeal",
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM appeals WHERE id=$1 FOR UPDATE", appealColumns, [][]driver.Value{pending}, "a1")
+				script.query("FROM users WHERE id=$1 FOR UPDATE", accountColumns,
+					[][]driver.Value{{models.AccountStatusSuspended, now.AddDate(0, 0, 7), int64(4)}}, "user2")
+				script.rollback()
+			},
+			wantErr: service.ErrAppealOutdated,
+		},
+		{
+			name: "Restriction already lifted",
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM appeals WHERE id=$1 FOR UPDATE", appealColumns, [][]driver.Value{pending}, "a1")
+				script.query("FROM users WHERE id=$1 FOR UPDATE", accountColumns, [][]driver.Value{{models.AccountStatusActive, nil, int64(4)}}, "user2")
+				script.rollback()
+			},
+			wantErr: service.ErrAppealOutdated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, script := newSQLScript(t)
+			tt.script(script)
+
+			accounts := &service.AccountServiceImpl{DB: db}
+			if _, err := accounts.DecideAppeal("a1", "admin:staff1", models.AppealDecisionLift, "Sorry", now); !errors.Is(err, tt.wantErr) {
+				t.Errorf("DecideAppeal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	// Children before parents, so no foreign key is left dangling
+	erasure := []string{
+		"DELETE FROM push_deliveries", "DELETE FROM devices", "DELETE FROM notification_preferences",
+		"DELETE FROM messages", "DELETE FROM matches", "DELETE FROM swipes", "DELETE FROM swipe_batch_keys",
+		"DELETE FROM blocks", "DELETE FROM reports", "UPDATE reports SET reporter_id=NULL", "DELETE FROM appeals",
+		"DELETE FROM exports", "DELETE FROM verifications", "DELETE FROM photos", "DELETE FROM profile_prompts",
+		"DELETE FROM profiles", "DELETE FROM boosts", "DELETE FROM subscriptions", "UPDATE purchases SET user_id=NULL",
+		"UPDATE promo_redemptions SET user_id=NULL", "DELETE FROM idempotency_keys", "DELETE FROM users",
+	}
+
+	tests := []struct {
+		name     string
+		deleteAt interface{}
+		wantKeys []string
+		wantErr  error
+	}{
+		{
+			name:     "Due",
+			deleteAt: now.Add(-time.Minute),
+			wantKeys: []string{"photos/p1/large.jpg", "photos/p1/medium.jpg", "photos/p1/thumbnail.jpg", "verifications/v1.jpg"},
+		},
+		{"Canceled while queued", nil, nil, service.ErrDeletionNotScheduled},
+		{"Not due yet", now.Add(time.Hour), nil, service.ErrDeletionNotScheduled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, script := newSQLScript(t)
+			script.begin()
+			script.query("SELECT deletion_scheduled_at FROM users WHERE id=$1 FOR UPDATE", []string{"deletion_scheduled_at"}, [][]driver.Value{{tt.deleteAt}}, "user1")
+			if tt.wantErr == nil {
+				script.query("UNION ALL", []string{"key", "photo"}, [][]driver.Value{{"photos/p1", true}, {"verifications/v1.jpg", false}}, "user1")
+				for _, statement := range erasure {
+					script.exec(statement, 1, "user1")
+				}
+				script.commit()
+			} else {
+				script.rollback()
+			}
+
+			users := &service.UserServiceImpl{DB: db}
+			keys, err := users.Delete("user1", now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Delete() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && strings.Join(keys, " ") != strings.Join(tt.wantKeys, " ") {
+				t.Errorf("Delete() = %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"dating-app/service"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// jwtSecret signs and verifies the tokens issued at login
+var jwtSecret = []byte("your_secret_key")
+
+type contextKey string
+
+const (
+	userIDContextKey contextKey = "userID"
+	rolesContextKey  contextKey = "roles"
+)
+
+// tokenClaims are the JWT claims issued at login. Roles carries the user's staff
+// roles so the admin API can check them without a database lookup, and Version
+// the account's token version, so a token stops working once it is bumped.
+type tokenClaims struct {
+	Roles   []string `json:"roles,omitempty"`
+	Version int      `json:"ver"`
+	jwt.StandardClaims
+}
+
+// authenticate rejects requests without a valid bearer token issued by
+// LoginHandler and makes the token subject and roles available to the handler
+// through authenticatedUserID and authenticatedRoles. The account is looked up
+// on every request, so suspensions, bans and sign-outs apply immediately.
+func authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if tokenString == "" {
+			http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+
+		claims := &tokenClaims{}
+		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+			}
+			return jwtSecret, nil
+		})
+		if err != nil || !token.Valid || claims.Subject == "" {
+			http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+
+		account, err := accountService.GetAccount(claims.Subject)
+		if errors.Is(err, service.ErrUserNotFound) {
+			http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+			return
+		}
+		if err := service.Restriction(account, time.Now()); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusForbidden)
+			return
+		}
+		if account.TokenVersion != claims.Version {
+			http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userIDContextKey, claims.Subject)
+		ctx = context.WithValue(ctx, rolesContextKey, claims.Roles)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// authenticatedUserID returns the ID of the user making an authenticated request
+func authenticatedUserID(r *http.Request) string {
+	userID, _ := r.Context().Value(userIDContextKey).(string)
+	return userID
+}
+
+// authenticatedRoles returns the staff roles of the user making an
+// authenticated request
+func authenticatedRoles(r *http.Request) []string {
+	roles, _ := r.Context().Value(rolesContextKey).([]string)
+	return roles
+}
+
+// requireRole only lets authenticated users holding role through. It goes
+// inside authenticate.
+func requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !service.HasRole(authenticatedRoles(r), role) {
+			http.Error(w, `{"error": "Forbidden"}`, http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminActor identifies the staff member making an admin request in the audit
+// log and on the records they review
+func adminActor(r *http.Request) string {
+	return "admin:" + authenticatedUserID(r)
+}
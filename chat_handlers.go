@@ -0,0 +1,337 @@
+package main
+
+import (
+	"dating-app/models"
+	"dating-app/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	defaultMessagePageSize = 50
+	maxMessagePageSize     = 100
+)
+
+// @Summary List matches
+// @Description Returns the authenticated user's open matches, newest first. Matches are made when two users swipe right on each other. Each match has the other user's presence (online, recently_active or offline) unless they hide it.
+// @Tags Chat
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} map[string][]models.Match
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /matches [get]
+func ListMatchesHandler(w http.ResponseWriter, r *http.Request) {
+	matches, err := chatService.ListMatches(authenticatedUserID(r), time.Now())
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]models.Match{"matches": matches})
+}
+
+// @Summary Send message
+// @Description Sends a message to the other user of a match. It is delivered in real time to both users' open /ws connections and pushed to the recipient's devices.
+// @Tags Chat
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param Idempotency-Key header string false "Client-generated key that makes retries safe"
+// @Param id path string true "Match ID"
+// @Param body body string true "Message text, at most 1000 characters"
+// @Success 201 {object} models.Message
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /matches/{id}/messages [post]
+func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Body string `json:"body"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	message, err := chatService.SendMessage(authenticatedUserID(r), mux.Vars(r)["id"], request.Body, time.Now())
+	if !writeChatError(w, err) {
+		return
+	}
+
+	// Both users get it, so the sender's other devices stay in sync. A hidden
+	// message must look sent to its sender and nothing more.
+	event := models.Event{Type: models.EventMessage, Data: message}
+	broker.Publish(message.SenderID, event)
+	if !message.Hidden {
+		broker.Publish(message.RecipientID, event)
+		notifyMessage(message)
+	}
+	botDetector.Observe(message.SenderID)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(message)
+}
+
+// @Summary Message history
+// @Description Returns a page of a match's conversation, newest first. Pass the nextCursor of a page as cursor to get the messages before it.
+// @Tags Chat
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "Match ID"
+// @Param cursor query string false "nextCursor of the previous page"
+// @Param limit query int false "Number of messages to return (default 50, max 100)"
+// @Success 200 {object} models.MessagePage
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /matches/{id}/messages [get]
+func ListMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	limit := defaultMessagePageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxMessagePageSize {
+			http.Error(w, `{"error": "Invalid limit"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := chatService.ListMessages(authenticatedUserID(r), mux.Vars(r)["id"], r.URL.Query().Get("cursor"), limit)
+	if !writeChatError(w, err) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(page)
+}
+
+// @Summary Send receipt
+// @Description Marks every message the other user sent in the match up to and including messageID as delivered to the authenticated user's device, or as read by them; read implies delivered. The sender gets a "receipt" event. Receipts can also be sent over /ws.
+// @Tags Chat
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "Match ID"
+// @Param status body string true "delivered or read"
+// @Param messageID body string true "Latest message covered"
+// @Success 200 {object} models.Receipt
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /matches/{id}/receipts [post]
+func SendReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Status    string `json:"status"`
+		MessageID string `json:"messageID"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	receipt, err := sendReceipt(authenticatedUserID(r), mux.Vars(r)["id"], request.Status, request.MessageID)
+	if !writeChatError(w, err) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(receipt)
+}
+
+// sendReceipt marks messages as delivered or read and tells their sender,
+// unless the user sending the receipt is shadow-banned
+func sendReceipt(userID, matchID, status, messageID string) (models.Receipt, error) {
+	receipt, err := chatService.MarkMessages(userID, matchID, status, messageID, time.Now())
+	if err != nil {
+		return receipt, err
+	}
+	if !receipt.Hidden {
+		broker.Publish(receipt.SenderID, models.Event{Type: models.EventReceipt, Data: receipt})
+	}
+	return receipt, nil
+}
+
+// @Summary Unmatch
+// @Description Ends a match for both users. The conversation is closed: neither of them can read or send messages in it any more.
+// @Tags Chat
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "Match ID"
+// @Success 200 {object} models.Match
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /matches/{id} [delete]
+func UnmatchHandler(w http.ResponseWriter, r *http.Request) {
+	match, err := chatService.Unmatch(authenticatedUserID(r), mux.Vars(r)["id"], time.Now())
+	if !writeChatError(w, err) {
+		return
+	}
+
+	for _, userID := range match.UserIDs {
+		broker.Publish(userID, models.Event{Type: models.EventUnmatched, Data: match})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(match)
+}
+
+// writeChatError writes the response for a ChatService error and reports
+// whether there was none
+func writeChatError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, service.ErrInvalidMessage):
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+	case errors.Is(err, service.ErrInvalidReceipt):
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+	case errors.Is(err, service.ErrInvalidCursor):
+		http.Error(w, `{"error": "Invalid cursor"}`, http.StatusBadRequest)
+	case errors.Is(err, service.ErrMatchNotFound):
+		http.Error(w, `{"error": "Match not found"}`, http.StatusNotFound)
+	case errors.Is(err, service.ErrMatchClosed):
+		http.Error(w, `{"error": "Conversation is closed"}`, http.StatusConflict)
+	default:
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+	}
+	return false
+}
+
+// @Summary Real-time events
+// @Description Upgrades to a WebSocket that streams the authenticated user's events as JSON objects with a type and data: "match" for new matches, "message" for messages sent or received, "receipt" when the other user got or read messages, "typing" while they type, "presence" when a match comes online or leaves, "unmatched" when a match ends, and "signed_out" just before the server closes the connection because the account was suspended or banned. Clients send {"type": "typing", "matchID": ...} while the user types and {"type": "receipt", "matchID": ..., "status": "delivered" or "read", "messageID": ...} for receipts. Browsers, which can't set headers on a WebSocket, can pass the token as access_token instead.
+// @Tags Chat
+// @Param Authorization header string false "Bearer token"
+// @Param access_token query string false "Token from /login"
+// @Success 101
+// @Failure 401 {object} map[string]string
+// @Router /ws [get]
+func EventsSocketHandler(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+	server := websocket.Server{
+		// Requests are authorized by token, not cookies, so any origin may connect
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			streamEvents(conn, userID)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// typingInterval is how often typing events of a connection are passed on
+// per match; clients usually send one per keystroke
+const typingInterval = 3 * time.Second
+
+// socketCommand is a frame sent by a client over /ws
+type socketCommand struct {
+	Type      string `json:"type"`
+	MatchID   string `json:"matchID"`
+	Status    string `json:"status"`
+	MessageID string `json:"messageID"`
+}
+
+// streamEvents writes the user's events to conn and handles the commands the
+// client sends, until either side closes it. The user counts as online while
+// connected.
+func streamEvents(conn *websocket.Conn, userID string) {
+	events, unsubscribe := broker.Subscribe(userID)
+	defer unsubscribe()
+
+	seen(userID, models.PresenceOnline)
+	defer seen(userID, models.PresenceRecentlyActive)
+	heartbeat := time.NewTicker(service.PresenceHeartbeat)
+	defer heartbeat.Stop()
+
+	closed := make(chan struct{})
+	go func() {
+		readCommands(conn, userID)
+		close(closed)
+	}()
+
+	for {
+		select {
+		case event := <-events:
+			if err := websocket.JSON.Send(conn, event); err != nil || event.Type == models.EventSignedOut {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := userService.RecordSeen(userID, time.Now()); err != nil {
+				log.Printf("failed to record that %s was seen: %v", userID, err)
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// readCommands handles the client's commands until the connection fails.
+// Commands that can't be carried out are dropped; the client isn't waiting
+// for an answer.
+func readCommands(conn *websocket.Conn, userID string) {
+	lastTyping := make(map[string]time.Time)
+	for {
+		var command socketCommand
+		err := websocket.JSON.Receive(conn, &command)
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		switch command.Type {
+		case models.EventTyping:
+			if time.Since(lastTyping[command.MatchID]) < typingInterval {
+				continue
+			}
+			lastTyping[command.MatchID] = time.Now()
+			typing, err := chatService.Typing(userID, command.MatchID)
+			if err != nil {
+				if !isChatClientError(err) {
+					log.Printf("failed to check %s typing: %v", userID, err)
+				}
+				continue
+			}
+			if !typing.Hidden {
+				broker.Publish(typing.RecipientID, models.Event{Type: models.EventTyping, Data: typing})
+			}
+		case models.EventReceipt:
+			if _, err := sendReceipt(userID, command.MatchID, command.Status, command.MessageID); err != nil && !isChatClientError(err) {
+				log.Printf("failed to record a receipt from %s: %v", userID, err)
+			}
+		}
+	}
+}
+
+// isChatClientError reports whether a ChatService error was caused by the request
+func isChatClientError(err error) bool {
+	return errors.Is(err, service.ErrInvalidReceipt) || errors.Is(err, service.ErrMatchNotFound) || errors.Is(err, service.ErrMatchClosed)
+}
+
+// tokenFromQuery lets WebSocket clients that can't set headers authenticate
+// with an access_token query parameter
+func tokenFromQuery(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next(w, r)
+	}
+}
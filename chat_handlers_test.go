@@ -0,0 +1,453 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dating-app/models"
+	"dating-app/service"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/net/websocket"
+)
+
+type MockChatService struct {
+	mock.Mock
+}
+
+func (m *MockChatService) ListMatches(userID string, now time.Time) ([]models.Match, error) {
+	args := m.Called(userID, now)
+	return args.Get(0).([]models.Match), args.Error(1)
+}
+
+func (m *MockChatService) Typing(userID, matchID string) (models.Typing, error) {
+	args := m.Called(userID, matchID)
+	return args.Get(0).(models.Typing), args.Error(1)
+}
+
+func (m *MockChatService) MarkMessages(userID, matchID, status, upToMessageID string, now time.Time) (models.Receipt, error) {
+	args := m.Called(userID, matchID, status, upToMessageID, now)
+	return args.Get(0).(models.Receipt), args.Error(1)
+}
+
+func (m *MockChatService) SendMessage(userID, matchID, body string, now time.Time) (models.Message, error) {
+	args := m.Called(userID, matchID, body, now)
+	return args.Get(0).(models.Message), args.Error(1)
+}
+
+func (m *MockChatService) ListMessages(userID, matchID, cursor string, limit int) (models.MessagePage, error) {
+	args := m.Called(userID, matchID, cursor, limit)
+	return args.Get(0).(models.MessagePage), args.Error(1)
+}
+
+func (m *MockChatService) Unmatch(userID, matchID string, now time.Time) (models.Match, error) {
+	args := m.Called(userID, matchID, now)
+	return args.Get(0).(models.Match), args.Error(1)
+}
+
+func TestSendMessageHandler(t *testing.T) {
+	message := models.Message{ID: "1", MatchID: "m1", SenderID: "user1", RecipientID: "user2", Body: "Hi!"}
+
+	tests := []struct {
+		name           string
+		hidden         bool
+		serviceErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "Message sent",
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Shadow-banned sender",
+			hidden:         true,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Empty message",
+			serviceErr:     fmt.Errorf("%w: body is required", service.ErrInvalidMessage),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Not part of the match",
+			serviceErr:     service.ErrMatchNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Unmatched",
+			serviceErr:     service.ErrMatchClosed,
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockChatService := new(MockChatService)
+			chatService = mockChatService
+			memoryBroker := service.NewMemoryBroker()
+			broker = memoryBroker
+			recipientEvents, unsubscribe := memoryBroker.Subscribe("user2")
+			defer unsubscribe()
+
+			message := message
+			message.Hidden = tt.hidden
+			delivered := tt.serviceErr == nil && !tt.hidden
+			mockChatService.On("SendMessage", "user1", "m1", "Hi!", mock.AnythingOfType("time.Time")).Return(message, tt.serviceErr)
+			mockNotificationService := new(MockNotificationService)
+			notificationService = mockNotificationService
+			if delivered {
+				mockNotificationService.On("Notify", "user2", mock.MatchedBy(func(notification models.Notification) bool {
+					return notification.Type == models.NotificationMessage && notification.Data["messageID"] == "1"
+				}), mock.AnythingOfType("time.Time")).Return(nil)
+			}
+
+			req := authorizedRequest(t, "POST", "/matches/m1/messages", "user1", []byte(`{"body": "Hi!"}`))
+			req = mux.SetURLVars(req, map[string]string{"id": "m1"})
+			rr := httptest.NewRecorder()
+			handler := authenticate(SendMessageHandler)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			select {
+			case event := <-recipientEvents:
+				if !delivered {
+					t.Errorf("unexpected event for an undelivered message: %v", event)
+				} else if event.Type != models.EventMessage || !reflect.DeepEqual(event.Data, message) {
+					t.Errorf("recipient got unexpected event: %v", event)
+				}
+			default:
+				if delivered {
+					t.Error("message was not delivered to the recipient")
+				}
+			}
+
+			mockChatService.AssertExpectations(t)
+			mockNotificationService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListMessagesHandler(t *testing.T) {
+	page := models.MessagePage{
+		Messages:   []models.Message{{ID: "42", MatchID: "m1", SenderID: "user2", RecipientID: "user1", Body: "Hey"}},
+		NextCursor: service.EncodeMessageCursor("42"),
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedCursor string
+		expectedLimit  int
+		serviceErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "Latest messages",
+			expectedLimit:  defaultMessagePageSize,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Older messages",
+			query:          "?limit=1&cursor=NDM",
+			expectedCursor: "NDM",
+			expectedLimit:  1,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Invalid cursor",
+			query:          "?cursor=bad",
+			expectedCursor: "bad",
+			expectedLimit:  defaultMessagePageSize,
+			serviceErr:     service.ErrInvalidCursor,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Page size too large",
+			query:          "?limit=500",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockChatService := new(MockChatService)
+			chatService = mockChatService
+			if tt.expectedLimit != 0 {
+				mockChatService.On("ListMessages", "user1", "m1", tt.expectedCursor, tt.expectedLimit).Return(page, tt.serviceErr)
+			}
+
+			req := authorizedRequest(t, "GET", "/matches/m1/messages"+tt.query, "user1", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "m1"})
+			rr := httptest.NewRecorder()
+			handler := authenticate(ListMessagesHandler)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockChatService.AssertExpectations(t)
+		})
+	}
+}
+
+// startEventsServer serves /ws for a test. Once the test is done it waits for
+// the connections to be handled, so none outlives the mocks it uses.
+func startEventsServer(t *testing.T) *httptest.Server {
+	var handlers sync.WaitGroup
+	handler := tokenFromQuery(authenticate(EventsSocketHandler))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.Add(1)
+		defer handlers.Done()
+		handler(w, r)
+	}))
+	t.Cleanup(func() {
+		server.Close()
+		handlers.Wait()
+	})
+	return server
+}
+
+// dialEvents opens /ws as the given user on a test server
+func dialEvents(t *testing.T, server *httptest.Server, userID string) *websocket.Conn {
+	token, err := generateJWT(models.User{ID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?access_token=" + token
+	conn, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// receiveEvent waits for the next event on conn, calling publish until one
+// arrives since the subscription is only made once the handshake is done
+func receiveEvent(t *testing.T, conn *websocket.Conn, publish func()) models.Event {
+	received := make(chan models.Event, 1)
+	go func() {
+		var event models.Event
+		if err := websocket.JSON.Receive(conn, &event); err == nil {
+			received <- event
+		}
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		publish()
+		select {
+		case event := <-received:
+			return event
+		case <-deadline:
+			t.Fatal("no event received")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestEventsSocketHandler(t *testing.T) {
+	memoryBroker := service.NewMemoryBroker()
+	broker = memoryBroker
+	mockUserService := new(MockUserService)
+	userService = mockUserService
+	mockUserService.On("RecordSeen", "user2", mock.AnythingOfType("time.Time")).Return(false, nil)
+	server := startEventsServer(t)
+
+	conn := dialEvents(t, server, "user2")
+	defer conn.Close()
+
+	message := models.Message{ID: "1", MatchID: "m1", SenderID: "user1", RecipientID: "user2", Body: "Hi!"}
+	event := receiveEvent(t, conn, func() {
+		memoryBroker.Publish("user2", models.Event{Type: models.EventMessage, Data: message})
+	})
+	data, _ := json.Marshal(event.Data)
+	var got models.Message
+	json.Unmarshal(data, &got)
+	if event.Type != models.EventMessage || got.ID != message.ID || got.Body != message.Body {
+		t.Errorf("socket returned unexpected event: %+v", event)
+	}
+}
+
+func TestEventsSocketTyping(t *testing.T) {
+	memoryBroker := service.NewMemoryBroker()
+	broker = memoryBroker
+	mockUserService := new(MockUserService)
+	userService = mockUserService
+	mockUserService.On("RecordSeen", "user1", mock.AnythingOfType("time.Time")).Return(false, nil)
+	mockChatService := new(MockChatService)
+	chatService = mockChatService
+	typing := models.Typing{MatchID: "m1", UserID: "user1", RecipientID: "user2"}
+	mockChatService.On("Typing", "user1", "m1").Return(typing, nil)
+	// In m2 user1 is shadow-banned, so the recipient must not hear of it
+	mockChatService.On("Typing", "user1", "m2").Return(models.Typing{MatchID: "m2", UserID: "user1", RecipientID: "user2", Hidden: true}, nil)
+	server := startEventsServer(t)
+
+	recipientEvents, unsubscribe := memoryBroker.Subscribe("user2")
+	defer unsubscribe()
+	conn := dialEvents(t, server, "user1")
+	defer conn.Close()
+	for _, matchID := range []string{"m2", "m1"} {
+		if err := websocket.JSON.Send(conn, map[string]string{"type": "typing", "matchID": matchID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Commands are handled in order, so the first event is m1's
+	select {
+	case event := <-recipientEvents:
+		if !reflect.DeepEqual(event, models.Event{Type: models.EventTyping, Data: typing}) {
+			t.Errorf("recipient got unexpected event: %v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("typing event was not delivered")
+	}
+}
+
+func TestSendReceiptHandler(t *testing.T) {
+	receipt := models.Receipt{MatchID: "m1", UserID: "user2", SenderID: "user1", Status: models.ReceiptRead, UpToMessageID: "42"}
+
+	tests := []struct {
+		name           string
+		status         string
+		hidden         bool
+		serviceErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "Messages read",
+			status:         models.ReceiptRead,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Shadow-banned reader",
+			status:         models.ReceiptRead,
+			hidden:         true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Unknown status",
+			status:         "seen",
+			serviceErr:     fmt.Errorf("%w: status must be delivered or read", service.ErrInvalidReceipt),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unmatched",
+			status:         models.ReceiptRead,
+			serviceErr:     service.ErrMatchClosed,
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockChatService := new(MockChatService)
+			chatService = mockChatService
+			memoryBroker := service.NewMemoryBroker()
+			broker = memoryBroker
+			senderEvents, unsubscribe := memoryBroker.Subscribe("user1")
+			defer unsubscribe()
+
+			receipt := receipt
+			receipt.Hidden = tt.hidden
+			mockChatService.On("MarkMessages", "user2", "m1", tt.status, "42", mock.AnythingOfType("time.Time")).Return(receipt, tt.serviceErr)
+
+			body, _ := json.Marshal(map[string]string{"status": tt.status, "messageID": "42"})
+			req := authorizedRequest(t, "POST", "/matches/m1/receipts", "user2", body)
+			req = mux.SetURLVars(req, map[string]string{"id": "m1"})
+			rr := httptest.NewRecorder()
+			handler := authenticate(SendReceiptHandler)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			select {
+			case event := <-senderEvents:
+				if tt.serviceErr != nil || tt.hidden || event.Type != models.EventReceipt {
+					t.Errorf("sender got unexpected event: %v", event)
+				}
+			default:
+				if tt.serviceErr == nil && !tt.hidden {
+					t.Error("receipt was not delivered to the sender")
+				}
+			}
+
+			mockChatService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPresenceStatus(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		lastSeen time.Time
+		expected string
+	}{
+		{lastSeen: now.Add(-30 * time.Second), expected: models.PresenceOnline},
+		{lastSeen: now.Add(-time.Hour), expected: models.PresenceRecentlyActive},
+		{lastSeen: now.AddDate(0, 0, -3), expected: models.PresenceOffline},
+	}
+
+	for _, tt := range tests {
+		if got := service.PresenceStatus(tt.lastSeen, now); got != tt.expected {
+			t.Errorf("PresenceStatus(%v) returned %q want %q", tt.lastSeen, got, tt.expected)
+		}
+	}
+}
+
+func TestEventsSocketHandlerRequiresToken(t *testing.T) {
+	req := httptest.NewRequest("GET", "/ws?access_token=invalid", nil)
+	rr := httptest.NewRecorder()
+	handler := tokenFromQuery(authenticate(EventsSocketHandler))
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
+func TestMessageCursor(t *testing.T) {
+	cursor := service.EncodeMessageCursor("42")
+	if id, err := service.DecodeMessageCursor(cursor); err != nil || id != "42" {
+		t.Errorf("DecodeMessageCursor(%q) returned %q, %v want 42", cursor, id, err)
+	}
+
+	for _, cursor := range []string{"not base64!", service.EncodeMessageCursor("1; DROP TABLE messages")} {
+		if _, err := service.DecodeMessageCursor(cursor); err != service.ErrInvalidCursor {
+			t.Errorf("DecodeMessageCursor(%q) returned %v want %v", cursor, err, service.ErrInvalidCursor)
+		}
+	}
+}
+
+func TestChatTyping(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	matchColumns := []string{"id", "user_a", "user_b", "created_at", "unmatched_at"}
+
+	for _, shadowBanned := range []bool{false, true} {
+		db, script := newSQLScript(t)
+		script.query("FROM matches WHERE id=$1 AND $2 IN (user_a, user_b)", matchColumns, [][]driver.Value{{"m1", "user1", "user2", now, nil}}, "m1", "user1")
+		script.query("SELECT shadow_banned FROM users WHERE id=$1", []string{"shadow_banned"}, [][]driver.Value{{shadowBanned}}, "user1")
+
+		chat := &service.ChatServiceImpl{DB: db}
+		typing, err := chat.Typing("user1", "m1")
+		expected := models.Typing{MatchID: "m1", UserID: "user1", RecipientID: "user2", Hidden: shadowBanned}
+		if err != nil || typing != expected {
+			t.Errorf("Typing() = %+v, %v, want %+v", typing, err, expected)
+		}
+	}
+}
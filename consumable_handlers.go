@@ -0,0 +1,57 @@
+package main
+
+import (
+	"dating-app/service"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// @Summary Balances
+// @Description Returns the authenticated user's remaining swipes for today, extra swipes, boosts and the end of their active boost
+// @Tags Consumables
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} models.Balances
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /balances [get]
+func GetBalancesHandler(w http.ResponseWriter, r *http.Request) {
+	balances, err := userService.GetBalances(authenticatedUserID(r), time.Now())
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(balances)
+}
+
+// @Summary Start boost
+// @Description Spends one of the authenticated user's boosts to rank them first in other users' feeds for 30 minutes
+// @Tags Consumables
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param Idempotency-Key header string false "Client-generated key that makes retries safe"
+// @Success 201 {object} models.Boost
+// @Failure 401 {object} map[string]string
+// @Failure 402 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /boosts [post]
+func ActivateBoostHandler(w http.ResponseWriter, r *http.Request) {
+	boost, err := userService.ActivateBoost(authenticatedUserID(r), time.Now())
+	switch {
+	case errors.Is(err, service.ErrNoBoostCredits):
+		http.Error(w, `{"error": "No boosts left"}`, http.StatusPaymentRequired)
+		return
+	case errors.Is(err, service.ErrBoostActive):
+		http.Error(w, `{"error": "A boost is already active"}`, http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(boost)
+}
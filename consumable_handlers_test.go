@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dating-app/models"
+	"dating-app/service"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func TestGetBalancesHandler(t *testing.T) {
+	mockUserService := new(MockUserService)
+	userService = mockUserService
+
+	boostEnd := time.Date(2025, 2, 1, 12, 30, 0, 0, time.UTC)
+	balances := models.Balances{SwipesLeftToday: 0, ExtraSwipes: 7, BoostCredits: 2, BoostActiveUntil: &boostEnd}
+	mockUserService.On("GetBalances", "user1", mock.AnythingOfType("time.Time")).Return(balances, nil)
+
+	rr := httptest.NewRecorder()
+	handler := authenticate(GetBalancesHandler)
+	handler.ServeHTTP(rr, authorizedRequest(t, "GET", "/balances", "user1", nil))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var responseBody models.Balances
+	if err := json.NewDecoder(rr.Body).Decode(&responseBody); err != nil {
+		t.Fatal(err)
+	}
+	if responseBody.ExtraSwipes != 7 || responseBody.BoostCredits != 2 || responseBody.BoostActiveUntil == nil || !responseBody.BoostActiveUntil.Equal(boostEnd) {
+		t.Errorf("handler returned unexpected body: got %v want %v", responseBody, balances)
+	}
+
+	mockUserService.AssertExpectations(t)
+}
+
+func TestActivateBoostHandler(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		mockReturn     models.Boost
+		mockErr        error
+		expectedStatus int
+	}{
+		{
+			name:           "Boost started",
+			mockReturn:     models.Boost{ID: "boost1", UserID: "user1", StartedAt: now, EndsAt: now.Add(service.BoostDuration)},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "No boosts left",
+			mockErr:        service.ErrNoBoostCredits,
+			expectedStatus: http.StatusPaymentRequired,
+		},
+		{
+			name:           "Boost already running",
+			mockErr:        service.ErrBoostActive,
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserService := new(MockUserService)
+			userService = mockUserService
+			mockUserService.On("ActivateBoost", "user1", mock.AnythingOfType("time.Time")).Return(tt.mockReturn, tt.mockErr)
+
+			rr := httptest.NewRecorder()
+			handler := authenticate(ActivateBoostHandler)
+			handler.ServeHTTP(rr, authorizedRequest(t, "POST", "/boosts", "user1", nil))
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockUserService.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql/driver"
+	"dating-app/service"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+)
+
+// MockEncryptionService is a mock implementation of EncryptionService
+type MockEncryptionService struct {
+	mock.Mock
+}
+
+func (m *MockEncryptionService) Reencrypt(column service.EncryptedColumn, limit int) (int, error) {
+	args := m.Called(column, limit)
+	return args.Int(0), args.Error(1)
+}
+
+// writeKeyfile writes a keyfile holding the given versions, each key filled
+// with its version number, and returns its path
+func writeKeyfile(t *testing.T, current int, versions ...int) string {
+	t.Helper()
+	file := map[string]interface{}{
+		"current": current,
+		"keys":    map[string]string{},
+	}
+	for _, version := range versions {
+		key := []byte(strings.Repeat(string(rune('0'+version)), 32))
+		file["keys"].(map[string]string)[string(rune('0'+version))] = base64.StdEncoding.EncodeToString(key)
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadCipher(t *testing.T, current int, versions ...int) *service.FieldCipher {
+	t.Helper()
+	keys, err := service.LoadLocalKeyProvider(writeKeyfile(t, current, versions...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &service.FieldCipher{Keys: keys}
+}
+
+func TestLoadLocalKeyProvider(t *testing.T) {
+	valid := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	tests := []struct {
+		name    string
+		keyfile string
+		wantErr bool
+	}{
+		{"Valid", `{"current": 1, "keys": {"1": "` + valid + `"}}`, false},
+		{"Current key missing", `{"current": 2, "keys": {"1": "` + valid + `"}}`, true},
+		{"Short key", `{"current": 1, "keys": {"1": "c2hvcnQ="}}`, true},
+		{"Bad version", `{"current": 1, "keys": {"1": "` + valid + `", "one": "` + valid + `"}}`, true},
+		{"Not JSON", `current=1`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys.json")
+			if err := os.WriteFile(path, []byte(tt.keyfile), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := service.LoadLocalKeyProvider(path); (err != nil) != tt.wantErr {
+				t.Errorf("LoadLocalKeyProvider() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFieldCipher(t *testing.T) {
+	old := loadCipher(t, 1, 1)
+	encrypted, err := old.Encrypt("1990-05-17")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, service.EncryptedPrefix(1)) || strings.Contains(encrypted, "1990") {
+		t.Fatalf("Encrypt() = %q", encrypted)
+	}
+	if again, _ := old.Encrypt("1990-05-17"); again == encrypted {
+		t.Error("Encrypt() reused a data key")
+	}
+
+	// After a rotation values under the old key still read until rewritten
+	rotated := loadCipher(t, 2, 1, 2)
+	if plaintext, err := rotated.Decrypt(encrypted); err != nil || plaintext != "1990-05-17" {
+		t.Errorf("Decrypt() after rotation = %q, %v", plaintext, err)
+	}
+	if reencrypted, _ := rotated.Encrypt("1990-05-17"); !strings.HasPrefix(reencrypted, service.EncryptedPrefix(2)) {
+		t.Errorf("Encrypt() after rotation = %q", reencrypted)
+	}
+	if _, err := loadCipher(t, 2, 2).Decrypt(encrypted); !errors.Is(err, service.ErrUnknownKeyVersion) {
+		t.Errorf("Decrypt() with the key removed error = %v", err)
+	}
+
+	// Values stored before encryption was turned on pass through
+	if plaintext, err := rotated.Decrypt("hello"); err != nil || plaintext != "hello" {
+		t.Errorf("Decrypt() of plaintext = %q, %v", plaintext, err)
+	}
+
+	tampered := encrypted[:len(encrypted)-2] + "AA"
+	if tampered == encrypted {
+		tampered = encrypted[:len(encrypted)-2] + "BB"
+	}
+	if _, err := old.Decrypt(tampered); !errors.Is(err, service.ErrInvalidCiphertext) {
+		t.Errorf("Decrypt() of tampered value error = %v", err)
+	}
+}
+
+func TestKeyRotationWorker(t *testing.T) {
+	t.Run("Batches until a column is done", func(t *testing.T) {
+		mockEncryptionService := new(MockEncryptionService)
+		for _, column := range service.EncryptedColumns {
+			// A full batch means there may be more
+			mockEncryptionService.On("Reencrypt", column, mock.AnythingOfType("int")).Return(500, nil).Once()
+			mockEncryptionService.On("Reencrypt", column, mock.AnythingOfType("int")).Return(12, nil).Once()
+		}
+
+		worker := &service.KeyRotationWorker{Encryption: mockEncryptionService}
+		worker.RunOnce()
+
+		mockEncryptionService.AssertExpectations(t)
+	})
+
+	t.Run("A failing column doesn't hold up the others", func(t *testing.T) {
+		mockEncryptionService := new(MockEncryptionService)
+		for _, column := range service.EncryptedColumns {
+			mockEncryptionService.On("Reencrypt", column, mock.AnythingOfType("int")).Return(0, errors.New("db down")).Once()
+		}
+
+		worker := &service.KeyRotationWorker{Encryption: mockEncryptionService}
+		worker.RunOnce()
+
+		mockEncryptionService.AssertExpectations(t)
+	})
+}
+
+func TestReencrypt(t *testing.T) {
+	old := loadCipher(t, 1, 1)
+	rotated := loadCipher(t, 2, 1, 2)
+	stale, err := old.Encrypt("1990-05-17")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Stale and plaintext values", func(t *testing.T) {
+		db, script := newSQLScript(t)
+		script.begin()
+		// Rows under the current key are skipped, and rows being written are
+		// left to the next batch
+		script.query("birthdate NOT LIKE $1 LIMIT $2 FOR UPDATE SKIP LOCKED", []string{"user_id", "birthdate"},
+			[][]driver.Value{{"r1", stale}, {"r2", "1985-01-02"}}, service.EncryptedPrefix(2)+"%", 500)
+		script.exec("UPDATE profiles SET birthdate=$1 WHERE user_id=$2", 1, anyArg, "r1")
+		script.exec("UPDATE profiles SET birthdate=$1 WHERE user_id=$2", 1, anyArg, "r2")
+		script.commit()
+
+		encryption := &service.EncryptionServiceImpl{DB: db, Cipher: rotated}
+		column := service.EncryptedColumn{Table: "profiles", Key: "user_id", Column: "birthdate"}
+		if rewritten, err := encryption.Reencrypt(column, 500); rewritten != 2 || err != nil {
+			t.Errorf("Reencrypt() = %d, %v", rewritten, err)
+		}
+	})
+
+	t.Run("Value under a removed key", func(t *testing.T) {
+		db, script := newSQLScript(t)
+		script.begin()
+		script.query("FOR UPDATE SKIP LOCKED", []string{"user_id", "birthdate"}, [][]driver.Value{{"r1", stale}})
+		script.rollback()
+
+		encryption := &service.EncryptionServiceImpl{DB: db, Cipher: loadCipher(t, 2, 2)}
+		column := service.EncryptedColumn{Table: "profiles", Key: "user_id", Column: "birthdate"}
+		if _, err := encryption.Reencrypt(column, 500); !errors.Is(err, service.ErrUnknownKeyVersion) {
+			t.Errorf("Reencrypt() error = %v, want %v", err, service.ErrUnknownKeyVersion)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"dating-app/models"
+	"dating-app/service"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// withDownloadURL sets the download link of a ready export
+func withDownloadURL(export models.Export) models.Export {
+	if export.Status == models.ExportStatusReady {
+		export.DownloadURL = service.ExportDownloadPath(export)
+	}
+	return export
+}
+
+// @Summary Request data export
+// @Description Starts building a ZIP of the user's data: profile, photos, swipes, matches, messages and purchases as JSON, plus the photo files. The archive is built in the background; the user gets an account notification when it is ready, and GET /me/export then returns its download link, which works for 7 days. While an export is pending, asking again returns it with 200.
+// @Tags Account
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param Idempotency-Key header string false "Client-generated key that makes retries safe"
+// @Success 202 {object} models.Export
+// @Success 200 {object} models.Export
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /me/export [post]
+func RequestExportHandler(w http.ResponseWriter, r *http.Request) {
+	export, created, err := exportService.RequestExport(authenticatedUserID(r), time.Now())
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusAccepted
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(export)
+}
+
+// @Summary Data export status
+// @Description Returns the user's latest data export, with its download link once it is ready
+// @Tags Account
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} models.Export
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /me/export [get]
+func GetExportHandler(w http.ResponseWriter, r *http.Request) {
+	export, err := exportService.LatestExport(authenticatedUserID(r))
+	if errors.Is(err, service.ErrExportNotFound) {
+		http.Error(w, `{"error": "No export requested"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(withDownloadURL(export))
+}
+
+// @Summary Download data export
+// @Description Downloads the ZIP of a data export. The link is the secret, so it works without a token and can be opened in a browser, but only until the export expires.
+// @Tags Account
+// @Produce  application/zip
+// @Param token path string true "Token from the export's downloadURL"
+// @Success 200 {file} file
+// @Failure 404 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /exports/{token} [get]
+func DownloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	export, err := exportService.GetExportByToken(mux.Vars(r)["token"])
+	if errors.Is(err, service.ErrExportNotFound) {
+		http.Error(w, `{"error": "Export not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	if export.Status == models.ExportStatusExpired || (export.ExpiresAt != nil && !time.Now().Before(*export.ExpiresAt)) {
+		http.Error(w, `{"error": "Download link has expired"}`, http.StatusGone)
+		return
+	}
+	if export.Status != models.ExportStatusReady {
+		http.Error(w, `{"error": "Export not found"}`, http.StatusNotFound)
+		return
+	}
+
+	archive, err := blobStore.Get(export.StorageKey)
+	if err != nil {
+		http.Error(w, `{"error": "Storage error"}`, http.StatusInternalServerError)
+		return
+	}
+	defer archive.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="dating-app-data.zip"`)
+	w.Header().Set("Cache-Control", "no-store")
+	io.Copy(w, archive)
+}
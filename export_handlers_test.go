@@ -0,0 +1,279 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"dating-app/models"
+	"dating-app/repository"
+	"dating-app/service"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockExportService struct {
+	mock.Mock
+}
+
+func (m *MockExportService) RequestExport(userID string, now time.Time) (models.Export, bool, error) {
+	args := m.Called(userID, now)
+	return args.Get(0).(models.Export), args.Bool(1), args.Error(2)
+}
+
+func (m *MockExportService) LatestExport(userID string) (models.Export, error) {
+	args := m.Called(userID)
+	return args.Get(0).(models.Export), args.Error(1)
+}
+
+func (m *MockExportService) GetExportByToken(token string) (models.Export, error) {
+	args := m.Called(token)
+	return args.Get(0).(models.Export), args.Error(1)
+}
+
+func (m *MockExportService) DueExports(now time.Time, limit int) ([]models.Export, error) {
+	args := m.Called(now, limit)
+	return args.Get(0).([]models.Export), args.Error(1)
+}
+
+func (m *MockExportService) MarkExportReady(exportID, storageKey string, readyAt, expiresAt time.Time) error {
+	args := m.Called(exportID, storageKey, readyAt, expiresAt)
+	return args.Error(0)
+}
+
+func (m *MockExportService) MarkExportFailed(exportID string) error {
+	args := m.Called(exportID)
+	return args.Error(0)
+}
+
+func (m *MockExportService) ExpiredExports(now time.Time, limit int) ([]models.Export, error) {
+	args := m.Called(now, limit)
+	return args.Get(0).([]models.Export), args.Error(1)
+}
+
+func (m *MockExportService) MarkExportExpired(exportID string) error {
+	args := m.Called(exportID)
+	return args.Error(0)
+}
+
+func (m *MockExportService) Swipes(userID string) ([]models.SwipeRecord, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]models.SwipeRecord), args.Error(1)
+}
+
+func (m *MockExportService) Matches(userID string) ([]models.Match, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]models.Match), args.Error(1)
+}
+
+func (m *MockExportService) Messages(userID string) ([]models.Message, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]models.Message), args.Error(1)
+}
+
+func TestRequestExportHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		created        bool
+		expectedStatus int
+	}{
+		{name: "Export queued", created: true, expectedStatus: http.StatusAccepted},
+		{name: "Export already pending", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockExportService := new(MockExportService)
+			exportService = mockExportService
+			export := models.Export{ID: "e1", UserID: "user1", Status: models.ExportStatusPending, Token: "secret"}
+			mockExportService.On("RequestExport", "user1", mock.AnythingOfType("time.Time")).Return(export, tt.created, nil)
+
+			rr := httptest.NewRecorder()
+			handler := authenticate(RequestExportHandler)
+			handler.ServeHTTP(rr, authorizedRequest(t, "POST", "/me/export", "user1", nil))
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			if strings.Contains(rr.Body.String(), "secret") {
+				t.Errorf("pending export leaked its download token: %s", rr.Body.String())
+			}
+
+			mockExportService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDownloadExportHandler(t *testing.T) {
+	blobStore = &service.LocalBlobStore{Dir: t.TempDir()}
+	if err := blobStore.Put("exports/abc.zip", "application/zip", strings.NewReader("zip data")); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	earlier := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name           string
+		export         models.Export
+		serviceErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "Ready",
+			export:         models.Export{ID: "e1", Status: models.ExportStatusReady, StorageKey: "exports/abc.zip", ExpiresAt: &later},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Link expired",
+			export:         models.Export{ID: "e1", Status: models.ExportStatusReady, StorageKey: "exports/abc.zip", ExpiresAt: &earlier},
+			expectedStatus: http.StatusGone,
+		},
+		{
+			name:           "Archive deleted",
+			export:         models.Export{ID: "e1", Status: models.ExportStatusExpired, ExpiresAt: &earlier},
+			expectedStatus: http.StatusGone,
+		},
+		{
+			name:           "Still building",
+			export:         models.Export{ID: "e1", Status: models.ExportStatusPending},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Unknown token",
+			serviceErr:     service.ErrExportNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockExportService := new(MockExportService)
+			exportService = mockExportService
+			mockExportService.On("GetExportByToken", "secret").Return(tt.export, tt.serviceErr)
+
+			req := httptest.NewRequest("GET", "/exports/secret", nil)
+			req = mux.SetURLVars(req, map[string]string{"token": "secret"})
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(DownloadExportHandler).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			if tt.expectedStatus == http.StatusOK && rr.Body.String() != "zip data" {
+				t.Errorf("handler returned unexpected body: %q", rr.Body.String())
+			}
+
+			mockExportService.AssertExpectations(t)
+		})
+	}
+
+	t.Run("Not served as media", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/media/exports/abc.zip", nil)
+		req = mux.SetURLVars(req, map[string]string{"key": "exports/abc.zip"})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(ServeBlobHandler).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+}
+
+func TestExportWorker(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	blobs := &service.LocalBlobStore{Dir: t.TempDir()}
+	if err := blobs.Put(service.PhotoBlobKey("photos/p1", models.PhotoSizeLarge), "image/jpeg", strings.NewReader("jpeg")); err != nil {
+		t.Fatal(err)
+	}
+	// Stored before uploads were converted to JPEG
+	if err := blobs.Put(service.PhotoBlobKey("photos/p2", models.PhotoSizeLarge), "image/png", strings.NewReader("\x89PNG\r\n\x1a\nIHDR")); err != nil {
+		t.Fatal(err)
+	}
+	if err := blobs.Put("exports/old.zip", "application/zip", strings.NewReader("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	mockExportService := new(MockExportService)
+	mockRepository := new(MockProfileRepository)
+	mockPhotoService := new(MockPhotoService)
+	mockPurchaseService := new(MockPurchaseService)
+	mockNotificationService := new(MockNotificationService)
+
+	mockExportService.On("DueExports", now, mock.AnythingOfType("int")).Return([]models.Export{
+		{ID: "e1", UserID: "user1", Status: models.ExportStatusPending, Attempts: 1},
+		{ID: "e2", UserID: "user2", Status: models.ExportStatusPending, Attempts: 3},
+	}, nil)
+	mockRepository.On("GetUserProfile", "user1").Return(models.Profile{UserID: "user1", Bio: "Hi"}, nil)
+	// The file of p3 has gone missing, which doesn't hold up the export
+	mockPhotoService.On("ListPhotos", "user1").Return([]models.Photo{
+		{ID: "p1", UserID: "user1", StorageKey: "photos/p1"},
+		{ID: "p2", UserID: "user1", StorageKey: "photos/p2"},
+		{ID: "p3", UserID: "user1", StorageKey: "photos/p3"},
+	}, nil)
+	mockExportService.On("Swipes", "user1").Return([]models.SwipeRecord{{TargetID: "user2", Action: "right"}}, nil)
+	mockExportService.On("Matches", "user1").Return([]models.Match{}, nil)
+	mockExportService.On("Messages", "user1").Return([]models.Message{}, nil)
+	mockPurchaseService.On("ListPurchases", "user1").Return([]models.Purchase{}, nil)
+	var storageKey string
+	mockExportService.On("MarkExportReady", "e1", mock.AnythingOfType("string"), now, now.Add(service.ExportLinkTTL)).
+		Run(func(args mock.Arguments) { storageKey = args.String(1) }).Return(nil)
+	mockNotificationService.On("Notify", "user1", mock.MatchedBy(func(notification models.Notification) bool {
+		return notification.Type == models.NotificationAccount && notification.Data["exportID"] == "e1"
+	}), now).Return(nil)
+
+	// The last attempt at the second export fails
+	mockRepository.On("GetUserProfile", "user2").Return(models.Profile{}, repository.ErrNotFound)
+	mockPhotoService.On("ListPhotos", "user2").Return([]models.Photo{}, errors.New("connection reset"))
+	mockExportService.On("MarkExportFailed", "e2").Return(nil)
+
+	mockExportService.On("ExpiredExports", now, mock.AnythingOfType("int")).Return([]models.Export{
+		{ID: "e0", UserID: "user1", Status: models.ExportStatusReady, StorageKey: "exports/old.zip"},
+	}, nil)
+	mockExportService.On("MarkExportExpired", "e0").Return(nil)
+
+	worker := &service.ExportWorker{
+		Exports:       mockExportService,
+		Profiles:      &service.ProfileServiceImpl{Repository: mockRepository},
+		Photos:        mockPhotoService,
+		Purchases:     mockPurchaseService,
+		Notifications: mockNotificationService,
+		Blobs:         blobs,
+		Interval:      time.Second,
+	}
+	worker.RunOnce(now)
+
+	mockExportService.AssertExpectations(t)
+	mockNotificationService.AssertExpectations(t)
+
+	if _, err := blobs.Get("exports/old.zip"); !errors.Is(err, service.ErrBlobNotFound) {
+		t.Errorf("expired archive was not deleted: %v", err)
+	}
+
+	archive, err := blobs.Get(storageKey)
+	if err != nil {
+		t.Fatalf("archive was not stored: %v", err)
+	}
+	defer archive.Close()
+	data, _ := io.ReadAll(archive)
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("archive is not a ZIP: %v", err)
+	}
+	var names []string
+	for _, f := range reader.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	expected := []string{"matches.json", "messages.json", "photos.json", "photos/p1.jpg", "photos/p2.png", "profile.json", "purchases.json", "swipes.json"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("archive has files %v want %v", names, expected)
+	}
+}
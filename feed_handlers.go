@@ -0,0 +1,81 @@
+package main
+
+import (
+	"dating-app/models"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultFeedSize = 20
+	maxFeedSize     = 50
+)
+
+// @Summary Feed
+// @Description Returns profiles the authenticated user hasn't swiped on yet, best first. Profiles with an active boost are ranked first. Hidden users are left out, and incognito users only appear to people they swiped right on.
+// @Tags Feed
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param limit query int false "Number of profiles to return (default 20, max 50)"
+// @Success 200 {object} map[string][]models.FeedProfile
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /feed [get]
+func FeedHandler(w http.ResponseWriter, r *http.Request) {
+	limit, ok := pageSize(w, r)
+	if !ok {
+		return
+	}
+
+	profiles, err := feedService.Feed(authenticatedUserID(r), limit, time.Now())
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]models.FeedProfile{"profiles": profiles})
+}
+
+// pageSize reads the limit query parameter, writing the error response itself
+// when it is invalid
+func pageSize(w http.ResponseWriter, r *http.Request) (int, bool) {
+	limit := defaultFeedSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxFeedSize {
+			http.Error(w, `{"error": "Invalid limit"}`, http.StatusBadRequest)
+			return 0, false
+		}
+	}
+	return limit, true
+}
+
+// @Summary Who liked me
+// @Description Returns people who swiped right on the authenticated user and haven't been swiped on by them yet, most recent first. Hidden users are left out; incognito users are shown, since they liked the viewer.
+// @Tags Feed
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param limit query int false "Number of likes to return (default 20, max 50)"
+// @Success 200 {object} map[string][]models.Like
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /likes [get]
+func LikesHandler(w http.ResponseWriter, r *http.Request) {
+	limit, ok := pageSize(w, r)
+	if !ok {
+		return
+	}
+
+	likes, err := feedService.Likes(authenticatedUserID(r), limit)
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]models.Like{"likes": likes})
+}
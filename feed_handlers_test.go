@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"dating-app/models"
+	"dating-app/service"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockFeedService struct {
+	mock.Mock
+}
+
+func (m *MockFeedService) Feed(userID string, limit int, now time.Time) ([]models.FeedProfile, error) {
+	args := m.Called(userID, limit, now)
+	return args.Get(0).([]models.FeedProfile), args.Error(1)
+}
+
+func TestFeedHandler(t *testing.T) {
+	profiles := []models.FeedProfile{{UserID: "user2", Username: "bob"}, {UserID: "user3", Username: "carol"}}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedLimit  int
+		expectedStatus int
+	}{
+		{
+			name:           "Default page size",
+			expectedLimit:  defaultFeedSize,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Custom page size",
+			query:          "?limit=5",
+			expectedLimit:  5,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Page size too large",
+			query:          "?limit=500",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockFeedService := new(MockFeedService)
+			feedService = mockFeedService
+			if tt.expectedStatus == http.StatusOK {
+				mockFeedService.On("Feed", "user1", tt.expectedLimit, mock.AnythingOfType("time.Time")).Return(profiles, nil)
+			}
+
+			rr := httptest.NewRecorder()
+			handler := authenticate(FeedHandler)
+			handler.ServeHTTP(rr, authorizedRequest(t, "GET", "/feed"+tt.query, "user1", nil))
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			if tt.expectedStatus == http.StatusOK {
+				var responseBody map[string][]models.FeedProfile
+				if err := json.NewDecoder(rr.Body).Decode(&responseBody); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(responseBody["profiles"], profiles) {
+					t.Errorf("handler returned unexpected body: got %v want %v", responseBody["profiles"], profiles)
+				}
+			}
+
+			mockFeedService.AssertExpectations(t)
+		})
+	}
+}
+
+func (m *MockFeedService) Likes(userID string, limit int) ([]models.Like, error) {
+	args := m.Called(userID, limit)
+	return args.Get(0).([]models.Like), args.Error(1)
+}
+
+func TestLikesHandler(t *testing.T) {
+	likes := []models.Like{{UserID: "user2", Username: "bob", LikedAt: time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)}}
+
+	mockFeedService := new(MockFeedService)
+	feedService = mockFeedService
+	mockFeedService.On("Likes", "user1", 5).Return(likes, nil)
+
+	rr := httptest.NewRecorder()
+	handler := authenticate(LikesHandler)
+	handler.ServeHTTP(rr, authorizedRequest(t, "GET", "/likes?limit=5", "user1", nil))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var responseBody map[string][]models.Like
+	if err := json.NewDecoder(rr.Body).Decode(&responseBody); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(responseBody["likes"], likes) {
+		t.Errorf("handler returned unexpected body: got %v want %v", responseBody["likes"], likes)
+	}
+
+	mockFeedService.AssertExpectations(t)
+}
+
+func TestFeedHidesRestrictedAccounts(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	restricted := "u.status <> 'banned' AND (u.status <> 'suspended' OR u.suspended_until IS NULL OR u.suspended_until <= NOW())"
+
+	db, script := newSQLScript(t)
+	script.query(restricted, []string{"id", "username", "last_swipe", "ends_at", "shared_tags"},
+		[][]driver.Value{{"user2", "bob", now, nil, int64(0)}}, "user1", now, 50)
+	script.query(restricted, []string{"id", "username", "liked_at"}, [][]driver.Value{{"user2", "bob", now}}, "user1", 10)
+
+	feed := &service.FeedServiceImpl{DB: db}
+	if profiles, err := feed.Feed("user1", 10, now); err != nil || len(profiles) != 1 {
+		t.Errorf("Feed() = %v, %v", profiles, err)
+	}
+	if likes, err := feed.Likes("user1", 10); err != nil || len(likes) != 1 {
+		t.Errorf("Likes() = %v, %v", likes, err)
+	}
+}
+
+func TestVisibleTo(t *testing.T) {
+	tests := []struct {
+		visibility  string
+		likedViewer bool
+		expected    bool
+	}{
+		{visibility: models.VisibilityEveryone, likedViewer: false, expected: true},
+		{visibility: models.VisibilityLikedOnly, likedViewer: false, expected: false},
+		{visibility: models.VisibilityLikedOnly, likedViewer: true, expected: true},
+		{visibility: models.VisibilityHidden, likedViewer: true, expected: false},
+	}
+
+	for _, tt := range tests {
+		if got := service.VisibleTo(tt.visibility, tt.likedViewer); got != tt.expected {
+			t.Errorf("VisibleTo(%q, %v) returned %v want %v", tt.visibility, tt.likedViewer, got, tt.expected)
+		}
+	}
+}
+
+func TestRankFeed(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	boostEnd := now.Add(10 * time.Minute)
+	expiredBoost := now.Add(-time.Minute)
+
+	profiles := []models.FeedProfile{
+		{UserID: "inactive", LastActive: now.AddDate(0, 0, -30)},
+		{UserID: "active", LastActive: now.Add(-time.Hour)},
+		{UserID: "boosted", LastActive: now.AddDate(0, 0, -30), BoostedUntil: &boostEnd},
+		{UserID: "boost-ended", LastActive: now.AddDate(0, 0, -10), BoostedUntil: &expiredBoost},
+		{UserID: "shared-tags", LastActive: now.AddDate(0, 0, -10), SharedTags: 3},
+	}
+	service.RankFeed(profiles, now)
+
+	var order []string
+	for _, profile := range profiles {
+		order = append(order, profile.UserID)
+	}
+	expected := []string{"boosted", "active", "shared-tags", "boost-ended", "inactive"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("RankFeed returned wrong order: got %v want %v", order, expected)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+)
+
+const (
+	maxIdempotencyKeyLength  = 255
+	maxIdempotentRequestSize = 1 << 20
+)
+
+// idempotent makes a mutating endpoint safe to retry. A request carrying an
+// Idempotency-Key header is run once; retries with the same key and body get
+// the stored response replayed, and reusing the key for a different body is
+// rejected with 422. Requests without the header are passed through.
+//
+// Keys are scoped per user, so idempotent must run inside authenticate; a key
+// on a request without a user is rejected rather than shared between callers.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, `{"error": "Invalid Idempotency-Key"}`, http.StatusBadRequest)
+			return
+		}
+		userID := authenticatedUserID(r)
+		if userID == "" {
+			http.Error(w, `{"error": "Idempotency-Key requires an authenticated request"}`, http.StatusBadRequest)
+			return
+		}
+
+		// Read one byte past the limit so a larger body is refused rather
+		// than fingerprinted by its first megabyte
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentRequestSize+1))
+		if err != nil {
+			http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxIdempotentRequestSize {
+			http.Error(w, `{"error": "Request too large"}`, http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Keys are scoped per endpoint and per user so they can't collide
+		scope := r.Method + " " + r.URL.Path + " " + userID
+		fingerprint := sha256.Sum256(body)
+
+		record, claimed, err := idempotencyStore.Begin(scope, key, hex.EncodeToString(fingerprint[:]))
+		if err != nil {
+			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+			return
+		}
+
+		if !claimed {
+			switch {
+			case record.Fingerprint != hex.EncodeToString(fingerprint[:]):
+				http.Error(w, `{"error": "Idempotency-Key was used with a different request"}`, http.StatusUnprocessableEntity)
+			case record.StatusCode == 0:
+				http.Error(w, `{"error": "A request with this Idempotency-Key is in progress"}`, http.StatusConflict)
+			default:
+				if record.ContentType != "" {
+					w.Header().Set("Content-Type", record.ContentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(record.StatusCode)
+				w.Write(record.Body)
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next(recorder, r)
+
+		// Server errors are not final, so let the client retry them
+		if recorder.statusCode >= 500 {
+			err = idempotencyStore.Release(scope, key)
+		} else {
+			err = idempotencyStore.Complete(scope, key, recorder.statusCode, recorder.Header().Get("Content-Type"), recorder.body.Bytes())
+		}
+		if err != nil {
+			log.Printf("failed to store response for idempotency key %q: %v", key, err)
+		}
+	}
+}
+
+// responseRecorder passes a response through while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if !r.wroteHeader {
+		r.statusCode = statusCode
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
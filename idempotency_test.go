@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dating-app/models"
+)
+
+// memoryIdempotencyStore is an in-memory IdempotencyStore for tests
+type memoryIdempotencyStore struct {
+	records map[string]models.IdempotencyRecord
+}
+
+func (s *memoryIdempotencyStore) Begin(scope, key, fingerprint string) (models.IdempotencyRecord, bool, error) {
+	if record, ok := s.records[scope+key]; ok {
+		return record, false, nil
+	}
+	record := models.IdempotencyRecord{Scope: scope, Key: key, Fingerprint: fingerprint}
+	s.records[scope+key] = record
+	return record, true, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(scope, key string, statusCode int, contentType string, body []byte) error {
+	record := s.records[scope+key]
+	record.StatusCode = statusCode
+	record.ContentType = contentType
+	record.Body = body
+	s.records[scope+key] = record
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(scope, key string) error {
+	delete(s.records, scope+key)
+	return nil
+}
+
+func TestIdempotentMiddleware(t *testing.T) {
+	idempotencyStore = &memoryIdempotencyStore{records: map[string]models.IdempotencyRecord{}}
+
+	calls := 0
+	handler := authenticate(idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]int{"calls": calls})
+	}))
+
+	tests := []struct {
+		name           string
+		userID         string
+		key            string
+		body           string
+		expectedStatus int
+		expectedCalls  int
+		expectReplay   bool
+	}{
+		{
+			name:           "Server error releases the key",
+			userID:         "user1",
+			key:            "key1",
+			body:           `{"userID": "user1"}`,
+			expectedStatus: http.StatusInternalServerError,
+			expectedCalls:  1,
+		},
+		{
+			name:           "Retry after a server error runs the handler",
+			userID:         "user1",
+			key:            "key1",
+			body:           `{"userID": "user1"}`,
+			expectedStatus: http.StatusAccepted,
+			expectedCalls:  2,
+		},
+		{
+			name:           "Duplicate request replays the stored response",
+			userID:         "user1",
+			key:            "key1",
+			body:           `{"userID": "user1"}`,
+			expectedStatus: http.StatusAccepted,
+			expectedCalls:  2,
+			expectReplay:   true,
+		},
+		{
+			name:           "Key reused with a different body",
+			userID:         "user1",
+			key:            "key1",
+			body:           `{"userID": "user2"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCalls:  2,
+		},
+		{
+			name:           "Requests without a key are passed through",
+			userID:         "user1",
+			body:           `{"userID": "user1"}`,
+			expectedStatus: http.StatusAccepted,
+			expectedCalls:  3,
+		},
+		{
+			name:           "Another user's key doesn't collide",
+			userID:         "user2",
+			key:            "key1",
+			body:           `{"userID": "user2"}`,
+			expectedStatus: http.StatusAccepted,
+			expectedCalls:  4,
+		},
+		{
+			name:           "Key without an authenticated user",
+			key:            "key2",
+			body:           `{"userID": "user1"}`,
+			expectedStatus: http.StatusUnauthorized,
+			expectedCalls:  4,
+		},
+		{
+			name:           "Oversized body",
+			userID:         "user1",
+			key:            "key3",
+			body:           strings.Repeat("x", maxIdempotentRequestSize+1),
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedCalls:  4,
+		},
+	}
+
+	var firstResponse string
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/purchase", bytes.NewBufferString(tt.body))
+			if tt.userID != "" {
+				req = authorizedRequest(t, "POST", "/purchase", tt.userID, []byte(tt.body))
+			}
+			if tt.key != "" {
+				req.Header.Set("Idempotency-Key", tt.key)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			if calls != tt.expectedCalls {
+				t.Errorf("handler ran %d times, want %d", calls, tt.expectedCalls)
+			}
+
+			replayed := rr.Header().Get("Idempotent-Replayed") == "true"
+			if replayed != tt.expectReplay {
+				t.Errorf("Idempotent-Replayed = %v, want %v", replayed, tt.expectReplay)
+			}
+			if tt.expectedStatus == http.StatusAccepted && tt.key != "" && tt.userID == "user1" {
+				if firstResponse == "" {
+					firstResponse = rr.Body.String()
+				} else if rr.Body.String() != firstResponse {
+					t.Errorf("replayed body %q, want %q", rr.Body.String(), firstResponse)
+				}
+			}
+		})
+	}
+
+	// Mounted without authenticate the key has no user to be scoped to
+	req := httptest.NewRequest("POST", "/signup", bytes.NewBufferString(`{}`))
+	req.Header.Set("Idempotency-Key", "key1")
+	rr := httptest.NewRecorder()
+	idempotent(func(w http.ResponseWriter, r *http.Request) { calls++ }).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || calls != 4 {
+		t.Errorf("key without a user got %d and ran the handler %d times", rr.Code, calls)
+	}
+}
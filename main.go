@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"dating-app/models"
+	"dating-app/repository"
 	"dating-app/service"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	_ "dating-app/docs"
@@ -22,6 +26,35 @@ import (
 
 var db *sql.DB
 var userService service.UserService = &service.UserServiceImpl{}
+var purchaseService service.PurchaseService = &service.PurchaseServiceImpl{}
+var subscriptionService service.SubscriptionService = &service.SubscriptionServiceImpl{}
+var paymentGateway service.PaymentGateway
+var idempotencyStore service.IdempotencyStore = &service.IdempotencyStoreImpl{}
+var promoService service.PromoService = &service.PromoServiceImpl{}
+var auditService service.AuditService = &service.AuditServiceImpl{}
+var feedService service.FeedService = &service.FeedServiceImpl{}
+var profileService service.ProfileService = &service.ProfileServiceImpl{}
+var blobStore service.BlobStore = &service.LocalBlobStore{}
+var photoService service.PhotoService = &service.PhotoServiceImpl{}
+var verificationService service.VerificationService = &service.VerificationServiceImpl{}
+var chatService service.ChatService = &service.ChatServiceImpl{}
+var broker service.Broker = service.NewMemoryBroker()
+var notificationService service.NotificationService = &service.NotificationServiceImpl{}
+var safetyService service.SafetyService = &service.SafetyServiceImpl{}
+var moderationService service.ModerationService = &service.ModerationServiceImpl{}
+var accountService service.AccountService = &service.AccountServiceImpl{}
+var botService service.BotService = &service.BotServiceImpl{}
+var exportService service.ExportService = &service.ExportServiceImpl{}
+var encryptionService service.EncryptionService = &service.EncryptionServiceImpl{}
+var botDetector = &service.BotDetector{}
+var botThresholds = service.DefaultBotThresholds
+var pushProviders map[string]service.PushProvider
+var receiptVerifiers map[string]service.ReceiptVerifier
+var paymentWebhookSecret []byte
+
+// devEndpoints mounts the routes that stand in for third parties during local
+// development. It must stay off in any deployment reachable by users.
+var devEndpoints bool
 
 func init() {
 	if err := godotenv.Load(); err != nil {
@@ -42,12 +75,170 @@ func init() {
 		log.Fatal(err)
 	}
 
-	// Initialize userService
+	paymentWebhookSecret = []byte(os.Getenv("PAYMENT_WEBHOOK_SECRET"))
+	if len(paymentWebhookSecret) == 0 {
+		log.Fatal("PAYMENT_WEBHOOK_SECRET must be set")
+	}
+
+	// Sensitive columns are encrypted with keys kept outside the database
+	if os.Getenv("ENCRYPTION_KEYFILE") == "" {
+		log.Fatal("ENCRYPTION_KEYFILE must be set")
+	}
+	keys, err := service.LoadLocalKeyProvider(os.Getenv("ENCRYPTION_KEYFILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fieldCipher := &service.FieldCipher{Keys: keys}
+
+	blobDir := os.Getenv("BLOB_STORE_DIR")
+	if blobDir == "" {
+		blobDir = "data/blobs"
+	}
+	mediaBaseURL := os.Getenv("MEDIA_BASE_URL")
+	if mediaBaseURL == "" {
+		mediaBaseURL = "http://localhost:8080/media"
+	}
+
+	receiptVerifiers, err = newReceiptVerifiers()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pushProviders, err = newPushProviders()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	botThresholds, err = newBotThresholds()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Initialize services
 	userService = &service.UserServiceImpl{DB: db}
+	purchaseService = &service.PurchaseServiceImpl{DB: db}
+	subscriptionService = &service.SubscriptionServiceImpl{DB: db}
+	idempotencyStore = &service.IdempotencyStoreImpl{DB: db}
+	promoService = &service.PromoServiceImpl{DB: db}
+	auditService = &service.AuditServiceImpl{DB: db}
+	feedService = &service.FeedServiceImpl{DB: db}
+	profileService = &service.ProfileServiceImpl{Repository: &repository.ProfileRepositoryImpl{DB: db, Cipher: fieldCipher}}
+	blobStore = &service.LocalBlobStore{Dir: blobDir, BaseURL: mediaBaseURL}
+	photoService = &service.PhotoServiceImpl{DB: db, Blobs: blobStore}
+	verificationService = &service.VerificationServiceImpl{DB: db, Blobs: blobStore}
+	chatService = &service.ChatServiceImpl{DB: db, Cipher: fieldCipher}
+	notificationService = &service.NotificationServiceImpl{DB: db}
+	safetyService = &service.SafetyServiceImpl{DB: db}
+	moderationService = &service.ModerationServiceImpl{DB: db, Cipher: fieldCipher}
+	accountService = &service.AccountServiceImpl{DB: db}
+	botService = &service.BotServiceImpl{DB: db, Cipher: fieldCipher}
+	exportService = &service.ExportServiceImpl{DB: db, Cipher: fieldCipher}
+	encryptionService = &service.EncryptionServiceImpl{DB: db, Cipher: fieldCipher}
+
+	// The fake gateway is the only one so far. It has to be asked for, so a
+	// deployment never ends up on it by accident.
+	switch os.Getenv("PAYMENT_GATEWAY") {
+	case "fake":
+		paymentGateway = &service.FakePaymentGateway{
+			WebhookURL: "http://localhost:8080/webhooks/payments",
+			Secret:     paymentWebhookSecret,
+		}
+	default:
+		log.Fatal(`PAYMENT_GATEWAY must be set to "fake", the only gateway supported`)
+	}
+	devEndpoints = os.Getenv("DEV_ENDPOINTS") == "true"
+}
+
+// newBotThresholds reads the bot detection thresholds from the environment,
+// falling back to the defaults for those not set
+func newBotThresholds() (service.BotThresholds, error) {
+	thresholds := service.DefaultBotThresholds
+	if value := os.Getenv("BOT_SCORE_WINDOW"); value != "" {
+		window, err := time.ParseDuration(value)
+		if err != nil {
+			return thresholds, fmt.Errorf("BOT_SCORE_WINDOW: %w", err)
+		}
+		thresholds.Window = window
+	}
+	for name, score := range map[string]*float64{
+		"BOT_REVIEW_SCORE":     &thresholds.Review,
+		"BOT_SHADOW_BAN_SCORE": &thresholds.ShadowBan,
+	} {
+		if value := os.Getenv(name); value != "" {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return thresholds, fmt.Errorf("%s: %w", name, err)
+			}
+			*score = parsed
+		}
+	}
+	return thresholds, thresholds.Validate()
 }
 
 func main() {
+	r := newRouter()
+
+	// Renew and expire subscriptions in the background
+	subscriptionWorker := &service.SubscriptionWorker{
+		Subscriptions: subscriptionService,
+		Purchases:     purchaseService,
+		Gateway:       paymentGateway,
+		Interval:      time.Minute,
+	}
+	go subscriptionWorker.Run(context.Background())
+
+	// Send queued push notifications in the background
+	notificationWorker := &service.NotificationWorker{
+		Notifications: notificationService,
+		Providers:     pushProviders,
+		Interval:      5 * time.Second,
+	}
+	go notificationWorker.Run(context.Background())
+
+	// Build requested data exports and delete expired ones in the background
+	exportWorker := &service.ExportWorker{
+		Exports:       exportService,
+		Profiles:      profileService,
+		Photos:        photoService,
+		Purchases:     purchaseService,
+		Notifications: notificationService,
+		Blobs:         blobStore,
+		Interval:      30 * time.Second,
+	}
+	go exportWorker.Run(context.Background())
+
+	// Erase accounts whose cooling-off period is over
+	deletionWorker := &service.AccountDeletionWorker{
+		Users:    userService,
+		Blobs:    blobStore,
+		Audit:    auditService,
+		Broker:   broker,
+		Interval: time.Hour,
+	}
+	go deletionWorker.Run(context.Background())
+
+	// Encrypt values written before their column was, and move values onto
+	// the current key after a rotation
+	rotationWorker := &service.KeyRotationWorker{
+		Encryption: encryptionService,
+		Interval:   time.Hour,
+	}
+	go rotationWorker.Run(context.Background())
+
+	// Score users who swipe or send messages for bot-like activity
+	botDetector = service.NewBotDetector(botService, botThresholds, 10*time.Second)
+	go botDetector.Run(context.Background())
 
+	http.Handle("/", r)
+	log.Println("Server started on :8080")
+	log.Fatal(http.ListenAndServe(":8080", nil))
+}
+
+// newRouter mounts the API. Every route that acts for a user goes through
+// authenticate, which also turns away suspended, banned and signed-out users;
+// only signing up, logging in, appealing and the signed webhooks and links are
+// open.
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
 
 	// Swagger UI route (use swaggerFiles.Handler directly)
@@ -57,17 +248,89 @@ func main() {
 
 	r.HandleFunc("/signup", SignupHandler).Methods("POST")
 	r.HandleFunc("/login", LoginHandler).Methods("POST")
-	r.HandleFunc("/swipe", SwipeHandler).Methods("POST")
-	r.HandleFunc("/purchase", PurchaseHandler).Methods("POST")
+	r.HandleFunc("/appeals", AppealHandler).Methods("POST")
+	r.HandleFunc("/swipe", authenticate(idempotent(SwipeHandler))).Methods("POST")
+	r.HandleFunc("/swipes/batch", authenticate(idempotent(SwipeBatchHandler))).Methods("POST")
+	r.HandleFunc("/purchase", authenticate(idempotent(PurchaseHandler))).Methods("POST")
+	r.HandleFunc("/products", ListProductsHandler).Methods("GET")
+	r.HandleFunc("/profile", authenticate(UpdateProfileHandler)).Methods("PUT")
+	r.HandleFunc("/profile/catalog", ProfileCatalogHandler).Methods("GET")
+	r.HandleFunc("/profile/{userID}", authenticate(GetProfileHandler)).Methods("GET")
+	r.HandleFunc("/photos", authenticate(UploadPhotoHandler)).Methods("POST")
+	r.HandleFunc("/photos", authenticate(ListPhotosHandler)).Methods("GET")
+	r.HandleFunc("/photos/order", authenticate(ReorderPhotosHandler)).Methods("PUT")
+	r.HandleFunc("/photos/{id}", authenticate(DeletePhotoHandler)).Methods("DELETE")
+	r.HandleFunc("/media/{key:.+}", ServeBlobHandler).Methods("GET")
+	r.HandleFunc("/verification", authenticate(GetVerificationHandler)).Methods("GET")
+	r.HandleFunc("/verification/challenge", authenticate(StartVerificationHandler)).Methods("POST")
+	r.HandleFunc("/verification/selfie", authenticate(SubmitSelfieHandler)).Methods("POST")
+	r.HandleFunc("/feed", authenticate(FeedHandler)).Methods("GET")
+	r.HandleFunc("/likes", authenticate(LikesHandler)).Methods("GET")
+	r.HandleFunc("/matches", authenticate(ListMatchesHandler)).Methods("GET")
+	r.HandleFunc("/matches/{id}", authenticate(UnmatchHandler)).Methods("DELETE")
+	r.HandleFunc("/matches/{id}/messages", authenticate(ListMessagesHandler)).Methods("GET")
+	r.HandleFunc("/matches/{id}/messages", authenticate(idempotent(SendMessageHandler))).Methods("POST")
+	r.HandleFunc("/matches/{id}/receipts", authenticate(SendReceiptHandler)).Methods("POST")
+	r.HandleFunc("/ws", tokenFromQuery(authenticate(EventsSocketHandler))).Methods("GET")
+	r.HandleFunc("/settings/visibility", authenticate(GetVisibilityHandler)).Methods("GET")
+	r.HandleFunc("/settings/visibility", authenticate(SetVisibilityHandler)).Methods("PUT")
+	r.HandleFunc("/settings/presence", authenticate(GetPresenceSettingHandler)).Methods("GET")
+	r.HandleFunc("/settings/presence", authenticate(SetPresenceSettingHandler)).Methods("PUT")
+	r.HandleFunc("/settings/notifications", authenticate(GetNotificationPreferencesHandler)).Methods("GET")
+	r.HandleFunc("/settings/notifications", authenticate(SetNotificationPreferencesHandler)).Methods("PUT")
+	r.HandleFunc("/devices", authenticate(RegisterDeviceHandler)).Methods("POST")
+	r.HandleFunc("/users/{id}/block", authenticate(BlockUserHandler)).Methods("POST")
+	r.HandleFunc("/users/{id}/block", authenticate(UnblockUserHandler)).Methods("DELETE")
+	r.HandleFunc("/users/{id}/report", authenticate(idempotent(ReportUserHandler))).Methods("POST")
+	r.HandleFunc("/devices/{id}", authenticate(UnregisterDeviceHandler)).Methods("DELETE")
+	r.HandleFunc("/me/export", authenticate(idempotent(RequestExportHandler))).Methods("POST")
+	r.HandleFunc("/me/export", authenticate(GetExportHandler)).Methods("GET")
+	r.HandleFunc("/exports/{token}", DownloadExportHandler).Methods("GET")
+	r.HandleFunc("/me/deletion", authenticate(ScheduleDeletionHandler)).Methods("POST")
+	r.HandleFunc("/me/deletion", authenticate(CancelDeletionHandler)).Methods("DELETE")
+	r.HandleFunc("/balances", authenticate(GetBalancesHandler)).Methods("GET")
+	r.HandleFunc("/boosts", authenticate(idempotent(ActivateBoostHandler))).Methods("POST")
+	r.HandleFunc("/purchases", authenticate(ListPurchasesHandler)).Methods("GET")
+	r.HandleFunc("/subscription", authenticate(GetSubscriptionHandler)).Methods("GET")
+	r.HandleFunc("/subscription/cancel", authenticate(idempotent(CancelSubscriptionHandler))).Methods("POST")
+	r.HandleFunc("/subscription/resume", authenticate(idempotent(ResumeSubscriptionHandler))).Methods("POST")
+	r.HandleFunc("/purchases/receipts", authenticate(idempotent(VerifyReceiptHandler))).Methods("POST")
+	r.HandleFunc("/promo-codes/redeem", authenticate(idempotent(RedeemPromoCodeHandler))).Methods("POST")
+	r.HandleFunc("/webhooks/payments", PaymentWebhookHandler).Methods("POST")
+	r.HandleFunc("/webhooks/appstore", StoreNotificationHandler(models.StoreApple)).Methods("POST")
+	r.HandleFunc("/webhooks/googleplay", StoreNotificationHandler(models.StoreGoogle)).Methods("POST")
+
+	// The admin API is for staff. Moderators work the report, appeal and
+	// verification queues and shadow-ban users; promo codes, refunds and roles
+	// are for admins only.
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(func(next http.Handler) http.Handler {
+		return authenticate(requireRole(models.RoleModerator, next.ServeHTTP))
+	})
+	admin.HandleFunc("/reports", ListReportsHandler).Methods("GET")
+	admin.HandleFunc("/reports/{id}", GetReportHandler).Methods("GET")
+	admin.HandleFunc("/reports/{id}/actions", idempotent(ReportActionHandler)).Methods("POST")
+	admin.HandleFunc("/users/{id}/shadow-ban", SetShadowBanHandler).Methods("PUT")
+	admin.HandleFunc("/appeals", ListAppealsHandler).Methods("GET")
+	admin.HandleFunc("/appeals/{id}/decision", idempotent(DecideAppealHandler)).Methods("POST")
+	admin.HandleFunc("/verifications", ListVerificationsHandler).Methods("GET")
+	admin.HandleFunc("/verifications/{id}/selfie", VerificationSelfieHandler).Methods("GET")
+	admin.HandleFunc("/verifications/{id}/review", idempotent(ReviewVerificationHandler)).Methods("POST")
+	admin.HandleFunc("/promo-codes", requireRole(models.RoleAdmin, CreatePromoCodeHandler)).Methods("POST")
+	admin.HandleFunc("/promo-codes", requireRole(models.RoleAdmin, ListPromoCodesHandler)).Methods("GET")
+	admin.HandleFunc("/purchases/{id}/refund", requireRole(models.RoleAdmin, idempotent(RefundPurchaseHandler))).Methods("POST")
+	admin.HandleFunc("/users/{id}/roles", requireRole(models.RoleAdmin, SetRolesHandler)).Methods("PUT")
+
+	// Lets local clients complete their payments on the fake gateway
+	if devEndpoints {
+		r.HandleFunc("/dev/payments/{intentID}/confirm", authenticate(ConfirmFakePaymentHandler)).Methods("POST")
+	}
 
 	// Serve static Swagger JSON file
 	r.HandleFunc("/swagger/doc.json", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "docs/swagger.json")
 	})
-
-	http.Handle("/", r)
-	log.Println("Server started on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	return r
 }
 
 // @Summary Signup a new user
@@ -100,13 +363,14 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary User Login
-// @Description Logs in a user and returns a token
+// @Description Logs in a user and returns a token. Suspended and banned users are turned away with 403 and can file an appeal instead.
 // @Tags User Login
 // @Accept  json
 // @Produce  json
 // @Param username body string true "Username"
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Router /login [post]
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var request struct {
@@ -131,6 +395,12 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Suspended and banned users can only file an appeal
+	if err := service.Restriction(user.Account, time.Now()); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusForbidden)
+		return
+	}
+
 	// Generate JWT token
 	token, err := generateJWT(user)
 	if err != nil {
@@ -143,20 +413,22 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary Swipe action
-// @Description Records a swipe action from a user
+// @Description Records a swipe action from the authenticated user. A right swipe on someone who already swiped right on the user makes a match: its matchID is returned and both users are notified. Swipes between users who blocked one another are rejected with 403.
 // @Tags Swipe Action
 // @Accept  json
 // @Produce  json
-// @Param userID body string true "User ID"
+// @Param Authorization header string true "Bearer token"
 // @Param targetID body string true "Target User ID"
 // @Param action body string true "Swipe action (left or right)"
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /swipe [post]
 func SwipeHandler(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
 	var request struct {
-		UserID   string `json:"userID"`
 		TargetID string `json:"targetID"`
 		Action   string `json:"action"`
 	}
@@ -166,7 +438,7 @@ func SwipeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if request.UserID == "" || request.TargetID == "" || request.Action == "" {
+	if request.TargetID == "" || request.Action == "" {
 		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
 		return
 	}
@@ -176,29 +448,104 @@ func SwipeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := userService.Swipe(request.UserID, request.TargetID, request.Action); err != nil {
+	match, err := userService.Swipe(userID, request.TargetID, request.Action)
+	if errors.Is(err, service.ErrBlocked) {
+		http.Error(w, `{"error": "User is blocked"}`, http.StatusForbidden)
+		return
+	}
+	if err != nil {
 		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
 		return
 	}
+	notifyMatch(match)
+	botDetector.Observe(userID)
+
+	response := map[string]string{"message": "Swipe action recorded"}
+	if match != nil {
+		response["matchID"] = match.ID
+	}
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Swipe action recorded"})
+	json.NewEncoder(w).Encode(response)
+}
+
+// maxSwipeBatchSize bounds how many queued swipes a client can replay at once
+const maxSwipeBatchSize = 100
+
+// @Summary Batch swipe action
+// @Description Replays swipes the authenticated user queued while offline. Every item carries a client-generated idempotency key, so resubmitting a batch never counts a swipe twice.
+// @Tags Swipe Action
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param swipes body []models.BatchSwipe true "Queued swipes in the order they were made"
+// @Success 200 {object} map[string][]models.BatchSwipeResult
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /swipes/batch [post]
+func SwipeBatchHandler(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+	var request struct {
+		Swipes []models.BatchSwipe `json:"swipes"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	if len(request.Swipes) == 0 || len(request.Swipes) > maxSwipeBatchSize {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	for _, item := range request.Swipes {
+		if item.IdempotencyKey == "" || item.TargetID == "" {
+			http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+			return
+		}
+		if item.Action != "left" && item.Action != "right" {
+			http.Error(w, `{"error": "Invalid action"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, err := userService.SwipeBatch(userID, request.Swipes)
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	for _, result := range results {
+		notifyMatch(result.Match)
+	}
+	botDetector.Observe(userID)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]models.BatchSwipeResult{"results": results})
 }
 
 // @Summary Purchase Premium
-// @Description Allows a user to purchase a premium package
+// @Description Places a pending order for a catalog product for the authenticated user and creates a payment intent for it. Entitlements are granted when the payment gateway confirms the payment. An optional percent_off promo code discounts the order; a fully discounted order completes immediately with 200.
 // @Tags Payments
 // @Accept  json
 // @Produce  json
-// @Param userID body string true "User ID"
-// @Param purchaseType body string true "Purchase type (remove_quota or add_verified)"
+// @Param Authorization header string true "Bearer token"
+// @Param purchaseType body string true "Product SKU (e.g. swipes_10, boost_1 or premium_monthly)"
+// @Param promoCode body string false "Promo code to apply at checkout"
+// @Param Idempotency-Key header string false "Client-generated key that makes retries safe"
 // @Success 200 {object} map[string]string
+// @Success 202 {object} map[string]string
 // @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 422 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Failure 502 {object} map[string]string
 // @Router /purchase [post]
 func PurchaseHandler(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
 	var request struct {
-		UserID       string `json:"userID"`
 		PurchaseType string `json:"purchaseType"`
+		PromoCode    string `json:"promoCode"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -206,47 +553,101 @@ func PurchaseHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if request.UserID == "" || request.PurchaseType == "" {
+	if request.PurchaseType == "" {
 		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
 		return
 	}
 
-	if request.PurchaseType != "remove_quota" && request.PurchaseType != "add_verified" {
+	product, err := purchaseService.GetProduct(request.PurchaseType)
+	if errors.Is(err, service.ErrProductNotFound) || (err == nil && !product.Active) {
 		http.Error(w, `{"error": "Invalid purchase type"}`, http.StatusBadRequest)
 		return
 	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
 
-	if request.PurchaseType == "remove_quota" {
-		if err := userService.RemoveSwipeQuota(request.UserID); err != nil {
-			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+	var promo *models.PromoCode
+	if request.PromoCode != "" {
+		code, err := promoService.GetPromoCode(request.PromoCode)
+		if err == nil {
+			err = service.CheckPromoCode(code, product.SKU, time.Now())
+		}
+		if err != nil {
+			writePromoError(w, err)
 			return
 		}
-	} else if request.PurchaseType == "add_verified" {
-		if err := userService.AddVerifiedLabel(request.UserID); err != nil {
+		promo = &code
+	}
+
+	purchase, err := purchaseService.CreatePurchase(userID, product, promo)
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if promo != nil {
+		if _, err := promoService.Redeem(promo.Code, userID, &purchase, time.Now()); err != nil {
+			failPurchase(purchase.ID)
+			writePromoError(w, err)
+			return
+		}
+	}
+
+	// A fully discounted order has nothing to collect
+	if purchase.Amount == 0 {
+		if _, err := purchaseService.FulfilPurchase(purchase.ID, time.Now()); err != nil {
 			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
 			return
 		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Purchase completed", "purchaseID": purchase.ID})
+		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Purchase action completed"})
+	// Entitlements are granted by PaymentWebhookHandler once the gateway
+	// reports the payment as succeeded
+	intent, err := paymentGateway.CreateIntent(purchase.Amount, purchase.Currency, map[string]string{"purchaseID": purchase.ID})
+	if err != nil {
+		failPurchase(purchase.ID)
+		http.Error(w, `{"error": "Payment gateway error"}`, http.StatusBadGateway)
+		return
+	}
+
+	if err := purchaseService.SetPaymentIntent(purchase.ID, intent.ID); err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":         "Payment required",
+		"purchaseID":      purchase.ID,
+		"paymentIntentID": intent.ID,
+		"clientSecret":    intent.ClientSecret,
+	})
 }
 
 func generateJWT(user models.User) (string, error) {
 	// Define token expiration time
 	expirationTime := time.Now().Add(24 * time.Hour)
 
-	// Create the JWT claims, which includes the username and expiry time
-	claims := &jwt.StandardClaims{
-		Subject:   user.ID,
-		ExpiresAt: expirationTime.Unix(),
+	// Create the JWT claims, which includes the user ID, staff roles, token version and expiry time
+	claims := &tokenClaims{
+		Roles:   user.Roles,
+		Version: user.TokenVersion,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   user.ID,
+			ExpiresAt: expirationTime.Unix(),
+		},
 	}
 
 	// Create the token
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	// Sign the token with a secret key
-	tokenString, err := token.SignedString([]byte("your_secret_key"))
+	tokenString, err := token.SignedString(jwtSecret)
 	if err != nil {
 		return "", err
 	}
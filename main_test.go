@@ -2,12 +2,17 @@ package main
 
 import (
 	"bytes"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
+	"time"
 
 	"dating-app/models"
+	"dating-app/service"
 
 	"github.com/stretchr/testify/mock"
 )
@@ -26,23 +31,90 @@ func (m *MockUserService) ValidateUser(username, password string) (models.User,
 	return args.Get(0).(models.User), args.Error(1)
 }
 
-func (m *MockUserService) Swipe(userID, targetID, action string) error {
-	args := m.Called(userID, targetID, action)
+func (m *MockUserService) ScheduleDeletion(userID string, deleteAt time.Time) (time.Time, error) {
+	args := m.Called(userID, deleteAt)
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+func (m *MockUserService) CancelDeletion(userID string) error {
+	args := m.Called(userID)
 	return args.Error(0)
 }
 
+func (m *MockUserService) DueDeletions(now time.Time, limit int) ([]string, error) {
+	args := m.Called(now, limit)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockUserService) Delete(userID string, now time.Time) ([]string, error) {
+	args := m.Called(userID, now)
+	keys, _ := args.Get(0).([]string)
+	return keys, args.Error(1)
+}
+
+func (m *MockUserService) Swipe(userID, targetID, action string) (*models.Match, error) {
+	args := m.Called(userID, targetID, action)
+	match, _ := args.Get(0).(*models.Match)
+	return match, args.Error(1)
+}
+
+func (m *MockUserService) SwipeBatch(userID string, swipes []models.BatchSwipe) ([]models.BatchSwipeResult, error) {
+	args := m.Called(userID, swipes)
+	return args.Get(0).([]models.BatchSwipeResult), args.Error(1)
+}
+
 func (m *MockUserService) PurchasePremium(userID string) error {
 	args := m.Called(userID)
 	return args.Error(0)
 }
 
-func (m *MockUserService) AddVerifiedLabel(userID string) error {
+func (m *MockUserService) RevokePremium(userID string) error {
 	args := m.Called(userID)
 	return args.Error(0)
 }
 
-func (m *MockUserService) RemoveSwipeQuota(userID string) error {
+func (m *MockUserService) AddExtraSwipes(userID string, count int) error {
+	args := m.Called(userID, count)
+	return args.Error(0)
+}
+
+func (m *MockUserService) AddBoostCredits(userID string, count int) error {
+	args := m.Called(userID, count)
+	return args.Error(0)
+}
+
+func (m *MockUserService) ActivateBoost(userID string, now time.Time) (models.Boost, error) {
+	args := m.Called(userID, now)
+	return args.Get(0).(models.Boost), args.Error(1)
+}
+
+func (m *MockUserService) GetBalances(userID string, now time.Time) (models.Balances, error) {
+	args := m.Called(userID, now)
+	return args.Get(0).(models.Balances), args.Error(1)
+}
+
+func (m *MockUserService) GetVisibility(userID string) (string, error) {
 	args := m.Called(userID)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockUserService) SetVisibility(userID, visibility string) error {
+	args := m.Called(userID, visibility)
+	return args.Error(0)
+}
+
+func (m *MockUserService) RecordSeen(userID string, now time.Time) (bool, error) {
+	args := m.Called(userID, now)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserService) GetPresenceVisible(userID string) (bool, error) {
+	args := m.Called(userID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserService) SetPresenceVisible(userID string, visible bool) error {
+	args := m.Called(userID, visible)
 	return args.Error(0)
 }
 
@@ -83,6 +155,23 @@ func TestLoginHandler(t *testing.T) {
 			},
 			expectValidateUserCall: true,
 		},
+		{
+			name: "Banned user",
+			requestBody: map[string]string{
+				"username": "banneduser",
+				"password": "testpassword",
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   map[string]string{"error": "account banned"},
+			mockReturn: struct {
+				user models.User
+				err  error
+			}{
+				user: models.User{Username: "banneduser", Account: models.Account{Status: models.AccountStatusBanned}},
+				err:  nil,
+			},
+			expectValidateUserCall: true,
+		},
 		{
 			name: "Invalid request payload",
 			requestBody: map[string]string{
@@ -216,11 +305,11 @@ func TestSwipeHandler(t *testing.T) {
 		expectedBody    map[string]string
 		mockReturn      error
 		expectSwipeCall bool
+		unauthenticated bool
 	}{
 		{
 			name: "Successful swipe",
 			requestBody: map[string]string{
-				"userID":   "user1",
 				"targetID": "target1",
 				"action":   "right",
 			},
@@ -232,29 +321,50 @@ func TestSwipeHandler(t *testing.T) {
 		{
 			name: "Invalid request payload",
 			requestBody: map[string]string{
-				"userID": "user1",
+				"targetID": "target1",
 			},
 			expectedStatus:  http.StatusBadRequest,
 			expectedBody:    map[string]string{"error": "Invalid request payload"},
 			mockReturn:      nil,
 			expectSwipeCall: false,
 		},
+		{
+			name: "Blocked target",
+			requestBody: map[string]string{
+				"targetID": "target2",
+				"action":   "right",
+			},
+			expectedStatus:  http.StatusForbidden,
+			expectedBody:    map[string]string{"error": "User is blocked"},
+			mockReturn:      service.ErrBlocked,
+			expectSwipeCall: true,
+		},
+		{
+			name: "Unauthenticated",
+			requestBody: map[string]string{
+				"targetID": "target1",
+				"action":   "right",
+			},
+			expectedStatus:  http.StatusUnauthorized,
+			expectedBody:    map[string]string{"error": "Unauthorized"},
+			unauthenticated: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if tt.expectSwipeCall {
-				mockUserService.On("Swipe", tt.requestBody["userID"], tt.requestBody["targetID"], tt.requestBody["action"]).Return(tt.mockReturn)
+				mockUserService.On("Swipe", "user1", tt.requestBody["targetID"], tt.requestBody["action"]).Return(nil, tt.mockReturn)
 			}
 
 			body, _ := json.Marshal(tt.requestBody)
-			req, err := http.NewRequest("POST", "/swipe", bytes.NewBuffer(body))
-			if err != nil {
-				t.Fatal(err)
+			req := authorizedRequest(t, "POST", "/swipe", "user1", body)
+			if tt.unauthenticated {
+				req.Header.Del("Authorization")
 			}
 
 			rr := httptest.NewRecorder()
-			handler := http.HandlerFunc(SwipeHandler)
+			handler := authenticate(SwipeHandler)
 			handler.ServeHTTP(rr, req)
 
 			if status := rr.Code; status != tt.expectedStatus {
@@ -277,9 +387,113 @@ func TestSwipeHandler(t *testing.T) {
 	}
 }
 
+func TestSwipeBatchHandler(t *testing.T) {
+	mockUserService := new(MockUserService)
+	userService = mockUserService
+
+	timestamp := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	swipes := []models.BatchSwipe{
+		{IdempotencyKey: "key1", TargetID: "target1", Action: "right", Timestamp: timestamp},
+		{IdempotencyKey: "key2", TargetID: "target2", Action: "left", Timestamp: timestamp},
+	}
+
+	tests := []struct {
+		name                 string
+		requestBody          map[string]interface{}
+		expectedStatus       int
+		expectedResults      []models.BatchSwipeResult
+		expectedError        string
+		expectSwipeBatchCall bool
+	}{
+		{
+			name: "Successful batch with a replayed key",
+			requestBody: map[string]interface{}{
+				"swipes": swipes,
+			},
+			expectedStatus: http.StatusOK,
+			expectedResults: []models.BatchSwipeResult{
+				{IdempotencyKey: "key1", Status: models.SwipeStatusRecorded},
+				{IdempotencyKey: "key2", Status: models.SwipeStatusRejected, Error: "daily swipe limit reached", Replayed: true},
+			},
+			expectSwipeBatchCall: true,
+		},
+		{
+			name: "Missing idempotency key",
+			requestBody: map[string]interface{}{
+				"swipes": []models.BatchSwipe{{TargetID: "target1", Action: "right"}},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid request payload",
+		},
+		{
+			name: "Invalid action",
+			requestBody: map[string]interface{}{
+				"swipes": []models.BatchSwipe{{IdempotencyKey: "key1", TargetID: "target1", Action: "up"}},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid action",
+		},
+		{
+			name: "Empty batch",
+			requestBody: map[string]interface{}{
+				"swipes": []models.BatchSwipe{},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid request payload",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.expectSwipeBatchCall {
+				mockUserService.On("SwipeBatch", "user1", swipes).Return(tt.expectedResults, nil)
+			}
+
+			body, _ := json.Marshal(tt.requestBody)
+			req := authorizedRequest(t, "POST", "/swipes/batch", "user1", body)
+
+			rr := httptest.NewRecorder()
+			handler := authenticate(SwipeBatchHandler)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			if tt.expectedError != "" {
+				var responseBody map[string]string
+				if err := json.NewDecoder(rr.Body).Decode(&responseBody); err != nil {
+					t.Fatal(err)
+				}
+				if responseBody["error"] != tt.expectedError {
+					t.Errorf("handler returned unexpected body: got %v want %v", responseBody, tt.expectedError)
+				}
+			} else {
+				var responseBody map[string][]models.BatchSwipeResult
+				if err := json.NewDecoder(rr.Body).Decode(&responseBody); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(responseBody["results"], tt.expectedResults) {
+					t.Errorf("handler returned unexpected body: got %v want %v", responseBody["results"], tt.expectedResults)
+				}
+			}
+
+			mockUserService.AssertExpectations(t)
+		})
+	}
+}
+
 func TestPurchaseHandler(t *testing.T) {
 	mockUserService := new(MockUserService)
 	userService = mockUserService
+	mockPurchaseService := new(MockPurchaseService)
+	purchaseService = mockPurchaseService
+	paymentGateway = &service.FakePaymentGateway{}
+
+	products := map[string]models.Product{
+		"swipes_10": {SKU: "swipes_10", Price: 199, Currency: "USD", Entitlements: []string{models.EntitlementSwipes}, Quantity: 10, Active: true},
+		"boost_1":   {SKU: "boost_1", Price: 299, Currency: "USD", Entitlements: []string{models.EntitlementBoost}, Quantity: 1, Active: true},
+	}
 
 	tests := []struct {
 		name           string
@@ -289,54 +503,59 @@ func TestPurchaseHandler(t *testing.T) {
 		mockReturn     error
 	}{
 		{
-			name: "Successful purchase - remove quota",
+			name: "Successful purchase - swipe pack",
 			requestBody: map[string]string{
-				"userID":       "user1",
-				"purchaseType": "remove_quota",
+				"purchaseType": "swipes_10",
 			},
-			expectedStatus: http.StatusOK,
-			expectedBody:   map[string]string{"message": "Purchase action completed"},
+			expectedStatus: http.StatusAccepted,
+			expectedBody:   map[string]string{"message": "Payment required"},
 			mockReturn:     nil,
 		},
 		{
-			name: "Successful purchase - add verified",
+			name: "Successful purchase - boost",
 			requestBody: map[string]string{
-				"userID":       "user1",
-				"purchaseType": "add_verified",
+				"purchaseType": "boost_1",
 			},
-			expectedStatus: http.StatusOK,
-			expectedBody:   map[string]string{"message": "Purchase action completed"},
+			expectedStatus: http.StatusAccepted,
+			expectedBody:   map[string]string{"message": "Payment required"},
 			mockReturn:     nil,
 		},
 		{
 			name: "Invalid request payload",
 			requestBody: map[string]string{
-				"userID": "user1",
+				"promoCode": "",
 			},
 			expectedStatus: http.StatusBadRequest,
 			expectedBody:   map[string]string{"error": "Invalid request payload"},
 			mockReturn:     nil,
 		},
+		{
+			name: "Unknown purchase type",
+			requestBody: map[string]string{
+				"purchaseType": "unknown",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"error": "Invalid purchase type"},
+			mockReturn:     service.ErrProductNotFound,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if tt.mockReturn == nil {
-				if tt.requestBody["purchaseType"] == "remove_quota" {
-					mockUserService.On("RemoveSwipeQuota", tt.requestBody["userID"]).Return(tt.mockReturn)
-				} else if tt.requestBody["purchaseType"] == "add_verified" {
-					mockUserService.On("AddVerifiedLabel", tt.requestBody["userID"]).Return(tt.mockReturn)
-				}
+			if product, ok := products[tt.requestBody["purchaseType"]]; ok {
+				purchase := models.Purchase{ID: "purchase-" + product.SKU, UserID: "user1", SKU: product.SKU, Amount: product.Price, Currency: product.Currency, Status: models.PurchaseStatusPending}
+				mockPurchaseService.On("GetProduct", product.SKU).Return(product, nil)
+				mockPurchaseService.On("CreatePurchase", "user1", product, (*models.PromoCode)(nil)).Return(purchase, nil)
+				mockPurchaseService.On("SetPaymentIntent", purchase.ID, mock.AnythingOfType("string")).Return(nil)
+			} else if tt.mockReturn != nil {
+				mockPurchaseService.On("GetProduct", tt.requestBody["purchaseType"]).Return(models.Product{}, tt.mockReturn)
 			}
 
 			body, _ := json.Marshal(tt.requestBody)
-			req, err := http.NewRequest("POST", "/purchase", bytes.NewBuffer(body))
-			if err != nil {
-				t.Fatal(err)
-			}
+			req := authorizedRequest(t, "POST", "/purchase", "user1", body)
 
 			rr := httptest.NewRecorder()
-			handler := http.HandlerFunc(PurchaseHandler)
+			handler := authenticate(PurchaseHandler)
 			handler.ServeHTTP(rr, req)
 
 			if status := rr.Code; status != tt.expectedStatus {
@@ -355,6 +574,84 @@ func TestPurchaseHandler(t *testing.T) {
 			}
 
 			mockUserService.AssertExpectations(t)
+			mockPurchaseService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSwipeQuota(t *testing.T) {
+	userColumns := []string{"swipes", "extra_swipes", "last_swipe"}
+	recently := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name    string
+		script  func(*sqlScript)
+		wantErr error
+	}{
+		{
+			name: "Within the quota",
+			script: func(script *sqlScript) {
+				script.query("FROM users WHERE id=$1 FOR UPDATE", userColumns, [][]driver.Value{{int64(3), int64(0), recently}}, "user1")
+				script.query("SELECT NOT", []string{"blocked"}, [][]driver.Value{{false}}, "user1", "user2")
+				script.query("SELECT COUNT(*) FROM swipes", []string{"count"}, [][]driver.Value{{int64(0)}})
+				script.exec("UPDATE users SET swipes=$1", 1, 4, anyArg, "user1")
+				// The server's receipt time is kept next to the swipe's own
+				script.exec("INSERT INTO swipes (user_id, target_id, action, created_at, received_at) VALUES ($1, $2, $3, $4, NOW())", 1, "user1", "user2", "left", anyArg)
+				script.commit()
+			},
+		},
+		{
+			name: "Quota used up draws on extra swipes",
+			script: func(script *sqlScript) {
+				script.query("FROM users WHERE id=$1 FOR UPDATE", userColumns, [][]driver.Value{{int64(service.DailySwipeQuota), int64(2), recently}}, "user1")
+				script.query("SELECT NOT", []string{"blocked"}, [][]driver.Value{{false}})
+				script.query("SELECT COUNT(*) FROM swipes", []string{"count"}, [][]driver.Value{{int64(0)}})
+				script.exec("UPDATE users SET extra_swipes=extra_swipes-1", 1, anyArg, "user1")
+				script.exec("INSERT INTO swipes", 1)
+				script.commit()
+			},
+		},
+		{
+			name: "Quota and extra swipes used up",
+			script: func(script *sqlScript) {
+				script.query("FROM users WHERE id=$1 FOR UPDATE", userColumns, [][]driver.Value{{int64(service.DailySwipeQuota), int64(0), recently}}, "user1")
+				script.query("SELECT NOT", []string{"blocked"}, [][]driver.Value{{false}})
+				script.rollback()
+			},
+			wantErr: service.ErrDailySwipeLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, script := newSQLScript(t)
+			script.begin()
+			tt.script(script)
+
+			users := &service.UserServiceImpl{DB: db}
+			if _, err := users.Swipe("user1", "user2", "left"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Swipe() error = %v, want %v", err, tt.wantErr)
+			}
 		})
 	}
 }
+
+func TestNewBotThresholds(t *testing.T) {
+	t.Setenv("BOT_SCORE_WINDOW", "30m")
+	t.Setenv("BOT_REVIEW_SCORE", "0.5")
+	t.Setenv("BOT_SHADOW_BAN_SCORE", "1.1")
+
+	thresholds, err := newBotThresholds()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := service.BotThresholds{Window: 30 * time.Minute, Review: 0.5, ShadowBan: 1.1}
+	if thresholds != expected {
+		t.Errorf("got thresholds %+v want %+v", thresholds, expected)
+	}
+
+	t.Setenv("BOT_SHADOW_BAN_SCORE", "0.4")
+	if _, err := newBotThresholds(); err == nil {
+		t.Error("expected an error for a shadow-ban score below the review score")
+	}
+}
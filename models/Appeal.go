@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Appeal statuses
+const (
+	AppealStatusPending = "pending"
+	AppealStatusUpheld  = "upheld"
+	AppealStatusLifted  = "lifted"
+)
+
+// Appeal decisions a moderator can make
+const (
+	AppealDecisionUphold = "uphold"
+	AppealDecisionLift   = "lift"
+)
+
+// Appeal is a suspended or banned user's request to have their account
+// restored. AccountStatus is the restriction being appealed.
+type Appeal struct {
+	ID            string     `json:"id"`
+	UserID        string     `json:"userID"`
+	AccountStatus string     `json:"accountStatus"`
+	Message       string     `json:"message"`
+	Status        string     `json:"status"`
+	Response      string     `json:"response,omitempty"`
+	DecidedBy     string     `json:"decidedBy,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	DecidedAt     *time.Time `json:"decidedAt,omitempty"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Audited actions
+const (
+	AuditActionPurchaseRefunded     = "purchase.refunded"
+	AuditActionVerificationApproved = "verification.approved"
+	AuditActionVerificationRejected = "verification.rejected"
+	AuditActionVerificationRevoked  = "verification.revoked"
+	AuditActionUserWarned           = "user.warned"
+	AuditActionUserSuspended        = "user.suspended"
+	AuditActionUserBanned           = "user.banned"
+	AuditActionUserRolesChanged     = "user.roles_changed"
+	AuditActionUserDeleted          = "user.deleted"
+	AuditActionUserShadowBanned     = "user.shadow_banned"
+	AuditActionUserShadowBanLifted  = "user.shadow_ban_lifted"
+	AuditActionReportDismissed      = "report.dismissed"
+	AuditActionAppealUpheld         = "appeal.upheld"
+	AuditActionAppealLifted         = "appeal.lifted"
+)
+
+// AuditEntry records an action taken on behalf of a user, an admin or an
+// external system. Actor identifies who took the action, e.g. "admin:42" for
+// the staff member with user ID 42 or the name of the payment gateway or store
+// that reported it.
+type AuditEntry struct {
+	ID         string            `json:"id"`
+	Actor      string            `json:"actor"`
+	Action     string            `json:"action"`
+	TargetType string            `json:"targetType"`
+	TargetID   string            `json:"targetID"`
+	Details    map[string]string `json:"details,omitempty"`
+	CreatedAt  time.Time         `json:"createdAt"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Boost is a window during which a user is ranked first in other users' feeds
+type Boost struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userID"`
+	StartedAt time.Time `json:"startedAt"`
+	EndsAt    time.Time `json:"endsAt"`
+}
+
+// Balances are a user's remaining consumables
+type Balances struct {
+	SwipesLeftToday  int        `json:"swipesLeftToday"`
+	ExtraSwipes      int        `json:"extraSwipes"`
+	BoostCredits     int        `json:"boostCredits"`
+	BoostActiveUntil *time.Time `json:"boostActiveUntil,omitempty"`
+}
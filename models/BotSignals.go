@@ -0,0 +1,12 @@
+package models
+
+// BotSignals summarizes a user's recent activity for bot detection
+type BotSignals struct {
+	Swipes      int `json:"swipes"`
+	RightSwipes int `json:"rightSwipes"`
+	// MedianSwipeGap is the median number of seconds between swipes
+	MedianSwipeGap float64 `json:"medianSwipeGap"`
+	// DuplicateMessages counts messages whose text the user had already sent
+	// in another match
+	DuplicateMessages int `json:"duplicateMessages"`
+}
@@ -0,0 +1,68 @@
+package models
+
+import "time"
+
+// Real-time event types
+const (
+	EventMatch     = "match"
+	EventMessage   = "message"
+	EventUnmatched = "unmatched"
+	EventReceipt   = "receipt"
+	EventTyping    = "typing"
+	EventPresence  = "presence"
+	// EventSignedOut is the last event of a connection closed because the
+	// account was suspended, banned or deleted
+	EventSignedOut = "signed_out"
+)
+
+// Event is pushed to a user's open real-time connections
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// Receipt statuses, in the order a message goes through them
+const (
+	ReceiptDelivered = "delivered"
+	ReceiptRead      = "read"
+)
+
+// Receipt tells the sender that the recipient's device got, or the recipient
+// read, every message of a match up to and including UpToMessageID
+type Receipt struct {
+	MatchID string `json:"matchID"`
+	// UserID is the recipient sending the receipt, SenderID the user whose
+	// messages it covers
+	UserID        string    `json:"userID"`
+	SenderID      string    `json:"senderID"`
+	Status        string    `json:"status"`
+	UpToMessageID string    `json:"upToMessageID"`
+	At            time.Time `json:"at"`
+	// Hidden is set when the user sending the receipt is shadow-banned. The
+	// messages are still marked, but like their own messages the receipt is
+	// never sent to the other user.
+	Hidden bool `json:"-"`
+}
+
+// Typing tells a match that the other user is typing. It is never stored.
+type Typing struct {
+	MatchID string `json:"matchID"`
+	UserID  string `json:"userID"`
+	// RecipientID is the other user of the match. Hidden is set when the
+	// typing user is shadow-banned, so the recipient isn't told.
+	RecipientID string `json:"-"`
+	Hidden      bool   `json:"-"`
+}
+
+// Presence statuses
+const (
+	PresenceOnline         = "online"
+	PresenceRecentlyActive = "recently_active"
+	PresenceOffline        = "offline"
+)
+
+// Presence is whether a user is around to chat
+type Presence struct {
+	UserID string `json:"userID"`
+	Status string `json:"status"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Export statuses
+const (
+	ExportStatusPending = "pending"
+	ExportStatusReady   = "ready"
+	ExportStatusFailed  = "failed"
+	ExportStatusExpired = "expired"
+)
+
+// Export is a user's request for a copy of their personal data. Once the
+// archive is built it can be downloaded through DownloadURL until ExpiresAt.
+type Export struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"userID"`
+	Status      string     `json:"status"`
+	DownloadURL string     `json:"downloadURL,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ReadyAt     *time.Time `json:"readyAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	// Token is the secret in the download link
+	Token      string `json:"-"`
+	StorageKey string `json:"-"`
+	Attempts   int    `json:"-"`
+}
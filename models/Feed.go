@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// FeedProfile is a profile shown in another user's feed. The ranking features
+// stay server-side so clients can't tell why a profile was ranked where it was.
+type FeedProfile struct {
+	UserID   string `json:"userID"`
+	Username string `json:"username"`
+	// BoostedUntil is the end of the profile's active boost, if any
+	BoostedUntil *time.Time `json:"-"`
+	LastActive   time.Time  `json:"-"`
+	// SharedTags is how many interest tags the profile shares with the viewer
+	SharedTags int `json:"-"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// IdempotencyRecord is a stored request made with an Idempotency-Key header.
+// StatusCode is zero while the original request is still being processed.
+type IdempotencyRecord struct {
+	Scope       string
+	Key         string
+	Fingerprint string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+}
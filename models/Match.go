@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// Match is a pair of users who swiped right on each other. Its conversation
+// is closed once either of them unmatches.
+type Match struct {
+	ID          string     `json:"id"`
+	UserIDs     []string   `json:"userIDs"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UnmatchedAt *time.Time `json:"unmatchedAt,omitempty"`
+	// Presence is the other user's presence as seen by the viewer; it is
+	// empty when they hide it
+	Presence string `json:"presence,omitempty"`
+}
+
+// Message is a chat message sent within a match
+type Message struct {
+	ID          string    `json:"id"`
+	MatchID     string    `json:"matchID"`
+	SenderID    string    `json:"senderID"`
+	RecipientID string    `json:"recipientID"`
+	Body        string    `json:"body"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// DeliveredAt and ReadAt are set from the recipient's receipts
+	DeliveredAt *time.Time `json:"deliveredAt,omitempty"`
+	ReadAt      *time.Time `json:"readAt,omitempty"`
+	// Hidden is set on messages sent by a shadow-banned user. Only the sender
+	// sees them, and the flag itself is never sent to clients.
+	Hidden bool `json:"-"`
+}
+
+// MessagePage is a page of a conversation, newest first. NextCursor fetches
+// the older messages and is empty on the last page.
+type MessagePage struct {
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"nextCursor,omitempty"`
+}
+
+// OtherUser returns the ID of the user matched with userID
+func (m Match) OtherUser(userID string) string {
+	for _, id := range m.UserIDs {
+		if id != userID {
+			return id
+		}
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// Device platforms, each delivered through its own push provider
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+)
+
+// Device is a phone registered to receive push notifications
+type Device struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userID"`
+	Platform  string    `json:"platform"`
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Notification types. Users can turn off matches and messages; account
+// notices, such as those from the moderators or about a data export, are
+// always sent.
+const (
+	NotificationMatch   = "match"
+	NotificationMessage = "message"
+	NotificationAccount = "account"
+)
+
+// Notification is a push notification. Data is passed to the app, which uses
+// it to open the right screen.
+type Notification struct {
+	Type  string            `json:"type"`
+	Title string            `json:"title"`
+	Body  string            `json:"body"`
+	Data  map[string]string `json:"data,omitempty"`
+}
+
+// NotificationPreferences are the notification types a user wants pushed
+type NotificationPreferences struct {
+	Matches  bool `json:"matches"`
+	Messages bool `json:"messages"`
+}
+
+// Push delivery statuses
+const (
+	PushStatusPending   = "pending"
+	PushStatusDelivered = "delivered"
+	PushStatusFailed    = "failed"
+)
+
+// PushDelivery is a notification queued for one device
+type PushDelivery struct {
+	ID           string
+	DeviceID     string
+	Platform     string
+	Token        string
+	Notification Notification
+	Attempts     int
+}
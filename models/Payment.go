@@ -0,0 +1,38 @@
+package models
+
+// Payment intent statuses reported by a payment gateway
+const (
+	PaymentStatusRequiresPayment = "requires_payment"
+	PaymentStatusSucceeded       = "succeeded"
+	PaymentStatusFailed          = "failed"
+	PaymentStatusRefunded        = "refunded"
+)
+
+// Payment event types delivered to the payments webhook
+const (
+	PaymentEventSucceeded = "payment.succeeded"
+	PaymentEventFailed    = "payment.failed"
+	// PaymentEventRefunded carries the refunded amount, which may be less
+	// than the intent's amount for a partial refund, and the refund's ID
+	PaymentEventRefunded = "payment.refunded"
+)
+
+// PaymentIntent is a gateway-side request to collect Amount from the customer.
+// ClientSecret is handed to the app so it can complete the payment with the gateway.
+type PaymentIntent struct {
+	ID           string `json:"id"`
+	ClientSecret string `json:"clientSecret"`
+	Amount       int64  `json:"amount"`
+	Currency     string `json:"currency"`
+	Status       string `json:"status"`
+}
+
+// PaymentEvent is the body of a payments webhook call
+type PaymentEvent struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	IntentID string `json:"intentID"`
+	RefundID string `json:"refundID,omitempty"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Sizes every uploaded photo is stored in
+const (
+	PhotoSizeLarge     = "large"
+	PhotoSizeMedium    = "medium"
+	PhotoSizeThumbnail = "thumbnail"
+)
+
+// Photo is a profile photo. Photos are shown in ascending Position, the first
+// one being the main photo; URLs maps each stored size to where it is served.
+type Photo struct {
+	ID         string            `json:"id"`
+	UserID     string            `json:"userID"`
+	Position   int               `json:"position"`
+	Width      int               `json:"width"`
+	Height     int               `json:"height"`
+	URLs       map[string]string `json:"urls"`
+	StorageKey string            `json:"-"`
+	CreatedAt  time.Time         `json:"createdAt"`
+}
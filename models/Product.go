@@ -0,0 +1,31 @@
+package models
+
+// Entitlements a product can grant to its buyer
+const (
+	EntitlementPremium = "premium"
+	// EntitlementVerified belongs to the retired add_verified product. The
+	// badge is now earned through selfie verification and grants nothing.
+	EntitlementVerified = "verified"
+	// EntitlementSwipes adds Quantity extra swipes, used once the daily quota
+	// is exhausted
+	EntitlementSwipes = "swipes"
+	// EntitlementBoost adds Quantity boosts the buyer can start at any time
+	EntitlementBoost = "boost"
+	// EntitlementSwipeReset belongs to the retired remove_quota product. Orders
+	// placed before it was retired are honored with a day's worth of extra swipes.
+	EntitlementSwipeReset = "swipe_reset"
+)
+
+// Product is an entry of the purchasable catalog. Price is in minor units of
+// Currency (e.g. cents) and DurationDays is 0 for entitlements that never expire.
+// Quantity is how many units a consumable product grants.
+type Product struct {
+	SKU          string   `json:"sku"`
+	Name         string   `json:"name"`
+	Price        int64    `json:"price"`
+	Currency     string   `json:"currency"`
+	Entitlements []string `json:"entitlements"`
+	DurationDays int      `json:"durationDays"`
+	Quantity     int      `json:"quantity"`
+	Active       bool     `json:"-"`
+}
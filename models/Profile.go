@@ -0,0 +1,72 @@
+package models
+
+import "time"
+
+// Genders a profile can declare
+const (
+	GenderWoman     = "woman"
+	GenderMan       = "man"
+	GenderNonBinary = "nonbinary"
+	GenderOther     = "other"
+)
+
+// Profile is the owner's view of their profile. Birthdate (YYYY-MM-DD) is
+// private; other users only see the age derived from it in PublicProfile.
+// Prompts are answers to catalog prompts in display order and Tags are IDs of
+// catalog interest tags.
+type Profile struct {
+	UserID      string         `json:"userID"`
+	DisplayName string         `json:"displayName"`
+	Bio         string         `json:"bio"`
+	Birthdate   string         `json:"birthdate"`
+	Gender      string         `json:"gender"`
+	Job         string         `json:"job"`
+	School      string         `json:"school"`
+	Interests   []string       `json:"interests"`
+	Prompts     []PromptAnswer `json:"prompts"`
+	Tags        []string       `json:"tags"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
+}
+
+// PublicProfile is what other users see of a profile
+type PublicProfile struct {
+	UserID      string         `json:"userID"`
+	DisplayName string         `json:"displayName"`
+	Bio         string         `json:"bio"`
+	Age         int            `json:"age"`
+	Gender      string         `json:"gender"`
+	Job         string         `json:"job,omitempty"`
+	School      string         `json:"school,omitempty"`
+	Interests   []string       `json:"interests"`
+	Prompts     []PromptAnswer `json:"prompts"`
+	Tags        []string       `json:"tags"`
+	Photos      []Photo        `json:"photos"`
+	Verified    bool           `json:"verified"`
+}
+
+// Prompt is a question from the catalog that users can answer on their profile
+type Prompt struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// Tag is an interest tag from the catalog
+type Tag struct {
+	ID       string `json:"id"`
+	Label    string `json:"label"`
+	Category string `json:"category"`
+}
+
+// PromptAnswer is a user's answer to a prompt. Text is the prompt's question,
+// filled in when the profile is read.
+type PromptAnswer struct {
+	PromptID string `json:"promptID"`
+	Text     string `json:"text"`
+	Answer   string `json:"answer"`
+}
+
+// ProfileCatalog lists the prompts and tags users can put on their profile
+type ProfileCatalog struct {
+	Prompts []Prompt `json:"prompts"`
+	Tags    []Tag    `json:"tags"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Promo code effects. A percent_off code discounts an order at checkout; the
+// other effects are gifts applied when the code is redeemed.
+const (
+	PromoEffectPercentOff      = "percent_off"
+	PromoEffectFreePremiumDays = "free_premium_days"
+	PromoEffectExtraSwipes     = "extra_swipes"
+)
+
+// PromoCode is a marketing code belonging to a campaign. Value is the
+// percentage, number of days or number of swipes depending on Effect.
+// SKU restricts a percent_off code to one product and names the premium
+// product granted by a free_premium_days code.
+type PromoCode struct {
+	Code     string `json:"code"`
+	Campaign string `json:"campaign"`
+	Effect   string `json:"effect"`
+	Value    int    `json:"value"`
+	SKU      string `json:"sku,omitempty"`
+	// MaxRedemptions is the total number of uses allowed, 0 for unlimited
+	MaxRedemptions int `json:"maxRedemptions"`
+	// MaxPerUser is how many times a single user may redeem the code
+	MaxPerUser  int       `json:"maxPerUser"`
+	Redemptions int       `json:"redemptions"`
+	ValidFrom   time.Time `json:"validFrom"`
+	ValidUntil  time.Time `json:"validUntil"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
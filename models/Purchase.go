@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// Purchase statuses. An order starts pending and moves to paid or failed;
+// only a paid order can be refunded, and it stays paid until refunds add up
+// to its whole amount.
+const (
+	PurchaseStatusPending  = "pending"
+	PurchaseStatusPaid     = "paid"
+	PurchaseStatusFailed   = "failed"
+	PurchaseStatusRefunded = "refunded"
+)
+
+// Purchase is a ledger entry for a single order. Amount and Currency are
+// copied from the product at order time so later price changes don't rewrite history.
+type Purchase struct {
+	ID       string `json:"id"`
+	UserID   string `json:"userID"`
+	SKU      string `json:"sku"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Status   string `json:"status"`
+	// PaymentIntentID is the gateway intent collecting the payment, if any
+	PaymentIntentID string `json:"paymentIntentID,omitempty"`
+	// Store and StoreTransactionID identify purchases made through an app store
+	Store              string `json:"store,omitempty"`
+	StoreTransactionID string `json:"storeTransactionID,omitempty"`
+	// PromoCode is the discount code applied at checkout, if any
+	PromoCode string `json:"promoCode,omitempty"`
+	// RefundedAmount is how much of Amount was given back so far; partial
+	// refunds leave it below Amount and the purchase paid
+	RefundedAmount int64     `json:"refundedAmount,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// Refund is money given back on a paid purchase. RefundID is the gateway's or
+// store's reference for it, so a refund reported twice, e.g. by the admin who
+// made it and by the gateway's webhook, is applied once.
+type Refund struct {
+	PurchaseID string
+	RefundID   string
+	Amount     int64
+	// Actor and Reason are recorded in the audit log
+	Actor  string
+	Reason string
+}
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// App stores that sell in-app purchases
+const (
+	StoreApple  = "apple"
+	StoreGoogle = "google"
+)
+
+// Store notification types, normalized across stores
+const (
+	StoreNotificationRenewed          = "renewed"
+	StoreNotificationRenewalFailed    = "renewal_failed"
+	StoreNotificationAutoRenewChanged = "auto_renew_changed"
+	StoreNotificationExpired          = "expired"
+	StoreNotificationRefunded         = "refunded"
+	StoreNotificationIgnored          = "ignored"
+)
+
+// StoreTransaction is a purchase as verified with an app store.
+// TransactionID identifies a single charge (a renewal gets a new one) while
+// OriginalTransactionID stays the same for the life of a subscription.
+type StoreTransaction struct {
+	Store                 string     `json:"store"`
+	TransactionID         string     `json:"transactionID"`
+	OriginalTransactionID string     `json:"originalTransactionID"`
+	ProductID             string     `json:"productID"`
+	PurchasedAt           time.Time  `json:"purchasedAt"`
+	ExpiresAt             *time.Time `json:"expiresAt,omitempty"`
+	AutoRenew             bool       `json:"autoRenew"`
+	Revoked               bool       `json:"revoked"`
+}
+
+// StoreNotification is a server-to-server notification from an app store
+type StoreNotification struct {
+	Type        string           `json:"type"`
+	Transaction StoreTransaction `json:"transaction"`
+	GraceUntil  *time.Time       `json:"graceUntil,omitempty"`
+}
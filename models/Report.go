@@ -0,0 +1,72 @@
+package models
+
+import "time"
+
+// Reasons a user can be reported for
+const (
+	ReportReasonSpam          = "spam"
+	ReportReasonScam          = "scam"
+	ReportReasonHarassment    = "harassment"
+	ReportReasonInappropriate = "inappropriate_content"
+	ReportReasonFakeProfile   = "fake_profile"
+	ReportReasonUnderage      = "underage"
+	ReportReasonOther         = "other"
+	// ReportReasonAutomated is used by the bot detector and can't be chosen
+	// by users
+	ReportReasonAutomated = "automated"
+)
+
+// ReportReasons lists the accepted report reasons
+var ReportReasons = []string{
+	ReportReasonSpam,
+	ReportReasonScam,
+	ReportReasonHarassment,
+	ReportReasonInappropriate,
+	ReportReasonFakeProfile,
+	ReportReasonUnderage,
+	ReportReasonOther,
+}
+
+// Report statuses
+const (
+	ReportStatusOpen      = "open"
+	ReportStatusActioned  = "actioned"
+	ReportStatusDismissed = "dismissed"
+)
+
+// Actions a moderator can take on a report
+const (
+	ModerationWarn    = "warn"
+	ModerationSuspend = "suspend"
+	ModerationBan     = "ban"
+	ModerationDismiss = "dismiss"
+)
+
+// Report is a complaint one user filed about another. Reports filed by the
+// bot detector, or by users who have since deleted their account, have no
+// ReporterID.
+type Report struct {
+	ID         string    `json:"id"`
+	ReporterID string    `json:"reporterID,omitempty"`
+	ReportedID string    `json:"reportedID"`
+	Reason     string    `json:"reason"`
+	Details    string    `json:"details,omitempty"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"createdAt"`
+	// Resolution is the moderator action that closed the report
+	Resolution string     `json:"resolution,omitempty"`
+	ResolvedBy string     `json:"resolvedBy,omitempty"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// ReportCase is what a moderator sees when reviewing a report: the reported
+// user's profile and photos, their messages with the reporter, and the
+// reports and moderation actions the user has had so far
+type ReportCase struct {
+	Report   Report       `json:"report"`
+	Profile  *Profile     `json:"profile,omitempty"`
+	Photos   []Photo      `json:"photos"`
+	Messages []Message    `json:"messages"`
+	Reports  []Report     `json:"reports"`
+	Actions  []AuditEntry `json:"actions"`
+}
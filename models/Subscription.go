@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Subscription statuses. A subscription is in grace while a renewal payment is
+// outstanding; the user keeps its entitlements until GraceUntil.
+const (
+	SubscriptionStatusActive  = "active"
+	SubscriptionStatusGrace   = "grace"
+	SubscriptionStatusExpired = "expired"
+)
+
+// Subscription is a recurring purchase of a product with a non-zero duration
+type Subscription struct {
+	ID                 string     `json:"id"`
+	UserID             string     `json:"userID"`
+	SKU                string     `json:"sku"`
+	Status             string     `json:"status"`
+	AutoRenew          bool       `json:"autoRenew"`
+	CurrentPeriodStart time.Time  `json:"currentPeriodStart"`
+	CurrentPeriodEnd   time.Time  `json:"currentPeriodEnd"`
+	GraceUntil         *time.Time `json:"graceUntil,omitempty"`
+	CanceledAt         *time.Time `json:"canceledAt,omitempty"`
+	// Store is set for subscriptions billed and renewed by an app store
+	Store string `json:"store,omitempty"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Outcomes reported for each item of a swipe batch
+const (
+	SwipeStatusRecorded = "recorded"
+	SwipeStatusRejected = "rejected"
+	SwipeStatusFailed   = "failed"
+)
+
+// SwipeRecord is a swipe as a user's data export lists it
+type SwipeRecord struct {
+	TargetID  string    `json:"targetID"`
+	Action    string    `json:"action"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// BatchSwipe is a swipe queued by a client while offline and replayed later
+type BatchSwipe struct {
+	IdempotencyKey string    `json:"idempotencyKey"`
+	TargetID       string    `json:"targetID"`
+	Action         string    `json:"action"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// BatchSwipeResult is the outcome of a single BatchSwipe. Replayed is set when
+// the idempotency key was already processed and the stored outcome is returned.
+type BatchSwipeResult struct {
+	IdempotencyKey string `json:"idempotencyKey"`
+	Status         string `json:"status"`
+	Error          string `json:"error,omitempty"`
+	Replayed       bool   `json:"replayed"`
+	// Match is set when the swipe made a match. Replays don't repeat it.
+	Match *Match `json:"match,omitempty"`
+}
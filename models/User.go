@@ -2,11 +2,37 @@ package models
 
 import "time"
 
+// Staff roles. Moderators handle reports and verifications; admins can also
+// manage promo codes, refunds and roles, and hold every moderator permission.
+const (
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Account statuses set by moderators
+const (
+	AccountStatusActive    = "active"
+	AccountStatusSuspended = "suspended"
+	AccountStatusBanned    = "banned"
+)
+
 type User struct {
-	ID        string    `json:"id"`
-	Username  string    `json:"username"`
-	Password  string    `json:"password"`
-	Premium   bool      `json:"premium"`
-	Swipes    int       `json:"swipes"`
-	LastSwipe time.Time `json:"last_swipe"`
+	ID          string    `json:"id"`
+	Username    string    `json:"username"`
+	Password    string    `json:"password"`
+	Premium     bool      `json:"premium"`
+	Swipes      int       `json:"swipes"`
+	ExtraSwipes int       `json:"extra_swipes"`
+	LastSwipe   time.Time `json:"last_swipe"`
+	Roles       []string  `json:"roles,omitempty"`
+	Account
+}
+
+// Account is the standing of a user's account, checked when they log in and
+// on every authenticated request. TokenVersion is carried in the tokens issued
+// to the user; bumping it signs out every session at once.
+type Account struct {
+	Status         string     `json:"status,omitempty"`
+	SuspendedUntil *time.Time `json:"suspended_until,omitempty"`
+	TokenVersion   int        `json:"-"`
 }
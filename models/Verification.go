@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Verification statuses. A verification starts as a pose challenge awaiting
+// the selfie, is pending while in the review queue, and is approved or
+// rejected by a moderator. An approved verification is revoked when the photos
+// it vouched for are replaced.
+const (
+	VerificationStatusAwaitingSelfie = "awaiting_selfie"
+	VerificationStatusPending        = "pending"
+	VerificationStatusApproved       = "approved"
+	VerificationStatusRejected       = "rejected"
+	VerificationStatusRevoked        = "revoked"
+)
+
+// Verification is a user's attempt to earn the verified badge by taking a
+// selfie in a pose chosen by the server. PhotoIDs are the profile photos the
+// selfie is compared against. The selfie is only kept until it is reviewed.
+type Verification struct {
+	ID                 string     `json:"id"`
+	UserID             string     `json:"userID"`
+	Pose               string     `json:"pose"`
+	PoseInstruction    string     `json:"poseInstruction"`
+	Status             string     `json:"status"`
+	SelfieURL          string     `json:"selfieURL,omitempty"`
+	SelfieKey          string     `json:"-"`
+	PhotoIDs           []string   `json:"photoIDs,omitempty"`
+	Photos             []Photo    `json:"photos,omitempty"`
+	RejectionReason    string     `json:"rejectionReason,omitempty"`
+	ReviewedBy         string     `json:"reviewedBy,omitempty"`
+	ChallengeExpiresAt time.Time  `json:"challengeExpiresAt"`
+	BadgeExpiresAt     *time.Time `json:"badgeExpiresAt,omitempty"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	SubmittedAt        *time.Time `json:"submittedAt,omitempty"`
+	ReviewedAt         *time.Time `json:"reviewedAt,omitempty"`
+}
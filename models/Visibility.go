@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Who can see a user in feeds and in "who liked me"
+const (
+	VisibilityEveryone = "everyone"
+	// VisibilityLikedOnly is incognito mode: only people the user swiped right
+	// on can see them. It is a premium feature.
+	VisibilityLikedOnly = "liked_only"
+	// VisibilityHidden pauses the profile; nobody is shown it
+	VisibilityHidden = "hidden"
+)
+
+// Like is a right swipe on the viewer by someone they haven't swiped on yet
+type Like struct {
+	UserID   string    `json:"userID"`
+	Username string    `json:"username"`
+	LikedAt  time.Time `json:"likedAt"`
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"dating-app/models"
+	"dating-app/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// reportCaseMessages is how many of the latest messages between the reporter
+// and the reported user a moderator is shown
+const reportCaseMessages = 50
+
+// @Summary Report queue
+// @Description Returns reports with the given status, oldest first. Open reports are the moderators' queue.
+// @Tags Moderation
+// @Produce  json
+// @Param Authorization header string true "Bearer token of a moderator"
+// @Param status query string false "open (default), actioned or dismissed"
+// @Param limit query int false "Number of reports to return (default 20, max 50)"
+// @Success 200 {object} map[string][]models.Report
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/reports [get]
+func ListReportsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = models.ReportStatusOpen
+	case models.ReportStatusOpen, models.ReportStatusActioned, models.ReportStatusDismissed:
+	default:
+		http.Error(w, `{"error": "status must be open, actioned or dismissed"}`, http.StatusBadRequest)
+		return
+	}
+	limit, ok := pageSize(w, r)
+	if !ok {
+		return
+	}
+
+	reports, err := moderationService.ListReports(status, limit)
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]models.Report{"reports": reports})
+}
+
+// @Summary Review report
+// @Description Returns a report with what a moderator needs to act on it: the reported user's full profile and photos, their latest messages with the reporter (including ended and blocked conversations), every report filed about them and the moderation actions they have had
+// @Tags Moderation
+// @Produce  json
+// @Param Authorization header string true "Bearer token of a moderator"
+// @Param id path string true "Report ID"
+// @Success 200 {object} models.ReportCase
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/reports/{id} [get]
+func GetReportHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := moderationService.GetReport(mux.Vars(r)["id"])
+	if errors.Is(err, service.ErrReportNotFound) {
+		http.Error(w, `{"error": "Report not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	reportCase, err := buildReportCase(report)
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(reportCase)
+}
+
+// buildReportCase gathers the reported user's content and history for a report
+func buildReportCase(report models.Report) (models.ReportCase, error) {
+	reportCase := models.ReportCase{Report: report}
+	reportedID := report.ReportedID
+
+	profile, err := profileService.GetProfile(reportedID)
+	if err == nil {
+		reportCase.Profile = &profile
+	} else if !errors.Is(err, service.ErrProfileNotFound) {
+		return reportCase, err
+	}
+	if reportCase.Photos, err = photoService.ListPhotos(reportedID); err != nil {
+		return reportCase, err
+	}
+	// Automated reports have no reporter to have talked to
+	reportCase.Messages = []models.Message{}
+	if report.ReporterID != "" {
+		if reportCase.Messages, err = moderationService.MessagesBetween(report.ReporterID, reportedID, reportCaseMessages); err != nil {
+			return reportCase, err
+		}
+	}
+	if reportCase.Reports, err = moderationService.ReportsAgainst(reportedID); err != nil {
+		return reportCase, err
+	}
+	reportCase.Actions, err = auditService.History("user", reportedID)
+	return reportCase, err
+}
+
+// @Summary Act on report
+// @Description Resolves an open report. warn sends the reported user the reason as a notice, suspend signs them out and locks them out for suspendDays days, ban signs them out for good, and dismiss closes the report without action. A warning, suspension or ban also resolves the other open reports about the user. Every action is recorded in the audit log.
+// @Tags Moderation
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token of a moderator"
+// @Param Idempotency-Key header string false "Client-generated key that makes retries safe"
+// @Param id path string true "Report ID"
+// @Param action body string true "warn, suspend, ban or dismiss"
+// @Param reason body string false "Reason, required unless dismissing"
+// @Param suspendDays body int false "Length of a suspension in days (1-365)"
+// @Success 200 {object} models.Report
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/reports/{id}/actions [post]
+func ReportActionHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Action      string `json:"action"`
+		Reason      string `json:"reason"`
+		SuspendDays int    `json:"suspendDays"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+	if err := service.ValidateModerationAction(request.Action, request.Reason, request.SuspendDays); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	report, err := moderationService.TakeAction(mux.Vars(r)["id"], adminActor(r), request.Action, request.Reason, request.SuspendDays, now)
+	if errors.Is(err, service.ErrReportNotFound) {
+		http.Error(w, `{"error": "Report not found"}`, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, service.ErrReportResolved) {
+		http.Error(w, `{"error": "Report has already been resolved"}`, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Suspended and banned users are signed out; close their open connections
+	if request.Action == models.ModerationSuspend || request.Action == models.ModerationBan {
+		broker.Publish(report.ReportedID, models.Event{Type: models.EventSignedOut, Data: map[string]string{"reason": request.Action}})
+	}
+
+	if request.Action == models.ModerationWarn {
+		err := notificationService.Notify(report.ReportedID, models.Notification{
+			Type:  models.NotificationAccount,
+			Title: "Community guidelines warning",
+			Body:  request.Reason,
+		}, now)
+		if err != nil {
+			log.Printf("failed to notify %s of a warning: %v", report.ReportedID, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// @Summary Set roles
+// @Description Replaces a user's staff roles. moderator gives access to the report and verification queues; admin also to promo codes, refunds and roles. The change takes effect at the user's next login.
+// @Tags Moderation
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token of an admin"
+// @Param id path string true "User ID"
+// @Param roles body []string true "Roles, empty to make the user a regular user again"
+// @Success 200 {object} map[string][]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/roles [put]
+func SetRolesHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Roles []string `json:"roles"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+	if request.Roles == nil {
+		request.Roles = []string{}
+	}
+
+	userID := mux.Vars(r)["id"]
+	err := moderationService.SetRoles(userID, request.Roles, adminActor(r))
+	if errors.Is(err, service.ErrInvalidRole) {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, service.ErrUserNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]string{"roles": request.Roles})
+}
+
+// @Summary Shadow-ban user
+// @Description Shadow-bans a user or lifts it. Unlike a ban, the user isn't told: their swipes and messages keep looking successful to them, but they are left out of other users' feeds and likes, make no matches, and their messages are never delivered. Every change is recorded in the audit log.
+// @Tags Moderation
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token of a moderator"
+// @Param id path string true "User ID"
+// @Param shadowBanned body bool true "true to shadow-ban, false to lift it"
+// @Param reason body string true "Reason, recorded in the audit log"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/shadow-ban [put]
+func SetShadowBanHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ShadowBanned *bool  `json:"shadowBanned"`
+		Reason       string `json:"reason"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.ShadowBanned == nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+	if request.Reason == "" {
+		http.Error(w, `{"error": "A reason is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	userID := mux.Vars(r)["id"]
+	err := moderationService.SetShadowBanned(userID, *request.ShadowBanned, adminActor(r), request.Reason)
+	if errors.Is(err, service.ErrUserNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// The user is deliberately not notified
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"userID": userID, "shadowBanned": *request.ShadowBanned})
+}
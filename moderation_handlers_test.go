@@ -0,0 +1,531 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dating-app/models"
+	"dating-app/repository"
+	"dating-app/service"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockModerationService struct {
+	mock.Mock
+}
+
+func (m *MockModerationService) ListReports(status string, limit int) ([]models.Report, error) {
+	args := m.Called(status, limit)
+	return args.Get(0).([]models.Report), args.Error(1)
+}
+
+func (m *MockModerationService) GetReport(reportID string) (models.Report, error) {
+	args := m.Called(reportID)
+	return args.Get(0).(models.Report), args.Error(1)
+}
+
+func (m *MockModerationService) ReportsAgainst(userID string) ([]models.Report, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]models.Report), args.Error(1)
+}
+
+func (m *MockModerationService) MessagesBetween(userID, otherID string, limit int) ([]models.Message, error) {
+	args := m.Called(userID, otherID, limit)
+	return args.Get(0).([]models.Message), args.Error(1)
+}
+
+func (m *MockModerationService) TakeAction(reportID, moderator, action, reason string, suspendDays int, now time.Time) (models.Report, error) {
+	args := m.Called(reportID, moderator, action, reason, suspendDays, now)
+	return args.Get(0).(models.Report), args.Error(1)
+}
+
+func (m *MockModerationService) SetRoles(userID string, roles []string, actor string) error {
+	args := m.Called(userID, roles, actor)
+	return args.Error(0)
+}
+
+func (m *MockModerationService) SetShadowBanned(userID string, shadowBanned bool, actor, reason string) error {
+	args := m.Called(userID, shadowBanned, actor, reason)
+	return args.Error(0)
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name           string
+		roles          []string
+		required       string
+		expectedStatus int
+	}{
+		{name: "Moderator reviews reports", roles: []string{models.RoleModerator}, required: models.RoleModerator, expectedStatus: http.StatusOK},
+		{name: "Admins hold every role", roles: []string{models.RoleAdmin}, required: models.RoleModerator, expectedStatus: http.StatusOK},
+		{name: "Moderator can't refund", roles: []string{models.RoleModerator}, required: models.RoleAdmin, expectedStatus: http.StatusForbidden},
+		{name: "Regular user", required: models.RoleModerator, expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := authenticate(requireRole(tt.required, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, staffRequest(t, "GET", "/admin/reports", "staff1", tt.roles, nil))
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestGetReportHandler(t *testing.T) {
+	mockModerationService := new(MockModerationService)
+	moderationService = mockModerationService
+	mockRepository := new(MockProfileRepository)
+	profileService = &service.ProfileServiceImpl{Repository: mockRepository}
+	mockPhotoService := new(MockPhotoService)
+	photoService = mockPhotoService
+	mockAuditService := new(MockAuditService)
+	auditService = mockAuditService
+
+	report := models.Report{ID: "r1", ReporterID: "user1", ReportedID: "user2", Reason: models.ReportReasonScam, Status: models.ReportStatusOpen}
+	messages := []models.Message{{ID: "7", SenderID: "user2", RecipientID: "user1", Body: "Send me a gift card"}}
+	warning := models.AuditEntry{Actor: "admin:staff1", Action: models.AuditActionUserWarned, TargetType: "user", TargetID: "user2"}
+	mockModerationService.On("GetReport", "r1").Return(report, nil)
+	mockModerationService.On("MessagesBetween", "user1", "user2", reportCaseMessages).Return(messages, nil)
+	mockModerationService.On("ReportsAgainst", "user2").Return([]models.Report{report}, nil)
+	mockRepository.On("GetUserProfile", "user2").Return(models.Profile{}, repository.ErrNotFound)
+	mockPhotoService.On("ListPhotos", "user2").Return([]models.Photo{}, nil)
+	mockAuditService.On("History", "user", "user2").Return([]models.AuditEntry{warning}, nil)
+
+	req := staffRequest(t, "GET", "/admin/reports/r1", "staff1", []string{models.RoleModerator}, nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "r1"})
+	rr := httptest.NewRecorder()
+	handler := authenticate(GetReportHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var reportCase models.ReportCase
+	if err := json.NewDecoder(rr.Body).Decode(&reportCase); err != nil {
+		t.Fatal(err)
+	}
+	if reportCase.Profile != nil || len(reportCase.Messages) != 1 || len(reportCase.Reports) != 1 || len(reportCase.Actions) != 1 {
+		t.Errorf("handler returned unexpected case: %+v", reportCase)
+	}
+
+	mockModerationService.AssertExpectations(t)
+	mockAuditService.AssertExpectations(t)
+}
+
+func TestReportActionHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		request        map[string]interface{}
+		serviceErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "Warning notifies the user",
+			request:        map[string]interface{}{"action": "warn", "reason": "Asking for money"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Suspension",
+			request:        map[string]interface{}{"action": "suspend", "reason": "Harassment", "suspendDays": 7},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Dismissal needs no reason",
+			request:        map[string]interface{}{"action": "dismiss"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Ban needs a reason",
+			request:        map[string]interface{}{"action": "ban"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Suspension needs a length",
+			request:        map[string]interface{}{"action": "suspend", "reason": "Harassment"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown action",
+			request:        map[string]interface{}{"action": "delete", "reason": "Spam"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Report already resolved",
+			request:        map[string]interface{}{"action": "ban", "reason": "Scam"},
+			serviceErr:     service.ErrReportResolved,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Unknown report",
+			request:        map[string]interface{}{"action": "dismiss"},
+			serviceErr:     service.ErrReportNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Action that can't be audited isn't taken",
+			request:        map[string]interface{}{"action": "ban", "reason": "Scam"},
+			serviceErr:     errors.New("audit_log: connection reset"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockModerationService := new(MockModerationService)
+			moderationService = mockModerationService
+			mockNotificationService := new(MockNotificationService)
+			notificationService = mockNotificationService
+
+			action, _ := tt.request["action"].(string)
+			reason, _ := tt.request["reason"].(string)
+			suspendDays, _ := tt.request["suspendDays"].(int)
+			report := models.Report{ID: "r1", ReporterID: "user1", ReportedID: "user2", Status: models.ReportStatusActioned, Resolution: action}
+			if tt.expectedStatus != http.StatusBadRequest {
+				mockModerationService.On("TakeAction", "r1", "admin:staff1", action, reason, suspendDays, mock.AnythingOfType("time.Time")).Return(report, tt.serviceErr)
+			}
+			if action == models.ModerationWarn {
+				mockNotificationService.On("Notify", "user2", mock.MatchedBy(func(notification models.Notification) bool {
+					return notification.Type == models.NotificationAccount && notification.Body == "Asking for money"
+				}), mock.AnythingOfType("time.Time")).Return(nil)
+			}
+
+			body, _ := json.Marshal(tt.request)
+			req := staffRequest(t, "POST", "/admin/reports/r1/actions", "staff1", []string{models.RoleModerator}, body)
+			req = mux.SetURLVars(req, map[string]string{"id": "r1"})
+			rr := httptest.NewRecorder()
+			handler := authenticate(ReportActionHandler)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockModerationService.AssertExpectations(t)
+			mockNotificationService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSetShadowBanHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		request        map[string]interface{}
+		serviceErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "Shadow-ban",
+			request:        map[string]interface{}{"shadowBanned": true, "reason": "Copy-pasted scam messages"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Lift shadow ban",
+			request:        map[string]interface{}{"shadowBanned": false, "reason": "False positive"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Missing reason",
+			request:        map[string]interface{}{"shadowBanned": true},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Missing flag",
+			request:        map[string]interface{}{"reason": "Spam"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown user",
+			request:        map[string]interface{}{"shadowBanned": true, "reason": "Spam"},
+			serviceErr:     service.ErrUserNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockModerationService := new(MockModerationService)
+			moderationService = mockModerationService
+			// No mocked calls: the user must not be told
+			mockNotificationService := new(MockNotificationService)
+			notificationService = mockNotificationService
+
+			if tt.expectedStatus != http.StatusBadRequest {
+				mockModerationService.On("SetShadowBanned", "user2", tt.request["shadowBanned"], "admin:staff1", tt.request["reason"]).Return(tt.serviceErr)
+			}
+
+			body, _ := json.Marshal(tt.request)
+			req := staffRequest(t, "PUT", "/admin/users/user2/shadow-ban", "staff1", []string{models.RoleModerator}, body)
+			req = mux.SetURLVars(req, map[string]string{"id": "user2"})
+			rr := httptest.NewRecorder()
+			handler := authenticate(SetShadowBanHandler)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockModerationService.AssertExpectations(t)
+			mockNotificationService.AssertExpectations(t)
+		})
+	}
+}
+
+type MockBotService struct {
+	mock.Mock
+}
+
+func (m *MockBotService) Signals(userID string, since time.Time) (models.BotSignals, error) {
+	args := m.Called(userID, since)
+	return args.Get(0).(models.BotSignals), args.Error(1)
+}
+
+func (m *MockBotService) Flag(userID string, score float64, details string, shadowBan bool, now time.Time) (bool, error) {
+	args := m.Called(userID, score, details, shadowBan, now)
+	return args.Bool(0), args.Error(1)
+}
+
+func TestBotDetector(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		signals   models.BotSignals
+		filed     bool
+		flag      bool
+		shadowBan bool
+	}{
+		{
+			name:    "Person swiping",
+			signals: models.BotSignals{Swipes: 40, RightSwipes: 12, MedianSwipeGap: 6},
+		},
+		{
+			name:    "Person swiping right on everyone",
+			signals: models.BotSignals{Swipes: 60, RightSwipes: 60, MedianSwipeGap: 4},
+		},
+		{
+			name:    "Few fast swipes",
+			signals: models.BotSignals{Swipes: 5, RightSwipes: 5, MedianSwipeGap: 0.2},
+		},
+		{
+			name:    "Swiping at machine speed",
+			signals: models.BotSignals{Swipes: 300, RightSwipes: 300, MedianSwipeGap: 0.3},
+			filed:   true,
+			flag:    true,
+		},
+		{
+			name:      "Swiping and spamming chats",
+			signals:   models.BotSignals{Swipes: 300, RightSwipes: 300, MedianSwipeGap: 0.3, DuplicateMessages: 8},
+			filed:     true,
+			flag:      true,
+			shadowBan: true,
+		},
+		{
+			name:      "Already under review",
+			signals:   models.BotSignals{Swipes: 300, RightSwipes: 300, MedianSwipeGap: 0.3, DuplicateMessages: 8},
+			flag:      true,
+			shadowBan: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockBotService := new(MockBotService)
+			detector := service.NewBotDetector(mockBotService, service.DefaultBotThresholds, time.Second)
+
+			mockBotService.On("Signals", "user1", now.Add(-time.Hour)).Return(tt.signals, nil)
+			if tt.flag {
+				mockBotService.On("Flag", "user1", mock.AnythingOfType("float64"), mock.AnythingOfType("string"), tt.shadowBan, now).Return(tt.filed, nil)
+			}
+
+			if _, err := detector.Check("user1", now); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			mockBotService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestTakeAction(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	reportColumns := []string{"id", "reporter_id", "reported_id", "reason", "details", "status", "created_at", "resolution", "resolved_by", "resolved_at"}
+	openReport := []driver.Value{"r1", "user1", "user2", "harassment", "", models.ReportStatusOpen, now, "", "", nil}
+	resolvedReport := []driver.Value{"r1", "user1", "user2", "harassment", "", models.ReportStatusActioned, now, models.ModerationSuspend, "admin:staff1", now}
+
+	tests := []struct {
+		name    string
+		script  func(script *sqlScript)
+		wantErr bool
+	}{
+		{
+			name: "Suspension is audited in its transaction",
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM reports WHERE id=$1 FOR UPDATE", reportColumns, [][]driver.Value{openReport}, "r1")
+				script.exec("UPDATE users SET status=$1, suspended_until=GREATEST(suspended_until, $2)", 1, models.AccountStatusSuspended, now.AddDate(0, 0, 7), "user2", models.AccountStatusBanned)
+				script.query("UPDATE reports SET status=$1", reportColumns, [][]driver.Value{resolvedReport})
+				script.exec("WHERE reported_id=$5 AND status=$6", 0)
+				script.exec("INSERT INTO audit_log", 1, "admin:staff1", models.AuditActionUserSuspended, "user", "user2", anyArg)
+				script.commit()
+			},
+		},
+		{
+			name: "Failed audit undoes the suspension",
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM reports WHERE id=$1 FOR UPDATE", reportColumns, [][]driver.Value{openReport}, "r1")
+				script.exec("UPDATE users SET status=$1", 1)
+				script.query("UPDATE reports SET status=$1", reportColumns, [][]driver.Value{resolvedReport})
+				script.exec("WHERE reported_id=$5 AND status=$6", 0)
+				script.exec("INSERT INTO audit_log", 0).fails(errors.New("connection reset"))
+				script.rollback()
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, script := newSQLScript(t)
+			tt.script(script)
+
+			moderation := &service.ModerationServiceImpl{DB: db}
+			if _, err := moderation.TakeAction("r1", "admin:staff1", models.ModerationSuspend, "Harassment", 7, now); (err != nil) != tt.wantErr {
+				t.Errorf("TakeAction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetShadowBanned(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  func(script *sqlScript)
+		wantErr error
+	}{
+		{
+			name: "Shadow ban is audited in its transaction",
+			script: func(script *sqlScript) {
+				script.begin()
+				script.exec("UPDATE users SET shadow_banned=$1", 1, true, "user2")
+				script.exec("INSERT INTO audit_log", 1, "admin:staff1", models.AuditActionUserShadowBanned, "user", "user2", []byte(`{"reason":"Spam"}`))
+				script.commit()
+			},
+		},
+		{
+			name: "Unknown user",
+			script: func(script *sqlScript) {
+				script.begin()
+				script.exec("UPDATE users SET shadow_banned=$1", 0)
+				script.rollback()
+			},
+			wantErr: service.ErrUserNotFound,
+		},
+		{
+			name: "Failed audit undoes the shadow ban",
+			script: func(script *sqlScript) {
+				script.begin()
+				script.exec("UPDATE users SET shadow_banned=$1", 1)
+				script.exec("INSERT INTO audit_log", 0).fails(errors.New("connection reset"))
+				script.rollback()
+			},
+			wantErr: errors.New("connection reset"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, script := newSQLScript(t)
+			tt.script(script)
+
+			moderation := &service.ModerationServiceImpl{DB: db}
+			err := moderation.SetShadowBanned("user2", true, "admin:staff1", "Spam")
+			if (err == nil) != (tt.wantErr == nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+				t.Errorf("SetShadowBanned() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBotFlag(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		shadowBanned bool
+		open         bool
+		shadowBan    bool
+		filed        bool
+	}{
+		{name: "Report and shadow ban", shadowBan: true, filed: true},
+		{name: "Report only", filed: true},
+		{name: "Open report is not filed again but the shadow ban still applies", open: true, shadowBan: true},
+		{name: "Open report and no shadow ban", open: true},
+		{name: "Already shadow-banned", shadowBanned: true, open: true, shadowBan: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, script := newSQLScript(t)
+			script.begin()
+			script.query("SELECT shadow_banned FROM users WHERE id=$1 FOR UPDATE", []string{"shadow_banned"}, [][]driver.Value{{tt.shadowBanned}}, "user1")
+			script.query("SELECT EXISTS", []string{"exists"}, [][]driver.Value{{tt.open}})
+			if tt.shadowBan && !tt.shadowBanned {
+				script.exec("UPDATE users SET shadow_banned=true", 1, "user1")
+				script.exec("INSERT INTO audit_log", 1, service.BotActor, models.AuditActionUserShadowBanned, "user", "user1", []byte(`{"score":"0.93"}`))
+			}
+			if !tt.open {
+				script.exec("INSERT INTO reports", 1)
+			}
+			script.commit()
+
+			bots := &service.BotServiceImpl{DB: db}
+			if filed, err := bots.Flag("user1", 0.93, "score 0.93", tt.shadowBan, now); filed != tt.filed || err != nil {
+				t.Errorf("Flag() = %v, %v, want %v", filed, err, tt.filed)
+			}
+		})
+	}
+}
+
+func TestBotSignals(t *testing.T) {
+	since := time.Date(2025, 2, 1, 11, 0, 0, 0, time.UTC)
+	cipher := loadCipher(t, 1, 1)
+	encrypt := func(text string) string {
+		encrypted, err := cipher.Encrypt(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encrypted
+	}
+
+	db, script := newSQLScript(t)
+	script.query("FROM swipes WHERE user_id=$1 AND received_at >= $2", []string{"swipes", "right", "gap"}, [][]driver.Value{{int64(40), int64(40), 0.5}}, "user1", since)
+	// The same text is encrypted differently each time, and older rows may
+	// still be in plaintext
+	script.query("SELECT match_id, body FROM messages WHERE sender_id=$1", []string{"match_id", "body"}, [][]driver.Value{
+		{"m1", encrypt("hey, check my profile")},
+		{"m2", encrypt("hey, check my profile")},
+		{"m3", "hey, check my profile"},
+		{"m1", encrypt("hey, check my profile")},
+		{"m2", encrypt("how was your day?")},
+	}, "user1", since)
+
+	bots := &service.BotServiceImpl{DB: db, Cipher: cipher}
+	signals, err := bots.Signals("user1", since)
+	expected := models.BotSignals{Swipes: 40, RightSwipes: 40, MedianSwipeGap: 0.5, DuplicateMessages: 2}
+	if err != nil || signals != expected {
+		t.Errorf("Signals() = %+v, %v, want %+v", signals, err, expected)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"dating-app/models"
+	"dating-app/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// @Summary Register device
+// @Description Registers a phone to receive push notifications for the authenticated user. token is the APNs device token on ios and the FCM registration token on android. Registering a token again, for instance after another user logged in on the phone, moves it to the authenticated user.
+// @Tags Notifications
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param platform body string true "ios or android"
+// @Param token body string true "Push token issued to the app"
+// @Success 201 {object} models.Device
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /devices [post]
+func RegisterDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Platform string `json:"platform"`
+		Token    string `json:"token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	device, err := notificationService.RegisterDevice(authenticatedUserID(r), request.Platform, request.Token, time.Now())
+	if errors.Is(err, service.ErrInvalidDevice) {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(device)
+}
+
+// @Summary Unregister device
+// @Description Stops push notifications to one of the authenticated user's devices, for instance on logout
+// @Tags Notifications
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "Device ID"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /devices/{id} [delete]
+func UnregisterDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	err := notificationService.UnregisterDevice(authenticatedUserID(r), mux.Vars(r)["id"])
+	if errors.Is(err, service.ErrDeviceNotFound) {
+		http.Error(w, `{"error": "Device not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Device unregistered"})
+}
+
+// @Summary Notification preferences
+// @Description Returns which notifications the authenticated user gets pushed. Everything is on by default.
+// @Tags Notifications
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} models.NotificationPreferences
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /settings/notifications [get]
+func GetNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	preferences, err := notificationService.GetPreferences(authenticatedUserID(r))
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(preferences)
+}
+
+// @Summary Change notification preferences
+// @Description Turns push notifications for new matches and new messages on or off
+// @Tags Notifications
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param preferences body models.NotificationPreferences true "Notification preferences"
+// @Success 200 {object} models.NotificationPreferences
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /settings/notifications [put]
+func SetNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	var preferences models.NotificationPreferences
+	if err := json.NewDecoder(r.Body).Decode(&preferences); err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := notificationService.SetPreferences(authenticatedUserID(r), preferences); err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(preferences)
+}
+
+// notifyMatch tells both users about a new match, in real time and by push.
+// The swipe is already saved, so failures are logged, not reported.
+func notifyMatch(match *models.Match) {
+	if match == nil {
+		return
+	}
+	for _, userID := range match.UserIDs {
+		broker.Publish(userID, models.Event{Type: models.EventMatch, Data: match})
+		err := notificationService.Notify(userID, models.Notification{
+			Type:  models.NotificationMatch,
+			Title: "It's a match!",
+			Body:  "Someone you liked likes you back. Say hi!",
+			Data:  map[string]string{"matchID": match.ID},
+		}, time.Now())
+		if err != nil {
+			log.Printf("failed to notify %s of match %s: %v", userID, match.ID, err)
+		}
+	}
+}
+
+// notifyMessage pushes a new message to its recipient. The text is left out
+// because notifications show on lock screens.
+func notifyMessage(message models.Message) {
+	err := notificationService.Notify(message.RecipientID, models.Notification{
+		Type:  models.NotificationMessage,
+		Title: "New message",
+		Body:  "You have a new message",
+		Data:  map[string]string{"matchID": message.MatchID, "messageID": message.ID},
+	}, time.Now())
+	if err != nil {
+		log.Printf("failed to notify %s of message %s: %v", message.RecipientID, message.ID, err)
+	}
+}
+
+// newPushProviders configures a PushProvider per platform from the
+// environment. Platforms without credentials log notifications instead.
+func newPushProviders() (map[string]service.PushProvider, error) {
+	providers := map[string]service.PushProvider{
+		models.PlatformIOS:     &service.LoggingPushProvider{Platform: models.PlatformIOS},
+		models.PlatformAndroid: &service.LoggingPushProvider{Platform: models.PlatformAndroid},
+	}
+
+	if os.Getenv("APNS_KEY_ID") != "" {
+		privateKey, err := readECPrivateKey("APNS_PRIVATE_KEY_PATH")
+		if err != nil {
+			return nil, err
+		}
+		baseURL := service.APNsProductionURL
+		if os.Getenv("APNS_ENVIRONMENT") == "sandbox" {
+			baseURL = service.APNsSandboxURL
+		}
+		providers[models.PlatformIOS] = &service.APNsPushProvider{
+			BaseURL:    baseURL,
+			KeyID:      os.Getenv("APNS_KEY_ID"),
+			TeamID:     os.Getenv("APNS_TEAM_ID"),
+			Topic:      os.Getenv("APNS_TOPIC"),
+			PrivateKey: privateKey,
+		}
+	}
+
+	if os.Getenv("FCM_PROJECT_ID") != "" {
+		tokens, err := readServiceAccount("FCM_SERVICE_ACCOUNT_PATH", "https://www.googleapis.com/auth/firebase.messaging")
+		if err != nil {
+			return nil, err
+		}
+		providers[models.PlatformAndroid] = &service.FCMPushProvider{
+			BaseURL:   service.FCMAPIURL,
+			ProjectID: os.Getenv("FCM_PROJECT_ID"),
+			Tokens:    tokens,
+		}
+	}
+
+	return providers, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dating-app/models"
+	"dating-app/service"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockNotificationService struct {
+	mock.Mock
+}
+
+func (m *MockNotificationService) RegisterDevice(userID, platform, token string, now time.Time) (models.Device, error) {
+	args := m.Called(userID, platform, token, now)
+	return args.Get(0).(models.Device), args.Error(1)
+}
+
+func (m *MockNotificationService) UnregisterDevice(userID, deviceID string) error {
+	args := m.Called(userID, deviceID)
+	return args.Error(0)
+}
+
+func (m *MockNotificationService) GetPreferences(userID string) (models.NotificationPreferences, error) {
+	args := m.Called(userID)
+	return args.Get(0).(models.NotificationPreferences), args.Error(1)
+}
+
+func (m *MockNotificationService) SetPreferences(userID string, preferences models.NotificationPreferences) error {
+	args := m.Called(userID, preferences)
+	return args.Error(0)
+}
+
+func (m *MockNotificationService) Notify(userID string, notification models.Notification, now time.Time) error {
+	args := m.Called(userID, notification, now)
+	return args.Error(0)
+}
+
+func (m *MockNotificationService) DuePushes(now time.Time, limit int) ([]models.PushDelivery, error) {
+	args := m.Called(now, limit)
+	return args.Get(0).([]models.PushDelivery), args.Error(1)
+}
+
+func (m *MockNotificationService) MarkPushed(deliveryID string, err error, retryAt *time.Time) error {
+	args := m.Called(deliveryID, err, retryAt)
+	return args.Error(0)
+}
+
+func (m *MockNotificationService) RemoveDevice(deviceID string) error {
+	args := m.Called(deviceID)
+	return args.Error(0)
+}
+
+func TestRegisterDeviceHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		platform       string
+		serviceErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "Device registered",
+			platform:       models.PlatformIOS,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Unknown platform",
+			platform:       "blackberry",
+			serviceErr:     fmt.Errorf("%w: platform must be ios or android", service.ErrInvalidDevice),
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockNotificationService := new(MockNotificationService)
+			notificationService = mockNotificationService
+			device := models.Device{ID: "d1", UserID: "user1", Platform: tt.platform, Token: "token1"}
+			mockNotificationService.On("RegisterDevice", "user1", tt.platform, "token1", mock.AnythingOfType("time.Time")).Return(device, tt.serviceErr)
+
+			body, _ := json.Marshal(map[string]string{"platform": tt.platform, "token": "token1"})
+			rr := httptest.NewRecorder()
+			handler := authenticate(RegisterDeviceHandler)
+			handler.ServeHTTP(rr, authorizedRequest(t, "POST", "/devices", "user1", body))
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockNotificationService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSwipeNotifiesMatch(t *testing.T) {
+	mockUserService := new(MockUserService)
+	userService = mockUserService
+	mockNotificationService := new(MockNotificationService)
+	notificationService = mockNotificationService
+	memoryBroker := service.NewMemoryBroker()
+	broker = memoryBroker
+	events, unsubscribe := memoryBroker.Subscribe("user2")
+	defer unsubscribe()
+
+	match := &models.Match{ID: "m1", UserIDs: []string{"user1", "user2"}}
+	mockUserService.On("Swipe", "user1", "user2", "right").Return(match, nil)
+	for _, userID := range match.UserIDs {
+		mockNotificationService.On("Notify", userID, mock.MatchedBy(func(notification models.Notification) bool {
+			return notification.Type == models.NotificationMatch && notification.Data["matchID"] == "m1"
+		}), mock.AnythingOfType("time.Time")).Return(nil)
+	}
+
+	body, _ := json.Marshal(map[string]string{"targetID": "user2", "action": "right"})
+	rr := httptest.NewRecorder()
+	handler := authenticate(SwipeHandler)
+	handler.ServeHTTP(rr, authorizedRequest(t, "POST", "/swipe", "user1", body))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var responseBody map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&responseBody); err != nil {
+		t.Fatal(err)
+	}
+	if responseBody["matchID"] != "m1" {
+		t.Errorf("handler returned unexpected body: got %v want matchID m1", responseBody)
+	}
+	select {
+	case event := <-events:
+		if event.Type != models.EventMatch {
+			t.Errorf("matched user got unexpected event: %v", event)
+		}
+	default:
+		t.Error("match was not delivered in real time")
+	}
+
+	mockUserService.AssertExpectations(t)
+	mockNotificationService.AssertExpectations(t)
+}
+
+// fakePushProvider returns the error registered for a token
+type fakePushProvider struct {
+	errors map[string]error
+	sent   []string
+}
+
+func (p *fakePushProvider) Send(ctx context.Context, token string, notification models.Notification) error {
+	p.sent = append(p.sent, token)
+	return p.errors[token]
+}
+
+func TestNotificationWorker(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	unavailable := errors.New("connection reset")
+	rejected := fmt.Errorf("%w: payload too large", service.ErrPushRejected)
+	provider := &fakePushProvider{errors: map[string]error{
+		"gone":        service.ErrDeviceUnregistered,
+		"unavailable": unavailable,
+		"exhausted":   unavailable,
+		"rejected":    rejected,
+	}}
+
+	mockNotificationService := new(MockNotificationService)
+	mockNotificationService.On("DuePushes", now, mock.AnythingOfType("int")).Return([]models.PushDelivery{
+		{ID: "1", DeviceID: "d1", Platform: models.PlatformIOS, Token: "ok"},
+		{ID: "2", DeviceID: "d2", Platform: models.PlatformIOS, Token: "gone"},
+		{ID: "3", DeviceID: "d3", Platform: models.PlatformAndroid, Token: "unavailable", Attempts: 1},
+		{ID: "4", DeviceID: "d4", Platform: models.PlatformAndroid, Token: "exhausted", Attempts: service.MaxPushAttempts - 1},
+		{ID: "5", DeviceID: "d5", Platform: models.PlatformIOS, Token: "rejected"},
+	}, nil)
+	mockNotificationService.On("MarkPushed", "1", nil, (*time.Time)(nil)).Return(nil)
+	mockNotificationService.On("RemoveDevice", "d2").Return(nil)
+	retryAt := now.Add(time.Minute)
+	mockNotificationService.On("MarkPushed", "3", unavailable, &retryAt).Return(nil)
+	mockNotificationService.On("MarkPushed", "4", unavailable, (*time.Time)(nil)).Return(nil)
+	mockNotificationService.On("MarkPushed", "5", rejected, (*time.Time)(nil)).Return(nil)
+
+	worker := &service.NotificationWorker{
+		Notifications: mockNotificationService,
+		Providers:     map[string]service.PushProvider{models.PlatformIOS: provider, models.PlatformAndroid: provider},
+	}
+	worker.RunOnce(context.Background(), now)
+
+	if len(provider.sent) != 5 {
+		t.Errorf("worker sent %d notifications want 5", len(provider.sent))
+	}
+	mockNotificationService.AssertExpectations(t)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"dating-app/models"
+	"dating-app/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// maxUploadOverhead is how much a multipart body may add around the image
+const maxUploadOverhead = 64 << 10
+
+// @Summary Upload photo
+// @Description Adds a photo after the user's other photos. The file is sent as the "photo" field of a multipart/form-data body and must be a JPEG, PNG or WebP image of at most 10 MB and at least 320x320 pixels. It is stored without its metadata (EXIF location included) in large, medium and square thumbnail sizes. Users can have up to 9 photos.
+// @Tags Photos
+// @Accept  mpfd
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param photo formData file true "Photo"
+// @Success 201 {object} models.Photo
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /photos [post]
+func UploadPhotoHandler(w http.ResponseWriter, r *http.Request) {
+	data, ok := readUpload(w, r, "photo")
+	if !ok {
+		return
+	}
+
+	photo, err := photoService.UploadPhoto(authenticatedUserID(r), data)
+	if errors.Is(err, service.ErrInvalidPhoto) {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, service.ErrTooManyPhotos) {
+		http.Error(w, fmt.Sprintf(`{"error": "You can have at most %d photos"}`, service.MaxPhotos), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(photo)
+}
+
+// readUpload reads an image sent as the given field of a multipart/form-data
+// body, writing the error response itself when there is none or it is too large
+func readUpload(w http.ResponseWriter, r *http.Request, field string) ([]byte, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, service.MaxPhotoBytes+maxUploadOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, `{"error": "Expected a multipart/form-data upload"}`, http.StatusBadRequest)
+		return nil, false
+	}
+
+	var data []byte
+	for data == nil {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err == nil && part.FormName() == field {
+			// Read one byte past the limit to tell a full-size image from a larger one
+			data, err = io.ReadAll(io.LimitReader(part, service.MaxPhotoBytes+1))
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) || len(data) > service.MaxPhotoBytes {
+			http.Error(w, fmt.Sprintf(`{"error": "Images must be at most %d MB"}`, service.MaxPhotoBytes>>20), http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		if err != nil {
+			http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	if data == nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Missing %s"}`, field), http.StatusBadRequest)
+		return nil, false
+	}
+	return data, true
+}
+
+// @Summary List photos
+// @Description Returns the authenticated user's photos in display order, the main photo first
+// @Tags Photos
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} map[string][]models.Photo
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /photos [get]
+func ListPhotosHandler(w http.ResponseWriter, r *http.Request) {
+	photos, err := photoService.ListPhotos(authenticatedUserID(r))
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]models.Photo{"photos": photos})
+}
+
+// @Summary Reorder photos
+// @Description Puts the authenticated user's photos in the given order. Every photo must be listed exactly once; the first becomes the main photo. Making a photo the main one that was not reviewed when the user was verified revokes the verified badge.
+// @Tags Photos
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param photoIDs body []string true "Photo IDs in display order"
+// @Success 200 {object} map[string][]models.Photo
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /photos/order [put]
+func ReorderPhotosHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		PhotoIDs []string `json:"photoIDs"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	userID := authenticatedUserID(r)
+	photos, err := photoService.ReorderPhotos(userID, request.PhotoIDs)
+	if errors.Is(err, service.ErrInvalidPhoto) {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	revokeBadgeOnPhotoChange(userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]models.Photo{"photos": photos})
+}
+
+// @Summary Delete photo
+// @Description Deletes one of the authenticated user's photos; the photos after it move up. The verified badge is revoked if the new main photo was not reviewed when the user was verified, or if fewer than half of the reviewed photos are left.
+// @Tags Photos
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "Photo ID"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /photos/{id} [delete]
+func DeletePhotoHandler(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+	err := photoService.DeletePhoto(userID, mux.Vars(r)["id"])
+	if errors.Is(err, service.ErrPhotoNotFound) {
+		http.Error(w, `{"error": "Photo not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	revokeBadgeOnPhotoChange(userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Photo deleted"})
+}
+
+// ServeBlobHandler serves blobs kept in a LocalBlobStore at the URLs it hands
+// out. Keys are random, so knowing the URL is what grants access, as with a
+// CDN in front of a bucket. Data exports are only served through their
+// expiring download links and verification selfies only to moderators.
+func ServeBlobHandler(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if strings.HasPrefix(key, service.ExportKeyPrefix) || strings.HasPrefix(key, service.VerificationKeyPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	blob, err := blobStore.Get(key)
+	if errors.Is(err, service.ErrBlobNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Storage error", http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	// Blobs are never modified, only replaced under a new key
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	io.Copy(w, blob)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"dating-app/models"
+	"dating-app/service"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockPhotoService struct {
+	mock.Mock
+}
+
+func (m *MockPhotoService) UploadPhoto(userID string, data []byte) (models.Photo, error) {
+	args := m.Called(userID, data)
+	return args.Get(0).(models.Photo), args.Error(1)
+}
+
+func (m *MockPhotoService) ListPhotos(userID string) ([]models.Photo, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]models.Photo), args.Error(1)
+}
+
+func (m *MockPhotoService) ReorderPhotos(userID string, photoIDs []string) ([]models.Photo, error) {
+	args := m.Called(userID, photoIDs)
+	return args.Get(0).([]models.Photo), args.Error(1)
+}
+
+func (m *MockPhotoService) DeletePhoto(userID, photoID string) error {
+	args := m.Called(userID, photoID)
+	return args.Error(0)
+}
+
+// multipartBody builds an upload with the given file under the given field name
+func multipartBody(t *testing.T, field string, data []byte) ([]byte, string) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile(field, "photo.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	writer.Close()
+	return body.Bytes(), writer.FormDataContentType()
+}
+
+func TestUploadPhotoHandler(t *testing.T) {
+	photo := []byte("image bytes")
+
+	tests := []struct {
+		name           string
+		field          string
+		data           []byte
+		mockErr        error
+		expectUpload   bool
+		expectedStatus int
+	}{
+		{
+			name:           "Photo is uploaded",
+			field:          "photo",
+			data:           photo,
+			expectUpload:   true,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Not an image",
+			field:          "photo",
+			data:           photo,
+			mockErr:        service.ErrInvalidPhoto,
+			expectUpload:   true,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Photo limit reached",
+			field:          "photo",
+			data:           photo,
+			mockErr:        service.ErrTooManyPhotos,
+			expectUpload:   true,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Photo too large",
+			field:          "photo",
+			data:           make([]byte, service.MaxPhotoBytes+1),
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "Missing photo field",
+			field:          "file",
+			data:           photo,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockPhotoService := new(MockPhotoService)
+			photoService = mockPhotoService
+			if tt.expectUpload {
+				mockPhotoService.On("UploadPhoto", "user1", tt.data).Return(models.Photo{ID: "1", UserID: "user1"}, tt.mockErr)
+			}
+
+			body, contentType := multipartBody(t, tt.field, tt.data)
+			req := authorizedRequest(t, "POST", "/photos", "user1", body)
+			req.Header.Set("Content-Type", contentType)
+
+			rr := httptest.NewRecorder()
+			handler := authenticate(UploadPhotoHandler)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockPhotoService.AssertExpectations(t)
+		})
+	}
+}
+
+// exifJPEG encodes an image as a JPEG carrying an EXIF orientation tag
+func exifJPEG(t *testing.T, img image.Image, orientation uint16) []byte {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Big-endian TIFF header followed by an IFD holding only the orientation
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00")
+	binary.BigEndian.PutUint16(tiff[18:], orientation)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(data[4:], uint16(len(segment)+2))
+	data = append(data, segment...)
+	return append(data, encoded.Bytes()[2:]...)
+}
+
+func TestProcessPhoto(t *testing.T) {
+	landscape := image.NewRGBA(image.Rect(0, 0, 2000, 1000))
+	for x := 0; x < 1000; x++ {
+		for y := 0; y < 1000; y++ {
+			landscape.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	var pngData bytes.Buffer
+	png.Encode(&pngData, landscape)
+	var smallData bytes.Buffer
+	png.Encode(&smallData, image.NewRGBA(image.Rect(0, 0, 100, 100)))
+
+	tests := []struct {
+		name           string
+		data           []byte
+		expectedWidth  int
+		expectedHeight int
+		redAt          image.Point
+		expectErr      bool
+	}{
+		{
+			name:           "PNG is scaled down",
+			data:           pngData.Bytes(),
+			expectedWidth:  1280,
+			expectedHeight: 640,
+			redAt:          image.Pt(100, 320),
+		},
+		{
+			name:           "EXIF orientation is applied",
+			data:           exifJPEG(t, landscape, 6),
+			expectedWidth:  640,
+			expectedHeight: 1280,
+			redAt:          image.Pt(320, 100),
+		},
+		{
+			name:      "Not an image",
+			data:      []byte("<html><body>hello</body></html>"),
+			expectErr: true,
+		},
+		{
+			name:      "Too small",
+			data:      smallData.Bytes(),
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processed, err := service.ProcessPhoto(tt.data)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("expected an error for %s", tt.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if processed.Width != tt.expectedWidth || processed.Height != tt.expectedHeight {
+				t.Errorf("wrong dimensions: got %dx%d want %dx%d", processed.Width, processed.Height, tt.expectedWidth, tt.expectedHeight)
+			}
+			for _, size := range []string{models.PhotoSizeLarge, models.PhotoSizeMedium, models.PhotoSizeThumbnail} {
+				if bytes.Contains(processed.Sizes[size], []byte("Exif")) {
+					t.Errorf("%s rendition kept EXIF metadata", size)
+				}
+				img, err := jpeg.Decode(bytes.NewReader(processed.Sizes[size]))
+				if err != nil {
+					t.Fatalf("%s rendition is not a JPEG: %v", size, err)
+				}
+				if size == models.PhotoSizeLarge {
+					if r, g, _, _ := img.At(tt.redAt.X, tt.redAt.Y).RGBA(); r < 0xc000 || g > 0x4000 {
+						t.Errorf("large rendition is not upright: pixel %v is not red", tt.redAt)
+					}
+				}
+				if size == models.PhotoSizeThumbnail && img.Bounds().Dx() != img.Bounds().Dy() {
+					t.Errorf("thumbnail is not square: %v", img.Bounds())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"dating-app/models"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// @Summary Presence setting
+// @Description Returns whether the authenticated user's matches can see when they are online or recently active
+// @Tags Presence
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} map[string]bool
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /settings/presence [get]
+func GetPresenceSettingHandler(w http.ResponseWriter, r *http.Request) {
+	visible, err := userService.GetPresenceVisible(authenticatedUserID(r))
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]bool{"showPresence": visible})
+}
+
+// @Summary Change presence setting
+// @Description Shows or hides the authenticated user's presence. Hidden presence is left out of /matches and no presence events are sent for the user.
+// @Tags Presence
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param showPresence body bool true "Whether matches can see the user's presence"
+// @Success 200 {object} map[string]bool
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /settings/presence [put]
+func SetPresenceSettingHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ShowPresence *bool `json:"showPresence"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.ShowPresence == nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := userService.SetPresenceVisible(authenticatedUserID(r), *request.ShowPresence); err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]bool{"showPresence": *request.ShowPresence})
+}
+
+// seen records that the user was just seen and tells their matches about the
+// new presence, unless the user hides it. Presence events are hints for open
+// chats; /matches has the presence as of the request.
+func seen(userID, presence string) {
+	now := time.Now()
+	visible, err := userService.RecordSeen(userID, now)
+	if err != nil {
+		log.Printf("failed to record that %s was seen: %v", userID, err)
+		return
+	}
+	if !visible {
+		return
+	}
+
+	matches, err := chatService.ListMatches(userID, now)
+	if err != nil {
+		log.Printf("failed to announce the presence of %s: %v", userID, err)
+		return
+	}
+	event := models.Event{Type: models.EventPresence, Data: models.Presence{UserID: userID, Status: presence}}
+	for _, match := range matches {
+		broker.Publish(match.OtherUser(userID), event)
+	}
+}
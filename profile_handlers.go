@@ -0,0 +1,118 @@
+package main
+
+import (
+	"dating-app/models"
+	"dating-app/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// @Summary Update profile
+// @Description Creates or replaces the authenticated user's profile. Users must be at least 18; birthdate is never shown to other users, who only see the age. Prompts and tags must come from /profile/catalog.
+// @Tags Profile
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param profile body models.Profile true "Profile"
+// @Success 200 {object} models.Profile
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /profile [put]
+func UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	var profile models.Profile
+	if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	updated, err := profileService.UpdateProfile(authenticatedUserID(r), profile)
+	if errors.Is(err, service.ErrInvalidProfile) {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(updated)
+}
+
+// @Summary Profile catalog
+// @Description Returns the prompts users can answer (up to 3) and the interest tags they can pick (up to 10) for their profile
+// @Tags Profile
+// @Produce  json
+// @Success 200 {object} models.ProfileCatalog
+// @Failure 500 {object} map[string]string
+// @Router /profile/catalog [get]
+func ProfileCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	catalog, err := profileService.Catalog()
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(catalog)
+}
+
+// @Summary View profile
+// @Description Returns a user's profile. Owners get their full profile (models.Profile); everyone else gets the public view (models.PublicProfile) without private fields and with the user's photos in display order and whether they hold the verified badge. Users who blocked each other get 404, as if the profile didn't exist.
+// @Tags Profile
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param userID path string true "User ID"
+// @Success 200 {object} models.PublicProfile
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /profile/{userID} [get]
+func GetProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["userID"]
+	viewerID := authenticatedUserID(r)
+	if userID != viewerID {
+		isBlocked, err := safetyService.Blocked(viewerID, userID)
+		if err != nil {
+			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+			return
+		}
+		if isBlocked {
+			http.Error(w, `{"error": "Profile not found"}`, http.StatusNotFound)
+			return
+		}
+	}
+
+	profile, err := profileService.GetProfile(userID)
+	if errors.Is(err, service.ErrProfileNotFound) {
+		http.Error(w, `{"error": "Profile not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if userID == viewerID {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(profile)
+		return
+	}
+
+	now := time.Now()
+	public := service.PublicView(profile, now)
+	public.Photos, err = photoService.ListPhotos(userID)
+	if err == nil {
+		public.Verified, err = verificationService.IsVerified(userID, now)
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(public)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dating-app/models"
+	"dating-app/repository"
+	"dating-app/service"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockProfileRepository struct {
+	mock.Mock
+}
+
+func (m *MockProfileRepository) GetUserProfile(userID string) (models.Profile, error) {
+	args := m.Called(userID)
+	return args.Get(0).(models.Profile), args.Error(1)
+}
+
+func (m *MockProfileRepository) UpdateUserProfile(profile models.Profile) (models.Profile, error) {
+	args := m.Called(profile)
+	return args.Get(0).(models.Profile), args.Error(1)
+}
+
+func (m *MockProfileRepository) GetProfileCatalog() (models.ProfileCatalog, error) {
+	args := m.Called()
+	return args.Get(0).(models.ProfileCatalog), args.Error(1)
+}
+
+func TestUpdateProfileHandler(t *testing.T) {
+	adult := time.Now().AddDate(-30, 0, 0).Format("2006-01-02")
+	minor := time.Now().AddDate(-17, 0, 0).Format("2006-01-02")
+	catalog := models.ProfileCatalog{
+		Prompts: []models.Prompt{{ID: "simple_pleasures", Text: "My simple pleasures"}, {ID: "green_flags", Text: "Green flags I look for"}},
+		Tags:    []models.Tag{{ID: "hiking", Label: "Hiking", Category: "outdoors"}, {ID: "jazz", Label: "Jazz", Category: "music"}},
+	}
+	answers := []models.PromptAnswer{{PromptID: "simple_pleasures", Answer: "Coffee"}, {PromptID: "green_flags", Answer: "Kindness"}}
+
+	tests := []struct {
+		name           string
+		profile        models.Profile
+		expectedSave   *models.Profile
+		expectCatalog  bool
+		expectedStatus int
+	}{
+		{
+			name:           "Valid profile is normalized and saved",
+			profile:        models.Profile{DisplayName: "  Alice ", Bio: "Hi", Birthdate: adult, Gender: models.GenderWoman, Interests: []string{"Hiking", "hiking", " ", "Jazz"}, Prompts: []models.PromptAnswer{{PromptID: "simple_pleasures", Answer: " Coffee "}}, Tags: []string{"jazz", "jazz"}},
+			expectedSave:   &models.Profile{UserID: "user1", DisplayName: "Alice", Bio: "Hi", Birthdate: adult, Gender: models.GenderWoman, Interests: []string{"Hiking", "Jazz"}, Prompts: []models.PromptAnswer{{PromptID: "simple_pleasures", Answer: "Coffee"}}, Tags: []string{"jazz"}},
+			expectCatalog:  true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Unknown tag",
+			profile:        models.Profile{DisplayName: "Alice", Birthdate: adult, Gender: models.GenderWoman, Tags: []string{"skydiving"}},
+			expectCatalog:  true,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown prompt",
+			profile:        models.Profile{DisplayName: "Alice", Birthdate: adult, Gender: models.GenderWoman, Prompts: []models.PromptAnswer{{PromptID: "made_up", Answer: "Hi"}}},
+			expectCatalog:  true,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "More than three prompts",
+			profile:        models.Profile{DisplayName: "Alice", Birthdate: adult, Gender: models.GenderWoman, Prompts: append(answers, answers...)},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Prompt answered twice",
+			profile:        models.Profile{DisplayName: "Alice", Birthdate: adult, Gender: models.GenderWoman, Prompts: []models.PromptAnswer{answers[0], answers[0]}},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Empty prompt answer",
+			profile:        models.Profile{DisplayName: "Alice", Birthdate: adult, Gender: models.GenderWoman, Prompts: []models.PromptAnswer{{PromptID: "green_flags", Answer: "  "}}},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Under minimum age",
+			profile:        models.Profile{DisplayName: "Alice", Birthdate: minor, Gender: models.GenderWoman},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Malformed birthdate",
+			profile:        models.Profile{DisplayName: "Alice", Birthdate: "01/02/1990", Gender: models.GenderWoman},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown gender",
+			profile:        models.Profile{DisplayName: "Alice", Birthdate: adult, Gender: "unknown"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Missing display name",
+			profile:        models.Profile{DisplayName: "   ", Birthdate: adult, Gender: models.GenderWoman},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepository := new(MockProfileRepository)
+			profileService = &service.ProfileServiceImpl{Repository: mockRepository}
+			if tt.expectCatalog {
+				mockRepository.On("GetProfileCatalog").Return(catalog, nil)
+			}
+			if tt.expectedSave != nil {
+				mockRepository.On("UpdateUserProfile", *tt.expectedSave).Return(*tt.expectedSave, nil)
+			}
+
+			body, _ := json.Marshal(tt.profile)
+			rr := httptest.NewRecorder()
+			handler := authenticate(UpdateProfileHandler)
+			handler.ServeHTTP(rr, authorizedRequest(t, "PUT", "/profile", "user1", body))
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockRepository.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetProfileHandler(t *testing.T) {
+	birthdate := time.Now().AddDate(-30, 0, -1)
+	profile := models.Profile{UserID: "user1", DisplayName: "Alice", Birthdate: birthdate.Format("2006-01-02"), Gender: models.GenderWoman, Interests: []string{"Jazz"}}
+
+	tests := []struct {
+		name           string
+		viewerID       string
+		blocked        bool
+		mockErr        error
+		expectedStatus int
+		expectPrivate  bool
+	}{
+		{
+			name:           "Owner sees the full profile",
+			viewerID:       "user1",
+			expectedStatus: http.StatusOK,
+			expectPrivate:  true,
+		},
+		{
+			name:           "Other users see the public view",
+			viewerID:       "user2",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "No profile yet",
+			viewerID:       "user2",
+			mockErr:        repository.ErrNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Blocked either way",
+			viewerID:       "user2",
+			blocked:        true,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepository := new(MockProfileRepository)
+			profileService = &service.ProfileServiceImpl{Repository: mockRepository}
+			if !tt.blocked {
+				mockRepository.On("GetUserProfile", "user1").Return(profile, tt.mockErr)
+			}
+			mockSafetyService := new(MockSafetyService)
+			safetyService = mockSafetyService
+			if tt.viewerID != "user1" {
+				mockSafetyService.On("Blocked", tt.viewerID, "user1").Return(tt.blocked, nil)
+			}
+			mockPhotoService := new(MockPhotoService)
+			photoService = mockPhotoService
+			mockVerificationService := new(MockVerificationService)
+			verificationService = mockVerificationService
+			if tt.expectedStatus == http.StatusOK && !tt.expectPrivate {
+				mockPhotoService.On("ListPhotos", "user1").Return([]models.Photo{{ID: "1", UserID: "user1"}}, nil)
+				mockVerificationService.On("IsVerified", "user1", mock.AnythingOfType("time.Time")).Return(true, nil)
+			}
+
+			req := authorizedRequest(t, "GET", "/profile/user1", tt.viewerID, nil)
+			req = mux.SetURLVars(req, map[string]string{"userID": "user1"})
+			rr := httptest.NewRecorder()
+			handler := authenticate(GetProfileHandler)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			if tt.expectedStatus == http.StatusOK {
+				var responseBody map[string]interface{}
+				if err := json.NewDecoder(rr.Body).Decode(&responseBody); err != nil {
+					t.Fatal(err)
+				}
+				_, hasBirthdate := responseBody["birthdate"]
+				if hasBirthdate != tt.expectPrivate {
+					t.Errorf("handler returned unexpected body: got %v", responseBody)
+				}
+				if !tt.expectPrivate && responseBody["age"] != float64(30) {
+					t.Errorf("handler returned wrong age: got %v want %v", responseBody["age"], 30)
+				}
+				if photos, _ := responseBody["photos"].([]interface{}); !tt.expectPrivate && (len(photos) != 1 || responseBody["verified"] != true) {
+					t.Errorf("handler returned unexpected body: got %v", responseBody)
+				}
+			}
+
+			mockRepository.AssertExpectations(t)
+			mockPhotoService.AssertExpectations(t)
+			mockVerificationService.AssertExpectations(t)
+			mockSafetyService.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"dating-app/models"
+	"dating-app/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// @Summary Create promo code
+// @Description Creates a promo code for a marketing campaign. percent_off codes discount an order at checkout, optionally only for one sku; free_premium_days codes gift days of the premium product named by sku; extra_swipes codes gift swipes used after the daily quota.
+// @Tags Promotions
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token of an admin"
+// @Param promoCode body models.PromoCode true "Promo code"
+// @Success 201 {object} map[string]models.PromoCode
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/promo-codes [post]
+func CreatePromoCodeHandler(w http.ResponseWriter, r *http.Request) {
+	var promo models.PromoCode
+	if err := json.NewDecoder(r.Body).Decode(&promo); err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	promo.Code = service.NormalizePromoCode(promo.Code)
+	if promo.MaxPerUser == 0 {
+		promo.MaxPerUser = 1
+	}
+	if err := service.ValidatePromoCode(promo); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if promo.SKU != "" {
+		product, err := purchaseService.GetProduct(promo.SKU)
+		if errors.Is(err, service.ErrProductNotFound) {
+			http.Error(w, `{"error": "Unknown product"}`, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+			return
+		}
+		if promo.Effect == models.PromoEffectFreePremiumDays && (product.DurationDays == 0 || !hasEntitlement(product, models.EntitlementPremium)) {
+			http.Error(w, `{"error": "Product is not a premium subscription"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	created, err := promoService.CreatePromoCode(promo)
+	if errors.Is(err, service.ErrPromoCodeExists) {
+		http.Error(w, `{"error": "Promo code already exists"}`, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]models.PromoCode{"promoCode": created})
+}
+
+// @Summary List promo codes
+// @Description Returns every promo code with its redemption count, newest first
+// @Tags Promotions
+// @Produce  json
+// @Param Authorization header string true "Bearer token of an admin"
+// @Success 200 {object} map[string][]models.PromoCode
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/promo-codes [get]
+func ListPromoCodesHandler(w http.ResponseWriter, r *http.Request) {
+	promos, err := promoService.ListPromoCodes()
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]models.PromoCode{"promoCodes": promos})
+}
+
+// @Summary Redeem promo code
+// @Description Redeems a gift code (free premium days or extra swipes) for the authenticated user. Discount codes are applied at checkout through /purchase instead.
+// @Tags Promotions
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param Idempotency-Key header string false "Client-generated key that makes retries safe"
+// @Param code body string true "Promo code"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /promo-codes/redeem [post]
+func RedeemPromoCodeHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Code == "" {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	userID := authenticatedUserID(r)
+	now := time.Now()
+	promo, err := promoService.Redeem(request.Code, userID, nil, now)
+	if err != nil {
+		writePromoError(w, err)
+		return
+	}
+
+	if err := applyPromoGift(userID, promo, now); err != nil {
+		log.Printf("promo code %s redeemed by %s but not applied: %v", promo.Code, userID, err)
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Promo code redeemed",
+		"effect":  promo.Effect,
+		"value":   strconv.Itoa(promo.Value),
+	})
+}
+
+// applyPromoGift credits the effect of a redeemed gift code to the user
+func applyPromoGift(userID string, promo models.PromoCode, now time.Time) error {
+	switch promo.Effect {
+	case models.PromoEffectExtraSwipes:
+		return userService.AddExtraSwipes(userID, promo.Value)
+	case models.PromoEffectFreePremiumDays:
+		product, err := purchaseService.GetProduct(promo.SKU)
+		if err != nil {
+			return err
+		}
+		if err := service.GrantEntitlements(userService, userID, product); err != nil {
+			return err
+		}
+		_, err = subscriptionService.Extend(userID, product, promo.Value, now)
+		return err
+	}
+	return fmt.Errorf("promo effect %q is not a gift", promo.Effect)
+}
+
+// writePromoError reports why a promo code could not be used
+func writePromoError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrPromoCodeNotFound):
+		http.Error(w, `{"error": "Invalid promo code"}`, http.StatusBadRequest)
+	case errors.Is(err, service.ErrPromoCodeNotActive),
+		errors.Is(err, service.ErrPromoCodeExhausted),
+		errors.Is(err, service.ErrPromoCodeNotApplicable):
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+	default:
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+	}
+}
+
+func hasEntitlement(product models.Product, entitlement string) bool {
+	for _, e := range product.Entitlements {
+		if e == entitlement {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dating-app/models"
+	"dating-app/service"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockPromoService struct {
+	mock.Mock
+}
+
+func (m *MockPromoService) CreatePromoCode(promo models.PromoCode) (models.PromoCode, error) {
+	args := m.Called(promo)
+	return args.Get(0).(models.PromoCode), args.Error(1)
+}
+
+func (m *MockPromoService) ListPromoCodes() ([]models.PromoCode, error) {
+	args := m.Called()
+	return args.Get(0).([]models.PromoCode), args.Error(1)
+}
+
+func (m *MockPromoService) GetPromoCode(code string) (models.PromoCode, error) {
+	args := m.Called(code)
+	return args.Get(0).(models.PromoCode), args.Error(1)
+}
+
+func (m *MockPromoService) Redeem(code, userID string, purchase *models.Purchase, now time.Time) (models.PromoCode, error) {
+	args := m.Called(code, userID, purchase, now)
+	return args.Get(0).(models.PromoCode), args.Error(1)
+}
+
+func (m *MockPromoService) ReleaseRedemption(purchaseID string) error {
+	args := m.Called(purchaseID)
+	return args.Error(0)
+}
+
+func TestCreatePromoCodeHandler(t *testing.T) {
+	validFrom := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	validUntil := validFrom.AddDate(0, 1, 0)
+	premium := models.Product{SKU: "premium_monthly", Price: 999, Currency: "USD", Entitlements: []string{models.EntitlementPremium}, DurationDays: 30, Active: true}
+	boost := models.Product{SKU: "boost_1", Price: 299, Currency: "USD", Entitlements: []string{models.EntitlementBoost}, Quantity: 1, Active: true}
+
+	tests := []struct {
+		name           string
+		roles          []string
+		promo          models.PromoCode
+		product        *models.Product
+		mockErr        error
+		expectCreate   bool
+		expectedStatus int
+	}{
+		{
+			name:           "Create discount code",
+			roles:          []string{models.RoleAdmin},
+			promo:          models.PromoCode{Code: "spring25", Campaign: "spring", Effect: models.PromoEffectPercentOff, Value: 25, MaxRedemptions: 1000, ValidFrom: validFrom, ValidUntil: validUntil},
+			expectCreate:   true,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Create free premium days code",
+			roles:          []string{models.RoleAdmin},
+			promo:          models.PromoCode{Code: "WEEKFREE", Campaign: "launch", Effect: models.PromoEffectFreePremiumDays, Value: 7, SKU: "premium_monthly", ValidFrom: validFrom, ValidUntil: validUntil},
+			product:        &premium,
+			expectCreate:   true,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Free premium days need a premium product",
+			roles:          []string{models.RoleAdmin},
+			promo:          models.PromoCode{Code: "WEEKFREE", Campaign: "launch", Effect: models.PromoEffectFreePremiumDays, Value: 7, SKU: "boost_1", ValidFrom: validFrom, ValidUntil: validUntil},
+			product:        &boost,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Percentage out of range",
+			roles:          []string{models.RoleAdmin},
+			promo:          models.PromoCode{Code: "HALFOFF", Effect: models.PromoEffectPercentOff, Value: 150, ValidFrom: validFrom, ValidUntil: validUntil},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Validity window ends before it starts",
+			roles:          []string{models.RoleAdmin},
+			promo:          models.PromoCode{Code: "BACKWARDS", Effect: models.PromoEffectExtraSwipes, Value: 10, ValidFrom: validUntil, ValidUntil: validFrom},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Duplicate code",
+			roles:          []string{models.RoleAdmin},
+			promo:          models.PromoCode{Code: "SWIPES10", Effect: models.PromoEffectExtraSwipes, Value: 10, ValidFrom: validFrom, ValidUntil: validUntil},
+			mockErr:        service.ErrPromoCodeExists,
+			expectCreate:   true,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Not an admin",
+			roles:          []string{models.RoleModerator},
+			promo:          models.PromoCode{Code: "SWIPES10", Effect: models.PromoEffectExtraSwipes, Value: 10, ValidFrom: validFrom, ValidUntil: validUntil},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockPromoService := new(MockPromoService)
+			promoService = mockPromoService
+			mockPurchaseService := new(MockPurchaseService)
+			purchaseService = mockPurchaseService
+
+			if tt.product != nil {
+				mockPurchaseService.On("GetProduct", tt.product.SKU).Return(*tt.product, nil)
+			}
+			if tt.expectCreate {
+				expected := tt.promo
+				expected.Code = service.NormalizePromoCode(expected.Code)
+				expected.MaxPerUser = 1
+				mockPromoService.On("CreatePromoCode", expected).Return(expected, tt.mockErr)
+			}
+
+			body, _ := json.Marshal(tt.promo)
+			req := staffRequest(t, "POST", "/admin/promo-codes", "staff1", tt.roles, body)
+
+			rr := httptest.NewRecorder()
+			handler := authenticate(requireRole(models.RoleAdmin, CreatePromoCodeHandler))
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockPromoService.AssertExpectations(t)
+			mockPurchaseService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRedeemPromoCodeHandler(t *testing.T) {
+	premium := models.Product{SKU: "premium_monthly", Price: 999, Currency: "USD", Entitlements: []string{models.EntitlementPremium}, DurationDays: 30, Active: true}
+
+	tests := []struct {
+		name           string
+		code           string
+		mockReturn     models.PromoCode
+		mockErr        error
+		expectedStatus int
+		expectedBody   map[string]string
+	}{
+		{
+			name:           "Extra swipes",
+			code:           "SWIPES10",
+			mockReturn:     models.PromoCode{Code: "SWIPES10", Effect: models.PromoEffectExtraSwipes, Value: 10},
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]string{"message": "Promo code redeemed", "effect": models.PromoEffectExtraSwipes, "value": "10"},
+		},
+		{
+			name:           "Free premium days",
+			code:           "WEEKFREE",
+			mockReturn:     models.PromoCode{Code: "WEEKFREE", Effect: models.PromoEffectFreePremiumDays, Value: 7, SKU: "premium_monthly"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]string{"message": "Promo code redeemed", "effect": models.PromoEffectFreePremiumDays, "value": "7"},
+		},
+		{
+			name:           "Discount codes are only accepted at checkout",
+			code:           "SPRING25",
+			mockErr:        service.ErrPromoCodeNotApplicable,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"error": service.ErrPromoCodeNotApplicable.Error()},
+		},
+		{
+			name:           "Already redeemed",
+			code:           "SWIPES10",
+			mockErr:        service.ErrPromoCodeExhausted,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"error": service.ErrPromoCodeExhausted.Error()},
+		},
+		{
+			name:           "Unknown code",
+			code:           "NOPE",
+			mockErr:        service.ErrPromoCodeNotFound,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"error": "Invalid promo code"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserService := new(MockUserService)
+			userService = mockUserService
+			mockPurchaseService := new(MockPurchaseService)
+			purchaseService = mockPurchaseService
+			mockSubscriptionService := new(MockSubscriptionService)
+			subscriptionService = mockSubscriptionService
+			mockPromoService := new(MockPromoService)
+			promoService = mockPromoService
+
+			mockPromoService.On("Redeem", tt.code, "user1", (*models.Purchase)(nil), mock.AnythingOfType("time.Time")).Return(tt.mockReturn, tt.mockErr)
+			switch tt.mockReturn.Effect {
+			case models.PromoEffectExtraSwipes:
+				mockUserService.On("AddExtraSwipes", "user1", tt.mockReturn.Value).Return(nil)
+			case models.PromoEffectFreePremiumDays:
+				mockPurchaseService.On("GetProduct", premium.SKU).Return(premium, nil)
+				mockUserService.On("PurchasePremium", "user1").Return(nil)
+				mockSubscriptionService.On("Extend", "user1", premium, tt.mockReturn.Value, mock.AnythingOfType("time.Time")).Return(models.Subscription{ID: "sub1"}, nil)
+			}
+
+			body, _ := json.Marshal(map[string]string{"code": tt.code})
+			rr := httptest.NewRecorder()
+			handler := authenticate(RedeemPromoCodeHandler)
+			handler.ServeHTTP(rr, authorizedRequest(t, "POST", "/promo-codes/redeem", "user1", body))
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			var responseBody map[string]string
+			if err := json.NewDecoder(rr.Body).Decode(&responseBody); err != nil {
+				t.Fatal(err)
+			}
+
+			for key, value := range tt.expectedBody {
+				if responseBody[key] != value {
+					t.Errorf("handler returned unexpected body: got %v want %v", responseBody, tt.expectedBody)
+				}
+			}
+
+			mockUserService.AssertExpectations(t)
+			mockPurchaseService.AssertExpectations(t)
+			mockSubscriptionService.AssertExpectations(t)
+			mockPromoService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPurchaseHandlerWithPromoCode(t *testing.T) {
+	paymentGateway = &service.FakePaymentGateway{}
+	now := time.Now()
+	product := models.Product{SKU: "boost_1", Price: 299, Currency: "USD", Entitlements: []string{models.EntitlementBoost}, Quantity: 1, Active: true}
+
+	tests := []struct {
+		name           string
+		promo          models.PromoCode
+		expectedStatus int
+		expectedBody   map[string]string
+	}{
+		{
+			name:           "Partial discount still requires payment",
+			promo:          models.PromoCode{Code: "HALFOFF", Effect: models.PromoEffectPercentOff, Value: 50, MaxPerUser: 1, ValidFrom: now.Add(-time.Hour), ValidUntil: now.Add(time.Hour)},
+			expectedStatus: http.StatusAccepted,
+			expectedBody:   map[string]string{"message": "Payment required"},
+		},
+		{
+			name:           "Fully discounted order completes without payment",
+			promo:          models.PromoCode{Code: "FREEBIE", Effect: models.PromoEffectPercentOff, Value: 100, MaxPerUser: 1, ValidFrom: now.Add(-time.Hour), ValidUntil: now.Add(time.Hour)},
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]string{"message": "Purchase completed"},
+		},
+		{
+			name:           "Expired code",
+			promo:          models.PromoCode{Code: "OLDCODE", Effect: models.PromoEffectPercentOff, Value: 50, MaxPerUser: 1, ValidFrom: now.Add(-2 * time.Hour), ValidUntil: now.Add(-time.Hour)},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"error": service.ErrPromoCodeNotActive.Error()},
+		},
+		{
+			name:           "Code for another product",
+			promo:          models.PromoCode{Code: "PREMIUM50", Effect: models.PromoEffectPercentOff, Value: 50, SKU: "premium_monthly", MaxPerUser: 1, ValidFrom: now.Add(-time.Hour), ValidUntil: now.Add(time.Hour)},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"error": service.ErrPromoCodeNotApplicable.Error()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserService := new(MockUserService)
+			userService = mockUserService
+			mockPurchaseService := new(MockPurchaseService)
+			purchaseService = mockPurchaseService
+			mockPromoService := new(MockPromoService)
+			promoService = mockPromoService
+
+			mockPurchaseService.On("GetProduct", product.SKU).Return(product, nil)
+			mockPromoService.On("GetPromoCode", tt.promo.Code).Return(tt.promo, nil)
+			if tt.expectedStatus != http.StatusBadRequest {
+				purchase := models.Purchase{ID: "purchase1", UserID: "user1", SKU: product.SKU, Amount: service.DiscountedPrice(product.Price, &tt.promo), Currency: product.Currency, Status: models.PurchaseStatusPending, PromoCode: tt.promo.Code}
+				mockPurchaseService.On("CreatePurchase", "user1", product, &tt.promo).Return(purchase, nil)
+				mockPromoService.On("Redeem", tt.promo.Code, "user1", &purchase, mock.AnythingOfType("time.Time")).Return(tt.promo, nil)
+				if purchase.Amount == 0 {
+					mockPurchaseService.On("FulfilPurchase", "purchase1", mock.AnythingOfType("time.Time")).Return(true, nil)
+				} else {
+					mockPurchaseService.On("SetPaymentIntent", "purchase1", mock.AnythingOfType("string")).Return(nil)
+				}
+			}
+
+			body, _ := json.Marshal(map[string]string{"purchaseType": product.SKU, "promoCode": tt.promo.Code})
+			req := authorizedRequest(t, "POST", "/purchase", "user1", body)
+
+			rr := httptest.NewRecorder()
+			handler := authenticate(PurchaseHandler)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			var responseBody map[string]string
+			if err := json.NewDecoder(rr.Body).Decode(&responseBody); err != nil {
+				t.Fatal(err)
+			}
+
+			for key, value := range tt.expectedBody {
+				if responseBody[key] != value {
+					t.Errorf("handler returned unexpected body: got %v want %v", responseBody, tt.expectedBody)
+				}
+			}
+
+			mockUserService.AssertExpectations(t)
+			mockPurchaseService.AssertExpectations(t)
+			mockPromoService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRedeemPromoCode(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	promoColumns := []string{"code", "campaign", "effect", "value", "sku", "max_redemptions", "max_per_user", "redemptions", "valid_from", "valid_until", "created_at"}
+	promoRow := func(redemptions int64) [][]driver.Value {
+		return [][]driver.Value{{"SPRING25", "spring", models.PromoEffectPercentOff, int64(25), "", int64(100), int64(1), redemptions, now.AddDate(0, 0, -1), now.AddDate(0, 0, 30), now.AddDate(0, 0, -1)}}
+	}
+	purchase := &models.Purchase{ID: "purchase1", SKU: "premium_monthly"}
+
+	tests := []struct {
+		name    string
+		script  func(*sqlScript)
+		wantErr error
+	}{
+		{
+			name: "Redeemed",
+			script: func(script *sqlScript) {
+				script.query("FROM promo_codes WHERE code=$1 FOR UPDATE", promoColumns, promoRow(4), "SPRING25")
+				script.query("SELECT COUNT(*) FROM promo_redemptions", []string{"count"}, [][]driver.Value{{int64(0)}}, "SPRING25", "user1")
+				script.exec("INSERT INTO promo_redemptions", 1, "SPRING25", "user1", "purchase1", now)
+				script.exec("UPDATE promo_codes SET redemptions=redemptions+1", 1, "SPRING25")
+				script.commit()
+			},
+		},
+		{
+			name: "Used up by others while locked",
+			script: func(script *sqlScript) {
+				script.query("FROM promo_codes WHERE code=$1 FOR UPDATE", promoColumns, promoRow(100), "SPRING25")
+				script.rollback()
+			},
+			wantErr: service.ErrPromoCodeExhausted,
+		},
+		{
+			name: "Already used by the user",
+			script: func(script *sqlScript) {
+				script.query("FROM promo_codes WHERE code=$1 FOR UPDATE", promoColumns, promoRow(4), "SPRING25")
+				script.query("SELECT COUNT(*) FROM promo_redemptions", []string{"count"}, [][]driver.Value{{int64(1)}})
+				script.rollback()
+			},
+			wantErr: service.ErrPromoCodeExhausted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, script := newSQLScript(t)
+			script.begin()
+			tt.script(script)
+
+			promos := &service.PromoServiceImpl{DB: db}
+			if _, err := promos.Redeem("spring25", "user1", purchase, now); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Redeem() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"dating-app/models"
+	"dating-app/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// @Summary List products
+// @Description Returns the catalog of purchasable products
+// @Tags Payments
+// @Produce  json
+// @Success 200 {object} map[string][]models.Product
+// @Failure 500 {object} map[string]string
+// @Router /products [get]
+func ListProductsHandler(w http.ResponseWriter, r *http.Request) {
+	products, err := purchaseService.ListProducts()
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]models.Product{"products": products})
+}
+
+// @Summary Purchase history
+// @Description Returns every order placed by the authenticated user, newest first
+// @Tags Payments
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} map[string][]models.Purchase
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /purchases [get]
+func ListPurchasesHandler(w http.ResponseWriter, r *http.Request) {
+	purchases, err := purchaseService.ListPurchases(authenticatedUserID(r))
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]models.Purchase{"purchases": purchases})
+}
+
+// maxWebhookPayloadSize bounds the body accepted from the payment gateway
+const maxWebhookPayloadSize = 64 << 10
+
+// @Summary Payment webhook
+// @Description Receives payment events from the payment gateway. The X-Payment-Signature header must carry a valid HMAC-SHA256 signature of the payload; entitlements are only granted on payment.succeeded and taken back on payment.refunded.
+// @Tags Payments
+// @Accept  json
+// @Produce  json
+// @Param X-Payment-Signature header string true "Signature in the form t=<unix seconds>,v1=<hex HMAC>"
+// @Param event body models.PaymentEvent true "Payment event"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /webhooks/payments [post]
+func PaymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookPayloadSize))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := service.VerifyWebhookSignature(paymentWebhookSecret, payload, r.Header.Get("X-Payment-Signature"), time.Now()); err != nil {
+		http.Error(w, `{"error": "Invalid signature"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var event models.PaymentEvent
+	if err := json.Unmarshal(payload, &event); err != nil || event.IntentID == "" || (event.Type == models.PaymentEventRefunded && event.RefundID == "") {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	purchase, err := purchaseService.GetPurchaseByPaymentIntent(event.IntentID)
+	if errors.Is(err, service.ErrPurchaseNotFound) {
+		// Not one of ours; acknowledge so the gateway stops retrying
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Event ignored"})
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Gateways deliver events at least once, so a purchase that has already
+	// moved on from the status the event applies to has been handled. This is
+	// only a shortcut: the status change, or for a refund recording its ID, is
+	// what claims the event.
+	expectedStatus := models.PurchaseStatusPending
+	if event.Type == models.PaymentEventRefunded {
+		expectedStatus = models.PurchaseStatusPaid
+	}
+	if purchase.Status != expectedStatus {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Event ignored"})
+		return
+	}
+
+	switch event.Type {
+	case models.PaymentEventSucceeded:
+		if event.Amount != purchase.Amount || event.Currency != purchase.Currency {
+			log.Printf("payment %s amount %d %s does not match purchase %s", event.IntentID, event.Amount, event.Currency, purchase.ID)
+			http.Error(w, `{"error": "Amount mismatch"}`, http.StatusBadRequest)
+			return
+		}
+		fulfilled, err := purchaseService.FulfilPurchase(purchase.ID, time.Now())
+		if err != nil {
+			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+			return
+		}
+		if !fulfilled {
+			// A concurrent delivery got there first
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Event ignored"})
+			return
+		}
+	case models.PaymentEventFailed:
+		err := purchaseService.UpdatePurchaseStatus(purchase.ID, models.PurchaseStatusFailed)
+		if err != nil && !errors.Is(err, service.ErrInvalidStatusTransition) {
+			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+			return
+		}
+		// The order was never paid, so the buyer gets their promo code back
+		if err := promoService.ReleaseRedemption(purchase.ID); err != nil {
+			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+			return
+		}
+	case models.PaymentEventRefunded:
+		if event.Amount <= 0 || event.Amount > purchase.Amount || event.Currency != purchase.Currency {
+			log.Printf("refund %s amount %d %s does not match purchase %s", event.IntentID, event.Amount, event.Currency, purchase.ID)
+			http.Error(w, `{"error": "Amount mismatch"}`, http.StatusBadRequest)
+			return
+		}
+		_, err := purchaseService.RefundPurchase(models.Refund{PurchaseID: purchase.ID, RefundID: event.RefundID, Amount: event.Amount, Actor: "payment_gateway"}, time.Now())
+		if errors.Is(err, service.ErrRefundExceedsAmount) {
+			log.Printf("refund %s of %d %s exceeds what is left of purchase %s", event.RefundID, event.Amount, event.Currency, purchase.ID)
+			http.Error(w, `{"error": "Amount mismatch"}`, http.StatusBadRequest)
+			return
+		}
+		if err != nil && !errors.Is(err, service.ErrInvalidStatusTransition) {
+			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Event processed"})
+}
+
+// failPurchase marks an order that could not be placed as failed and gives
+// back any promo code applied to it
+func failPurchase(purchaseID string) {
+	if err := purchaseService.UpdatePurchaseStatus(purchaseID, models.PurchaseStatusFailed); err != nil {
+		log.Printf("failed to mark purchase %s as failed: %v", purchaseID, err)
+	}
+	if err := promoService.ReleaseRedemption(purchaseID); err != nil {
+		log.Printf("failed to release promo code of purchase %s: %v", purchaseID, err)
+	}
+}
+
+// ConfirmFakePaymentHandler completes a payment on the fake gateway, standing
+// in for the app's payment sheet during local development. It is only mounted
+// when DEV_ENDPOINTS is on, and users can only confirm their own payments.
+func ConfirmFakePaymentHandler(w http.ResponseWriter, r *http.Request) {
+	gateway, ok := paymentGateway.(*service.FakePaymentGateway)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	intentID := mux.Vars(r)["intentID"]
+	purchase, err := purchaseService.GetPurchaseByPaymentIntent(intentID)
+	if errors.Is(err, service.ErrPurchaseNotFound) || (err == nil && purchase.UserID != authenticatedUserID(r)) {
+		http.Error(w, `{"error": "Payment not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if err := gateway.Confirm(intentID); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Payment confirmed"})
+}
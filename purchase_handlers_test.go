@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"dating-app/models"
+	"dating-app/service"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockPurchaseService struct {
+	mock.Mock
+}
+
+func (m *MockPurchaseService) ListProducts() ([]models.Product, error) {
+	args := m.Called()
+	return args.Get(0).([]models.Product), args.Error(1)
+}
+
+func (m *MockPurchaseService) GetProduct(sku string) (models.Product, error) {
+	args := m.Called(sku)
+	return args.Get(0).(models.Product), args.Error(1)
+}
+
+func (m *MockPurchaseService) GetProductByStoreID(store, storeProductID string) (models.Product, error) {
+	args := m.Called(store, storeProductID)
+	return args.Get(0).(models.Product), args.Error(1)
+}
+
+func (m *MockPurchaseService) CreatePurchase(userID string, product models.Product, promo *models.PromoCode) (models.Purchase, error) {
+	args := m.Called(userID, product, promo)
+	return args.Get(0).(models.Purchase), args.Error(1)
+}
+
+func (m *MockPurchaseService) GetPurchase(purchaseID string) (models.Purchase, error) {
+	args := m.Called(purchaseID)
+	return args.Get(0).(models.Purchase), args.Error(1)
+}
+
+func (m *MockPurchaseService) RefundPurchase(refund models.Refund, now time.Time) (bool, error) {
+	args := m.Called(refund, now)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockPurchaseService) UpdatePurchaseStatus(purchaseID, status string) error {
+	args := m.Called(purchaseID, status)
+	return args.Error(0)
+}
+
+func (m *MockPurchaseService) FulfilPurchase(purchaseID string, now time.Time) (bool, error) {
+	args := m.Called(purchaseID, now)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockPurchaseService) SetPaymentIntent(purchaseID, intentID string) error {
+	args := m.Called(purchaseID, intentID)
+	return args.Error(0)
+}
+
+func (m *MockPurchaseService) GetPurchaseByPaymentIntent(intentID string) (models.Purchase, error) {
+	args := m.Called(intentID)
+	return args.Get(0).(models.Purchase), args.Error(1)
+}
+
+func (m *MockPurchaseService) ListPurchases(userID string) ([]models.Purchase, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]models.Purchase), args.Error(1)
+}
+
+func (m *MockPurchaseService) RecordStorePurchase(userID string, product models.Product, transaction models.StoreTransaction) (models.Purchase, bool, error) {
+	args := m.Called(userID, product, transaction)
+	return args.Get(0).(models.Purchase), args.Bool(1), args.Error(2)
+}
+
+func (m *MockPurchaseService) GetPurchaseByStoreTransaction(store, transactionID string) (models.Purchase, error) {
+	args := m.Called(store, transactionID)
+	return args.Get(0).(models.Purchase), args.Error(1)
+}
+
+// authorizedRequest builds a request carrying a token issued for userID
+func authorizedRequest(t *testing.T, method, url, userID string, body []byte) *http.Request {
+	req := httptest.NewRequest(method, url, bytes.NewReader(body))
+	token, err := generateJWT(models.User{ID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// staffRequest is an authorizedRequest from a user holding staff roles
+func staffRequest(t *testing.T, method, url, userID string, roles []string, body []byte) *http.Request {
+	req := httptest.NewRequest(method, url, bytes.NewReader(body))
+	token, err := generateJWT(models.User{ID: userID, Roles: roles})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestListPurchasesHandler(t *testing.T) {
+	mockPurchaseService := new(MockPurchaseService)
+	purchaseService = mockPurchaseService
+
+	purchases := []models.Purchase{
+		{ID: "2", UserID: "user1", SKU: "add_verified", Amount: 499, Currency: "USD", Status: models.PurchaseStatusPaid},
+		{ID: "1", UserID: "user1", SKU: "remove_quota", Amount: 199, Currency: "USD", Status: models.PurchaseStatusFailed},
+	}
+
+	tests := []struct {
+		name              string
+		authorized        bool
+		expectedStatus    int
+		expectedPurchases []models.Purchase
+	}{
+		{
+			name:              "Purchase history of the authenticated user",
+			authorized:        true,
+			expectedStatus:    http.StatusOK,
+			expectedPurchases: purchases,
+		},
+		{
+			name:           "Missing token",
+			authorized:     false,
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req *http.Request
+			if tt.authorized {
+				mockPurchaseService.On("ListPurchases", "user1").Return(purchases, nil)
+				req = authorizedRequest(t, "GET", "/purchases", "user1", nil)
+			} else {
+				req = httptest.NewRequest("GET", "/purchases", nil)
+			}
+
+			rr := httptest.NewRecorder()
+			handler := authenticate(ListPurchasesHandler)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			if tt.expectedPurchases != nil {
+				var responseBody map[string][]models.Purchase
+				if err := json.NewDecoder(rr.Body).Decode(&responseBody); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(responseBody["purchases"], tt.expectedPurchases) {
+					t.Errorf("handler returned unexpected body: got %v want %v", responseBody["purchases"], tt.expectedPurchases)
+				}
+			}
+
+			mockPurchaseService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPaymentWebhookHandler(t *testing.T) {
+	paymentWebhookSecret = []byte("test_webhook_secret")
+
+	tests := []struct {
+		name           string
+		event          models.PaymentEvent
+		purchaseStatus string
+		signature      string
+		expectedStatus int
+		expectedBody   map[string]string
+		expectFulfil   bool
+		fulfilled      bool
+		expectedUpdate string
+	}{
+		{
+			name:           "Payment succeeded fulfils the purchase",
+			event:          models.PaymentEvent{ID: "evt_1", Type: models.PaymentEventSucceeded, IntentID: "pi_1", Amount: 499, Currency: "USD"},
+			purchaseStatus: models.PurchaseStatusPending,
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]string{"message": "Event processed"},
+			expectFulfil:   true,
+			fulfilled:      true,
+		},
+		{
+			name:           "Concurrent delivery fulfilled it first",
+			event:          models.PaymentEvent{ID: "evt_1", Type: models.PaymentEventSucceeded, IntentID: "pi_1", Amount: 499, Currency: "USD"},
+			purchaseStatus: models.PurchaseStatusPending,
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]string{"message": "Event ignored"},
+			expectFulfil:   true,
+		},
+		{
+			name:           "Payment failed marks the purchase failed",
+			event:          models.PaymentEvent{ID: "evt_2", Type: models.PaymentEventFailed, IntentID: "pi_1", Amount: 499, Currency: "USD"},
+			purchaseStatus: models.PurchaseStatusPending,
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]string{"message": "Event processed"},
+			expectedUpdate: models.PurchaseStatusFailed,
+		},
+		{
+			name:           "Redelivered event is ignored",
+			event:          models.PaymentEvent{ID: "evt_1", Type: models.PaymentEventSucceeded, IntentID: "pi_1", Amount: 499, Currency: "USD"},
+			purchaseStatus: models.PurchaseStatusPaid,
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]string{"message": "Event ignored"},
+		},
+		{
+			name:           "Amount mismatch is rejected",
+			event:          models.PaymentEvent{ID: "evt_3", Type: models.PaymentEventSucceeded, IntentID: "pi_1", Amount: 1, Currency: "USD"},
+			purchaseStatus: models.PurchaseStatusPending,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"error": "Amount mismatch"},
+		},
+		{
+			name:           "Invalid signature",
+			event:          models.PaymentEvent{ID: "evt_4", Type: models.PaymentEventSucceeded, IntentID: "pi_1", Amount: 499, Currency: "USD"},
+			signature:      "t=1,v1=deadbeef",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   map[string]string{"error": "Invalid signature"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockPurchaseService := new(MockPurchaseService)
+			purchaseService = mockPurchaseService
+			mockPromoService := new(MockPromoService)
+			promoService = mockPromoService
+
+			payload, _ := json.Marshal(tt.event)
+			signature := tt.signature
+			if signature == "" {
+				signature = service.SignWebhookPayload(paymentWebhookSecret, payload, time.Now())
+				purchase := models.Purchase{ID: "purchase1", UserID: "user1", SKU: "boost_1", Amount: 499, Currency: "USD", Status: tt.purchaseStatus, PaymentIntentID: "pi_1"}
+				mockPurchaseService.On("GetPurchaseByPaymentIntent", "pi_1").Return(purchase, nil)
+			}
+			if tt.expectFulfil {
+				mockPurchaseService.On("FulfilPurchase", "purchase1", mock.AnythingOfType("time.Time")).Return(tt.fulfilled, nil).Once()
+			}
+			if tt.expectedUpdate != "" {
+				mockPurchaseService.On("UpdatePurchaseStatus", "purchase1", tt.expectedUpdate).Return(nil)
+			}
+			if tt.expectedUpdate == models.PurchaseStatusFailed {
+				mockPromoService.On("ReleaseRedemption", "purchase1").Return(nil)
+			}
+
+			req := httptest.NewRequest("POST", "/webhooks/payments", bytes.NewReader(payload))
+			req.Header.Set("X-Payment-Signature", signature)
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(PaymentWebhookHandler)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			var responseBody map[string]string
+			if err := json.NewDecoder(rr.Body).Decode(&responseBody); err != nil {
+				t.Fatal(err)
+			}
+
+			for key, value := range tt.expectedBody {
+				if responseBody[key] != value {
+					t.Errorf("handler returned unexpected body: got %v want %v", responseBody, tt.expectedBody)
+				}
+			}
+
+			mockPurchaseService.AssertExpectations(t)
+			mockPromoService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestConfirmFakePaymentHandler(t *testing.T) {
+	gateway := &service.FakePaymentGateway{}
+	paymentGateway = gateway
+	own, _ := gateway.CreateIntent(499, "USD", nil)
+	other, _ := gateway.CreateIntent(499, "USD", nil)
+
+	mockPurchaseService := new(MockPurchaseService)
+	purchaseService = mockPurchaseService
+	mockPurchaseService.On("GetPurchaseByPaymentIntent", own.ID).Return(models.Purchase{ID: "purchase1", UserID: "user1"}, nil)
+	mockPurchaseService.On("GetPurchaseByPaymentIntent", other.ID).Return(models.Purchase{ID: "purchase2", UserID: "user2"}, nil)
+
+	tests := []struct {
+		name           string
+		intentID       string
+		expectedStatus int
+	}{
+		{"Own payment", own.ID, http.StatusOK},
+		{"Someone else's payment", other.ID, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := authorizedRequest(t, "POST", "/dev/payments/"+tt.intentID+"/confirm", "user1", nil)
+			req = mux.SetURLVars(req, map[string]string{"intentID": tt.intentID})
+
+			rr := httptest.NewRecorder()
+			handler := authenticate(ConfirmFakePaymentHandler)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+var (
+	purchaseRowColumns = []string{"id", "user_id", "sku", "amount", "currency", "status", "payment_intent_id", "store", "store_transaction_id", "promo_code", "refunded_amount", "created_at", "updated_at"}
+	productRowColumns  = []string{"sku", "name", "price", "currency", "entitlements", "duration_days", "quantity", "active"}
+)
+
+// purchaseRow is a purchases row as read through purchaseColumns
+func purchaseRow(sku, status string, amount, refunded int64) []driver.Value {
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	return []driver.Value{"purchase1", "user1", sku, amount, "USD", status, "pi_1", "", "", "", refunded, at, at}
+}
+
+// productRow is a products row as read through productColumns
+func productRow(product models.Product) []driver.Value {
+	return []driver.Value{product.SKU, product.SKU, product.Price, product.Currency, []byte("{" + strings.Join(product.Entitlements, ",") + "}"),
+		int64(product.DurationDays), int64(product.Quantity), true}
+}
+
+func TestFulfilPurchase(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	boost := models.Product{SKU: "boost_1", Price: 299, Currency: "USD", Entitlements: []string{models.EntitlementBoost}, Quantity: 1}
+	premium := models.Product{SKU: "premium_monthly", Price: 499, Currency: "USD", Entitlements: []string{models.EntitlementPremium}, DurationDays: 30}
+	subscriptionColumns := []string{"id", "user_id", "sku", "status", "auto_renew", "current_period_start", "current_period_end", "grace_until", "canceled_at", "store"}
+
+	tests := []struct {
+		name      string
+		script    func(script *sqlScript)
+		fulfilled bool
+	}{
+		{
+			name: "Pending purchase is granted and marked paid together",
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM purchases WHERE id=$1 FOR UPDATE", purchaseRowColumns, [][]driver.Value{purchaseRow("boost_1", models.PurchaseStatusPending, 299, 0)}, "purchase1")
+				script.query("FROM products WHERE sku=$1", productRowColumns, [][]driver.Value{productRow(boost)}, "boost_1")
+				script.exec("UPDATE users SET boost_credits=GREATEST(boost_credits+$1, 0)", 1, 1, "user1")
+				script.exec("UPDATE purchases SET status=$1", 1, models.PurchaseStatusPaid, "purchase1")
+				script.exec("INSERT INTO purchase_status_history", 1, "purchase1", models.PurchaseStatusPending, models.PurchaseStatusPaid)
+				script.commit()
+			},
+			fulfilled: true,
+		},
+		{
+			name: "Subscription product starts the subscription in the same transaction",
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM purchases WHERE id=$1 FOR UPDATE", purchaseRowColumns, [][]driver.Value{purchaseRow("premium_monthly", models.PurchaseStatusPending, 499, 0)}, "purchase1")
+				script.query("FROM products WHERE sku=$1", productRowColumns, [][]driver.Value{productRow(premium)}, "premium_monthly")
+				script.exec("UPDATE users SET premium=true", 1, "user1")
+				script.query("FROM subscriptions WHERE user_id=$1 AND status IN ('active', 'grace') FOR UPDATE", subscriptionColumns, nil, "user1")
+				script.query("INSERT INTO subscriptions", subscriptionColumns, [][]driver.Value{{"sub1", "user1", "premium_monthly", models.SubscriptionStatusActive, true, now, now.AddDate(0, 0, 30), nil, nil, ""}})
+				script.exec("UPDATE purchases SET status=$1", 1, models.PurchaseStatusPaid, "purchase1")
+				script.exec("INSERT INTO purchase_status_history", 1)
+				script.commit()
+			},
+			fulfilled: true,
+		},
+		{
+			name: "Payment for an erased account completes without granting anything",
+			script: func(script *sqlScript) {
+				script.begin()
+				// Erasure sets user_id to NULL, which is read as no user
+				erased := purchaseRow("boost_1", models.PurchaseStatusPending, 299, 0)
+				erased[1] = ""
+				script.query("COALESCE(user_id::text, '')", purchaseRowColumns, [][]driver.Value{erased}, "purchase1")
+				script.exec("UPDATE purchases SET status=$1", 1, models.PurchaseStatusPaid, "purchase1")
+				script.exec("INSERT INTO purchase_status_history", 1)
+				script.commit()
+			},
+			fulfilled: true,
+		},
+		{
+			name: "Purchase paid by an earlier delivery is left alone",
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM purchases WHERE id=$1 FOR UPDATE", purchaseRowColumns, [][]driver.Value{purchaseRow("boost_1", models.PurchaseStatusPaid, 299, 0)}, "purchase1")
+				script.rollback()
+			},
+		},
+		{
+			name: "Failed grant leaves the purchase pending",
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM purchases WHERE id=$1 FOR UPDATE", purchaseRowColumns, [][]driver.Value{purchaseRow("boost_1", models.PurchaseStatusPending, 299, 0)}, "purchase1")
+				script.query("FROM products WHERE sku=$1", productRowColumns, [][]driver.Value{productRow(boost)}, "boost_1")
+				script.exec("UPDATE users SET boost_credits", 0).fails(errors.New("connection reset"))
+				script.rollback()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, script := newSQLScript(t)
+			tt.script(script)
+
+			purchases := &service.PurchaseServiceImpl{DB: db}
+			fulfilled, err := purchases.FulfilPurchase("purchase1", now)
+			if fulfilled != tt.fulfilled {
+				t.Errorf("FulfilPurchase() = %v, %v, want fulfilled %v", fulfilled, err, tt.fulfilled)
+			}
+		})
+	}
+}
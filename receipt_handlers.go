@@ -0,0 +1,331 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"dating-app/models"
+	"dating-app/service"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// @Summary Verify app store receipt
+// @Description Verifies an in-app purchase with the App Store or Google Play and grants the matching product. For Apple the receipt is the StoreKit transaction ID, for Google the purchase token.
+// @Tags Payments
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param store body string true "Store (apple or google)"
+// @Param productID body string true "Store product identifier"
+// @Param receipt body string true "Transaction ID or purchase token"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Router /purchases/receipts [post]
+func VerifyReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Store     string `json:"store"`
+		ProductID string `json:"productID"`
+		Receipt   string `json:"receipt"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	if request.Store == "" || request.ProductID == "" || request.Receipt == "" {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	verifier, ok := receiptVerifiers[request.Store]
+	if !ok {
+		http.Error(w, `{"error": "Unknown store"}`, http.StatusBadRequest)
+		return
+	}
+
+	product, err := purchaseService.GetProductByStoreID(request.Store, request.ProductID)
+	if errors.Is(err, service.ErrProductNotFound) {
+		http.Error(w, `{"error": "Unknown product"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	transaction, err := verifier.Verify(r.Context(), request.ProductID, request.Receipt, product.DurationDays > 0)
+	if errors.Is(err, service.ErrReceiptInvalid) {
+		http.Error(w, `{"error": "Invalid receipt"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("verifying %s receipt: %v", request.Store, err)
+		http.Error(w, `{"error": "App store error"}`, http.StatusBadGateway)
+		return
+	}
+
+	if transaction.Revoked {
+		http.Error(w, `{"error": "Purchase has been refunded"}`, http.StatusBadRequest)
+		return
+	}
+	if transaction.ExpiresAt != nil && transaction.ExpiresAt.Before(time.Now()) {
+		http.Error(w, `{"error": "Subscription has expired"}`, http.StatusBadRequest)
+		return
+	}
+
+	userID := authenticatedUserID(r)
+	purchase, created, err := purchaseService.RecordStorePurchase(userID, product, transaction)
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	if purchase.UserID != userID {
+		http.Error(w, `{"error": "Receipt belongs to another account"}`, http.StatusConflict)
+		return
+	}
+
+	// Subscriptions are re-granted on every call so "restore purchases"
+	// works; one-off products are granted only the first time
+	if product.DurationDays > 0 {
+		if _, err := subscriptionService.ActivateStore(userID, product, transaction); err != nil {
+			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+	if created || product.DurationDays > 0 {
+		if err := service.GrantEntitlements(userService, userID, product); err != nil {
+			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Purchase verified", "purchaseID": purchase.ID})
+}
+
+// @Summary App store server notifications
+// @Description Receives App Store Server Notifications V2 (/webhooks/appstore) and Google Play real-time developer notifications (/webhooks/googleplay) so store renewals, expirations and refunds update entitlements
+// @Tags Payments
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /webhooks/appstore [post]
+// @Router /webhooks/googleplay [post]
+func StoreNotificationHandler(store string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		verifier, ok := receiptVerifiers[store]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		notification, err := verifier.ParseNotification(r.Context(), r)
+		if errors.Is(err, service.ErrInvalidNotification) {
+			http.Error(w, `{"error": "Invalid notification"}`, http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			// Let the store redeliver once it is reachable again
+			log.Printf("parsing %s notification: %v", store, err)
+			http.Error(w, `{"error": "App store error"}`, http.StatusInternalServerError)
+			return
+		}
+
+		if err := applyStoreNotification(store, notification); err != nil {
+			log.Printf("applying %s %s notification: %v", store, notification.Type, err)
+			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Notification processed"})
+	}
+}
+
+// applyStoreNotification updates purchases, subscriptions and entitlements
+// for a store notification. Notifications about purchases the app has not
+// reported yet are ignored; the app sends the receipt itself.
+func applyStoreNotification(store string, notification models.StoreNotification) error {
+	transaction := notification.Transaction
+
+	if notification.Type == models.StoreNotificationRefunded {
+		purchase, err := purchaseService.GetPurchaseByStoreTransaction(store, transaction.TransactionID)
+		if errors.Is(err, service.ErrPurchaseNotFound) {
+			return nil
+		}
+		if err != nil || purchase.Status != models.PurchaseStatusPaid {
+			return err
+		}
+		// Stores refund a transaction in full, so its ID identifies the refund
+		_, err = purchaseService.RefundPurchase(models.Refund{PurchaseID: purchase.ID, RefundID: transaction.TransactionID, Amount: purchase.Amount - purchase.RefundedAmount, Actor: store}, time.Now())
+		if err != nil && !errors.Is(err, service.ErrInvalidStatusTransition) {
+			return err
+		}
+	}
+
+	subscription, err := subscriptionService.GetStoreSubscription(store, transaction.OriginalTransactionID)
+	if errors.Is(err, service.ErrSubscriptionNotFound) {
+		return nil
+	}
+	if err != nil || subscription.Status == models.SubscriptionStatusExpired {
+		return err
+	}
+
+	switch notification.Type {
+	case models.StoreNotificationRenewed:
+		product, err := purchaseService.GetProductByStoreID(store, transaction.ProductID)
+		if err != nil {
+			return err
+		}
+		if _, _, err := purchaseService.RecordStorePurchase(subscription.UserID, product, transaction); err != nil {
+			return err
+		}
+		if _, err := subscriptionService.ActivateStore(subscription.UserID, product, transaction); err != nil {
+			return err
+		}
+		return service.GrantEntitlements(userService, subscription.UserID, product)
+	case models.StoreNotificationRenewalFailed:
+		// Without a store grace period access ends right away
+		graceUntil := notification.GraceUntil
+		if graceUntil == nil {
+			now := time.Now()
+			graceUntil = &now
+		}
+		return subscriptionService.UpdateStoreSubscription(subscription.ID, models.SubscriptionStatusGrace, graceUntil, transaction.AutoRenew)
+	case models.StoreNotificationAutoRenewChanged:
+		return subscriptionService.UpdateStoreSubscription(subscription.ID, subscription.Status, subscription.GraceUntil, transaction.AutoRenew)
+	case models.StoreNotificationExpired, models.StoreNotificationRefunded:
+		// A refunded store transaction revokes the whole subscription, even
+		// when earlier periods are left on it
+		product, err := purchaseService.GetProduct(subscription.SKU)
+		if err != nil {
+			return err
+		}
+		if err := service.RevokeEntitlements(userService, subscription.UserID, product); err != nil {
+			return err
+		}
+		return subscriptionService.MarkExpired(subscription.ID)
+	}
+	return nil
+}
+
+// newReceiptVerifiers builds a verifier per store. Stores without
+// credentials in the environment get a stub that rejects every receipt.
+func newReceiptVerifiers() (map[string]service.ReceiptVerifier, error) {
+	verifiers := map[string]service.ReceiptVerifier{
+		models.StoreApple:  &service.StubReceiptVerifier{Store: models.StoreApple},
+		models.StoreGoogle: &service.StubReceiptVerifier{Store: models.StoreGoogle},
+	}
+
+	if os.Getenv("APPLE_ISSUER_ID") != "" {
+		privateKey, err := readECPrivateKey("APPLE_PRIVATE_KEY_PATH")
+		if err != nil {
+			return nil, err
+		}
+
+		rootDER, err := os.ReadFile(os.Getenv("APPLE_ROOT_CA_PATH"))
+		if err != nil {
+			return nil, err
+		}
+		root, err := x509.ParseCertificate(rootDER)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		roots.AddCert(root)
+
+		baseURL := service.AppStoreProductionURL
+		if os.Getenv("APPLE_ENVIRONMENT") == "sandbox" {
+			baseURL = service.AppStoreSandboxURL
+		}
+
+		verifiers[models.StoreApple] = &service.AppleReceiptVerifier{
+			BaseURL:    baseURL,
+			IssuerID:   os.Getenv("APPLE_ISSUER_ID"),
+			KeyID:      os.Getenv("APPLE_KEY_ID"),
+			BundleID:   os.Getenv("APPLE_BUNDLE_ID"),
+			PrivateKey: privateKey,
+			RootCAs:    roots,
+		}
+	}
+
+	if os.Getenv("GOOGLE_PACKAGE_NAME") != "" {
+		tokens, err := readServiceAccount("GOOGLE_SERVICE_ACCOUNT_PATH", "https://www.googleapis.com/auth/androidpublisher")
+		if err != nil {
+			return nil, err
+		}
+
+		verifiers[models.StoreGoogle] = &service.GoogleReceiptVerifier{
+			BaseURL:     service.GooglePlayAPIURL,
+			PackageName: os.Getenv("GOOGLE_PACKAGE_NAME"),
+			PushToken:   os.Getenv("GOOGLE_PUBSUB_TOKEN"),
+			Tokens:      tokens,
+		}
+	}
+
+	return verifiers, nil
+}
+
+// readECPrivateKey reads a PKCS #8 EC private key, such as an Apple .p8 API
+// key, from the file named by the environment variable
+func readECPrivateKey(pathVar string) (*ecdsa.PrivateKey, error) {
+	keyPEM, err := os.ReadFile(os.Getenv(pathVar))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", pathVar)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an EC key", pathVar)
+	}
+	return privateKey, nil
+}
+
+// readServiceAccount reads a Google service account JSON key from the file
+// named by the environment variable and returns tokens for the given scope
+func readServiceAccount(pathVar, scope string) (*service.GoogleServiceAccountTokens, error) {
+	data, err := os.ReadFile(os.Getenv(pathVar))
+	if err != nil {
+		return nil, err
+	}
+	var account struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, err
+	}
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	return &service.GoogleServiceAccountTokens{
+		ClientEmail: account.ClientEmail,
+		PrivateKey:  privateKey,
+		TokenURL:    account.TokenURI,
+		Scope:       scope,
+	}, nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dating-app/models"
+	"dating-app/service"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestVerifyReceiptHandler(t *testing.T) {
+	product := models.Product{SKU: "boost_1", Price: 299, Currency: "USD", Entitlements: []string{models.EntitlementBoost}, Quantity: 1}
+	transaction := models.StoreTransaction{TransactionID: "1000001", OriginalTransactionID: "1000001", ProductID: "com.example.boost", PurchasedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)}
+
+	verifier := &service.StubReceiptVerifier{Store: models.StoreApple}
+	verifier.AddReceipt("1000001", transaction)
+	receiptVerifiers = map[string]service.ReceiptVerifier{models.StoreApple: verifier}
+	transaction.Store = models.StoreApple
+
+	tests := []struct {
+		name           string
+		receipt        string
+		purchaseOwner  string
+		created        bool
+		expectedStatus int
+		expectedBody   map[string]string
+		expectGrant    bool
+	}{
+		{
+			name:           "New receipt grants the product",
+			receipt:        "1000001",
+			purchaseOwner:  "user1",
+			created:        true,
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]string{"message": "Purchase verified", "purchaseID": "purchase1"},
+			expectGrant:    true,
+		},
+		{
+			name:           "Replayed receipt is not granted twice",
+			receipt:        "1000001",
+			purchaseOwner:  "user1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]string{"message": "Purchase verified", "purchaseID": "purchase1"},
+		},
+		{
+			name:           "Receipt used by another account",
+			receipt:        "1000001",
+			purchaseOwner:  "user2",
+			expectedStatus: http.StatusConflict,
+			expectedBody:   map[string]string{"error": "Receipt belongs to another account"},
+		},
+		{
+			name:           "Unknown receipt",
+			receipt:        "forged",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"error": "Invalid receipt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserService := new(MockUserService)
+			userService = mockUserService
+			mockPurchaseService := new(MockPurchaseService)
+			purchaseService = mockPurchaseService
+
+			mockPurchaseService.On("GetProductByStoreID", models.StoreApple, "com.example.boost").Return(product, nil)
+			if tt.purchaseOwner != "" {
+				purchase := models.Purchase{ID: "purchase1", UserID: tt.purchaseOwner, SKU: product.SKU, Status: models.PurchaseStatusPaid}
+				mockPurchaseService.On("RecordStorePurchase", "user1", product, transaction).Return(purchase, tt.created, nil)
+			}
+			if tt.expectGrant {
+				mockUserService.On("AddBoostCredits", "user1", 1).Return(nil)
+			}
+
+			body, _ := json.Marshal(map[string]string{"store": models.StoreApple, "productID": "com.example.boost", "receipt": tt.receipt})
+			rr := httptest.NewRecorder()
+			handler := authenticate(VerifyReceiptHandler)
+			handler.ServeHTTP(rr, authorizedRequest(t, "POST", "/purchases/receipts", "user1", body))
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			var responseBody map[string]string
+			if err := json.NewDecoder(rr.Body).Decode(&responseBody); err != nil {
+				t.Fatal(err)
+			}
+
+			for key, value := range tt.expectedBody {
+				if responseBody[key] != value {
+					t.Errorf("handler returned unexpected body: got %v want %v", responseBody, tt.expectedBody)
+				}
+			}
+
+			mockUserService.AssertExpectations(t)
+			mockPurchaseService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestStoreNotificationHandler(t *testing.T) {
+	verifier := &service.StubReceiptVerifier{Store: models.StoreGoogle, Token: "push-token"}
+	receiptVerifiers = map[string]service.ReceiptVerifier{models.StoreGoogle: verifier}
+
+	premium := models.Product{SKU: "premium_monthly", Price: 999, Currency: "USD", Entitlements: []string{models.EntitlementPremium}, DurationDays: 30}
+	subscription := models.Subscription{ID: "sub1", UserID: "user1", SKU: premium.SKU, Status: models.SubscriptionStatusActive, Store: models.StoreGoogle}
+
+	tests := []struct {
+		name           string
+		token          string
+		notification   models.StoreNotification
+		expectedStatus int
+		setup          func(*MockUserService, *MockPurchaseService, *MockSubscriptionService)
+	}{
+		{
+			name:  "Refund revokes entitlements and ends the subscription",
+			token: "push-token",
+			notification: models.StoreNotification{
+				Type:        models.StoreNotificationRefunded,
+				Transaction: models.StoreTransaction{TransactionID: "GPA.1", OriginalTransactionID: "token1", Revoked: true},
+			},
+			expectedStatus: http.StatusOK,
+			setup: func(users *MockUserService, purchases *MockPurchaseService, subscriptions *MockSubscriptionService) {
+				purchases.On("GetPurchaseByStoreTransaction", models.StoreGoogle, "GPA.1").Return(models.Purchase{ID: "purchase1", UserID: "user1", SKU: premium.SKU, Amount: 999, Currency: "USD", Status: models.PurchaseStatusPaid, Store: models.StoreGoogle}, nil)
+				purchases.On("RefundPurchase", models.Refund{PurchaseID: "purchase1", RefundID: "GPA.1", Amount: 999, Actor: models.StoreGoogle}, mock.AnythingOfType("time.Time")).Return(true, nil)
+				// An earlier period may still be left, but the store revokes it all
+				purchases.On("GetProduct", premium.SKU).Return(premium, nil)
+				users.On("RevokePremium", "user1").Return(nil)
+				subscriptions.On("GetStoreSubscription", models.StoreGoogle, "token1").Return(subscription, nil)
+				subscriptions.On("MarkExpired", "sub1").Return(nil)
+			},
+		},
+		{
+			name:  "Auto-renew turned off",
+			token: "push-token",
+			notification: models.StoreNotification{
+				Type:        models.StoreNotificationAutoRenewChanged,
+				Transaction: models.StoreTransaction{TransactionID: "GPA.1", OriginalTransactionID: "token1", AutoRenew: false},
+			},
+			expectedStatus: http.StatusOK,
+			setup: func(users *MockUserService, purchases *MockPurchaseService, subscriptions *MockSubscriptionService) {
+				subscriptions.On("GetStoreSubscription", models.StoreGoogle, "token1").Return(subscription, nil)
+				subscriptions.On("UpdateStoreSubscription", "sub1", models.SubscriptionStatusActive, (*time.Time)(nil), false).Return(nil)
+			},
+		},
+		{
+			name:           "Unauthenticated notification",
+			token:          "wrong",
+			notification:   models.StoreNotification{Type: models.StoreNotificationExpired},
+			expectedStatus: http.StatusUnauthorized,
+			setup:          func(*MockUserService, *MockPurchaseService, *MockSubscriptionService) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserService := new(MockUserService)
+			userService = mockUserService
+			mockPurchaseService := new(MockPurchaseService)
+			purchaseService = mockPurchaseService
+			mockSubscriptionService := new(MockSubscriptionService)
+			subscriptionService = mockSubscriptionService
+			tt.setup(mockUserService, mockPurchaseService, mockSubscriptionService)
+
+			body, _ := json.Marshal(tt.notification)
+			req := httptest.NewRequest("POST", "/webhooks/googleplay", bytes.NewReader(body))
+			req.Header.Set("X-Stub-Token", tt.token)
+
+			rr := httptest.NewRecorder()
+			handler := StoreNotificationHandler(models.StoreGoogle)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockUserService.AssertExpectations(t)
+			mockPurchaseService.AssertExpectations(t)
+			mockSubscriptionService.AssertExpectations(t)
+		})
+	}
+}
+
+// appleCertificate issues a test certificate signed by parent, or a self-signed
+// root if parent is nil, carrying the given extensions
+func appleCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, ca bool, oids ...asn1.ObjectIdentifier) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  ca,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	for _, oid := range oids {
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{Id: oid, Value: []byte{0x05, 0x00}})
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, key
+}
+
+func TestAppleNotificationCertificateChain(t *testing.T) {
+	receiptSigning := asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 11, 1}
+	intermediateCA := asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 2, 1}
+	root, rootKey := appleCertificate(t, "Apple Root CA - G3", nil, nil, true)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	tests := []struct {
+		name             string
+		intermediateOIDs []asn1.ObjectIdentifier
+		leafOIDs         []asn1.ObjectIdentifier
+		wantErr          bool
+	}{
+		{"App Store chain", []asn1.ObjectIdentifier{intermediateCA}, []asn1.ObjectIdentifier{receiptSigning}, false},
+		{"Leaf of another Apple service", []asn1.ObjectIdentifier{intermediateCA}, nil, true},
+		{"Intermediate of another Apple service", nil, []asn1.ObjectIdentifier{receiptSigning}, true},
+		{"Neither", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intermediate, intermediateKey := appleCertificate(t, "Apple Worldwide Developer Relations", root, rootKey, true, tt.intermediateOIDs...)
+			leaf, leafKey := appleCertificate(t, "Prod ECC Mac App Store and iTunes Store Receipt Signing", intermediate, intermediateKey, false, tt.leafOIDs...)
+			x5c := []string{
+				base64.StdEncoding.EncodeToString(leaf.Raw),
+				base64.StdEncoding.EncodeToString(intermediate.Raw),
+				base64.StdEncoding.EncodeToString(root.Raw),
+			}
+			sign := func(claims jwt.MapClaims) string {
+				token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+				token.Header["x5c"] = x5c
+				signed, err := token.SignedString(leafKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return signed
+			}
+			transaction := sign(jwt.MapClaims{"transactionId": "1000001", "originalTransactionId": "1000001", "bundleId": "com.example.app", "productId": "com.example.boost"})
+			payload := sign(jwt.MapClaims{"notificationType": "REFUND", "data": map[string]string{"bundleId": "com.example.app", "signedTransactionInfo": transaction}})
+
+			verifier := &service.AppleReceiptVerifier{BundleID: "com.example.app", RootCAs: roots}
+			body, _ := json.Marshal(map[string]string{"signedPayload": payload})
+			notification, err := verifier.ParseNotification(context.Background(), httptest.NewRequest("POST", "/webhooks/appstore", bytes.NewReader(body)))
+			if tt.wantErr {
+				if !errors.Is(err, service.ErrInvalidNotification) {
+					t.Errorf("ParseNotification() error = %v, want %v", err, service.ErrInvalidNotification)
+				}
+				return
+			}
+			if err != nil || notification.Type != models.StoreNotificationRefunded || notification.Transaction.TransactionID != "1000001" {
+				t.Errorf("ParseNotification() = %+v, %v", notification, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"dating-app/models"
+	"dating-app/service"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// @Summary Refund purchase
+// @Description Refunds a paid order through the payment gateway and takes back what it granted. Once refunds add up to the full amount the order's entitlements are revoked; each refund of a subscription shortens it by the refunded share of the period. Partial refunds leave the order paid, so it can be refunded again up to what is left. App store purchases are refunded through the store.
+// @Tags Payments
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token of an admin"
+// @Param id path string true "Purchase ID"
+// @Param amount body int false "Amount to refund in minor units, defaults to what is left of the amount paid"
+// @Param reason body string false "Reason recorded in the audit log"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Router /admin/purchases/{id}/refund [post]
+func RefundPurchaseHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Amount int64  `json:"amount"`
+		Reason string `json:"reason"`
+	}
+
+	// The body is optional; an empty one refunds the full amount
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	purchase, err := purchaseService.GetPurchase(mux.Vars(r)["id"])
+	if errors.Is(err, service.ErrPurchaseNotFound) {
+		http.Error(w, `{"error": "Purchase not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if purchase.Store != "" {
+		http.Error(w, `{"error": "Store purchases are refunded through the store"}`, http.StatusConflict)
+		return
+	}
+	if purchase.Status != models.PurchaseStatusPaid {
+		http.Error(w, `{"error": "Purchase is not refundable"}`, http.StatusConflict)
+		return
+	}
+
+	remaining := purchase.Amount - purchase.RefundedAmount
+	amount := request.Amount
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount < 0 || amount > remaining {
+		http.Error(w, `{"error": "Invalid refund amount"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Fully discounted orders were never charged, so there is nothing to pay
+	// back and they can only be refunded once
+	refundID := "uncharged"
+	if amount > 0 {
+		refundID, err = paymentGateway.Refund(purchase.PaymentIntentID, amount)
+		if err != nil {
+			log.Printf("refund of purchase %s failed: %v", purchase.ID, err)
+			http.Error(w, `{"error": "Payment gateway error"}`, http.StatusBadGateway)
+			return
+		}
+	}
+
+	// The gateway's webhook reports the same refund, and whichever arrives
+	// second finds it applied
+	_, err = purchaseService.RefundPurchase(models.Refund{
+		PurchaseID: purchase.ID,
+		RefundID:   refundID,
+		Amount:     amount,
+		Actor:      adminActor(r),
+		Reason:     request.Reason,
+	}, time.Now())
+	if err != nil {
+		log.Printf("purchase %s refunded by the gateway but not applied: %v", purchase.ID, err)
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":        "Purchase refunded",
+		"purchaseID":     purchase.ID,
+		"refundedAmount": strconv.FormatInt(amount, 10),
+	})
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dating-app/models"
+	"dating-app/service"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockAuditService struct {
+	mock.Mock
+}
+
+func (m *MockAuditService) Record(entry models.AuditEntry) error {
+	args := m.Called(entry)
+	return args.Error(0)
+}
+
+func (m *MockAuditService) History(targetType, targetID string) ([]models.AuditEntry, error) {
+	args := m.Called(targetType, targetID)
+	return args.Get(0).([]models.AuditEntry), args.Error(1)
+}
+
+func TestRefundPurchaseHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		roles          []string
+		request        map[string]interface{}
+		purchase       models.Purchase
+		purchaseErr    error
+		refundErr      error
+		expectedAmount int64
+		expectedStatus int
+	}{
+		{
+			name:           "Full refund",
+			roles:          []string{models.RoleAdmin},
+			request:        map[string]interface{}{"reason": "charged twice"},
+			purchase:       models.Purchase{ID: "purchase1", UserID: "user1", SKU: "boost_1", Amount: 299, Currency: "USD", Status: models.PurchaseStatusPaid},
+			expectedAmount: 299,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Partial refund",
+			roles:          []string{models.RoleAdmin},
+			request:        map[string]interface{}{"amount": 333},
+			purchase:       models.Purchase{ID: "purchase1", UserID: "user1", SKU: "premium_monthly", Amount: 999, Currency: "USD", Status: models.PurchaseStatusPaid},
+			expectedAmount: 333,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Refund after a partial one defaults to what is left",
+			roles:          []string{models.RoleAdmin},
+			purchase:       models.Purchase{ID: "purchase1", UserID: "user1", SKU: "premium_monthly", Amount: 999, Currency: "USD", Status: models.PurchaseStatusPaid, RefundedAmount: 333},
+			expectedAmount: 666,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Refund the gateway made but the ledger couldn't record",
+			roles:          []string{models.RoleAdmin},
+			purchase:       models.Purchase{ID: "purchase1", UserID: "user1", SKU: "boost_1", Amount: 299, Currency: "USD", Status: models.PurchaseStatusPaid},
+			refundErr:      errors.New("connection reset"),
+			expectedAmount: 299,
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "Refund larger than the amount paid",
+			roles:          []string{models.RoleAdmin},
+			request:        map[string]interface{}{"amount": 5000},
+			purchase:       models.Purchase{ID: "purchase1", UserID: "user1", SKU: "boost_1", Amount: 299, Currency: "USD", Status: models.PurchaseStatusPaid},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Refund larger than what is left",
+			roles:          []string{models.RoleAdmin},
+			request:        map[string]interface{}{"amount": 700},
+			purchase:       models.Purchase{ID: "purchase1", UserID: "user1", SKU: "premium_monthly", Amount: 999, Currency: "USD", Status: models.PurchaseStatusPaid, RefundedAmount: 333},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unpaid purchase",
+			roles:          []string{models.RoleAdmin},
+			purchase:       models.Purchase{ID: "purchase1", UserID: "user1", SKU: "boost_1", Amount: 299, Currency: "USD", Status: models.PurchaseStatusPending},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Store purchase",
+			roles:          []string{models.RoleAdmin},
+			purchase:       models.Purchase{ID: "purchase1", UserID: "user1", SKU: "premium_monthly", Amount: 999, Currency: "USD", Status: models.PurchaseStatusPaid, Store: models.StoreApple},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Unknown purchase",
+			roles:          []string{models.RoleAdmin},
+			purchase:       models.Purchase{ID: "missing"},
+			purchaseErr:    service.ErrPurchaseNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Not an admin",
+			purchase:       models.Purchase{ID: "purchase1"},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockPurchaseService := new(MockPurchaseService)
+			purchaseService = mockPurchaseService
+
+			// Settle a real payment on the fake gateway so it can be refunded
+			gateway := &service.FakePaymentGateway{}
+			paymentGateway = gateway
+			intent, _ := gateway.CreateIntent(tt.purchase.Amount, tt.purchase.Currency, nil)
+			gateway.Confirm(intent.ID)
+			gateway.Refund(intent.ID, tt.purchase.RefundedAmount)
+			tt.purchase.PaymentIntentID = intent.ID
+
+			if tt.roles != nil {
+				mockPurchaseService.On("GetPurchase", tt.purchase.ID).Return(tt.purchase, tt.purchaseErr)
+			}
+			if tt.expectedAmount != 0 {
+				mockPurchaseService.On("RefundPurchase", mock.MatchedBy(func(refund models.Refund) bool {
+					return refund.PurchaseID == tt.purchase.ID && refund.Amount == tt.expectedAmount &&
+						strings.HasPrefix(refund.RefundID, "re_") && refund.Actor == "admin:staff1"
+				}), mock.AnythingOfType("time.Time")).Return(tt.refundErr == nil, tt.refundErr)
+			}
+
+			var body []byte
+			if tt.request != nil {
+				body, _ = json.Marshal(tt.request)
+			}
+			req := staffRequest(t, "POST", "/admin/purchases/"+tt.purchase.ID+"/refund", "staff1", tt.roles, body)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.purchase.ID})
+
+			rr := httptest.NewRecorder()
+			handler := authenticate(requireRole(models.RoleAdmin, RefundPurchaseHandler))
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockPurchaseService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPaymentWebhookRefund(t *testing.T) {
+	paymentWebhookSecret = []byte("test_webhook_secret")
+
+	tests := []struct {
+		name           string
+		purchaseStatus string
+		refundErr      error
+		expectedStatus int
+		expectedBody   map[string]string
+	}{
+		{
+			name:           "Gateway refund is applied",
+			purchaseStatus: models.PurchaseStatusPaid,
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]string{"message": "Event processed"},
+		},
+		{
+			name:           "Refund already refunded in full is ignored",
+			purchaseStatus: models.PurchaseStatusRefunded,
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]string{"message": "Event ignored"},
+		},
+		{
+			name:           "Refund beyond what is left",
+			purchaseStatus: models.PurchaseStatusPaid,
+			refundErr:      service.ErrRefundExceedsAmount,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"error": "Amount mismatch"},
+		},
+		{
+			name:           "Failed refund is left for the gateway to redeliver",
+			purchaseStatus: models.PurchaseStatusPaid,
+			refundErr:      errors.New("connection reset"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   map[string]string{"error": "Database error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockPurchaseService := new(MockPurchaseService)
+			purchaseService = mockPurchaseService
+
+			purchase := models.Purchase{ID: "purchase1", UserID: "user1", SKU: "boost_1", Amount: 499, Currency: "USD", Status: tt.purchaseStatus, PaymentIntentID: "pi_1"}
+			mockPurchaseService.On("GetPurchaseByPaymentIntent", "pi_1").Return(purchase, nil)
+			if tt.purchaseStatus == models.PurchaseStatusPaid {
+				refund := models.Refund{PurchaseID: "purchase1", RefundID: "re_1", Amount: 499, Actor: "payment_gateway"}
+				mockPurchaseService.On("RefundPurchase", refund, mock.AnythingOfType("time.Time")).Return(tt.refundErr == nil, tt.refundErr)
+			}
+
+			payload, _ := json.Marshal(models.PaymentEvent{ID: "evt_1", Type: models.PaymentEventRefunded, IntentID: "pi_1", RefundID: "re_1", Amount: 499, Currency: "USD"})
+			req := httptest.NewRequest("POST", "/webhooks/payments", bytes.NewReader(payload))
+			req.Header.Set("X-Payment-Signature", service.SignWebhookPayload(paymentWebhookSecret, payload, time.Now()))
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(PaymentWebhookHandler)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			var responseBody map[string]string
+			if err := json.NewDecoder(rr.Body).Decode(&responseBody); err != nil {
+				t.Fatal(err)
+			}
+
+			for key, value := range tt.expectedBody {
+				if responseBody[key] != value {
+					t.Errorf("handler returned unexpected body: got %v want %v", responseBody, tt.expectedBody)
+				}
+			}
+
+			mockPurchaseService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRefundPurchase(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	boost := models.Product{SKU: "boost_1", Price: 299, Currency: "USD", Entitlements: []string{models.EntitlementBoost}, Quantity: 1}
+	premium := models.Product{SKU: "premium_monthly", Price: 999, Currency: "USD", Entitlements: []string{models.EntitlementPremium}, DurationDays: 30}
+	subscriptionColumns := []string{"id", "user_id", "sku", "status", "auto_renew", "current_period_start", "current_period_end", "grace_until", "canceled_at", "store"}
+	subscriptionRow := func(status string, end time.Time) []driver.Value {
+		return []driver.Value{"sub1", "user1", "premium_monthly", status, true, now.AddDate(0, 0, -10), end, nil, nil, ""}
+	}
+	audited := func(script *sqlScript) {
+		script.exec("INSERT INTO audit_log", 1, "admin:staff1", models.AuditActionPurchaseRefunded, "purchase", "purchase1", anyArg)
+	}
+
+	tests := []struct {
+		name    string
+		amount  int64
+		script  func(script *sqlScript)
+		applied bool
+		wantErr error
+	}{
+		{
+			name:   "Full refund revokes, marks refunded and audits together",
+			amount: 299,
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM purchases WHERE id=$1 FOR UPDATE", purchaseRowColumns, [][]driver.Value{purchaseRow("boost_1", models.PurchaseStatusPaid, 299, 0)}, "purchase1")
+				script.exec("INSERT INTO purchase_refunds", 1, "purchase1", "re_1", 299, "admin:staff1")
+				script.query("FROM products WHERE sku=$1", productRowColumns, [][]driver.Value{productRow(boost)}, "boost_1")
+				script.exec("UPDATE users SET boost_credits=GREATEST(boost_credits+$1, 0)", 1, -1, "user1")
+				script.exec("UPDATE purchases SET refunded_amount=$1", 1, 299, "purchase1")
+				script.exec("UPDATE purchases SET status=$1", 1, models.PurchaseStatusRefunded, "purchase1")
+				script.exec("INSERT INTO purchase_status_history", 1, "purchase1", models.PurchaseStatusPaid, models.PurchaseStatusRefunded)
+				audited(script)
+				script.commit()
+			},
+			applied: true,
+		},
+		{
+			name:   "Partial refund shortens the subscription and leaves the purchase paid",
+			amount: 333,
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM purchases WHERE id=$1 FOR UPDATE", purchaseRowColumns, [][]driver.Value{purchaseRow("premium_monthly", models.PurchaseStatusPaid, 999, 0)}, "purchase1")
+				script.exec("INSERT INTO purchase_refunds", 1)
+				script.query("FROM products WHERE sku=$1", productRowColumns, [][]driver.Value{productRow(premium)}, "premium_monthly")
+				script.query("FROM subscriptions WHERE user_id=$1 AND status IN ('active', 'grace') FOR UPDATE", subscriptionColumns, [][]driver.Value{subscriptionRow(models.SubscriptionStatusActive, now.AddDate(0, 0, 20))}, "user1")
+				script.query("UPDATE subscriptions SET status=$1, current_period_end=$2", subscriptionColumns, [][]driver.Value{subscriptionRow(models.SubscriptionStatusActive, now.AddDate(0, 0, 10))},
+					models.SubscriptionStatusActive, now.AddDate(0, 0, 10), "sub1")
+				script.exec("UPDATE purchases SET refunded_amount=$1", 1, 333, "purchase1")
+				audited(script)
+				script.commit()
+			},
+			applied: true,
+		},
+		{
+			name:   "Second partial refund that completes the amount ends the subscription",
+			amount: 666,
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM purchases WHERE id=$1 FOR UPDATE", purchaseRowColumns, [][]driver.Value{purchaseRow("premium_monthly", models.PurchaseStatusPaid, 999, 333)}, "purchase1")
+				script.exec("INSERT INTO purchase_refunds", 1)
+				script.query("FROM products WHERE sku=$1", productRowColumns, [][]driver.Value{productRow(premium)}, "premium_monthly")
+				script.query("FROM subscriptions WHERE user_id=$1", subscriptionColumns, [][]driver.Value{subscriptionRow(models.SubscriptionStatusActive, now.AddDate(0, 0, 10))}, "user1")
+				script.query("UPDATE subscriptions SET status=$1", subscriptionColumns, [][]driver.Value{subscriptionRow(models.SubscriptionStatusExpired, now)}, models.SubscriptionStatusExpired, now, "sub1")
+				script.exec("UPDATE users SET premium=false", 1)
+				script.exec("UPDATE purchases SET refunded_amount=$1", 1, 999, "purchase1")
+				script.exec("UPDATE purchases SET status=$1", 1, models.PurchaseStatusRefunded, "purchase1")
+				script.exec("INSERT INTO purchase_status_history", 1)
+				audited(script)
+				script.commit()
+			},
+			applied: true,
+		},
+		{
+			name:   "Refund reported a second time is left alone",
+			amount: 333,
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM purchases WHERE id=$1 FOR UPDATE", purchaseRowColumns, [][]driver.Value{purchaseRow("premium_monthly", models.PurchaseStatusPaid, 999, 333)}, "purchase1")
+				script.exec("INSERT INTO purchase_refunds", 0)
+				script.rollback()
+			},
+		},
+		{
+			name:   "Refund beyond what is left",
+			amount: 700,
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM purchases WHERE id=$1 FOR UPDATE", purchaseRowColumns, [][]driver.Value{purchaseRow("premium_monthly", models.PurchaseStatusPaid, 999, 333)}, "purchase1")
+				script.exec("INSERT INTO purchase_refunds", 1)
+				script.rollback()
+			},
+			wantErr: service.ErrRefundExceedsAmount,
+		},
+		{
+			name:   "Purchase already refunded in full",
+			amount: 299,
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM purchases WHERE id=$1 FOR UPDATE", purchaseRowColumns, [][]driver.Value{purchaseRow("boost_1", models.PurchaseStatusRefunded, 299, 299)}, "purchase1")
+				script.rollback()
+			},
+			wantErr: service.ErrInvalidStatusTransition,
+		},
+		{
+			name:   "Refund of an erased account's purchase revokes nothing",
+			amount: 299,
+			script: func(script *sqlScript) {
+				script.begin()
+				erased := purchaseRow("boost_1", models.PurchaseStatusPaid, 299, 0)
+				erased[1] = ""
+				script.query("COALESCE(user_id::text, '')", purchaseRowColumns, [][]driver.Value{erased}, "purchase1")
+				script.exec("INSERT INTO purchase_refunds", 1)
+				script.query("FROM products WHERE sku=$1", productRowColumns, [][]driver.Value{productRow(boost)}, "boost_1")
+				script.exec("UPDATE purchases SET refunded_amount=$1", 1, 299, "purchase1")
+				script.exec("UPDATE purchases SET status=$1", 1, models.PurchaseStatusRefunded, "purchase1")
+				script.exec("INSERT INTO purchase_status_history", 1)
+				script.exec("INSERT INTO audit_log", 1)
+				script.commit()
+			},
+			applied: true,
+		},
+		{
+			name:   "Failed audit undoes the refund so a retry applies it",
+			amount: 299,
+			script: func(script *sqlScript) {
+				script.begin()
+				script.query("FROM purchases WHERE id=$1 FOR UPDATE", purchaseRowColumns, [][]driver.Value{purchaseRow("boost_1", models.PurchaseStatusPaid, 299, 0)}, "purchase1")
+				script.exec("INSERT INTO purchase_refunds", 1)
+				script.query("FROM products WHERE sku=$1", productRowColumns, [][]driver.Value{productRow(boost)}, "boost_1")
+				script.exec("UPDATE users SET boost_credits", 1)
+				script.exec("UPDATE purchases SET refunded_amount=$1", 1)
+				script.exec("UPDATE purchases SET status=$1", 1)
+				script.exec("INSERT INTO purchase_status_history", 1)
+				script.exec("INSERT INTO audit_log", 0).fails(errors.New("connection reset"))
+				script.rollback()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, script := newSQLScript(t)
+			tt.script(script)
+
+			purchases := &service.PurchaseServiceImpl{DB: db}
+			applied, err := purchases.RefundPurchase(models.Refund{PurchaseID: "purchase1", RefundID: "re_1", Amount: tt.amount, Actor: "admin:staff1"}, now)
+			if applied != tt.applied || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("RefundPurchase() = %v, %v, want applied %v, error %v", applied, err, tt.applied, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"dating-app/models"
+	"errors"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// FieldEncrypter encrypts sensitive columns, such as the birthdate, before
+// they are stored
+type FieldEncrypter interface {
+	Encrypt(plaintext string) (string, error)
+	Decrypt(value string) (string, error)
+}
+
+// ProfileRepository interface
+type ProfileRepository interface {
+	GetUserProfile(userID string) (models.Profile, error)
+	// UpdateUserProfile creates the user's profile or replaces it, prompt
+	// answers included
+	UpdateUserProfile(profile models.Profile) (models.Profile, error)
+	// GetProfileCatalog returns the active prompts and tags
+	GetProfileCatalog() (models.ProfileCatalog, error)
+}
@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"database/sql"
+	"dating-app/models"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// ProfileRepositoryImpl struct implementing ProfileRepository
+type ProfileRepositoryImpl struct {
+	DB     *sql.DB
+	Cipher FieldEncrypter
+}
+
+const profileColumns = "user_id, display_name, bio, birthdate, gender, job, school, interests, tags, updated_at"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func scanProfile(row rowScanner, cipher FieldEncrypter) (models.Profile, error) {
+	var profile models.Profile
+	var birthdate string
+	err := row.Scan(&profile.UserID, &profile.DisplayName, &profile.Bio, &birthdate, &profile.Gender,
+		&profile.Job, &profile.School, pq.Array(&profile.Interests), pq.Array(&profile.Tags), &profile.UpdatedAt)
+	if err != nil {
+		return profile, err
+	}
+	profile.Birthdate, err = cipher.Decrypt(birthdate)
+	return profile, err
+}
+
+// readProfile loads a profile with its prompt answers
+func readProfile(db queryer, cipher FieldEncrypter, userID string) (models.Profile, error) {
+	profile, err := scanProfile(db.QueryRow("SELECT "+profileColumns+" FROM profiles WHERE user_id=$1", userID), cipher)
+	if errors.Is(err, sql.ErrNoRows) {
+		return profile, ErrNotFound
+	}
+	if err != nil {
+		return profile, err
+	}
+
+	rows, err := db.Query(`SELECT a.prompt_id, p.text, a.answer FROM profile_prompts a JOIN prompts p ON p.id=a.prompt_id
+		WHERE a.user_id=$1 ORDER BY a.position`, userID)
+	if err != nil {
+		return profile, err
+	}
+	defer rows.Close()
+
+	profile.Prompts = []models.PromptAnswer{}
+	for rows.Next() {
+		var prompt models.PromptAnswer
+		if err := rows.Scan(&prompt.PromptID, &prompt.Text, &prompt.Answer); err != nil {
+			return profile, err
+		}
+		profile.Prompts = append(profile.Prompts, prompt)
+	}
+	return profile, rows.Err()
+}
+
+func (r *ProfileRepositoryImpl) GetUserProfile(userID string) (models.Profile, error) {
+	return readProfile(r.DB, r.Cipher, userID)
+}
+
+func (r *ProfileRepositoryImpl) UpdateUserProfile(profile models.Profile) (models.Profile, error) {
+	birthdate, err := r.Cipher.Encrypt(profile.Birthdate)
+	if err != nil {
+		return profile, err
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return profile, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO profiles (user_id, display_name, bio, birthdate, gender, job, school, interests, tags, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET display_name=EXCLUDED.display_name, bio=EXCLUDED.bio, birthdate=EXCLUDED.birthdate,
+			gender=EXCLUDED.gender, job=EXCLUDED.job, school=EXCLUDED.school, interests=EXCLUDED.interests, tags=EXCLUDED.tags, updated_at=NOW()`,
+		profile.UserID, profile.DisplayName, profile.Bio, birthdate, profile.Gender, profile.Job, profile.School,
+		pq.Array(profile.Interests), pq.Array(profile.Tags))
+	if err != nil {
+		return profile, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM profile_prompts WHERE user_id=$1", profile.UserID); err != nil {
+		return profile, err
+	}
+	for position, prompt := range profile.Prompts {
+		_, err := tx.Exec("INSERT INTO profile_prompts (user_id, prompt_id, answer, position) VALUES ($1, $2, $3, $4)",
+			profile.UserID, prompt.PromptID, prompt.Answer, position)
+		if err != nil {
+			return profile, err
+		}
+	}
+
+	saved, err := readProfile(tx, r.Cipher, profile.UserID)
+	if err != nil {
+		return saved, err
+	}
+	return saved, tx.Commit()
+}
+
+func (r *ProfileRepositoryImpl) GetProfileCatalog() (models.ProfileCatalog, error) {
+	catalog := models.ProfileCatalog{Prompts: []models.Prompt{}, Tags: []models.Tag{}}
+
+	rows, err := r.DB.Query("SELECT id, text FROM prompts WHERE active ORDER BY id")
+	if err != nil {
+		return catalog, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var prompt models.Prompt
+		if err := rows.Scan(&prompt.ID, &prompt.Text); err != nil {
+			return catalog, err
+		}
+		catalog.Prompts = append(catalog.Prompts, prompt)
+	}
+	if err := rows.Err(); err != nil {
+		return catalog, err
+	}
+
+	rows, err = r.DB.Query("SELECT id, label, category FROM tags WHERE active ORDER BY category, label")
+	if err != nil {
+		return catalog, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag models.Tag
+		if err := rows.Scan(&tag.ID, &tag.Label, &tag.Category); err != nil {
+			return catalog, err
+		}
+		catalog.Tags = append(catalog.Tags, tag)
+	}
+	return catalog, rows.Err()
+}
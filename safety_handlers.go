@@ -0,0 +1,113 @@
+package main
+
+import (
+	"dating-app/models"
+	"dating-app/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// @Summary Block user
+// @Description Blocks a user. The two users no longer see each other in feeds, "who liked me" or chats, can't swipe on each other, and their match, if any, ends.
+// @Tags Safety
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "User ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /users/{id}/block [post]
+func BlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	match, err := safetyService.Block(authenticatedUserID(r), mux.Vars(r)["id"], time.Now())
+	if !writeSafetyError(w, err) {
+		return
+	}
+
+	if match != nil {
+		for _, userID := range match.UserIDs {
+			broker.Publish(userID, models.Event{Type: models.EventUnmatched, Data: match})
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "User blocked"})
+}
+
+// @Summary Unblock user
+// @Description Lifts a block. A match the block ended stays ended.
+// @Tags Safety
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "User ID"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /users/{id}/block [delete]
+func UnblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	if err := safetyService.Unblock(authenticatedUserID(r), mux.Vars(r)["id"]); err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "User unblocked"})
+}
+
+// @Summary Report user
+// @Description Reports a user to the moderators. reason is one of spam, scam, harassment, inappropriate_content, fake_profile, underage or other; details are free text and required for other. Reporting doesn't block the user.
+// @Tags Safety
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param Idempotency-Key header string false "Client-generated key that makes retries safe"
+// @Param id path string true "User ID"
+// @Param reason body string true "Report reason"
+// @Param details body string false "What happened, up to 1000 characters"
+// @Success 201 {object} models.Report
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /users/{id}/report [post]
+func ReportUserHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	report, err := safetyService.Report(authenticatedUserID(r), mux.Vars(r)["id"], request.Reason, request.Details, time.Now())
+	if !writeSafetyError(w, err) {
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(report)
+}
+
+// writeSafetyError writes the response for a SafetyService error and reports
+// whether there was none
+func writeSafetyError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, service.ErrInvalidReport):
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+	case errors.Is(err, service.ErrSelfTarget):
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+	case errors.Is(err, service.ErrUserNotFound):
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+	default:
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+	}
+	return false
+}
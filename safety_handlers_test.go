@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dating-app/models"
+	"dating-app/service"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockSafetyService struct {
+	mock.Mock
+}
+
+func (m *MockSafetyService) Block(userID, targetID string, now time.Time) (*models.Match, error) {
+	args := m.Called(userID, targetID, now)
+	match, _ := args.Get(0).(*models.Match)
+	return match, args.Error(1)
+}
+
+func (m *MockSafetyService) Unblock(userID, targetID string) error {
+	args := m.Called(userID, targetID)
+	return args.Error(0)
+}
+
+func (m *MockSafetyService) Blocked(userID, otherID string) (bool, error) {
+	args := m.Called(userID, otherID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockSafetyService) Report(userID, targetID, reason, details string, now time.Time) (models.Report, error) {
+	args := m.Called(userID, targetID, reason, details, now)
+	return args.Get(0).(models.Report), args.Error(1)
+}
+
+func TestBlockUserHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		targetID       string
+		match          *models.Match
+		serviceErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "Block ends the match",
+			targetID:       "user2",
+			match:          &models.Match{ID: "m1", UserIDs: []string{"user1", "user2"}},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Block without a match",
+			targetID:       "user3",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Blocking oneself",
+			targetID:       "user1",
+			serviceErr:     service.ErrSelfTarget,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown user",
+			targetID:       "user9",
+			serviceErr:     service.ErrUserNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSafetyService := new(MockSafetyService)
+			safetyService = mockSafetyService
+			memoryBroker := service.NewMemoryBroker()
+			broker = memoryBroker
+			events, unsubscribe := memoryBroker.Subscribe(tt.targetID)
+			defer unsubscribe()
+			mockSafetyService.On("Block", "user1", tt.targetID, mock.AnythingOfType("time.Time")).Return(tt.match, tt.serviceErr)
+
+			req := authorizedRequest(t, "POST", "/users/"+tt.targetID+"/block", "user1", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.targetID})
+			rr := httptest.NewRecorder()
+			handler := authenticate(BlockUserHandler)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			select {
+			case event := <-events:
+				if tt.match == nil || event.Type != models.EventUnmatched {
+					t.Errorf("blocked user got unexpected event: %v", event)
+				}
+			default:
+				if tt.match != nil {
+					t.Error("blocked user was not told the match ended")
+				}
+			}
+
+			mockSafetyService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestReportUserHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		serviceErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "Report filed",
+			body:           `{"reason": "scam", "details": "Asked me for gift cards"}`,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Invalid reason",
+			body:           `{"reason": "rude"}`,
+			serviceErr:     fmt.Errorf("%w: reason must be one of spam, scam", service.ErrInvalidReport),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown user",
+			body:           `{"reason": "spam"}`,
+			serviceErr:     service.ErrUserNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Invalid payload",
+			body:           `{"reason":`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSafetyService := new(MockSafetyService)
+			safetyService = mockSafetyService
+			var request struct {
+				Reason  string `json:"reason"`
+				Details string `json:"details"`
+			}
+			if json.Unmarshal([]byte(tt.body), &request) == nil {
+				report := models.Report{ID: "r1", ReporterID: "user1", ReportedID: "user2", Reason: request.Reason, Status: models.ReportStatusOpen}
+				mockSafetyService.On("Report", "user1", "user2", request.Reason, request.Details, mock.AnythingOfType("time.Time")).Return(report, tt.serviceErr)
+			}
+
+			req := authorizedRequest(t, "POST", "/users/user2/report", "user1", []byte(tt.body))
+			req = mux.SetURLVars(req, map[string]string{"id": "user2"})
+			rr := httptest.NewRecorder()
+			handler := authenticate(ReportUserHandler)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockSafetyService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestNormalizeReport(t *testing.T) {
+	tests := []struct {
+		name     string
+		reason   string
+		details  string
+		expected string
+		wantErr  bool
+	}{
+		{name: "Reason without details", reason: models.ReportReasonSpam, expected: ""},
+		{name: "Details are trimmed", reason: models.ReportReasonHarassment, details: "  rude messages \n", expected: "rude messages"},
+		{name: "Unknown reason", reason: "rude", wantErr: true},
+		{name: "Other needs details", reason: models.ReportReasonOther, details: "   ", wantErr: true},
+		{name: "Details too long", reason: models.ReportReasonOther, details: strings.Repeat("é", service.MaxReportDetailsRunes+1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details, err := service.NormalizeReport(tt.reason, tt.details)
+			if tt.wantErr {
+				if !errors.Is(err, service.ErrInvalidReport) {
+					t.Errorf("NormalizeReport returned %v want ErrInvalidReport", err)
+				}
+				return
+			}
+			if err != nil || details != tt.expected {
+				t.Errorf("NormalizeReport returned %q, %v want %q", details, err, tt.expected)
+			}
+		})
+	}
+}
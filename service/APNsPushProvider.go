@@ -0,0 +1,129 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"dating-app/models"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// APNs hosts
+const (
+	APNsProductionURL = "https://api.push.apple.com"
+	APNsSandboxURL    = "https://api.sandbox.push.apple.com"
+)
+
+// apnsTokenLifetime is how long a provider token is reused. Apple rejects
+// tokens older than an hour and throttles refreshing more often than every
+// 20 minutes.
+const apnsTokenLifetime = 50 * time.Minute
+
+// APNsPushProvider sends notifications through the Apple Push Notification
+// service with token-based authentication. Topic is the app's bundle ID.
+type APNsPushProvider struct {
+	BaseURL    string
+	KeyID      string
+	TeamID     string
+	Topic      string
+	PrivateKey *ecdsa.PrivateKey
+	HTTPClient *http.Client
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+func (p *APNsPushProvider) Send(ctx context.Context, token string, notification models.Notification) error {
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{"title": notification.Title, "body": notification.Body},
+			"sound": "default",
+		},
+	}
+	for key, value := range notification.Data {
+		payload[key] = value
+	}
+	payload["type"] = notification.Type
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	providerToken, err := p.providerToken()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.BaseURL+"/3/device/"+url.PathEscape(token), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+providerToken)
+	req.Header.Set("apns-topic", p.Topic)
+	req.Header.Set("apns-push-type", "alert")
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	var failure struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&failure)
+
+	switch {
+	case resp.StatusCode == http.StatusGone, failure.Reason == "BadDeviceToken", failure.Reason == "DeviceTokenNotForTopic":
+		return ErrDeviceUnregistered
+	case resp.StatusCode == http.StatusForbidden && failure.Reason == "ExpiredProviderToken":
+		// Retried with a fresh token
+		p.mu.Lock()
+		p.token = ""
+		p.mu.Unlock()
+		return fmt.Errorf("apns: %s", failure.Reason)
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("apns: status %d %s", resp.StatusCode, failure.Reason)
+	default:
+		return fmt.Errorf("%w: apns status %d %s", ErrPushRejected, resp.StatusCode, failure.Reason)
+	}
+}
+
+// providerToken returns the signed JWT APNs authenticates requests with
+func (p *APNsPushProvider) providerToken() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.token != "" && now.Sub(p.issuedAt) < apnsTokenLifetime {
+		return p.token, nil
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": p.TeamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = p.KeyID
+	signed, err := token.SignedString(p.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	p.token, p.issuedAt = signed, now
+	return signed, nil
+}
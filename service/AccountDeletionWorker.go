@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"dating-app/models"
+	"errors"
+	"log"
+	"time"
+)
+
+// deletionBatchSize bounds how many accounts one run of the worker erases
+const deletionBatchSize = 20
+
+// AccountDeletionWorker periodically erases the accounts whose cooling-off
+// period is over, together with their files
+type AccountDeletionWorker struct {
+	Users    UserService
+	Blobs    BlobStore
+	Audit    AuditService
+	Broker   Broker
+	Interval time.Duration
+}
+
+// Run erases due accounts every Interval until ctx is done
+func (w *AccountDeletionWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.RunOnce(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce erases the accounts due as of now
+func (w *AccountDeletionWorker) RunOnce(now time.Time) {
+	due, err := w.Users.DueDeletions(now, deletionBatchSize)
+	if err != nil {
+		log.Printf("account deletion worker: listing deletions: %v", err)
+		return
+	}
+
+	for _, userID := range due {
+		keys, err := w.Users.Delete(userID, now)
+		if errors.Is(err, ErrDeletionNotScheduled) || errors.Is(err, ErrUserNotFound) {
+			// Cancelled or erased since it was listed
+			continue
+		}
+		if err != nil {
+			log.Printf("account deletion worker: deleting %s: %v", userID, err)
+			continue
+		}
+
+		// The rows are gone, so a file left behind is only wasted space
+		for _, key := range keys {
+			if err := w.Blobs.Delete(key); err != nil {
+				log.Printf("account deletion worker: deleting %s of %s: %v", key, userID, err)
+			}
+		}
+		w.Broker.Publish(userID, models.Event{Type: models.EventSignedOut, Data: map[string]string{"reason": "deleted"}})
+
+		if err := w.Audit.Record(models.AuditEntry{
+			Actor:      "system",
+			Action:     models.AuditActionUserDeleted,
+			TargetType: "user",
+			TargetID:   userID,
+		}); err != nil {
+			log.Printf("account deletion worker: %s deleted but not audited: %v", userID, err)
+		}
+	}
+}
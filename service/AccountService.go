@@ -0,0 +1,62 @@
+package service
+
+import (
+	"dating-app/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrAccountSuspended = errors.New("account suspended")
+	ErrAccountBanned    = errors.New("account banned")
+	ErrAccountActive    = errors.New("account is not suspended or banned")
+	ErrInvalidAppeal    = errors.New("invalid appeal")
+	ErrAppealPending    = errors.New("an appeal is already awaiting review")
+	ErrAppealNotFound   = errors.New("appeal not found")
+	ErrAppealDecided    = errors.New("appeal has already been decided")
+	ErrAppealOutdated   = errors.New("the appealed restriction is no longer in place")
+)
+
+// MaxAppealRunes bounds the text of an appeal
+const MaxAppealRunes = 2000
+
+// AccountService interface
+type AccountService interface {
+	GetAccount(userID string) (models.Account, error)
+	// Appeal files an appeal against the user's suspension or ban. A user has
+	// at most one appeal pending.
+	Appeal(userID, message string, now time.Time) (models.Appeal, error)
+	// ListAppeals returns up to limit appeals with the given status, oldest
+	// first
+	ListAppeals(status string, limit int) ([]models.Appeal, error)
+	// DecideAppeal upholds a pending appeal's restriction or lifts it, making
+	// the account active again, and audits the decision in the same
+	// transaction. Lifting fails with ErrAppealOutdated when the account's
+	// restriction is no longer the appealed one.
+	DecideAppeal(appealID, moderator, decision, response string, now time.Time) (models.Appeal, error)
+}
+
+// Restriction returns why an account may not be used as of now, or nil when
+// it may. A suspension ends by itself once its time is up.
+func Restriction(account models.Account, now time.Time) error {
+	switch {
+	case account.Status == models.AccountStatusBanned:
+		return ErrAccountBanned
+	case account.Status == models.AccountStatusSuspended && account.SuspendedUntil != nil && now.Before(*account.SuspendedUntil):
+		return fmt.Errorf("%w until %s", ErrAccountSuspended, account.SuspendedUntil.UTC().Format(time.RFC3339))
+	default:
+		return nil
+	}
+}
+
+// NormalizeAppeal trims an appeal's message and checks its length
+func NormalizeAppeal(message string) (string, error) {
+	message = strings.TrimSpace(message)
+	if message == "" || utf8.RuneCountInString(message) > MaxAppealRunes {
+		return "", fmt.Errorf("%w: message must be 1 to %d characters", ErrInvalidAppeal, MaxAppealRunes)
+	}
+	return message, nil
+}
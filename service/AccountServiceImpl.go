@@ -0,0 +1,167 @@
+package service
+
+import (
+	"database/sql"
+	"dating-app/models"
+	"errors"
+	"time"
+)
+
+// AccountServiceImpl struct implementing AccountService
+type AccountServiceImpl struct {
+	DB *sql.DB
+}
+
+const appealColumns = "id, user_id, account_status, message, status, response, decided_by, created_at, decided_at"
+
+func scanAppeal(row rowScanner) (models.Appeal, error) {
+	var appeal models.Appeal
+	var decidedAt sql.NullTime
+	err := row.Scan(&appeal.ID, &appeal.UserID, &appeal.AccountStatus, &appeal.Message, &appeal.Status, &appeal.Response,
+		&appeal.DecidedBy, &appeal.CreatedAt, &decidedAt)
+	if decidedAt.Valid {
+		appeal.DecidedAt = &decidedAt.Time
+	}
+	return appeal, err
+}
+
+// getAccount loads a user's account standing, locking the row when forUpdate
+// is set
+func getAccount(q rowQueryer, userID string, forUpdate bool) (models.Account, error) {
+	query := "SELECT status, suspended_until, token_version FROM users WHERE id=$1"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	var account models.Account
+	var suspendedUntil sql.NullTime
+	err := q.QueryRow(query, userID).Scan(&account.Status, &suspendedUntil, &account.TokenVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return account, ErrUserNotFound
+	}
+	if suspendedUntil.Valid {
+		account.SuspendedUntil = &suspendedUntil.Time
+	}
+	return account, err
+}
+
+func (s *AccountServiceImpl) GetAccount(userID string) (models.Account, error) {
+	return getAccount(s.DB, userID, false)
+}
+
+func (s *AccountServiceImpl) Appeal(userID, message string, now time.Time) (models.Appeal, error) {
+	appeal := models.Appeal{UserID: userID, Status: models.AppealStatusPending, CreatedAt: now}
+	message, err := NormalizeAppeal(message)
+	if err != nil {
+		return appeal, err
+	}
+	appeal.Message = message
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return appeal, err
+	}
+	defer tx.Rollback()
+
+	// The user row lock keeps two appeals from being filed at once
+	account, err := getAccount(tx, userID, true)
+	if err != nil {
+		return appeal, err
+	}
+	if Restriction(account, now) == nil {
+		return appeal, ErrAccountActive
+	}
+	appeal.AccountStatus = account.Status
+
+	var pending bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM appeals WHERE user_id=$1 AND status=$2)", userID, models.AppealStatusPending).Scan(&pending)
+	if err != nil {
+		return appeal, err
+	}
+	if pending {
+		return appeal, ErrAppealPending
+	}
+
+	err = tx.QueryRow("INSERT INTO appeals (user_id, account_status, message, status, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		userID, appeal.AccountStatus, message, appeal.Status, now).Scan(&appeal.ID)
+	if err != nil {
+		return appeal, err
+	}
+	return appeal, tx.Commit()
+}
+
+func (s *AccountServiceImpl) ListAppeals(status string, limit int) ([]models.Appeal, error) {
+	rows, err := s.DB.Query("SELECT "+appealColumns+" FROM appeals WHERE status=$1 ORDER BY created_at, id LIMIT $2", status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appeals := []models.Appeal{}
+	for rows.Next() {
+		appeal, err := scanAppeal(rows)
+		if err != nil {
+			return nil, err
+		}
+		appeals = append(appeals, appeal)
+	}
+	return appeals, rows.Err()
+}
+
+func (s *AccountServiceImpl) DecideAppeal(appealID, moderator, decision, response string, now time.Time) (models.Appeal, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.Appeal{}, err
+	}
+	defer tx.Rollback()
+
+	appeal, err := scanAppeal(tx.QueryRow("SELECT "+appealColumns+" FROM appeals WHERE id=$1 FOR UPDATE", appealID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return appeal, ErrAppealNotFound
+	}
+	if err != nil {
+		return appeal, err
+	}
+	if appeal.Status != models.AppealStatusPending {
+		return appeal, ErrAppealDecided
+	}
+
+	status := models.AppealStatusUpheld
+	if decision == models.AppealDecisionLift {
+		status = models.AppealStatusLifted
+		// A restriction imposed or lifted since the appeal was filed isn't the
+		// one the moderator reviewed, so it is left as it is
+		account, err := getAccount(tx, appeal.UserID, true)
+		if err != nil {
+			return appeal, err
+		}
+		if account.Status != appeal.AccountStatus || Restriction(account, now) == nil {
+			return appeal, ErrAppealOutdated
+		}
+		_, err = tx.Exec("UPDATE users SET status=$1, suspended_until=NULL WHERE id=$2", models.AccountStatusActive, appeal.UserID)
+		if err != nil {
+			return appeal, err
+		}
+	}
+
+	appeal, err = scanAppeal(tx.QueryRow(`UPDATE appeals SET status=$1, response=$2, decided_by=$3, decided_at=$4
+		WHERE id=$5 RETURNING `+appealColumns, status, response, moderator, now, appealID))
+	if err != nil {
+		return appeal, err
+	}
+
+	action := models.AuditActionAppealUpheld
+	if status == models.AppealStatusLifted {
+		action = models.AuditActionAppealLifted
+	}
+	err = recordAudit(tx, models.AuditEntry{
+		Actor:      moderator,
+		Action:     action,
+		TargetType: "user",
+		TargetID:   appeal.UserID,
+		Details:    map[string]string{"appealID": appeal.ID, "response": response},
+	})
+	if err != nil {
+		return appeal, err
+	}
+	return appeal, tx.Commit()
+}
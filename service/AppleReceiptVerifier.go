@@ -0,0 +1,303 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"dating-app/models"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// App Store Server API hosts
+const (
+	AppStoreProductionURL = "https://api.storekit.itunes.apple.com"
+	AppStoreSandboxURL    = "https://api.storekit-sandbox.itunes.apple.com"
+)
+
+var errInvalidJWS = errors.New("invalid Apple signed data")
+
+// Extensions Apple puts on the certificates it signs App Store data with. Any
+// chain up to Apple Root CA - G3 verifies, so these tell the App Store's from
+// those of other Apple services.
+var (
+	appleReceiptSigningOID = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 11, 1}
+	appleIntermediateOID   = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 2, 1}
+)
+
+// AppleReceiptVerifier verifies StoreKit 2 transactions with the App Store
+// Server API. The receipt sent by the app is the transaction ID. Signed data
+// returned by Apple, and App Store Server Notifications V2, are JWS whose
+// x5c certificate chain must lead to one of RootCAs (Apple Root CA - G3).
+type AppleReceiptVerifier struct {
+	BaseURL    string
+	IssuerID   string
+	KeyID      string
+	BundleID   string
+	PrivateKey *ecdsa.PrivateKey
+	RootCAs    *x509.CertPool
+	HTTPClient *http.Client
+}
+
+type appleTransaction struct {
+	TransactionID         string `json:"transactionId"`
+	OriginalTransactionID string `json:"originalTransactionId"`
+	BundleID              string `json:"bundleId"`
+	ProductID             string `json:"productId"`
+	PurchaseDate          int64  `json:"purchaseDate"`
+	ExpiresDate           int64  `json:"expiresDate"`
+	RevocationDate        int64  `json:"revocationDate"`
+}
+
+type appleRenewalInfo struct {
+	AutoRenewStatus        int   `json:"autoRenewStatus"`
+	GracePeriodExpiresDate int64 `json:"gracePeriodExpiresDate"`
+}
+
+type appleNotification struct {
+	NotificationType string `json:"notificationType"`
+	Subtype          string `json:"subtype"`
+	Data             struct {
+		BundleID              string `json:"bundleId"`
+		SignedTransactionInfo string `json:"signedTransactionInfo"`
+		SignedRenewalInfo     string `json:"signedRenewalInfo"`
+	} `json:"data"`
+}
+
+func (v *AppleReceiptVerifier) Verify(ctx context.Context, productID, receipt string, subscription bool) (models.StoreTransaction, error) {
+	token, err := v.apiToken()
+	if err != nil {
+		return models.StoreTransaction{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", v.BaseURL+"/inApps/v1/transactions/"+url.PathEscape(receipt), nil)
+	if err != nil {
+		return models.StoreTransaction{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := v.client().Do(req)
+	if err != nil {
+		return models.StoreTransaction{}, fmt.Errorf("%w: %v", ErrStoreRequestFailed, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest:
+		return models.StoreTransaction{}, ErrReceiptInvalid
+	case resp.StatusCode != http.StatusOK:
+		return models.StoreTransaction{}, fmt.Errorf("%w: status %d", ErrStoreRequestFailed, resp.StatusCode)
+	}
+
+	var body struct {
+		SignedTransactionInfo string `json:"signedTransactionInfo"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxNotificationSize)).Decode(&body); err != nil {
+		return models.StoreTransaction{}, fmt.Errorf("%w: %v", ErrStoreRequestFailed, err)
+	}
+
+	transaction, err := v.decodeTransaction(body.SignedTransactionInfo)
+	if errors.Is(err, errInvalidJWS) {
+		return transaction, fmt.Errorf("%w: %v", ErrStoreRequestFailed, err)
+	}
+	if err != nil {
+		return transaction, err
+	}
+	if transaction.ProductID != productID {
+		return transaction, ErrReceiptInvalid
+	}
+	return transaction, nil
+}
+
+func (v *AppleReceiptVerifier) ParseNotification(ctx context.Context, r *http.Request) (models.StoreNotification, error) {
+	var result models.StoreNotification
+
+	var body struct {
+		SignedPayload string `json:"signedPayload"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxNotificationSize)).Decode(&body); err != nil {
+		return result, ErrInvalidNotification
+	}
+
+	var notification appleNotification
+	if err := v.verifyJWS(body.SignedPayload, &notification); err != nil {
+		return result, ErrInvalidNotification
+	}
+	if notification.Data.BundleID != v.BundleID {
+		return result, ErrInvalidNotification
+	}
+
+	transaction, err := v.decodeTransaction(notification.Data.SignedTransactionInfo)
+	if err != nil {
+		return result, ErrInvalidNotification
+	}
+	result.Transaction = transaction
+
+	var renewal appleRenewalInfo
+	if notification.Data.SignedRenewalInfo != "" {
+		if err := v.verifyJWS(notification.Data.SignedRenewalInfo, &renewal); err != nil {
+			return result, ErrInvalidNotification
+		}
+		result.Transaction.AutoRenew = renewal.AutoRenewStatus == 1
+	}
+
+	switch notification.NotificationType {
+	case "SUBSCRIBED", "DID_RENEW":
+		result.Type = models.StoreNotificationRenewed
+	case "DID_FAIL_TO_RENEW":
+		result.Type = models.StoreNotificationRenewalFailed
+		if renewal.GracePeriodExpiresDate > 0 {
+			graceUntil := time.UnixMilli(renewal.GracePeriodExpiresDate)
+			result.GraceUntil = &graceUntil
+		}
+	case "DID_CHANGE_RENEWAL_STATUS":
+		result.Type = models.StoreNotificationAutoRenewChanged
+	case "EXPIRED", "GRACE_PERIOD_EXPIRED":
+		result.Type = models.StoreNotificationExpired
+	case "REFUND", "REVOKE":
+		result.Type = models.StoreNotificationRefunded
+	default:
+		result.Type = models.StoreNotificationIgnored
+	}
+	return result, nil
+}
+
+func (v *AppleReceiptVerifier) decodeTransaction(signed string) (models.StoreTransaction, error) {
+	var info appleTransaction
+	if err := v.verifyJWS(signed, &info); err != nil {
+		return models.StoreTransaction{}, err
+	}
+	if info.BundleID != v.BundleID {
+		return models.StoreTransaction{}, ErrReceiptInvalid
+	}
+
+	transaction := models.StoreTransaction{
+		Store:                 models.StoreApple,
+		TransactionID:         info.TransactionID,
+		OriginalTransactionID: info.OriginalTransactionID,
+		ProductID:             info.ProductID,
+		PurchasedAt:           time.UnixMilli(info.PurchaseDate),
+		Revoked:               info.RevocationDate > 0,
+	}
+	if info.ExpiresDate > 0 {
+		expiresAt := time.UnixMilli(info.ExpiresDate)
+		transaction.ExpiresAt = &expiresAt
+		transaction.AutoRenew = true
+	}
+	return transaction, nil
+}
+
+// verifyJWS checks an Apple-signed JWS against its x5c certificate chain and
+// decodes its payload
+func (v *AppleReceiptVerifier) verifyJWS(signed string, payload interface{}) error {
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 {
+		return errInvalidJWS
+	}
+
+	var header struct {
+		Alg string   `json:"alg"`
+		X5c []string `json:"x5c"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "ES256" || len(header.X5c) == 0 {
+		return errInvalidJWS
+	}
+
+	certificates := make([]*x509.Certificate, 0, len(header.X5c))
+	for _, encoded := range header.X5c {
+		der, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return errInvalidJWS
+		}
+		certificate, err := x509.ParseCertificate(der)
+		if err != nil {
+			return errInvalidJWS
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	leaf := certificates[0]
+	chains, err := leaf.Verify(x509.VerifyOptions{Roots: v.RootCAs, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	if err != nil {
+		return errInvalidJWS
+	}
+	if !appStoreChain(chains) {
+		return errInvalidJWS
+	}
+
+	publicKey, ok := leaf.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return errInvalidJWS
+	}
+	if err := jwt.SigningMethodES256.Verify(parts[0]+"."+parts[1], parts[2], publicKey); err != nil {
+		return errInvalidJWS
+	}
+
+	if err := decodeSegment(parts[1], payload); err != nil {
+		return errInvalidJWS
+	}
+	return nil
+}
+
+// appStoreChain reports whether one of the verified chains runs from an App
+// Store signing certificate through Apple's intermediate CA
+func appStoreChain(chains [][]*x509.Certificate) bool {
+	for _, chain := range chains {
+		if len(chain) >= 3 && hasExtension(chain[0], appleReceiptSigningOID) && hasExtension(chain[1], appleIntermediateOID) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasExtension(certificate *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, extension := range certificate.Extensions {
+		if extension.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiToken signs the short-lived bearer token the App Store Server API expects
+func (v *AppleReceiptVerifier) apiToken() (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": v.IssuerID,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+		"aud": "appstoreconnect-v1",
+		"bid": v.BundleID,
+	})
+	token.Header["kid"] = v.KeyID
+	return token.SignedString(v.PrivateKey)
+}
+
+func (v *AppleReceiptVerifier) client() *http.Client {
+	if v.HTTPClient != nil {
+		return v.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,10 @@
+package service
+
+import "dating-app/models"
+
+// AuditService interface
+type AuditService interface {
+	Record(entry models.AuditEntry) error
+	// History returns the entries recorded about a record, newest first
+	History(targetType, targetID string) ([]models.AuditEntry, error)
+}
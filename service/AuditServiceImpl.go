@@ -0,0 +1,51 @@
+package service
+
+import (
+	"database/sql"
+	"dating-app/models"
+	"encoding/json"
+)
+
+// AuditServiceImpl struct implementing AuditService
+type AuditServiceImpl struct {
+	DB *sql.DB
+}
+
+func (s *AuditServiceImpl) Record(entry models.AuditEntry) error {
+	return recordAudit(s.DB, entry)
+}
+
+// recordAudit writes entry through db, so services can audit an action in
+// the transaction that takes it
+func recordAudit(db execer, entry models.AuditEntry) error {
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO audit_log (actor, action, target_type, target_id, details) VALUES ($1, $2, $3, $4, $5)",
+		entry.Actor, entry.Action, entry.TargetType, entry.TargetID, details)
+	return err
+}
+
+func (s *AuditServiceImpl) History(targetType, targetID string) ([]models.AuditEntry, error) {
+	rows, err := s.DB.Query(`SELECT id, actor, action, target_type, target_id, details, created_at FROM audit_log
+		WHERE target_type=$1 AND target_id=$2 ORDER BY created_at DESC, id DESC`, targetType, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var entry models.AuditEntry
+		var details []byte
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.TargetType, &entry.TargetID, &details, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(details, &entry.Details); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
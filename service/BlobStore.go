@@ -0,0 +1,18 @@
+package service
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps uploaded files such as photos. Keys are slash-separated
+// paths; URL returns where clients can fetch a blob, so an S3-compatible store
+// can hand out bucket or CDN URLs instead of serving blobs through the API.
+type BlobStore interface {
+	Put(key, contentType string, data io.Reader) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	URL(key string) string
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// botQueueSize bounds how many observed users wait to be scored. Events past
+// it are dropped; an active bot is observed again soon enough.
+const botQueueSize = 1000
+
+// BotActor is the audit actor of actions the bot detector takes
+const BotActor = "bot-detector"
+
+// BotDetector scores users whose swipes or messages it observes and flags
+// those whose activity looks automated. A user is scored at most once per
+// Interval however many events they produce.
+type BotDetector struct {
+	Bots       BotService
+	Thresholds BotThresholds
+	Interval   time.Duration
+	observed   chan string
+}
+
+// NewBotDetector makes a detector ready to observe events. The zero value
+// drops every event.
+func NewBotDetector(bots BotService, thresholds BotThresholds, interval time.Duration) *BotDetector {
+	return &BotDetector{
+		Bots:       bots,
+		Thresholds: thresholds,
+		Interval:   interval,
+		observed:   make(chan string, botQueueSize),
+	}
+}
+
+// Observe queues a user who just swiped or sent a message for scoring. It
+// never blocks.
+func (d *BotDetector) Observe(userID string) {
+	select {
+	case d.observed <- userID:
+	default:
+	}
+}
+
+// Run scores the observed users every Interval until ctx is done
+func (d *BotDetector) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	pending := map[string]bool{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case userID := <-d.observed:
+			pending[userID] = true
+		case <-ticker.C:
+			for userID := range pending {
+				if _, err := d.Check(userID, time.Now()); err != nil {
+					log.Printf("bot detector: checking %s: %v", userID, err)
+				}
+			}
+			pending = map[string]bool{}
+		}
+	}
+}
+
+// Check scores the user's activity as of now, reports them if the score
+// reaches the review threshold and shadow-bans them if it reaches the
+// shadow-ban threshold. It returns the score.
+func (d *BotDetector) Check(userID string, now time.Time) (float64, error) {
+	window := d.Thresholds.Window
+	signals, err := d.Bots.Signals(userID, now.Add(-window))
+	if err != nil {
+		return 0, err
+	}
+	score := ScoreBot(signals, window)
+	if score < d.Thresholds.Review {
+		return score, nil
+	}
+
+	shadowBan := score >= d.Thresholds.ShadowBan
+	_, err = d.Bots.Flag(userID, score, DescribeBotSignals(score, signals, window), shadowBan, now)
+	return score, err
+}
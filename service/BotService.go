@@ -0,0 +1,87 @@
+package service
+
+import (
+	"dating-app/models"
+	"fmt"
+	"math"
+	"time"
+)
+
+// BotThresholds configures bot detection. Users whose score over the last
+// Window reaches Review are reported to the moderators, and those reaching
+// ShadowBan are also shadow-banned right away. Scores never exceed 1, so a
+// ShadowBan above 1 only ever reports.
+type BotThresholds struct {
+	Window    time.Duration
+	Review    float64
+	ShadowBan float64
+}
+
+// DefaultBotThresholds flags a user who swipes right on everyone at machine
+// speed, and shadow-bans one who also pastes the same message into chats
+var DefaultBotThresholds = BotThresholds{
+	Window:    time.Hour,
+	Review:    0.6,
+	ShadowBan: 0.9,
+}
+
+// Validate checks that the thresholds are usable
+func (t BotThresholds) Validate() error {
+	if t.Window <= 0 {
+		return fmt.Errorf("bot detection window must be positive")
+	}
+	if t.Review <= 0 || t.ShadowBan < t.Review {
+		return fmt.Errorf("bot detection needs 0 < review score <= shadow-ban score")
+	}
+	return nil
+}
+
+// Scoring parameters. Swipe signals need a minimum number of swipes, since a
+// handful of quick right swipes says nothing.
+const (
+	botMinSwipes = 10
+	// botSwipesPerMinute is the sustained rate at which the rate signal
+	// saturates
+	botSwipesPerMinute = 5.0
+	// botHumanSwipeGap is the median number of seconds between swipes below
+	// which the pace starts to look automated
+	botHumanSwipeGap = 3.0
+	// botDuplicateMessages is how many repeated messages saturate that signal
+	botDuplicateMessages = 5
+)
+
+// BotService interface
+type BotService interface {
+	// Signals summarizes the user's swipes and messages since the given time
+	Signals(userID string, since time.Time) (models.BotSignals, error)
+	// Flag files an automated report about the user for the moderators,
+	// unless one is still open, and shadow-bans them if shadowBan is set,
+	// auditing the shadow ban with the score in the same transaction. It
+	// reports whether a report was filed.
+	Flag(userID string, score float64, details string, shadowBan bool, now time.Time) (bool, error)
+}
+
+// ScoreBot rates from 0 to 1 how automated activity over window looks. Four
+// signals weigh equally: swipe rate, share of right swipes, pace between
+// swipes and messages repeated across chats.
+func ScoreBot(signals models.BotSignals, window time.Duration) float64 {
+	score := 0.0
+	if signals.Swipes >= botMinSwipes {
+		perMinute := float64(signals.Swipes) / window.Minutes()
+		score += math.Min(perMinute/botSwipesPerMinute, 1)
+
+		// People are picky: swiping right on more than half is unusual
+		ratio := float64(signals.RightSwipes) / float64(signals.Swipes)
+		score += math.Max((ratio-0.5)/0.5, 0)
+
+		score += math.Max(1-signals.MedianSwipeGap/botHumanSwipeGap, 0)
+	}
+	score += math.Min(float64(signals.DuplicateMessages)/botDuplicateMessages, 1)
+	return score / 4
+}
+
+// DescribeBotSignals explains a score for the moderators reviewing it
+func DescribeBotSignals(score float64, signals models.BotSignals, window time.Duration) string {
+	return fmt.Sprintf("Automated flag, score %.2f over the last %s: %d swipes (%d right), median %.1fs between swipes, %d repeated messages",
+		score, window, signals.Swipes, signals.RightSwipes, signals.MedianSwipeGap, signals.DuplicateMessages)
+}
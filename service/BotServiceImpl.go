@@ -0,0 +1,118 @@
+package service
+
+import (
+	"database/sql"
+	"dating-app/models"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// BotServiceImpl struct implementing BotService
+type BotServiceImpl struct {
+	DB     *sql.DB
+	Cipher *FieldCipher
+}
+
+func (s *BotServiceImpl) Signals(userID string, since time.Time) (models.BotSignals, error) {
+	var signals models.BotSignals
+	// Swipes are timed by when the server got them, as the time of a batched
+	// swipe comes from the client. The first swipe of the window has no gap,
+	// and percentile_cont skips it.
+	err := s.DB.QueryRow(`SELECT COUNT(*), COUNT(*) FILTER (WHERE action='right'),
+			COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY gap), 0)
+		FROM (SELECT action, EXTRACT(EPOCH FROM received_at - LAG(received_at) OVER (ORDER BY received_at)) AS gap
+			FROM swipes WHERE user_id=$1 AND received_at >= $2) s`, userID, since).
+		Scan(&signals.Swipes, &signals.RightSwipes, &signals.MedianSwipeGap)
+	if err != nil {
+		return signals, err
+	}
+
+	signals.DuplicateMessages, err = s.duplicateMessages(userID, since)
+	return signals, err
+}
+
+// duplicateMessages counts the user's texts since then that also went to
+// other matches, each text counting once for the first match it went to.
+// Bodies are encrypted, so they are compared once decrypted.
+func (s *BotServiceImpl) duplicateMessages(userID string, since time.Time) (int, error) {
+	rows, err := s.DB.Query("SELECT match_id, body FROM messages WHERE sender_id=$1 AND created_at >= $2", userID, since)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	matchesByText := map[string]map[string]bool{}
+	for rows.Next() {
+		var matchID, body string
+		if err := rows.Scan(&matchID, &body); err != nil {
+			return 0, err
+		}
+		text, err := s.Cipher.Decrypt(body)
+		if err != nil {
+			return 0, err
+		}
+		if matchesByText[text] == nil {
+			matchesByText[text] = map[string]bool{}
+		}
+		matchesByText[text][matchID] = true
+	}
+
+	duplicates := 0
+	for _, matches := range matchesByText {
+		duplicates += len(matches) - 1
+	}
+	return duplicates, rows.Err()
+}
+
+func (s *BotServiceImpl) Flag(userID string, score float64, details string, shadowBan bool, now time.Time) (bool, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// The user row lock keeps concurrent checks from filing twice
+	var shadowBanned bool
+	err = tx.QueryRow("SELECT shadow_banned FROM users WHERE id=$1 FOR UPDATE", userID).Scan(&shadowBanned)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrUserNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var open bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM reports WHERE reported_id=$1 AND reason=$2 AND status=$3)",
+		userID, models.ReportReasonAutomated, models.ReportStatusOpen).Scan(&open)
+	if err != nil {
+		return false, err
+	}
+
+	// A user reported at the review score is still shadow-banned once they
+	// reach the shadow-ban score, though the open report isn't filed again
+	if shadowBan && !shadowBanned {
+		if _, err := tx.Exec("UPDATE users SET shadow_banned=true WHERE id=$1", userID); err != nil {
+			return false, err
+		}
+		err = recordAudit(tx, models.AuditEntry{
+			Actor:      BotActor,
+			Action:     models.AuditActionUserShadowBanned,
+			TargetType: "user",
+			TargetID:   userID,
+			Details:    map[string]string{"score": strconv.FormatFloat(score, 'f', 2, 64)},
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+	if open {
+		return false, tx.Commit()
+	}
+	_, err = tx.Exec("INSERT INTO reports (reporter_id, reported_id, reason, details, status, created_at) VALUES (NULL, $1, $2, $3, $4, $5)",
+		userID, models.ReportReasonAutomated, details, models.ReportStatusOpen, now)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
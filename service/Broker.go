@@ -0,0 +1,16 @@
+package service
+
+import "dating-app/models"
+
+// Broker fans real-time events out to the connections of a user. The
+// in-process MemoryBroker only reaches connections to this server; running
+// several servers needs a Broker backed by a shared pub/sub.
+type Broker interface {
+	// Publish delivers an event to every current subscription of the user.
+	// Delivery is best effort: events for a subscriber that has fallen
+	// behind are dropped.
+	Publish(userID string, event models.Event)
+	// Subscribe returns the user's events until unsubscribe is called, which
+	// closes the channel
+	Subscribe(userID string) (events <-chan models.Event, unsubscribe func())
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"dating-app/models"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrMatchNotFound is also returned to users outside the match, so they
+	// can't tell which matches exist
+	ErrMatchNotFound  = errors.New("match not found")
+	ErrMatchClosed    = errors.New("conversation is closed")
+	ErrInvalidMessage = errors.New("invalid message")
+	ErrInvalidCursor  = errors.New("invalid cursor")
+	ErrInvalidReceipt = errors.New("invalid receipt")
+)
+
+// MaxMessageRunes bounds the length of a chat message
+const MaxMessageRunes = 1000
+
+// ChatService interface. Every method takes the user acting so that only the
+// two matched users can read or write a conversation.
+type ChatService interface {
+	// ListMatches returns the user's open matches, newest first, with the
+	// presence of the other user
+	ListMatches(userID string, now time.Time) ([]models.Match, error)
+	// Typing checks that the user can type in an open match and returns the
+	// event for the other user
+	Typing(userID, matchID string) (models.Typing, error)
+	SendMessage(userID, matchID, body string, now time.Time) (models.Message, error)
+	// ListMessages returns up to limit messages sent before the cursor, newest
+	// first; an empty cursor starts at the latest message
+	ListMessages(userID, matchID, cursor string, limit int) (models.MessagePage, error)
+	// MarkMessages records that the user's device got (delivered) or the user
+	// read every message sent to them in the match up to upToMessageID
+	MarkMessages(userID, matchID, status, upToMessageID string, now time.Time) (models.Receipt, error)
+	// Unmatch closes the conversation for both users
+	Unmatch(userID, matchID string, now time.Time) (models.Match, error)
+}
+
+// MatchPair orders two user IDs the way matches store them, so a pair is
+// stored once whoever swiped right last
+func MatchPair(userID, targetID string) (string, string) {
+	if targetID < userID {
+		return targetID, userID
+	}
+	return userID, targetID
+}
+
+// NormalizeMessage trims the message body and checks its length
+func NormalizeMessage(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return body, fmt.Errorf("%w: body is required", ErrInvalidMessage)
+	}
+	if utf8.RuneCountInString(body) > MaxMessageRunes {
+		return body, fmt.Errorf("%w: body must be at most %d characters", ErrInvalidMessage, MaxMessageRunes)
+	}
+	return body, nil
+}
+
+// EncodeMessageCursor makes the cursor for the messages older than messageID.
+// Cursors are opaque to clients so the paging scheme can change.
+func EncodeMessageCursor(messageID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(messageID))
+}
+
+// DecodeMessageCursor returns the message ID a cursor starts before
+func DecodeMessageCursor(cursor string) (string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+	if _, err := strconv.ParseInt(string(decoded), 10, 64); err != nil {
+		return "", ErrInvalidCursor
+	}
+	return string(decoded), nil
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"database/sql"
+	"dating-app/models"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ChatServiceImpl struct implementing ChatService
+type ChatServiceImpl struct {
+	DB     *sql.DB
+	Cipher *FieldCipher
+}
+
+const matchColumns = "id, user_a, user_b, created_at, unmatched_at"
+
+func scanMatch(row rowScanner) (models.Match, error) {
+	var match models.Match
+	var userA, userB string
+	var unmatchedAt sql.NullTime
+	if err := row.Scan(&match.ID, &userA, &userB, &match.CreatedAt, &unmatchedAt); err != nil {
+		return match, err
+	}
+	match.UserIDs = []string{userA, userB}
+	if unmatchedAt.Valid {
+		match.UnmatchedAt = &unmatchedAt.Time
+	}
+	return match, nil
+}
+
+type rowQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// getMatch loads a match the user is part of. The row is share-locked so that
+// within a transaction an unmatch waits until the transaction is done. A
+// match between users who blocked one another is hidden.
+func getMatch(q rowQueryer, userID, matchID string) (models.Match, error) {
+	match, err := scanMatch(q.QueryRow("SELECT "+matchColumns+" FROM matches WHERE id=$1 AND $2 IN (user_a, user_b) AND "+notBlocked("user_a", "user_b")+" FOR SHARE", matchID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return match, ErrMatchNotFound
+	}
+	return match, err
+}
+
+func (s *ChatServiceImpl) ListMatches(userID string, now time.Time) ([]models.Match, error) {
+	rows, err := s.DB.Query(`SELECT m.id, m.user_a, m.user_b, m.created_at, m.unmatched_at, u.last_seen_at, u.show_presence
+		FROM matches m
+		JOIN users u ON u.id = CASE WHEN m.user_a=$1 THEN m.user_b ELSE m.user_a END
+		WHERE $1 IN (m.user_a, m.user_b) AND m.unmatched_at IS NULL
+		ORDER BY m.created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []models.Match{}
+	for rows.Next() {
+		var match models.Match
+		var userA, userB string
+		var unmatchedAt, lastSeen sql.NullTime
+		var showPresence bool
+		if err := rows.Scan(&match.ID, &userA, &userB, &match.CreatedAt, &unmatchedAt, &lastSeen, &showPresence); err != nil {
+			return nil, err
+		}
+		match.UserIDs = []string{userA, userB}
+		if showPresence {
+			match.Presence = models.PresenceOffline
+			if lastSeen.Valid {
+				match.Presence = PresenceStatus(lastSeen.Time, now)
+			}
+		}
+		matches = append(matches, match)
+	}
+	return matches, rows.Err()
+}
+
+func (s *ChatServiceImpl) Typing(userID, matchID string) (models.Typing, error) {
+	typing := models.Typing{MatchID: matchID, UserID: userID}
+	match, err := getMatch(s.DB, userID, matchID)
+	if err != nil {
+		return typing, err
+	}
+	if match.UnmatchedAt != nil {
+		return typing, ErrMatchClosed
+	}
+	typing.RecipientID = match.OtherUser(userID)
+	typing.Hidden, err = isShadowBanned(s.DB, userID)
+	return typing, err
+}
+
+// isShadowBanned reports whether what the user does in chats is hidden from
+// the people they talk to
+func isShadowBanned(q rowQueryer, userID string) (bool, error) {
+	var shadowBanned bool
+	err := q.QueryRow("SELECT shadow_banned FROM users WHERE id=$1", userID).Scan(&shadowBanned)
+	return shadowBanned, err
+}
+
+func (s *ChatServiceImpl) SendMessage(userID, matchID, body string, now time.Time) (models.Message, error) {
+	message := models.Message{MatchID: matchID, SenderID: userID, CreatedAt: now}
+	body, err := NormalizeMessage(body)
+	if err != nil {
+		return message, err
+	}
+	message.Body = body
+
+	// The body is stored encrypted, so which messages are the same across
+	// users can't be read from the database
+	encrypted, err := s.Cipher.Encrypt(body)
+	if err != nil {
+		return message, err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return message, err
+	}
+	defer tx.Rollback()
+
+	// An unmatch can't slip in between the check and the insert
+	match, err := getMatch(tx, userID, matchID)
+	if err != nil {
+		return message, err
+	}
+	if match.UnmatchedAt != nil {
+		return message, ErrMatchClosed
+	}
+	message.RecipientID = match.OtherUser(userID)
+
+	// Messages of shadow-banned users are stored hidden from the recipient
+	err = tx.QueryRow(`INSERT INTO messages (match_id, sender_id, body, created_at, hidden)
+		VALUES ($1, $2, $3, $4, (SELECT shadow_banned FROM users WHERE id=$2)) RETURNING id, hidden`,
+		matchID, userID, encrypted, now).Scan(&message.ID, &message.Hidden)
+	if err != nil {
+		return message, err
+	}
+	return message, tx.Commit()
+}
+
+func (s *ChatServiceImpl) ListMessages(userID, matchID, cursor string, limit int) (models.MessagePage, error) {
+	page := models.MessagePage{Messages: []models.Message{}}
+	before := ""
+	if cursor != "" {
+		var err error
+		if before, err = DecodeMessageCursor(cursor); err != nil {
+			return page, err
+		}
+	}
+
+	match, err := getMatch(s.DB, userID, matchID)
+	if err != nil {
+		return page, err
+	}
+	if match.UnmatchedAt != nil {
+		return page, ErrMatchClosed
+	}
+
+	// One extra row tells whether there is another page. Hidden messages are
+	// only listed for their sender.
+	rows, err := s.DB.Query(`SELECT id, match_id, sender_id, body, created_at, delivered_at, read_at FROM messages
+		WHERE match_id=$1 AND ($2 = '' OR id < NULLIF($2, '')::bigint) AND (NOT hidden OR sender_id=$4)
+		ORDER BY id DESC LIMIT $3`, matchID, before, limit+1, userID)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message models.Message
+		var deliveredAt, readAt sql.NullTime
+		if err := rows.Scan(&message.ID, &message.MatchID, &message.SenderID, &message.Body, &message.CreatedAt, &deliveredAt, &readAt); err != nil {
+			return page, err
+		}
+		if message.Body, err = s.Cipher.Decrypt(message.Body); err != nil {
+			return page, err
+		}
+		if deliveredAt.Valid {
+			message.DeliveredAt = &deliveredAt.Time
+		}
+		if readAt.Valid {
+			message.ReadAt = &readAt.Time
+		}
+		message.RecipientID = match.OtherUser(message.SenderID)
+		page.Messages = append(page.Messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	if len(page.Messages) > limit {
+		page.Messages = page.Messages[:limit]
+		page.NextCursor = EncodeMessageCursor(page.Messages[limit-1].ID)
+	}
+	return page, nil
+}
+
+func (s *ChatServiceImpl) MarkMessages(userID, matchID, status, upToMessageID string, now time.Time) (models.Receipt, error) {
+	receipt := models.Receipt{MatchID: matchID, UserID: userID, Status: status, UpToMessageID: upToMessageID, At: now}
+	if status != models.ReceiptDelivered && status != models.ReceiptRead {
+		return receipt, fmt.Errorf("%w: status must be delivered or read", ErrInvalidReceipt)
+	}
+	if _, err := strconv.ParseInt(upToMessageID, 10, 64); err != nil {
+		return receipt, fmt.Errorf("%w: messageID is required", ErrInvalidReceipt)
+	}
+
+	match, err := getMatch(s.DB, userID, matchID)
+	if err != nil {
+		return receipt, err
+	}
+	if match.UnmatchedAt != nil {
+		return receipt, ErrMatchClosed
+	}
+	receipt.SenderID = match.OtherUser(userID)
+
+	// Reading a message implies it was delivered. Timestamps already set are
+	// kept, so repeated receipts change nothing.
+	readAt := sql.NullTime{Time: now, Valid: status == models.ReceiptRead}
+	_, err = s.DB.Exec(`UPDATE messages SET delivered_at=COALESCE(delivered_at, $4), read_at=COALESCE(read_at, $5)
+		WHERE match_id=$1 AND sender_id<>$2 AND id<=$3 AND NOT hidden`, matchID, userID, upToMessageID, now, readAt)
+	if err != nil {
+		return receipt, err
+	}
+	receipt.Hidden, err = isShadowBanned(s.DB, userID)
+	return receipt, err
+}
+
+func (s *ChatServiceImpl) Unmatch(userID, matchID string, now time.Time) (models.Match, error) {
+	match, err := scanMatch(s.DB.QueryRow(`UPDATE matches SET unmatched_at=COALESCE(unmatched_at, $3), unmatched_by=COALESCE(unmatched_by, $2)
+		WHERE id=$1 AND $2 IN (user_a, user_b) RETURNING `+matchColumns, matchID, userID, now))
+	if errors.Is(err, sql.ErrNoRows) {
+		return match, ErrMatchNotFound
+	}
+	return match, err
+}
@@ -0,0 +1,22 @@
+package service
+
+// EncryptedColumn is a column holding FieldCipher values
+type EncryptedColumn struct {
+	Table  string
+	Key    string
+	Column string
+}
+
+// EncryptedColumns are the columns whose personal data is stored encrypted
+var EncryptedColumns = []EncryptedColumn{
+	{Table: "profiles", Key: "user_id", Column: "birthdate"},
+	{Table: "messages", Key: "id", Column: "body"},
+}
+
+// EncryptionService interface. It moves stored values onto the current key
+// after a rotation and encrypts the ones written before their column was.
+type EncryptionService interface {
+	// Reencrypt rewrites up to limit values of the column that are in
+	// plaintext or wrapped with an older key, and returns how many it rewrote
+	Reencrypt(column EncryptedColumn, limit int) (int, error)
+}
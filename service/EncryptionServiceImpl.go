@@ -0,0 +1,57 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// EncryptionServiceImpl struct implementing EncryptionService
+type EncryptionServiceImpl struct {
+	DB     *sql.DB
+	Cipher *FieldCipher
+}
+
+func (s *EncryptionServiceImpl) Reencrypt(column EncryptedColumn, limit int) (int, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Table and column names come from EncryptedColumns, never from a request.
+	// Locked rows are being written and are left to the next batch.
+	rows, err := tx.Query(fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s NOT LIKE $1 LIMIT $2 FOR UPDATE SKIP LOCKED",
+		column.Key, column.Column, column.Table, column.Column), EncryptedPrefix(s.Cipher.Keys.CurrentVersion())+"%", limit)
+	if err != nil {
+		return 0, err
+	}
+	type stale struct{ key, value string }
+	var values []stale
+	for rows.Next() {
+		var value stale
+		if err := rows.Scan(&value.key, &value.value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		values = append(values, value)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, value := range values {
+		plaintext, err := s.Cipher.Decrypt(value.value)
+		if err != nil {
+			return 0, fmt.Errorf("%s.%s of %s: %w", column.Table, column.Column, value.key, err)
+		}
+		encrypted, err := s.Cipher.Encrypt(plaintext)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s=$1 WHERE %s=$2", column.Table, column.Column, column.Key), encrypted, value.key); err != nil {
+			return 0, err
+		}
+	}
+	return len(values), tx.Commit()
+}
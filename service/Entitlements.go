@@ -0,0 +1,60 @@
+package service
+
+import (
+	"dating-app/models"
+	"fmt"
+)
+
+// EntitlementStore keeps what users bought. UserService is one; the purchase
+// ledger uses another that writes in its own transaction.
+type EntitlementStore interface {
+	PurchasePremium(userID string) error
+	RevokePremium(userID string) error
+	AddExtraSwipes(userID string, count int) error
+	AddBoostCredits(userID string, count int) error
+}
+
+// GrantEntitlements applies every entitlement of a product to the buyer
+func GrantEntitlements(users EntitlementStore, userID string, product models.Product) error {
+	for _, entitlement := range product.Entitlements {
+		var err error
+		switch entitlement {
+		case models.EntitlementPremium:
+			err = users.PurchasePremium(userID)
+		case models.EntitlementVerified:
+			// Retired: the badge can't be bought, only earned by verifying
+		case models.EntitlementSwipes:
+			err = users.AddExtraSwipes(userID, product.Quantity)
+		case models.EntitlementBoost:
+			err = users.AddBoostCredits(userID, product.Quantity)
+		case models.EntitlementSwipeReset:
+			err = users.AddExtraSwipes(userID, DailySwipeQuota)
+		default:
+			err = fmt.Errorf("unknown entitlement %q", entitlement)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeEntitlements takes back the lasting entitlements of a product and
+// whatever is left of its consumables; swipes and boosts already used stay used.
+func RevokeEntitlements(users EntitlementStore, userID string, product models.Product) error {
+	for _, entitlement := range product.Entitlements {
+		var err error
+		switch entitlement {
+		case models.EntitlementPremium:
+			err = users.RevokePremium(userID)
+		case models.EntitlementSwipes:
+			err = users.AddExtraSwipes(userID, -product.Quantity)
+		case models.EntitlementBoost:
+			err = users.AddBoostCredits(userID, -product.Quantity)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
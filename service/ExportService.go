@@ -0,0 +1,53 @@
+package service
+
+import (
+	"dating-app/models"
+	"errors"
+	"time"
+)
+
+var ErrExportNotFound = errors.New("export not found")
+
+const (
+	// ExportLinkTTL is how long the download link of an export works
+	ExportLinkTTL = 7 * 24 * time.Hour
+	// exportLease is how long a claimed export is left to its worker
+	exportLease = 10 * time.Minute
+	// maxExportAttempts is how many times building an export is tried
+	maxExportAttempts = 3
+	// ExportKeyPrefix starts the blob keys of export archives, which are only
+	// served through their expiring download links
+	ExportKeyPrefix = "exports/"
+)
+
+// ExportService interface. Exports are requested by users and built in the
+// background by an ExportWorker.
+type ExportService interface {
+	// RequestExport queues an export of the user's data. A user has at most
+	// one pending export; asking again returns it with created false.
+	RequestExport(userID string, now time.Time) (export models.Export, created bool, err error)
+	// LatestExport returns the user's most recent export
+	LatestExport(userID string) (models.Export, error)
+	GetExportByToken(token string) (models.Export, error)
+	// DueExports claims up to limit pending exports to build, counting an
+	// attempt for each
+	DueExports(now time.Time, limit int) ([]models.Export, error)
+	MarkExportReady(exportID, storageKey string, readyAt, expiresAt time.Time) error
+	MarkExportFailed(exportID string) error
+	// ExpiredExports returns up to limit ready exports whose link has expired
+	ExpiredExports(now time.Time, limit int) ([]models.Export, error)
+	// MarkExportExpired records that an expired export's archive was deleted
+	MarkExportExpired(exportID string) error
+
+	// Swipes returns every swipe the user made, oldest first
+	Swipes(userID string) ([]models.SwipeRecord, error)
+	// Matches returns every match the user had, including ended ones
+	Matches(userID string) ([]models.Match, error)
+	// Messages returns every message the user sent or received, oldest first
+	Messages(userID string) ([]models.Message, error)
+}
+
+// ExportDownloadPath is where the archive of an export is downloaded
+func ExportDownloadPath(export models.Export) string {
+	return "/exports/" + export.Token
+}
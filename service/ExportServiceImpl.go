@@ -0,0 +1,186 @@
+package service
+
+import (
+	"database/sql"
+	"dating-app/models"
+	"errors"
+	"time"
+)
+
+// ExportServiceImpl struct implementing ExportService
+type ExportServiceImpl struct {
+	DB     *sql.DB
+	Cipher *FieldCipher
+}
+
+const exportColumns = "id, user_id, status, token, storage_key, attempts, created_at, ready_at, expires_at"
+
+func scanExport(row rowScanner) (models.Export, error) {
+	var export models.Export
+	var readyAt, expiresAt sql.NullTime
+	err := row.Scan(&export.ID, &export.UserID, &export.Status, &export.Token, &export.StorageKey, &export.Attempts,
+		&export.CreatedAt, &readyAt, &expiresAt)
+	if readyAt.Valid {
+		export.ReadyAt = &readyAt.Time
+	}
+	if expiresAt.Valid {
+		export.ExpiresAt = &expiresAt.Time
+	}
+	return export, err
+}
+
+func (s *ExportServiceImpl) queryExports(query string, args ...interface{}) ([]models.Export, error) {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []models.Export{}
+	for rows.Next() {
+		export, err := scanExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+	return exports, rows.Err()
+}
+
+func (s *ExportServiceImpl) RequestExport(userID string, now time.Time) (models.Export, bool, error) {
+	// The partial unique index on pending exports settles concurrent requests
+	export, err := scanExport(s.DB.QueryRow(`INSERT INTO exports (user_id, status, token, storage_key, attempts, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, '', 0, $4, $4)
+		ON CONFLICT (user_id) WHERE status='pending' DO NOTHING
+		RETURNING `+exportColumns, userID, models.ExportStatusPending, randomHex(32), now))
+	if err == nil {
+		return export, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return export, false, err
+	}
+	export, err = scanExport(s.DB.QueryRow("SELECT "+exportColumns+" FROM exports WHERE user_id=$1 AND status=$2",
+		userID, models.ExportStatusPending))
+	return export, false, err
+}
+
+func (s *ExportServiceImpl) LatestExport(userID string) (models.Export, error) {
+	export, err := scanExport(s.DB.QueryRow("SELECT "+exportColumns+" FROM exports WHERE user_id=$1 ORDER BY created_at DESC, id DESC LIMIT 1", userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return export, ErrExportNotFound
+	}
+	return export, err
+}
+
+func (s *ExportServiceImpl) GetExportByToken(token string) (models.Export, error) {
+	export, err := scanExport(s.DB.QueryRow("SELECT "+exportColumns+" FROM exports WHERE token=$1", token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return export, ErrExportNotFound
+	}
+	return export, err
+}
+
+func (s *ExportServiceImpl) DueExports(now time.Time, limit int) ([]models.Export, error) {
+	// As with push deliveries, the lease hides a claimed export from other
+	// workers and retries it if this one dies while building
+	return s.queryExports(`WITH due AS (
+			SELECT id FROM exports WHERE status=$1 AND next_attempt_at <= $2
+			ORDER BY next_attempt_at LIMIT $3 FOR UPDATE SKIP LOCKED
+		)
+		UPDATE exports e SET next_attempt_at=$4, attempts=e.attempts+1
+		FROM due WHERE e.id=due.id
+		RETURNING e.id, e.user_id, e.status, e.token, e.storage_key, e.attempts, e.created_at, e.ready_at, e.expires_at`,
+		models.ExportStatusPending, now, limit, now.Add(exportLease))
+}
+
+func (s *ExportServiceImpl) MarkExportReady(exportID, storageKey string, readyAt, expiresAt time.Time) error {
+	_, err := s.DB.Exec("UPDATE exports SET status=$1, storage_key=$2, ready_at=$3, expires_at=$4 WHERE id=$5 AND status=$6",
+		models.ExportStatusReady, storageKey, readyAt, expiresAt, exportID, models.ExportStatusPending)
+	return err
+}
+
+func (s *ExportServiceImpl) MarkExportFailed(exportID string) error {
+	_, err := s.DB.Exec("UPDATE exports SET status=$1 WHERE id=$2 AND status=$3",
+		models.ExportStatusFailed, exportID, models.ExportStatusPending)
+	return err
+}
+
+func (s *ExportServiceImpl) ExpiredExports(now time.Time, limit int) ([]models.Export, error) {
+	return s.queryExports("SELECT "+exportColumns+" FROM exports WHERE status=$1 AND expires_at <= $2 ORDER BY expires_at LIMIT $3",
+		models.ExportStatusReady, now, limit)
+}
+
+func (s *ExportServiceImpl) MarkExportExpired(exportID string) error {
+	_, err := s.DB.Exec("UPDATE exports SET status=$1, storage_key='' WHERE id=$2", models.ExportStatusExpired, exportID)
+	return err
+}
+
+func (s *ExportServiceImpl) Swipes(userID string) ([]models.SwipeRecord, error) {
+	rows, err := s.DB.Query("SELECT target_id, action, created_at FROM swipes WHERE user_id=$1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	swipes := []models.SwipeRecord{}
+	for rows.Next() {
+		var swipe models.SwipeRecord
+		if err := rows.Scan(&swipe.TargetID, &swipe.Action, &swipe.CreatedAt); err != nil {
+			return nil, err
+		}
+		swipes = append(swipes, swipe)
+	}
+	return swipes, rows.Err()
+}
+
+func (s *ExportServiceImpl) Matches(userID string) ([]models.Match, error) {
+	rows, err := s.DB.Query("SELECT "+matchColumns+" FROM matches WHERE $1 IN (user_a, user_b) ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []models.Match{}
+	for rows.Next() {
+		match, err := scanMatch(rows)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, match)
+	}
+	return matches, rows.Err()
+}
+
+func (s *ExportServiceImpl) Messages(userID string) ([]models.Message, error) {
+	// Hidden messages the user never received are left out, as in the chat
+	rows, err := s.DB.Query(`SELECT msg.id, msg.match_id, msg.sender_id, m.user_a, m.user_b, msg.body, msg.created_at, msg.delivered_at, msg.read_at
+		FROM messages msg JOIN matches m ON m.id=msg.match_id
+		WHERE $1 IN (m.user_a, m.user_b) AND (NOT msg.hidden OR msg.sender_id=$1)
+		ORDER BY msg.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		var message models.Message
+		var userA, userB string
+		var deliveredAt, readAt sql.NullTime
+		if err := rows.Scan(&message.ID, &message.MatchID, &message.SenderID, &userA, &userB, &message.Body, &message.CreatedAt, &deliveredAt, &readAt); err != nil {
+			return nil, err
+		}
+		if message.Body, err = s.Cipher.Decrypt(message.Body); err != nil {
+			return nil, err
+		}
+		if deliveredAt.Valid {
+			message.DeliveredAt = &deliveredAt.Time
+		}
+		if readAt.Valid {
+			message.ReadAt = &readAt.Time
+		}
+		message.RecipientID = models.Match{UserIDs: []string{userA, userB}}.OtherUser(message.SenderID)
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
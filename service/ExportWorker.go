@@ -0,0 +1,215 @@
+package service
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"dating-app/models"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"time"
+)
+
+// exportBatchSize bounds how many exports one run of the worker builds;
+// archives are large, so few are built at a time
+const exportBatchSize = 5
+
+// photoExtensions names the photos in an archive after their detected format
+var photoExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+// ExportWorker periodically builds the requested data exports, stores them
+// in the blob store and notifies their users, and deletes the archives whose
+// download link has expired
+type ExportWorker struct {
+	Exports       ExportService
+	Profiles      ProfileService
+	Photos        PhotoService
+	Purchases     PurchaseService
+	Notifications NotificationService
+	Blobs         BlobStore
+	Interval      time.Duration
+}
+
+// Run builds and expires exports every Interval until ctx is done
+func (w *ExportWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.RunOnce(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce builds the exports due as of now and deletes the expired ones
+func (w *ExportWorker) RunOnce(now time.Time) {
+	due, err := w.Exports.DueExports(now, exportBatchSize)
+	if err != nil {
+		log.Printf("export worker: listing exports: %v", err)
+	}
+	for _, export := range due {
+		if err := w.build(export, now); err != nil {
+			log.Printf("export worker: building %s: %v", export.ID, err)
+			if export.Attempts >= maxExportAttempts {
+				if err := w.Exports.MarkExportFailed(export.ID); err != nil {
+					log.Printf("export worker: building %s: %v", export.ID, err)
+				}
+			}
+		}
+	}
+
+	expired, err := w.Exports.ExpiredExports(now, exportBatchSize*10)
+	if err != nil {
+		log.Printf("export worker: listing expired exports: %v", err)
+	}
+	for _, export := range expired {
+		if err := w.Blobs.Delete(export.StorageKey); err != nil {
+			log.Printf("export worker: expiring %s: %v", export.ID, err)
+			continue
+		}
+		if err := w.Exports.MarkExportExpired(export.ID); err != nil {
+			log.Printf("export worker: expiring %s: %v", export.ID, err)
+		}
+	}
+}
+
+// build stores the archive of an export and tells the user it is ready
+func (w *ExportWorker) build(export models.Export, now time.Time) error {
+	var archive bytes.Buffer
+	if err := w.writeArchive(&archive, export.UserID); err != nil {
+		return err
+	}
+	storageKey := ExportKeyPrefix + randomHex(16) + ".zip"
+	if err := w.Blobs.Put(storageKey, "application/zip", &archive); err != nil {
+		return err
+	}
+
+	expiresAt := now.Add(ExportLinkTTL)
+	if err := w.Exports.MarkExportReady(export.ID, storageKey, now, expiresAt); err != nil {
+		w.Blobs.Delete(storageKey)
+		return err
+	}
+
+	err := w.Notifications.Notify(export.UserID, models.Notification{
+		Type:  models.NotificationAccount,
+		Title: "Your data is ready",
+		Body:  "Download a copy of your data before " + expiresAt.UTC().Format("January 2, 2006") + ".",
+		Data:  map[string]string{"exportID": export.ID},
+	}, now)
+	if err != nil {
+		log.Printf("export worker: notifying %s of export %s: %v", export.UserID, export.ID, err)
+	}
+	return nil
+}
+
+// writeArchive writes a ZIP of the user's data: a JSON file for each kind of
+// record and the photos in their largest size
+func (w *ExportWorker) writeArchive(out io.Writer, userID string) error {
+	archive := zip.NewWriter(out)
+
+	profile, err := w.Profiles.GetProfile(userID)
+	if err != nil && !errors.Is(err, ErrProfileNotFound) {
+		return err
+	}
+	var profileData interface{}
+	if err == nil {
+		profileData = profile
+	}
+	photos, err := w.Photos.ListPhotos(userID)
+	if err != nil {
+		return err
+	}
+	swipes, err := w.Exports.Swipes(userID)
+	if err != nil {
+		return err
+	}
+	matches, err := w.Exports.Matches(userID)
+	if err != nil {
+		return err
+	}
+	messages, err := w.Exports.Messages(userID)
+	if err != nil {
+		return err
+	}
+	purchases, err := w.Purchases.ListPurchases(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", profileData},
+		{"photos.json", photos},
+		{"swipes.json", swipes},
+		{"matches.json", matches},
+		{"messages.json", messages},
+		{"purchases.json", purchases},
+	} {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return err
+		}
+	}
+
+	for _, photo := range photos {
+		key := PhotoBlobKey(photo.StorageKey, models.PhotoSizeLarge)
+		err := copyPhoto(archive, "photos/"+photo.ID, w.Blobs, key)
+		if errors.Is(err, ErrBlobNotFound) {
+			// Retrying won't bring the file back, so the rest is still exported
+			log.Printf("export worker: photo %s of %s has no file at %s, skipped", photo.ID, userID, key)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// copyPhoto adds a photo to the archive under name, with the extension of the
+// format it is stored in
+func copyPhoto(archive *zip.Writer, name string, blobs BlobStore, key string) error {
+	blob, err := blobs.Get(key)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	reader := bufio.NewReaderSize(blob, 512)
+	head, err := reader.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	extension, ok := photoExtensions[http.DetectContentType(head)]
+	if !ok {
+		extension = path.Ext(key)
+	}
+
+	f, err := archive.Create(name + extension)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, reader)
+	return err
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"dating-app/models"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// FCMAPIURL is the Firebase Cloud Messaging API host
+const FCMAPIURL = "https://fcm.googleapis.com"
+
+// FCMPushProvider sends notifications through the Firebase Cloud Messaging
+// HTTP v1 API, authorized as a service account of the Firebase project
+type FCMPushProvider struct {
+	BaseURL    string
+	ProjectID  string
+	Tokens     AccessTokenSource
+	HTTPClient *http.Client
+}
+
+func (p *FCMPushProvider) Send(ctx context.Context, token string, notification models.Notification) error {
+	data := map[string]string{"type": notification.Type}
+	for key, value := range notification.Data {
+		data[key] = value
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token":        token,
+			"notification": map[string]string{"title": notification.Title, "body": notification.Body},
+			"data":         data,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	accessToken, err := p.Tokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+
+	endpoint := p.BaseURL + "/v1/projects/" + url.PathEscape(p.ProjectID) + "/messages:send"
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	var failure struct {
+		Error struct {
+			Status  string `json:"status"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&failure)
+	code := failure.Error.Status
+	for _, detail := range failure.Error.Details {
+		if detail.ErrorCode != "" {
+			code = detail.ErrorCode
+		}
+	}
+
+	switch {
+	case code == "UNREGISTERED" || resp.StatusCode == http.StatusNotFound:
+		return ErrDeviceUnregistered
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("fcm: status %d %s", resp.StatusCode, code)
+	default:
+		return fmt.Errorf("%w: fcm status %d %s", ErrPushRejected, resp.StatusCode, code)
+	}
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"dating-app/models"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// FakePaymentGateway is an in-process PaymentGateway for tests and local
+// development. Intents live in memory; when a payment completes, a signed
+// event is POSTed to WebhookURL just like a real gateway would.
+type FakePaymentGateway struct {
+	WebhookURL string
+	Secret     []byte
+	// DeclineCaptures makes every Capture fail, to exercise failure paths
+	DeclineCaptures bool
+	// Unavailable makes CreateIntent fail as if the gateway were down
+	Unavailable bool
+
+	mu      sync.Mutex
+	intents map[string]*fakeIntent
+}
+
+type fakeIntent struct {
+	intent   models.PaymentIntent
+	metadata map[string]string
+	refunded int64
+}
+
+func (g *FakePaymentGateway) CreateIntent(amount int64, currency string, metadata map[string]string) (models.PaymentIntent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.Unavailable {
+		return models.PaymentIntent{}, fmt.Errorf("payment gateway unavailable")
+	}
+	if g.intents == nil {
+		g.intents = make(map[string]*fakeIntent)
+	}
+
+	id := "pi_" + randomHex(12)
+	intent := models.PaymentIntent{
+		ID:           id,
+		ClientSecret: id + "_secret_" + randomHex(12),
+		Amount:       amount,
+		Currency:     currency,
+		Status:       models.PaymentStatusRequiresPayment,
+	}
+	g.intents[id] = &fakeIntent{intent: intent, metadata: metadata}
+	return intent, nil
+}
+
+// Confirm simulates the customer completing the payment in the app
+func (g *FakePaymentGateway) Confirm(intentID string) error {
+	return g.complete(intentID, false)
+}
+
+// Capture charges the customer without their interaction, e.g. for renewals
+func (g *FakePaymentGateway) Capture(intentID string) error {
+	return g.complete(intentID, g.DeclineCaptures)
+}
+
+func (g *FakePaymentGateway) complete(intentID string, decline bool) error {
+	g.mu.Lock()
+	stored, ok := g.intents[intentID]
+	if !ok {
+		g.mu.Unlock()
+		return ErrPaymentIntentNotFound
+	}
+	if stored.intent.Status != models.PaymentStatusRequiresPayment {
+		g.mu.Unlock()
+		return fmt.Errorf("payment intent %s is %s", intentID, stored.intent.Status)
+	}
+
+	eventType := models.PaymentEventSucceeded
+	stored.intent.Status = models.PaymentStatusSucceeded
+	if decline {
+		eventType = models.PaymentEventFailed
+		stored.intent.Status = models.PaymentStatusFailed
+	}
+	intent := stored.intent
+	g.mu.Unlock()
+
+	g.notify(models.PaymentEvent{ID: "evt_" + randomHex(12), Type: eventType, IntentID: intent.ID, Amount: intent.Amount, Currency: intent.Currency})
+	if decline {
+		return ErrPaymentDeclined
+	}
+	return nil
+}
+
+func (g *FakePaymentGateway) Refund(intentID string, amount int64) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	stored, ok := g.intents[intentID]
+	if !ok {
+		return "", ErrPaymentIntentNotFound
+	}
+	if stored.intent.Status != models.PaymentStatusSucceeded && stored.intent.Status != models.PaymentStatusRefunded {
+		return "", fmt.Errorf("payment intent %s is %s", intentID, stored.intent.Status)
+	}
+	if stored.refunded+amount > stored.intent.Amount {
+		return "", fmt.Errorf("refund exceeds captured amount")
+	}
+	stored.refunded += amount
+	if stored.refunded == stored.intent.Amount {
+		stored.intent.Status = models.PaymentStatusRefunded
+	}
+	intent := stored.intent
+
+	refundID := "re_" + randomHex(12)
+	g.notify(models.PaymentEvent{ID: "evt_" + randomHex(12), Type: models.PaymentEventRefunded, IntentID: intent.ID, RefundID: refundID, Amount: amount, Currency: intent.Currency})
+	return refundID, nil
+}
+
+// notify delivers an event to the webhook in the background, as gateways do
+func (g *FakePaymentGateway) notify(event models.PaymentEvent) {
+	if g.WebhookURL == "" {
+		return
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("fake payment gateway: %v", err)
+		return
+	}
+	go func() {
+		req, err := http.NewRequest("POST", g.WebhookURL, bytes.NewReader(payload))
+		if err != nil {
+			log.Printf("fake payment gateway: %v", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Payment-Signature", SignWebhookPayload(g.Secret, payload, time.Now()))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Printf("fake payment gateway: delivering %s: %v", event.Type, err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"dating-app/models"
+	"math"
+	"sort"
+	"time"
+)
+
+// FeedService interface
+type FeedService interface {
+	// Feed returns up to limit profiles the user hasn't swiped on, best first
+	Feed(userID string, limit int, now time.Time) ([]models.FeedProfile, error)
+	// Likes returns up to limit people who swiped right on the user and whom
+	// the user hasn't swiped on yet, most recent first
+	Likes(userID string, limit int) ([]models.Like, error)
+}
+
+// VisibleTo reports whether a user with the given visibility can be shown to
+// a viewer, given whether the user has swiped right on that viewer
+func VisibleTo(visibility string, likedViewer bool) bool {
+	switch visibility {
+	case models.VisibilityEveryone:
+		return true
+	case models.VisibilityLikedOnly:
+		return likedViewer
+	default:
+		return false
+	}
+}
+
+// Ranking weights. A boost outweighs every other feature, so boosted profiles
+// always come first.
+const (
+	boostWeight     = 1000.0
+	activityWeight  = 10.0
+	sharedTagWeight = 2.0
+)
+
+// ScoreProfile combines the ranking features of a feed profile into a score;
+// higher scores are shown first
+func ScoreProfile(profile models.FeedProfile, now time.Time) float64 {
+	score := 0.0
+	if profile.BoostedUntil != nil && profile.BoostedUntil.After(now) {
+		score += boostWeight
+	}
+
+	// Recently active users are more likely to swipe back
+	days := math.Max(now.Sub(profile.LastActive).Hours()/24, 0)
+	score += activityWeight / (1 + days)
+
+	// Common interests give people something to talk about
+	score += sharedTagWeight * float64(profile.SharedTags)
+	return score
+}
+
+// RankFeed orders profiles by score, best first
+func RankFeed(profiles []models.FeedProfile, now time.Time) {
+	scores := make(map[string]float64, len(profiles))
+	for _, profile := range profiles {
+		scores[profile.UserID] = ScoreProfile(profile, now)
+	}
+	sort.SliceStable(profiles, func(i, j int) bool {
+		return scores[profiles[i].UserID] > scores[profiles[j].UserID]
+	})
+}
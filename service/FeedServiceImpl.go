@@ -0,0 +1,90 @@
+package service
+
+import (
+	"database/sql"
+	"dating-app/models"
+	"time"
+)
+
+// FeedServiceImpl struct implementing FeedService
+type FeedServiceImpl struct {
+	DB *sql.DB
+}
+
+// feedPoolFactor is how many more candidates than requested are loaded, so
+// ranking has something to choose from
+const feedPoolFactor = 5
+
+// visibleToViewer is the SQL form of VisibleTo for a user u and the viewer $1.
+// Shadow-banned users, accounts about to be deleted and accounts Restriction
+// keeps out are never shown.
+const visibleToViewer = `NOT u.shadow_banned AND u.deletion_scheduled_at IS NULL
+	AND u.status <> 'banned' AND (u.status <> 'suspended' OR u.suspended_until IS NULL OR u.suspended_until <= NOW())
+	AND (u.visibility = 'everyone' OR (u.visibility = 'liked_only'
+	AND EXISTS (SELECT 1 FROM swipes WHERE user_id=u.id AND target_id=$1 AND action='right')))`
+
+func (s *FeedServiceImpl) Feed(userID string, limit int, now time.Time) ([]models.FeedProfile, error) {
+	// Boosted profiles are loaded first so they are never cut off by the pool limit
+	rows, err := s.DB.Query(`SELECT u.id, u.username, u.last_swipe, b.ends_at,
+			(SELECT COUNT(*) FROM unnest(p.tags) AS t(tag) WHERE t.tag = ANY (COALESCE((SELECT tags FROM profiles WHERE user_id=$1), '{}'))) AS shared_tags
+		FROM users u
+		LEFT JOIN profiles p ON p.user_id=u.id
+		LEFT JOIN LATERAL (SELECT MAX(ends_at) AS ends_at FROM boosts WHERE user_id=u.id AND ends_at > $2) b ON true
+		WHERE u.id <> $1 AND NOT EXISTS (SELECT 1 FROM swipes WHERE user_id=$1 AND target_id=u.id)
+			AND `+visibleToViewer+` AND `+notBlocked("$1", "u.id")+`
+		ORDER BY b.ends_at IS NULL, u.last_swipe DESC NULLS LAST
+		LIMIT $3`, userID, now, limit*feedPoolFactor)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := []models.FeedProfile{}
+	for rows.Next() {
+		var profile models.FeedProfile
+		var lastActive, boostedUntil sql.NullTime
+		if err := rows.Scan(&profile.UserID, &profile.Username, &lastActive, &boostedUntil, &profile.SharedTags); err != nil {
+			return nil, err
+		}
+		profile.LastActive = lastActive.Time
+		if boostedUntil.Valid {
+			profile.BoostedUntil = &boostedUntil.Time
+		}
+		profiles = append(profiles, profile)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	RankFeed(profiles, now)
+	if len(profiles) > limit {
+		profiles = profiles[:limit]
+	}
+	return profiles, nil
+}
+
+func (s *FeedServiceImpl) Likes(userID string, limit int) ([]models.Like, error) {
+	rows, err := s.DB.Query(`SELECT u.id, u.username, MAX(l.created_at) AS liked_at
+		FROM swipes l
+		JOIN users u ON u.id=l.user_id
+		WHERE l.target_id=$1 AND l.action='right'
+			AND NOT EXISTS (SELECT 1 FROM swipes WHERE user_id=$1 AND target_id=u.id)
+			AND `+visibleToViewer+` AND `+notBlocked("$1", "u.id")+`
+		GROUP BY u.id, u.username
+		ORDER BY liked_at DESC
+		LIMIT $2`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	likes := []models.Like{}
+	for rows.Next() {
+		var like models.Like
+		if err := rows.Scan(&like.UserID, &like.Username, &like.LikedAt); err != nil {
+			return nil, err
+		}
+		likes = append(likes, like)
+	}
+	return likes, rows.Err()
+}
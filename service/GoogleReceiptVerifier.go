@@ -0,0 +1,318 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/subtle"
+	"dating-app/models"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// GooglePlayAPIURL is the Google Play Developer API host
+const GooglePlayAPIURL = "https://androidpublisher.googleapis.com"
+
+// AccessTokenSource provides OAuth 2.0 access tokens for Google APIs
+type AccessTokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// GoogleReceiptVerifier verifies Google Play purchase tokens with the Google
+// Play Developer API and acknowledges them, as Play refunds purchases that
+// are not acknowledged within three days. Real-time developer notifications
+// arrive as Pub/Sub push messages to an endpoint whose URL carries
+// PushToken as the "token" query parameter.
+type GoogleReceiptVerifier struct {
+	BaseURL     string
+	PackageName string
+	Tokens      AccessTokenSource
+	PushToken   string
+	HTTPClient  *http.Client
+}
+
+type googleSubscriptionPurchase struct {
+	SubscriptionState    string `json:"subscriptionState"`
+	LatestOrderID        string `json:"latestOrderId"`
+	StartTime            string `json:"startTime"`
+	AcknowledgementState string `json:"acknowledgementState"`
+	LineItems            []struct {
+		ProductID        string `json:"productId"`
+		ExpiryTime       string `json:"expiryTime"`
+		AutoRenewingPlan *struct {
+			AutoRenewEnabled bool `json:"autoRenewEnabled"`
+		} `json:"autoRenewingPlan"`
+	} `json:"lineItems"`
+}
+
+type googleProductPurchase struct {
+	PurchaseTimeMillis   string `json:"purchaseTimeMillis"`
+	PurchaseState        int    `json:"purchaseState"`
+	OrderID              string `json:"orderId"`
+	AcknowledgementState int    `json:"acknowledgementState"`
+}
+
+type googleDeveloperNotification struct {
+	PackageName              string `json:"packageName"`
+	SubscriptionNotification *struct {
+		NotificationType int    `json:"notificationType"`
+		PurchaseToken    string `json:"purchaseToken"`
+		SubscriptionID   string `json:"subscriptionId"`
+	} `json:"subscriptionNotification"`
+	VoidedPurchaseNotification *struct {
+		PurchaseToken string `json:"purchaseToken"`
+		OrderID       string `json:"orderId"`
+	} `json:"voidedPurchaseNotification"`
+}
+
+// Google Play subscription notification types
+const (
+	googleSubscriptionRecovered = 1
+	googleSubscriptionRenewed   = 2
+	googleSubscriptionCanceled  = 3
+	googleSubscriptionPurchased = 4
+	googleSubscriptionOnHold    = 5
+	googleSubscriptionInGrace   = 6
+	googleSubscriptionRestarted = 7
+	googleSubscriptionRevoked   = 12
+	googleSubscriptionExpired   = 13
+)
+
+func (v *GoogleReceiptVerifier) Verify(ctx context.Context, productID, receipt string, subscription bool) (models.StoreTransaction, error) {
+	transaction := models.StoreTransaction{Store: models.StoreGoogle, OriginalTransactionID: receipt, ProductID: productID}
+	base := v.BaseURL + "/androidpublisher/v3/applications/" + url.PathEscape(v.PackageName) + "/purchases/"
+
+	if subscription {
+		var purchase googleSubscriptionPurchase
+		if err := v.call(ctx, "GET", base+"subscriptionsv2/tokens/"+url.PathEscape(receipt), &purchase); err != nil {
+			return transaction, err
+		}
+		if len(purchase.LineItems) == 0 || purchase.LineItems[0].ProductID != productID || purchase.SubscriptionState == "SUBSCRIPTION_STATE_PENDING" {
+			return transaction, ErrReceiptInvalid
+		}
+
+		item := purchase.LineItems[0]
+		transaction.TransactionID = purchase.LatestOrderID
+		transaction.PurchasedAt, _ = time.Parse(time.RFC3339, purchase.StartTime)
+		if expiresAt, err := time.Parse(time.RFC3339, item.ExpiryTime); err == nil {
+			transaction.ExpiresAt = &expiresAt
+		}
+		transaction.AutoRenew = item.AutoRenewingPlan != nil && item.AutoRenewingPlan.AutoRenewEnabled
+
+		if purchase.AcknowledgementState == "ACKNOWLEDGEMENT_STATE_PENDING" {
+			if err := v.call(ctx, "POST", base+"subscriptions/"+url.PathEscape(productID)+"/tokens/"+url.PathEscape(receipt)+":acknowledge", nil); err != nil {
+				return transaction, err
+			}
+		}
+		return transaction, nil
+	}
+
+	var purchase googleProductPurchase
+	productURL := base + "products/" + url.PathEscape(productID) + "/tokens/" + url.PathEscape(receipt)
+	if err := v.call(ctx, "GET", productURL, &purchase); err != nil {
+		return transaction, err
+	}
+	// 0 is purchased; 1 canceled and 2 pending are not paid for
+	if purchase.PurchaseState != 0 {
+		return transaction, ErrReceiptInvalid
+	}
+
+	transaction.TransactionID = purchase.OrderID
+	if millis, err := strconv.ParseInt(purchase.PurchaseTimeMillis, 10, 64); err == nil {
+		transaction.PurchasedAt = time.UnixMilli(millis)
+	}
+
+	if purchase.AcknowledgementState == 0 {
+		if err := v.call(ctx, "POST", productURL+":acknowledge", nil); err != nil {
+			return transaction, err
+		}
+	}
+	return transaction, nil
+}
+
+func (v *GoogleReceiptVerifier) ParseNotification(ctx context.Context, r *http.Request) (models.StoreNotification, error) {
+	var result models.StoreNotification
+
+	if v.PushToken == "" || subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(v.PushToken)) != 1 {
+		return result, ErrInvalidNotification
+	}
+
+	var push struct {
+		Message struct {
+			Data []byte `json:"data"`
+		} `json:"message"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxNotificationSize)).Decode(&push); err != nil {
+		return result, ErrInvalidNotification
+	}
+
+	var notification googleDeveloperNotification
+	if err := json.Unmarshal(push.Message.Data, &notification); err != nil || notification.PackageName != v.PackageName {
+		return result, ErrInvalidNotification
+	}
+
+	if voided := notification.VoidedPurchaseNotification; voided != nil {
+		result.Type = models.StoreNotificationRefunded
+		result.Transaction = models.StoreTransaction{
+			Store:                 models.StoreGoogle,
+			TransactionID:         voided.OrderID,
+			OriginalTransactionID: voided.PurchaseToken,
+			Revoked:               true,
+		}
+		return result, nil
+	}
+
+	sub := notification.SubscriptionNotification
+	if sub == nil {
+		result.Type = models.StoreNotificationIgnored
+		return result, nil
+	}
+
+	// Notifications only carry the purchase token; the current state of the
+	// subscription has to be fetched from the API
+	transaction, err := v.Verify(ctx, sub.SubscriptionID, sub.PurchaseToken, true)
+	if err != nil {
+		return result, err
+	}
+	result.Transaction = transaction
+
+	switch sub.NotificationType {
+	case googleSubscriptionPurchased, googleSubscriptionRenewed, googleSubscriptionRecovered:
+		result.Type = models.StoreNotificationRenewed
+	case googleSubscriptionOnHold, googleSubscriptionInGrace:
+		result.Type = models.StoreNotificationRenewalFailed
+		result.GraceUntil = transaction.ExpiresAt
+	case googleSubscriptionCanceled, googleSubscriptionRestarted:
+		result.Type = models.StoreNotificationAutoRenewChanged
+	case googleSubscriptionExpired:
+		result.Type = models.StoreNotificationExpired
+	case googleSubscriptionRevoked:
+		result.Type = models.StoreNotificationRefunded
+		result.Transaction.Revoked = true
+	default:
+		result.Type = models.StoreNotificationIgnored
+	}
+	return result, nil
+}
+
+// call performs an authorized Play Developer API request and decodes the
+// JSON response into out, if given
+func (v *GoogleReceiptVerifier) call(ctx context.Context, method, endpoint string, out interface{}) error {
+	token, err := v.Tokens.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrStoreRequestFailed, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := v.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrStoreRequestFailed, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusGone:
+		return ErrReceiptInvalid
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent:
+		return fmt.Errorf("%w: status %d", ErrStoreRequestFailed, resp.StatusCode)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxNotificationSize)).Decode(out); err != nil {
+		return fmt.Errorf("%w: %v", ErrStoreRequestFailed, err)
+	}
+	return nil
+}
+
+// GoogleServiceAccountTokens is an AccessTokenSource that exchanges a
+// service account's signed JWT for an access token (the OAuth 2.0 JWT bearer
+// grant) and caches it until shortly before it expires
+type GoogleServiceAccountTokens struct {
+	ClientEmail string
+	PrivateKey  *rsa.PrivateKey
+	TokenURL    string
+	Scope       string
+	HTTPClient  *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func (s *GoogleServiceAccountTokens) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Before(s.expiresAt) {
+		return s.token, nil
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   s.ClientEmail,
+		"scope": s.Scope,
+		"aud":   s.TokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(s.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.TokenURL, bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := s.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("token request failed: %d %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+
+	s.token = token.AccessToken
+	s.expiresAt = now.Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return s.token, nil
+}
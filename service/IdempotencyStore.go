@@ -0,0 +1,21 @@
+package service
+
+import (
+	"dating-app/models"
+	"time"
+)
+
+// IdempotencyKeyTTL is how long a key is remembered; after that it can be reused
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// IdempotencyStore remembers requests made with an Idempotency-Key so that
+// retries can be answered with the original response
+type IdempotencyStore interface {
+	// Begin claims a key within a scope. If the key is already taken the
+	// stored record is returned with claimed set to false.
+	Begin(scope, key, fingerprint string) (record models.IdempotencyRecord, claimed bool, err error)
+	// Complete stores the response of a claimed key
+	Complete(scope, key string, statusCode int, contentType string, body []byte) error
+	// Release forgets a claimed key so the request can be retried
+	Release(scope, key string) error
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"database/sql"
+	"dating-app/models"
+	"time"
+)
+
+// IdempotencyStoreImpl struct implementing IdempotencyStore
+type IdempotencyStoreImpl struct {
+	DB *sql.DB
+}
+
+func (s *IdempotencyStoreImpl) Begin(scope, key, fingerprint string) (models.IdempotencyRecord, bool, error) {
+	record := models.IdempotencyRecord{Scope: scope, Key: key, Fingerprint: fingerprint}
+
+	// Expired keys may be reused
+	_, err := s.DB.Exec("DELETE FROM idempotency_keys WHERE scope=$1 AND key=$2 AND created_at < $3", scope, key, time.Now().Add(-IdempotencyKeyTTL))
+	if err != nil {
+		return record, false, err
+	}
+
+	res, err := s.DB.Exec("INSERT INTO idempotency_keys (scope, key, fingerprint) VALUES ($1, $2, $3) ON CONFLICT (scope, key) DO NOTHING", scope, key, fingerprint)
+	if err != nil {
+		return record, false, err
+	}
+	if claimed, err := res.RowsAffected(); err != nil || claimed == 1 {
+		return record, err == nil, err
+	}
+
+	var statusCode sql.NullInt64
+	var contentType sql.NullString
+	err = s.DB.QueryRow("SELECT fingerprint, status_code, content_type, response_body, created_at FROM idempotency_keys WHERE scope=$1 AND key=$2", scope, key).
+		Scan(&record.Fingerprint, &statusCode, &contentType, &record.Body, &record.CreatedAt)
+	record.StatusCode = int(statusCode.Int64)
+	record.ContentType = contentType.String
+	return record, false, err
+}
+
+func (s *IdempotencyStoreImpl) Complete(scope, key string, statusCode int, contentType string, body []byte) error {
+	_, err := s.DB.Exec("UPDATE idempotency_keys SET status_code=$1, content_type=$2, response_body=$3 WHERE scope=$4 AND key=$5", statusCode, contentType, body, scope, key)
+	return err
+}
+
+func (s *IdempotencyStoreImpl) Release(scope, key string) error {
+	_, err := s.DB.Exec("DELETE FROM idempotency_keys WHERE scope=$1 AND key=$2", scope, key)
+	return err
+}
@@ -0,0 +1,16 @@
+package service
+
+import "errors"
+
+var ErrUnknownKeyVersion = errors.New("unknown key version")
+
+// KeyProvider holds the key-encryption keys that wrap the per-value data keys
+// of encrypted columns. Keys are versioned: new values are wrapped with the
+// current version and older versions stay available for decryption until
+// every value has been re-encrypted, so a KMS-backed provider can replace the
+// local keyfile without changing the stored format.
+type KeyProvider interface {
+	CurrentVersion() int
+	WrapKey(version int, dataKey []byte) ([]byte, error)
+	UnwrapKey(version int, wrapped []byte) ([]byte, error)
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// rotationBatchSize bounds how many values one transaction re-encrypts
+const rotationBatchSize = 500
+
+// KeyRotationWorker periodically re-encrypts the values of EncryptedColumns
+// that are still in plaintext or wrapped with a retired key. Once a pass
+// finds nothing left, older key versions can be removed from the keyfile.
+type KeyRotationWorker struct {
+	Encryption EncryptionService
+	Interval   time.Duration
+}
+
+// Run re-encrypts stale values every Interval until ctx is done
+func (w *KeyRotationWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.RunOnce()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce re-encrypts every stale value, one batch at a time
+func (w *KeyRotationWorker) RunOnce() {
+	for _, column := range EncryptedColumns {
+		for {
+			rewritten, err := w.Encryption.Reencrypt(column, rotationBatchSize)
+			if err != nil {
+				log.Printf("key rotation worker: %s.%s: %v", column.Table, column.Column, err)
+				break
+			}
+			if rewritten < rotationBatchSize {
+				break
+			}
+		}
+	}
+}
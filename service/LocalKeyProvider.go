@@ -0,0 +1,106 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// LocalKeyProvider is a KeyProvider reading its keys from a JSON keyfile:
+//
+//	{"current": 2, "keys": {"1": "<base64>", "2": "<base64>"}}
+//
+// Keys are 32 random bytes. Rotating means adding a version, making it
+// current and keeping the old ones until the rotation worker is done.
+type LocalKeyProvider struct {
+	current int
+	keys    map[int]cipher.AEAD
+}
+
+type keyfile struct {
+	Current int               `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// LoadLocalKeyProvider reads and checks the keyfile at path
+func LoadLocalKeyProvider(path string) (*LocalKeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keyfile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("keyfile %s: %w", path, err)
+	}
+
+	provider := &LocalKeyProvider{current: file.Current, keys: map[int]cipher.AEAD{}}
+	for name, encoded := range file.Keys {
+		version, err := strconv.Atoi(name)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("keyfile %s: key version %q must be a positive number", path, name)
+		}
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("keyfile %s: key %d: %w", path, version, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if provider.keys[version], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := provider.keys[file.Current]; !ok {
+		return nil, fmt.Errorf("keyfile %s: current key %d is missing", path, file.Current)
+	}
+	return provider, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+func (p *LocalKeyProvider) CurrentVersion() int {
+	return p.current
+}
+
+func (p *LocalKeyProvider) WrapKey(version int, dataKey []byte) ([]byte, error) {
+	aead, ok := p.keys[version]
+	if !ok {
+		return nil, ErrUnknownKeyVersion
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+func (p *LocalKeyProvider) UnwrapKey(version int, wrapped []byte) ([]byte, error) {
+	aead, ok := p.keys[version]
+	if !ok {
+		return nil, ErrUnknownKeyVersion
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return dataKey, nil
+}
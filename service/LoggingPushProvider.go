@@ -0,0 +1,18 @@
+package service
+
+import (
+	"context"
+	"dating-app/models"
+	"log"
+)
+
+// LoggingPushProvider logs notifications instead of sending them, for
+// development and for platforms without credentials
+type LoggingPushProvider struct {
+	Platform string
+}
+
+func (p *LoggingPushProvider) Send(ctx context.Context, token string, notification models.Notification) error {
+	log.Printf("push (%s) to %s: %s: %s %v", p.Platform, token, notification.Title, notification.Body, notification.Data)
+	return nil
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"dating-app/models"
+	"log"
+	"sync"
+)
+
+// subscriptionBuffer is how many events a subscriber can fall behind before
+// its events are dropped
+const subscriptionBuffer = 32
+
+// MemoryBroker is a Broker for a single server
+type MemoryBroker struct {
+	mu            sync.Mutex
+	subscriptions map[string]map[chan models.Event]struct{}
+}
+
+// NewMemoryBroker creates an empty MemoryBroker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{subscriptions: make(map[string]map[chan models.Event]struct{})}
+}
+
+func (b *MemoryBroker) Publish(userID string, event models.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for events := range b.subscriptions[userID] {
+		select {
+		case events <- event:
+		default:
+			log.Printf("dropped %s event for %s: subscriber is behind", event.Type, userID)
+		}
+	}
+}
+
+func (b *MemoryBroker) Subscribe(userID string) (<-chan models.Event, func()) {
+	events := make(chan models.Event, subscriptionBuffer)
+
+	b.mu.Lock()
+	if b.subscriptions[userID] == nil {
+		b.subscriptions[userID] = make(map[chan models.Event]struct{})
+	}
+	b.subscriptions[userID][events] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscriptions[userID], events)
+			if len(b.subscriptions[userID]) == 0 {
+				delete(b.subscriptions, userID)
+			}
+			close(events)
+		})
+	}
+	return events, unsubscribe
+}
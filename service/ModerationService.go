@@ -0,0 +1,88 @@
+package service
+
+import (
+	"dating-app/models"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrReportNotFound          = errors.New("report not found")
+	ErrReportResolved          = errors.New("report has already been resolved")
+	ErrInvalidModerationAction = errors.New("invalid moderation action")
+	ErrInvalidRole             = errors.New("invalid role")
+)
+
+// MaxSuspensionDays bounds how long a suspension can last; longer ones should
+// be bans
+const MaxSuspensionDays = 365
+
+// ModerationService interface
+type ModerationService interface {
+	// ListReports returns up to limit reports with the given status, oldest
+	// first
+	ListReports(status string, limit int) ([]models.Report, error)
+	GetReport(reportID string) (models.Report, error)
+	// ReportsAgainst returns every report filed about a user, newest first
+	ReportsAgainst(userID string) ([]models.Report, error)
+	// MessagesBetween returns up to limit of the latest messages between two
+	// users, newest first, including those of ended or blocked matches
+	MessagesBetween(userID, otherID string, limit int) ([]models.Message, error)
+	// TakeAction resolves an open report with a moderator's action and applies
+	// it to the reported user; suspendDays is only used for suspensions. A
+	// warning, suspension or ban resolves every open report about the user,
+	// while a dismissal only resolves this one. The action is audited in the
+	// same transaction.
+	TakeAction(reportID, moderator, action, reason string, suspendDays int, now time.Time) (models.Report, error)
+	// SetRoles replaces a user's staff roles and signs the user out, so the
+	// new roles take effect at their next login. The change is audited as
+	// actor in the same transaction.
+	SetRoles(userID string, roles []string, actor string) error
+	// SetShadowBanned shadow-bans a user or lifts it, auditing the change as
+	// actor in the same transaction. A shadow-banned user can go on swiping
+	// and messaging, but is left out of feeds and likes, makes no matches and
+	// their messages are hidden from the recipient.
+	SetShadowBanned(userID string, shadowBanned bool, actor, reason string) error
+}
+
+// HasRole reports whether roles grant role. Admins hold every role.
+func HasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role || r == models.RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateRoles checks a set of staff roles
+func ValidateRoles(roles []string) error {
+	for _, role := range roles {
+		if role != models.RoleModerator && role != models.RoleAdmin {
+			return fmt.Errorf("%w: roles must be moderator or admin", ErrInvalidRole)
+		}
+	}
+	return nil
+}
+
+// ValidateModerationAction checks a moderator's action on a report. Every
+// action but a dismissal needs a reason, which is recorded and shown to the
+// user, and a suspension needs a length of 1 to MaxSuspensionDays days.
+func ValidateModerationAction(action, reason string, suspendDays int) error {
+	switch action {
+	case models.ModerationDismiss:
+		return nil
+	case models.ModerationWarn, models.ModerationBan:
+	case models.ModerationSuspend:
+		if suspendDays < 1 || suspendDays > MaxSuspensionDays {
+			return fmt.Errorf("%w: suspendDays must be between 1 and %d", ErrInvalidModerationAction, MaxSuspensionDays)
+		}
+	default:
+		return fmt.Errorf("%w: action must be warn, suspend, ban or dismiss", ErrInvalidModerationAction)
+	}
+	if reason == "" {
+		return fmt.Errorf("%w: a reason is required", ErrInvalidModerationAction)
+	}
+	return nil
+}
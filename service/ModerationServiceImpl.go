@@ -0,0 +1,244 @@
+package service
+
+import (
+	"database/sql"
+	"dating-app/models"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ModerationServiceImpl struct implementing ModerationService
+type ModerationServiceImpl struct {
+	DB     *sql.DB
+	Cipher *FieldCipher
+}
+
+const reportColumns = "id, COALESCE(reporter_id::text, ''), reported_id, reason, details, status, created_at, resolution, resolved_by, resolved_at"
+
+func scanReport(row rowScanner) (models.Report, error) {
+	var report models.Report
+	var resolvedAt sql.NullTime
+	err := row.Scan(&report.ID, &report.ReporterID, &report.ReportedID, &report.Reason, &report.Details, &report.Status,
+		&report.CreatedAt, &report.Resolution, &report.ResolvedBy, &resolvedAt)
+	if resolvedAt.Valid {
+		report.ResolvedAt = &resolvedAt.Time
+	}
+	return report, err
+}
+
+func (s *ModerationServiceImpl) queryReports(query string, args ...interface{}) ([]models.Report, error) {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []models.Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+func (s *ModerationServiceImpl) ListReports(status string, limit int) ([]models.Report, error) {
+	return s.queryReports("SELECT "+reportColumns+" FROM reports WHERE status=$1 ORDER BY created_at, id LIMIT $2", status, limit)
+}
+
+func (s *ModerationServiceImpl) GetReport(reportID string) (models.Report, error) {
+	report, err := scanReport(s.DB.QueryRow("SELECT "+reportColumns+" FROM reports WHERE id=$1", reportID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return report, ErrReportNotFound
+	}
+	return report, err
+}
+
+func (s *ModerationServiceImpl) ReportsAgainst(userID string) ([]models.Report, error) {
+	return s.queryReports("SELECT "+reportColumns+" FROM reports WHERE reported_id=$1 ORDER BY created_at DESC, id DESC", userID)
+}
+
+func (s *ModerationServiceImpl) MessagesBetween(userID, otherID string, limit int) ([]models.Message, error) {
+	userA, userB := MatchPair(userID, otherID)
+	rows, err := s.DB.Query(`SELECT msg.id, msg.match_id, msg.sender_id, msg.body, msg.created_at, msg.delivered_at, msg.read_at
+		FROM messages msg JOIN matches m ON m.id=msg.match_id
+		WHERE m.user_a=$1 AND m.user_b=$2
+		ORDER BY msg.id DESC LIMIT $3`, userA, userB, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		var message models.Message
+		var deliveredAt, readAt sql.NullTime
+		if err := rows.Scan(&message.ID, &message.MatchID, &message.SenderID, &message.Body, &message.CreatedAt, &deliveredAt, &readAt); err != nil {
+			return nil, err
+		}
+		if message.Body, err = s.Cipher.Decrypt(message.Body); err != nil {
+			return nil, err
+		}
+		if deliveredAt.Valid {
+			message.DeliveredAt = &deliveredAt.Time
+		}
+		if readAt.Valid {
+			message.ReadAt = &readAt.Time
+		}
+		message.RecipientID = userA
+		if message.SenderID == userA {
+			message.RecipientID = userB
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+func (s *ModerationServiceImpl) TakeAction(reportID, moderator, action, reason string, suspendDays int, now time.Time) (models.Report, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.Report{}, err
+	}
+	defer tx.Rollback()
+
+	// Lock the report so two moderators can't resolve it at once
+	report, err := scanReport(tx.QueryRow("SELECT "+reportColumns+" FROM reports WHERE id=$1 FOR UPDATE", reportID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return report, ErrReportNotFound
+	}
+	if err != nil {
+		return report, err
+	}
+	if report.Status != models.ReportStatusOpen {
+		return report, ErrReportResolved
+	}
+
+	// Suspending or banning also signs the user out everywhere by bumping the
+	// version their tokens must carry
+	suspendedUntil := now.AddDate(0, 0, suspendDays)
+	switch action {
+	case models.ModerationSuspend:
+		// A suspension never lifts a ban or shortens a longer suspension
+		_, err = tx.Exec(`UPDATE users SET status=$1, suspended_until=GREATEST(suspended_until, $2), token_version=token_version+1
+			WHERE id=$3 AND status <> $4`, models.AccountStatusSuspended, suspendedUntil, report.ReportedID, models.AccountStatusBanned)
+	case models.ModerationBan:
+		_, err = tx.Exec("UPDATE users SET status=$1, suspended_until=NULL, token_version=token_version+1 WHERE id=$2",
+			models.AccountStatusBanned, report.ReportedID)
+	}
+	if err != nil {
+		return report, err
+	}
+
+	status := models.ReportStatusActioned
+	if action == models.ModerationDismiss {
+		status = models.ReportStatusDismissed
+	}
+	report, err = scanReport(tx.QueryRow(`UPDATE reports SET status=$1, resolution=$2, resolved_by=$3, resolved_at=$4
+		WHERE id=$5 RETURNING `+reportColumns, status, action, moderator, now, reportID))
+	if err != nil {
+		return report, err
+	}
+	if action != models.ModerationDismiss {
+		_, err = tx.Exec(`UPDATE reports SET status=$1, resolution=$2, resolved_by=$3, resolved_at=$4
+			WHERE reported_id=$5 AND status=$6`, status, action, moderator, now, report.ReportedID, models.ReportStatusOpen)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	entry := models.AuditEntry{
+		Actor:      moderator,
+		TargetType: "user",
+		TargetID:   report.ReportedID,
+		Details:    map[string]string{"reportID": report.ID, "reason": reason},
+	}
+	switch action {
+	case models.ModerationWarn:
+		entry.Action = models.AuditActionUserWarned
+	case models.ModerationSuspend:
+		entry.Action = models.AuditActionUserSuspended
+		entry.Details["suspendedUntil"] = suspendedUntil.UTC().Format(time.RFC3339)
+		entry.Details["suspendDays"] = strconv.Itoa(suspendDays)
+	case models.ModerationBan:
+		entry.Action = models.AuditActionUserBanned
+	case models.ModerationDismiss:
+		entry.Action = models.AuditActionReportDismissed
+		entry.TargetType = "report"
+		entry.TargetID = report.ID
+		entry.Details = map[string]string{"userID": report.ReportedID}
+		if reason != "" {
+			entry.Details["reason"] = reason
+		}
+	}
+	if err := recordAudit(tx, entry); err != nil {
+		return report, err
+	}
+	return report, tx.Commit()
+}
+
+func (s *ModerationServiceImpl) SetRoles(userID string, roles []string, actor string) error {
+	if err := ValidateRoles(roles); err != nil {
+		return err
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+	encoded, err := json.Marshal(roles)
+	if err != nil {
+		return err
+	}
+
+	// Bumping the token version signs the user out, so they log in again
+	// with tokens carrying the new roles
+	return s.updateUser(models.AuditEntry{
+		Actor:      actor,
+		Action:     models.AuditActionUserRolesChanged,
+		TargetType: "user",
+		TargetID:   userID,
+		Details:    map[string]string{"roles": string(encoded)},
+	}, "UPDATE users SET roles=$1, token_version=token_version+1 WHERE id=$2", pq.Array(roles), userID)
+}
+
+func (s *ModerationServiceImpl) SetShadowBanned(userID string, shadowBanned bool, actor, reason string) error {
+	action := models.AuditActionUserShadowBanLifted
+	if shadowBanned {
+		action = models.AuditActionUserShadowBanned
+	}
+	return s.updateUser(models.AuditEntry{
+		Actor:      actor,
+		Action:     action,
+		TargetType: "user",
+		TargetID:   userID,
+		Details:    map[string]string{"reason": reason},
+	}, "UPDATE users SET shadow_banned=$1 WHERE id=$2", shadowBanned, userID)
+}
+
+// updateUser runs an update of a single user and writes its audit entry in
+// one transaction, failing with ErrUserNotFound if there is no such user
+func (s *ModerationServiceImpl) updateUser(entry models.AuditEntry, query string, args ...interface{}) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if updated, err := res.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return ErrUserNotFound
+	}
+	if err := recordAudit(tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"dating-app/models"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrInvalidDevice  = errors.New("invalid device")
+	ErrDeviceNotFound = errors.New("device not found")
+)
+
+const (
+	// MaxPushAttempts is how often a notification is tried before it is
+	// given up on
+	MaxPushAttempts = 5
+	// pushRetryDelay is the wait before the first retry; it doubles with
+	// every further attempt
+	pushRetryDelay = 30 * time.Second
+	// pushLease is how long a claimed delivery is left to its worker
+	pushLease = 2 * time.Minute
+	// maxDeviceTokenLength is well above what APNs and FCM hand out
+	maxDeviceTokenLength = 4096
+)
+
+// NotificationService interface
+type NotificationService interface {
+	// RegisterDevice adds a device to the user. A token registered before,
+	// possibly by another user of the same phone, moves to this user.
+	RegisterDevice(userID, platform, token string, now time.Time) (models.Device, error)
+	UnregisterDevice(userID, deviceID string) error
+	GetPreferences(userID string) (models.NotificationPreferences, error)
+	SetPreferences(userID string, preferences models.NotificationPreferences) error
+	// Notify queues a notification for each of the user's devices, unless the
+	// user turned its type off
+	Notify(userID string, notification models.Notification, now time.Time) error
+	// DuePushes claims up to limit pending deliveries whose next attempt is due
+	DuePushes(now time.Time, limit int) ([]models.PushDelivery, error)
+	// MarkPushed records a delivery attempt. A failed attempt is retried at
+	// retryAt, or given up on when retryAt is nil.
+	MarkPushed(deliveryID string, err error, retryAt *time.Time) error
+	// RemoveDevice forgets a device whose token the provider no longer accepts
+	RemoveDevice(deviceID string) error
+}
+
+// ValidateDevice checks a device registration
+func ValidateDevice(platform, token string) error {
+	if platform != models.PlatformIOS && platform != models.PlatformAndroid {
+		return fmt.Errorf("%w: platform must be ios or android", ErrInvalidDevice)
+	}
+	if token == "" || len(token) > maxDeviceTokenLength {
+		return fmt.Errorf("%w: token is required", ErrInvalidDevice)
+	}
+	return nil
+}
+
+// Wants reports whether the preferences allow a notification type
+func Wants(preferences models.NotificationPreferences, notificationType string) bool {
+	switch notificationType {
+	case models.NotificationMatch:
+		return preferences.Matches
+	case models.NotificationMessage:
+		return preferences.Messages
+	default:
+		return true
+	}
+}
+
+// NextPushAttempt is when a delivery that has failed attempts times should be
+// retried, or nil when it should be given up on
+func NextPushAttempt(attempts int, now time.Time) *time.Time {
+	if attempts >= MaxPushAttempts {
+		return nil
+	}
+	next := now.Add(pushRetryDelay << (attempts - 1))
+	return &next
+}
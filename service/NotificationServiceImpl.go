@@ -0,0 +1,136 @@
+package service
+
+import (
+	"database/sql"
+	"dating-app/models"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// NotificationServiceImpl struct implementing NotificationService. Notifications
+// are queued in push_deliveries and sent by a NotificationWorker.
+type NotificationServiceImpl struct {
+	DB *sql.DB
+}
+
+func (s *NotificationServiceImpl) RegisterDevice(userID, platform, token string, now time.Time) (models.Device, error) {
+	device := models.Device{UserID: userID, Platform: platform, Token: token}
+	if err := ValidateDevice(platform, token); err != nil {
+		return device, err
+	}
+
+	err := s.DB.QueryRow(`INSERT INTO devices (user_id, platform, token, created_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (token) DO UPDATE SET user_id=EXCLUDED.user_id, platform=EXCLUDED.platform
+		RETURNING id, created_at`, userID, platform, token, now).Scan(&device.ID, &device.CreatedAt)
+	return device, err
+}
+
+func (s *NotificationServiceImpl) UnregisterDevice(userID, deviceID string) error {
+	result, err := s.DB.Exec("DELETE FROM devices WHERE id=$1 AND user_id=$2", deviceID, userID)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrDeviceNotFound
+	}
+	return nil
+}
+
+func (s *NotificationServiceImpl) GetPreferences(userID string) (models.NotificationPreferences, error) {
+	// Everything is on until the user says otherwise
+	preferences := models.NotificationPreferences{Matches: true, Messages: true}
+	err := s.DB.QueryRow("SELECT matches, messages FROM notification_preferences WHERE user_id=$1", userID).
+		Scan(&preferences.Matches, &preferences.Messages)
+	if errors.Is(err, sql.ErrNoRows) {
+		return preferences, nil
+	}
+	return preferences, err
+}
+
+func (s *NotificationServiceImpl) SetPreferences(userID string, preferences models.NotificationPreferences) error {
+	_, err := s.DB.Exec(`INSERT INTO notification_preferences (user_id, matches, messages) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET matches=EXCLUDED.matches, messages=EXCLUDED.messages`,
+		userID, preferences.Matches, preferences.Messages)
+	return err
+}
+
+func (s *NotificationServiceImpl) Notify(userID string, notification models.Notification, now time.Time) error {
+	preferences, err := s.GetPreferences(userID)
+	if err != nil {
+		return err
+	}
+	if !Wants(preferences, notification.Type) {
+		return nil
+	}
+
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`INSERT INTO push_deliveries (device_id, payload, status, attempts, next_attempt_at, created_at)
+		SELECT id, $2, $3, 0, $4, $4 FROM devices WHERE user_id=$1`,
+		userID, payload, models.PushStatusPending, now)
+	return err
+}
+
+func (s *NotificationServiceImpl) DuePushes(now time.Time, limit int) ([]models.PushDelivery, error) {
+	// Claimed deliveries are pushed back by the lease, so other workers skip
+	// them and they are retried if this one dies while sending
+	rows, err := s.DB.Query(`WITH due AS (
+			SELECT id FROM push_deliveries WHERE status=$1 AND next_attempt_at <= $2
+			ORDER BY next_attempt_at LIMIT $3 FOR UPDATE SKIP LOCKED
+		)
+		UPDATE push_deliveries p SET next_attempt_at=$4
+		FROM due, devices d
+		WHERE p.id=due.id AND d.id=p.device_id
+		RETURNING p.id, p.device_id, d.platform, d.token, p.payload, p.attempts`,
+		models.PushStatusPending, now, limit, now.Add(pushLease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.PushDelivery{}
+	for rows.Next() {
+		var delivery models.PushDelivery
+		var payload []byte
+		if err := rows.Scan(&delivery.ID, &delivery.DeviceID, &delivery.Platform, &delivery.Token, &payload, &delivery.Attempts); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payload, &delivery.Notification); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *NotificationServiceImpl) MarkPushed(deliveryID string, pushErr error, retryAt *time.Time) error {
+	if pushErr == nil {
+		_, err := s.DB.Exec("UPDATE push_deliveries SET status=$1, attempts=attempts+1, last_error=NULL WHERE id=$2",
+			models.PushStatusDelivered, deliveryID)
+		return err
+	}
+
+	status := models.PushStatusPending
+	nextAttempt := sql.NullTime{}
+	if retryAt != nil {
+		nextAttempt = sql.NullTime{Time: *retryAt, Valid: true}
+	} else {
+		status = models.PushStatusFailed
+	}
+	_, err := s.DB.Exec("UPDATE push_deliveries SET status=$1, attempts=attempts+1, last_error=$2, next_attempt_at=COALESCE($3, next_attempt_at) WHERE id=$4",
+		status, pushErr.Error(), nextAttempt, deliveryID)
+	return err
+}
+
+func (s *NotificationServiceImpl) RemoveDevice(deviceID string) error {
+	// Its pending deliveries go with it (ON DELETE CASCADE)
+	_, err := s.DB.Exec("DELETE FROM devices WHERE id=$1", deviceID)
+	return err
+}
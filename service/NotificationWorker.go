@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// pushBatchSize bounds how many deliveries one run of the worker attempts
+const pushBatchSize = 100
+
+// NotificationWorker periodically sends queued push notifications through the
+// provider for each device's platform, retrying failures with backoff
+type NotificationWorker struct {
+	Notifications NotificationService
+	Providers     map[string]PushProvider
+	Interval      time.Duration
+}
+
+// Run sends due notifications every Interval until ctx is done
+func (w *NotificationWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.RunOnce(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce attempts the deliveries due as of now
+func (w *NotificationWorker) RunOnce(ctx context.Context, now time.Time) {
+	due, err := w.Notifications.DuePushes(now, pushBatchSize)
+	if err != nil {
+		log.Printf("notification worker: listing deliveries: %v", err)
+		return
+	}
+
+	for _, delivery := range due {
+		provider, ok := w.Providers[delivery.Platform]
+		if !ok {
+			log.Printf("notification worker: no push provider for %s", delivery.Platform)
+			continue
+		}
+
+		sendErr := provider.Send(ctx, delivery.Token, delivery.Notification)
+		if errors.Is(sendErr, ErrDeviceUnregistered) {
+			if err := w.Notifications.RemoveDevice(delivery.DeviceID); err != nil {
+				log.Printf("notification worker: removing device %s: %v", delivery.DeviceID, err)
+			}
+			continue
+		}
+
+		var retryAt *time.Time
+		if sendErr != nil && !errors.Is(sendErr, ErrPushRejected) {
+			retryAt = NextPushAttempt(delivery.Attempts+1, now)
+		}
+		if sendErr != nil {
+			log.Printf("notification worker: delivery %s: %v", delivery.ID, sendErr)
+		}
+		if err := w.Notifications.MarkPushed(delivery.ID, sendErr, retryAt); err != nil {
+			log.Printf("notification worker: delivery %s: %v", delivery.ID, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"dating-app/models"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrPaymentIntentNotFound = errors.New("payment intent not found")
+	ErrPaymentDeclined       = errors.New("payment declined")
+	ErrInvalidSignature      = errors.New("invalid webhook signature")
+)
+
+// WebhookSignatureTolerance is how old a signed webhook may be before it is
+// rejected as a possible replay
+const WebhookSignatureTolerance = 5 * time.Minute
+
+// PaymentGateway is the payment processor used to charge customers. Outcomes
+// are reported asynchronously through signed webhook events, never trusted
+// from the client.
+type PaymentGateway interface {
+	CreateIntent(amount int64, currency string, metadata map[string]string) (models.PaymentIntent, error)
+	Capture(intentID string) error
+	// Refund gives back amount of a captured payment and returns the
+	// gateway's ID for the refund, which its refund event carries too
+	Refund(intentID string, amount int64) (refundID string, err error)
+}
+
+// SignWebhookPayload returns the signature header value for a webhook payload
+// sent at the given time, in the form "t=<unix seconds>,v1=<hex HMAC-SHA256>".
+// The timestamp is part of the signed content so it cannot be altered.
+func SignWebhookPayload(secret, payload []byte, at time.Time) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, webhookMAC(secret, timestamp, payload))
+}
+
+// VerifyWebhookSignature checks a signature header produced by
+// SignWebhookPayload and rejects it if it is older than WebhookSignatureTolerance.
+func VerifyWebhookSignature(secret, payload []byte, header string, now time.Time) error {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+	if timestamp == "" || signature == "" {
+		return ErrInvalidSignature
+	}
+
+	sentAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(sentAt, 0)); age > WebhookSignatureTolerance || age < -WebhookSignatureTolerance {
+		return ErrInvalidSignature
+	}
+
+	expected := webhookMAC(secret, timestamp, payload)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func webhookMAC(secret []byte, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
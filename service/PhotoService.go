@@ -0,0 +1,241 @@
+package service
+
+import (
+	"bytes"
+	"dating-app/models"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrInvalidPhoto  = errors.New("invalid photo")
+	ErrPhotoNotFound = errors.New("photo not found")
+	ErrTooManyPhotos = errors.New("photo limit reached")
+)
+
+// Photo limits
+const (
+	MaxPhotoBytes  = 10 << 20
+	MaxPhotos      = 9
+	minPhotoEdge   = 320
+	maxPhotoPixels = 50_000_000
+	photoQuality   = 85
+)
+
+// photoSizes are the renditions stored for every photo. Photos are scaled down
+// to fit maxEdge but never up; the thumbnail is also cropped to a square.
+var photoSizes = []struct {
+	name    string
+	maxEdge int
+	square  bool
+}{
+	{models.PhotoSizeLarge, 1280, false},
+	{models.PhotoSizeMedium, 640, false},
+	{models.PhotoSizeThumbnail, 240, true},
+}
+
+// PhotoService interface
+type PhotoService interface {
+	// UploadPhoto processes an uploaded image and adds it after the user's
+	// other photos
+	UploadPhoto(userID string, data []byte) (models.Photo, error)
+	ListPhotos(userID string) ([]models.Photo, error)
+	// ReorderPhotos puts the user's photos in the given order, which must name
+	// each of them exactly once
+	ReorderPhotos(userID string, photoIDs []string) ([]models.Photo, error)
+	DeletePhoto(userID, photoID string) error
+}
+
+// ProcessedPhoto holds the JPEG renditions of an upload by size name
+type ProcessedPhoto struct {
+	Width  int
+	Height int
+	Sizes  map[string][]byte
+}
+
+// ProcessPhoto checks that an upload really is a JPEG, PNG or WebP image of a
+// reasonable size and renders it in every stored size. Re-encoding drops all
+// metadata, EXIF location included, so the orientation it records is applied
+// to the pixels first.
+func ProcessPhoto(data []byte) (ProcessedPhoto, error) {
+	contentType := http.DetectContentType(data)
+	if contentType != "image/jpeg" && contentType != "image/png" && contentType != "image/webp" {
+		return ProcessedPhoto{}, fmt.Errorf("%w: photos must be JPEG, PNG or WebP images", ErrInvalidPhoto)
+	}
+
+	// Check the dimensions before decoding so a small file can't claim a huge canvas
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return ProcessedPhoto{}, fmt.Errorf("%w: the image could not be read", ErrInvalidPhoto)
+	}
+	if config.Width < minPhotoEdge || config.Height < minPhotoEdge {
+		return ProcessedPhoto{}, fmt.Errorf("%w: photos must be at least %dx%d pixels", ErrInvalidPhoto, minPhotoEdge, minPhotoEdge)
+	}
+	if config.Width*config.Height > maxPhotoPixels {
+		return ProcessedPhoto{}, fmt.Errorf("%w: photos must be at most %d megapixels", ErrInvalidPhoto, maxPhotoPixels/1_000_000)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return ProcessedPhoto{}, fmt.Errorf("%w: the image could not be read", ErrInvalidPhoto)
+	}
+
+	orientation := 1
+	if contentType == "image/jpeg" {
+		orientation = jpegOrientation(data)
+	}
+
+	processed := ProcessedPhoto{Sizes: make(map[string][]byte, len(photoSizes))}
+	for _, size := range photoSizes {
+		rendition := orient(resize(src, size.maxEdge, size.square), orientation)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, rendition, &jpeg.Options{Quality: photoQuality}); err != nil {
+			return ProcessedPhoto{}, err
+		}
+		processed.Sizes[size.name] = buf.Bytes()
+		if size.name == models.PhotoSizeLarge {
+			processed.Width, processed.Height = rendition.Bounds().Dx(), rendition.Bounds().Dy()
+		}
+	}
+	return processed, nil
+}
+
+// PhotoBlobKey is where one size of a photo is kept in the BlobStore
+func PhotoBlobKey(storageKey, size string) string {
+	return storageKey + "/" + size + ".jpg"
+}
+
+// resize scales an image down to fit maxEdge, cropping it to a centered square
+// first if asked. Transparent areas are flattened onto white.
+func resize(src image.Image, maxEdge int, square bool) *image.RGBA {
+	bounds := src.Bounds()
+	if square {
+		side := min(bounds.Dx(), bounds.Dy())
+		x := bounds.Min.X + (bounds.Dx()-side)/2
+		y := bounds.Min.Y + (bounds.Dy()-side)/2
+		bounds = image.Rect(x, y, x+side, y+side)
+	}
+
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > maxEdge || height > maxEdge {
+		if width >= height {
+			width, height = maxEdge, max(1, height*maxEdge/width)
+		} else {
+			width, height = max(1, width*maxEdge/height), maxEdge
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+	return dst
+}
+
+// orient turns an image as described by an EXIF orientation (1-8) so that it
+// displays upright without the tag
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // rotated 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // rotated 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotated 90° counter-clockwise
+				sx, sy = w-1-y, x
+			}
+			dst.SetRGBA(x, y, src.RGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation of a JPEG, defaulting to 1
+// (upright) when it has none or its metadata is malformed
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		// Metadata segments all come before the start of the image data
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation finds the orientation tag in the first IFD of EXIF data
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int64(order.Uint32(tiff[4:]))
+	if offset+2 > int64(len(tiff)) {
+		return 1
+	}
+	entries := int64(order.Uint16(tiff[offset:]))
+	for n := int64(0); n < entries; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > int64(len(tiff)) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+	return 1
+}
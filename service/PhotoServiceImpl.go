@@ -0,0 +1,191 @@
+package service
+
+import (
+	"bytes"
+	"database/sql"
+	"dating-app/models"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// PhotoServiceImpl struct implementing PhotoService
+type PhotoServiceImpl struct {
+	DB    *sql.DB
+	Blobs BlobStore
+}
+
+const photoColumns = "id, user_id, position, width, height, storage_key, created_at"
+
+func (s *PhotoServiceImpl) scanPhoto(row rowScanner) (models.Photo, error) {
+	var photo models.Photo
+	err := row.Scan(&photo.ID, &photo.UserID, &photo.Position, &photo.Width, &photo.Height, &photo.StorageKey, &photo.CreatedAt)
+	if err != nil {
+		return photo, err
+	}
+	photo.URLs = make(map[string]string, len(photoSizes))
+	for _, size := range photoSizes {
+		photo.URLs[size.name] = s.Blobs.URL(PhotoBlobKey(photo.StorageKey, size.name))
+	}
+	return photo, nil
+}
+
+func (s *PhotoServiceImpl) UploadPhoto(userID string, data []byte) (models.Photo, error) {
+	processed, err := ProcessPhoto(data)
+	if err != nil {
+		return models.Photo{}, err
+	}
+
+	// Blobs are stored first so a saved photo never points at missing files
+	storageKey := "photos/" + randomHex(16)
+	for size, rendition := range processed.Sizes {
+		if err := s.Blobs.Put(PhotoBlobKey(storageKey, size), "image/jpeg", bytes.NewReader(rendition)); err != nil {
+			s.deleteBlobs(storageKey)
+			return models.Photo{}, err
+		}
+	}
+
+	photo, err := s.insertPhoto(userID, processed, storageKey)
+	if err != nil {
+		s.deleteBlobs(storageKey)
+	}
+	return photo, err
+}
+
+func (s *PhotoServiceImpl) insertPhoto(userID string, processed ProcessedPhoto, storageKey string) (models.Photo, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.Photo{}, err
+	}
+	defer tx.Rollback()
+
+	// Lock the user so concurrent uploads can't pass the limit or share a position
+	if _, err := tx.Exec("SELECT id FROM users WHERE id=$1 FOR UPDATE", userID); err != nil {
+		return models.Photo{}, err
+	}
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM photos WHERE user_id=$1", userID).Scan(&count); err != nil {
+		return models.Photo{}, err
+	}
+	if count >= MaxPhotos {
+		return models.Photo{}, ErrTooManyPhotos
+	}
+
+	photo, err := s.scanPhoto(tx.QueryRow("INSERT INTO photos (user_id, position, width, height, storage_key, created_at) VALUES ($1, $2, $3, $4, $5, NOW()) RETURNING "+photoColumns,
+		userID, count, processed.Width, processed.Height, storageKey))
+	if err != nil {
+		return photo, err
+	}
+	return photo, tx.Commit()
+}
+
+func (s *PhotoServiceImpl) ListPhotos(userID string) ([]models.Photo, error) {
+	rows, err := s.DB.Query("SELECT "+photoColumns+" FROM photos WHERE user_id=$1 ORDER BY position", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	photos := []models.Photo{}
+	for rows.Next() {
+		photo, err := s.scanPhoto(rows)
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, photo)
+	}
+	return photos, rows.Err()
+}
+
+func (s *PhotoServiceImpl) ReorderPhotos(userID string, photoIDs []string) ([]models.Photo, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT id FROM users WHERE id=$1 FOR UPDATE", userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query("SELECT id FROM photos WHERE user_id=$1", userID)
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		current[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(photoIDs) != len(current) {
+		return nil, fmt.Errorf("%w: the order must list each of your %d photos once", ErrInvalidPhoto, len(current))
+	}
+	for position, id := range photoIDs {
+		if !current[id] {
+			return nil, fmt.Errorf("%w: the order must list each of your %d photos once", ErrInvalidPhoto, len(current))
+		}
+		// Each ID can only match once, which also rejects repeated IDs
+		delete(current, id)
+		if _, err := tx.Exec("UPDATE photos SET position=$1 WHERE id=$2", position, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.ListPhotos(userID)
+}
+
+func (s *PhotoServiceImpl) DeletePhoto(userID, photoID string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT id FROM users WHERE id=$1 FOR UPDATE", userID); err != nil {
+		return err
+	}
+
+	var position int
+	var storageKey string
+	err = tx.QueryRow("DELETE FROM photos WHERE id=$1 AND user_id=$2 RETURNING position, storage_key", photoID, userID).Scan(&position, &storageKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrPhotoNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// Close the gap so positions stay 0..n-1
+	if _, err := tx.Exec("UPDATE photos SET position=position-1 WHERE user_id=$1 AND position > $2", userID, position); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.deleteBlobs(storageKey)
+	return nil
+}
+
+// deleteBlobs removes every size of a photo. Failures are only logged; an
+// orphaned blob is harmless since its random key is never handed out again.
+func (s *PhotoServiceImpl) deleteBlobs(storageKey string) {
+	for _, size := range photoSizes {
+		if err := s.Blobs.Delete(PhotoBlobKey(storageKey, size.name)); err != nil {
+			log.Printf("failed to delete blob %s: %v", PhotoBlobKey(storageKey, size.name), err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"dating-app/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidProfile  = errors.New("invalid profile")
+	ErrProfileNotFound = errors.New("profile not found")
+)
+
+// Profile limits
+const (
+	MinimumAge          = 18
+	maxAge              = 120
+	maxDisplayNameRunes = 50
+	maxBioRunes         = 500
+	maxWorkRunes        = 100
+	maxInterests        = 10
+	maxInterestRunes    = 30
+	maxPrompts          = 3
+	maxAnswerRunes      = 150
+	maxTags             = 10
+)
+
+// ProfileService interface
+type ProfileService interface {
+	GetProfile(userID string) (models.Profile, error)
+	// UpdateProfile validates and normalizes a profile, then saves it as the
+	// user's profile
+	UpdateProfile(userID string, profile models.Profile) (models.Profile, error)
+	// Catalog returns the prompts and tags profiles can use
+	Catalog() (models.ProfileCatalog, error)
+}
+
+var genders = map[string]bool{
+	models.GenderWoman:     true,
+	models.GenderMan:       true,
+	models.GenderNonBinary: true,
+	models.GenderOther:     true,
+}
+
+// NormalizeProfile trims free-text fields and drops empty or repeated
+// interests and tags
+func NormalizeProfile(profile models.Profile) models.Profile {
+	profile.DisplayName = strings.TrimSpace(profile.DisplayName)
+	profile.Bio = strings.TrimSpace(profile.Bio)
+	profile.Job = strings.TrimSpace(profile.Job)
+	profile.School = strings.TrimSpace(profile.School)
+
+	seen := map[string]bool{}
+	interests := []string{}
+	for _, interest := range profile.Interests {
+		interest = strings.TrimSpace(interest)
+		key := strings.ToLower(interest)
+		if interest == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		interests = append(interests, interest)
+	}
+	profile.Interests = interests
+
+	prompts := []models.PromptAnswer{}
+	for _, prompt := range profile.Prompts {
+		prompts = append(prompts, models.PromptAnswer{PromptID: prompt.PromptID, Answer: strings.TrimSpace(prompt.Answer)})
+	}
+	profile.Prompts = prompts
+
+	seen = map[string]bool{}
+	tags := []string{}
+	for _, tag := range profile.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	profile.Tags = tags
+	return profile
+}
+
+// ValidateProfile checks a normalized profile as of the given time
+func ValidateProfile(profile models.Profile, now time.Time) error {
+	if profile.DisplayName == "" || utf8.RuneCountInString(profile.DisplayName) > maxDisplayNameRunes {
+		return fmt.Errorf("%w: displayName must be 1-%d characters", ErrInvalidProfile, maxDisplayNameRunes)
+	}
+	if utf8.RuneCountInString(profile.Bio) > maxBioRunes {
+		return fmt.Errorf("%w: bio must be at most %d characters", ErrInvalidProfile, maxBioRunes)
+	}
+
+	birthdate, err := time.Parse("2006-01-02", profile.Birthdate)
+	if err != nil {
+		return fmt.Errorf("%w: birthdate must be a date in the form YYYY-MM-DD", ErrInvalidProfile)
+	}
+	age := Age(birthdate, now)
+	if age < MinimumAge {
+		return fmt.Errorf("%w: you must be at least %d years old", ErrInvalidProfile, MinimumAge)
+	}
+	if age > maxAge {
+		return fmt.Errorf("%w: birthdate is too far in the past", ErrInvalidProfile)
+	}
+
+	if !genders[profile.Gender] {
+		return fmt.Errorf("%w: gender must be woman, man, nonbinary or other", ErrInvalidProfile)
+	}
+	if utf8.RuneCountInString(profile.Job) > maxWorkRunes || utf8.RuneCountInString(profile.School) > maxWorkRunes {
+		return fmt.Errorf("%w: job and school must be at most %d characters", ErrInvalidProfile, maxWorkRunes)
+	}
+
+	if len(profile.Interests) > maxInterests {
+		return fmt.Errorf("%w: at most %d interests", ErrInvalidProfile, maxInterests)
+	}
+	for _, interest := range profile.Interests {
+		if utf8.RuneCountInString(interest) > maxInterestRunes {
+			return fmt.Errorf("%w: interests must be at most %d characters", ErrInvalidProfile, maxInterestRunes)
+		}
+	}
+
+	if len(profile.Prompts) > maxPrompts {
+		return fmt.Errorf("%w: at most %d prompts", ErrInvalidProfile, maxPrompts)
+	}
+	answered := map[string]bool{}
+	for _, prompt := range profile.Prompts {
+		if answered[prompt.PromptID] {
+			return fmt.Errorf("%w: each prompt can only be answered once", ErrInvalidProfile)
+		}
+		answered[prompt.PromptID] = true
+		if prompt.Answer == "" || utf8.RuneCountInString(prompt.Answer) > maxAnswerRunes {
+			return fmt.Errorf("%w: prompt answers must be 1-%d characters", ErrInvalidProfile, maxAnswerRunes)
+		}
+	}
+
+	if len(profile.Tags) > maxTags {
+		return fmt.Errorf("%w: at most %d tags", ErrInvalidProfile, maxTags)
+	}
+	return nil
+}
+
+// ValidateProfileCatalog checks that a profile only uses prompts and tags
+// from the catalog
+func ValidateProfileCatalog(profile models.Profile, catalog models.ProfileCatalog) error {
+	prompts := make(map[string]bool, len(catalog.Prompts))
+	for _, prompt := range catalog.Prompts {
+		prompts[prompt.ID] = true
+	}
+	for _, prompt := range profile.Prompts {
+		if !prompts[prompt.PromptID] {
+			return fmt.Errorf("%w: unknown prompt %q", ErrInvalidProfile, prompt.PromptID)
+		}
+	}
+
+	tags := make(map[string]bool, len(catalog.Tags))
+	for _, tag := range catalog.Tags {
+		tags[tag.ID] = true
+	}
+	for _, tag := range profile.Tags {
+		if !tags[tag] {
+			return fmt.Errorf("%w: unknown tag %q", ErrInvalidProfile, tag)
+		}
+	}
+	return nil
+}
+
+// Age returns the age in whole years of someone born on birthdate
+func Age(birthdate, now time.Time) int {
+	age := now.Year() - birthdate.Year()
+	if now.Month() < birthdate.Month() || (now.Month() == birthdate.Month() && now.Day() < birthdate.Day()) {
+		age--
+	}
+	return age
+}
+
+// PublicView returns the part of a profile other users may see, replacing the
+// birthdate with the age
+func PublicView(profile models.Profile, now time.Time) models.PublicProfile {
+	public := models.PublicProfile{
+		UserID:      profile.UserID,
+		DisplayName: profile.DisplayName,
+		Bio:         profile.Bio,
+		Gender:      profile.Gender,
+		Job:         profile.Job,
+		School:      profile.School,
+		Interests:   profile.Interests,
+		Prompts:     profile.Prompts,
+		Tags:        profile.Tags,
+	}
+	if birthdate, err := time.Parse("2006-01-02", profile.Birthdate); err == nil {
+		public.Age = Age(birthdate, now)
+	}
+	return public
+}
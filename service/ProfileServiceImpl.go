@@ -0,0 +1,42 @@
+package service
+
+import (
+	"dating-app/models"
+	"dating-app/repository"
+	"errors"
+	"time"
+)
+
+// ProfileServiceImpl struct implementing ProfileService
+type ProfileServiceImpl struct {
+	Repository repository.ProfileRepository
+}
+
+func (s *ProfileServiceImpl) GetProfile(userID string) (models.Profile, error) {
+	profile, err := s.Repository.GetUserProfile(userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return profile, ErrProfileNotFound
+	}
+	return profile, err
+}
+
+func (s *ProfileServiceImpl) UpdateProfile(userID string, profile models.Profile) (models.Profile, error) {
+	profile = NormalizeProfile(profile)
+	profile.UserID = userID
+	if err := ValidateProfile(profile, time.Now()); err != nil {
+		return profile, err
+	}
+
+	catalog, err := s.Repository.GetProfileCatalog()
+	if err != nil {
+		return profile, err
+	}
+	if err := ValidateProfileCatalog(profile, catalog); err != nil {
+		return profile, err
+	}
+	return s.Repository.UpdateUserProfile(profile)
+}
+
+func (s *ProfileServiceImpl) Catalog() (models.ProfileCatalog, error) {
+	return s.Repository.GetProfileCatalog()
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"dating-app/models"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidPromoCode       = errors.New("invalid promo code")
+	ErrPromoCodeExists        = errors.New("promo code already exists")
+	ErrPromoCodeNotFound      = errors.New("promo code not found")
+	ErrPromoCodeNotActive     = errors.New("promo code is not valid at this time")
+	ErrPromoCodeExhausted     = errors.New("promo code has no redemptions left")
+	ErrPromoCodeNotApplicable = errors.New("promo code does not apply to this purchase")
+)
+
+// PromoService interface
+type PromoService interface {
+	CreatePromoCode(promo models.PromoCode) (models.PromoCode, error)
+	ListPromoCodes() ([]models.PromoCode, error)
+	GetPromoCode(code string) (models.PromoCode, error)
+	// Redeem checks and records one use of a code by a user under a lock, so
+	// usage limits hold under concurrent redemptions. purchase is the order a
+	// discount code is applied to and nil for gift codes.
+	Redeem(code, userID string, purchase *models.Purchase, now time.Time) (models.PromoCode, error)
+	// ReleaseRedemption gives back the use of a code applied to an order that
+	// was never paid
+	ReleaseRedemption(purchaseID string) error
+}
+
+var promoCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{4,32}$`)
+
+// NormalizePromoCode makes codes case-insensitive for users typing them in
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// ValidatePromoCode checks a new promo code before it is stored
+func ValidatePromoCode(promo models.PromoCode) error {
+	if !promoCodePattern.MatchString(promo.Code) {
+		return fmt.Errorf("%w: code must be 4-32 letters, digits, '-' or '_'", ErrInvalidPromoCode)
+	}
+
+	switch promo.Effect {
+	case models.PromoEffectPercentOff:
+		if promo.Value < 1 || promo.Value > 100 {
+			return fmt.Errorf("%w: percentage must be between 1 and 100", ErrInvalidPromoCode)
+		}
+	case models.PromoEffectFreePremiumDays:
+		if promo.Value < 1 || promo.Value > 365 {
+			return fmt.Errorf("%w: days must be between 1 and 365", ErrInvalidPromoCode)
+		}
+		if promo.SKU == "" {
+			return fmt.Errorf("%w: sku of the premium product is required", ErrInvalidPromoCode)
+		}
+	case models.PromoEffectExtraSwipes:
+		if promo.Value < 1 || promo.Value > 1000 {
+			return fmt.Errorf("%w: swipes must be between 1 and 1000", ErrInvalidPromoCode)
+		}
+	default:
+		return fmt.Errorf("%w: unknown effect %q", ErrInvalidPromoCode, promo.Effect)
+	}
+
+	if promo.MaxRedemptions < 0 || promo.MaxPerUser < 1 {
+		return fmt.Errorf("%w: usage limits must be positive", ErrInvalidPromoCode)
+	}
+	if !promo.ValidUntil.After(promo.ValidFrom) {
+		return fmt.Errorf("%w: validUntil must be after validFrom", ErrInvalidPromoCode)
+	}
+	return nil
+}
+
+// CheckPromoCode reports whether a code can be used right now, either as a
+// discount on the product sku or, when sku is empty, as a gift
+func CheckPromoCode(promo models.PromoCode, sku string, now time.Time) error {
+	if now.Before(promo.ValidFrom) || !now.Before(promo.ValidUntil) {
+		return ErrPromoCodeNotActive
+	}
+	if promo.MaxRedemptions > 0 && promo.Redemptions >= promo.MaxRedemptions {
+		return ErrPromoCodeExhausted
+	}
+
+	discount := promo.Effect == models.PromoEffectPercentOff
+	if discount != (sku != "") {
+		return ErrPromoCodeNotApplicable
+	}
+	if discount && promo.SKU != "" && promo.SKU != sku {
+		return ErrPromoCodeNotApplicable
+	}
+	return nil
+}
+
+// DiscountedPrice applies a promo code to a price in minor units, rounding
+// the discount down so we never undercharge by a fraction of a cent
+func DiscountedPrice(price int64, promo *models.PromoCode) int64 {
+	if promo == nil || promo.Effect != models.PromoEffectPercentOff {
+		return price
+	}
+	return price - price*int64(promo.Value)/100
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"database/sql"
+	"dating-app/models"
+	"errors"
+	"time"
+)
+
+// PromoServiceImpl struct implementing PromoService
+type PromoServiceImpl struct {
+	DB *sql.DB
+}
+
+const promoCodeColumns = "code, campaign, effect, value, COALESCE(sku, ''), max_redemptions, max_per_user, redemptions, valid_from, valid_until, created_at"
+
+func scanPromoCode(row rowScanner) (models.PromoCode, error) {
+	var promo models.PromoCode
+	err := row.Scan(&promo.Code, &promo.Campaign, &promo.Effect, &promo.Value, &promo.SKU, &promo.MaxRedemptions,
+		&promo.MaxPerUser, &promo.Redemptions, &promo.ValidFrom, &promo.ValidUntil, &promo.CreatedAt)
+	return promo, err
+}
+
+func (s *PromoServiceImpl) CreatePromoCode(promo models.PromoCode) (models.PromoCode, error) {
+	var sku interface{}
+	if promo.SKU != "" {
+		sku = promo.SKU
+	}
+
+	created, err := scanPromoCode(s.DB.QueryRow("INSERT INTO promo_codes (code, campaign, effect, value, sku, max_redemptions, max_per_user, valid_from, valid_until) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT (code) DO NOTHING RETURNING "+promoCodeColumns,
+		NormalizePromoCode(promo.Code), promo.Campaign, promo.Effect, promo.Value, sku, promo.MaxRedemptions, promo.MaxPerUser, promo.ValidFrom, promo.ValidUntil))
+	if errors.Is(err, sql.ErrNoRows) {
+		return created, ErrPromoCodeExists
+	}
+	return created, err
+}
+
+func (s *PromoServiceImpl) ListPromoCodes() ([]models.PromoCode, error) {
+	rows, err := s.DB.Query("SELECT " + promoCodeColumns + " FROM promo_codes ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	promos := []models.PromoCode{}
+	for rows.Next() {
+		promo, err := scanPromoCode(rows)
+		if err != nil {
+			return nil, err
+		}
+		promos = append(promos, promo)
+	}
+	return promos, rows.Err()
+}
+
+func (s *PromoServiceImpl) GetPromoCode(code string) (models.PromoCode, error) {
+	promo, err := scanPromoCode(s.DB.QueryRow("SELECT "+promoCodeColumns+" FROM promo_codes WHERE code=$1", NormalizePromoCode(code)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return promo, ErrPromoCodeNotFound
+	}
+	return promo, err
+}
+
+func (s *PromoServiceImpl) Redeem(code, userID string, purchase *models.Purchase, now time.Time) (models.PromoCode, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.PromoCode{}, err
+	}
+	defer tx.Rollback()
+
+	promo, err := scanPromoCode(tx.QueryRow("SELECT "+promoCodeColumns+" FROM promo_codes WHERE code=$1 FOR UPDATE", NormalizePromoCode(code)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return promo, ErrPromoCodeNotFound
+	}
+	if err != nil {
+		return promo, err
+	}
+
+	var sku string
+	var purchaseID interface{}
+	if purchase != nil {
+		sku, purchaseID = purchase.SKU, purchase.ID
+	}
+	if err := CheckPromoCode(promo, sku, now); err != nil {
+		return promo, err
+	}
+
+	var used int
+	err = tx.QueryRow("SELECT COUNT(*) FROM promo_redemptions WHERE code=$1 AND user_id=$2", promo.Code, userID).Scan(&used)
+	if err != nil {
+		return promo, err
+	}
+	if used >= promo.MaxPerUser {
+		return promo, ErrPromoCodeExhausted
+	}
+
+	_, err = tx.Exec("INSERT INTO promo_redemptions (code, user_id, purchase_id, created_at) VALUES ($1, $2, $3, $4)", promo.Code, userID, purchaseID, now)
+	if err != nil {
+		return promo, err
+	}
+
+	_, err = tx.Exec("UPDATE promo_codes SET redemptions=redemptions+1 WHERE code=$1", promo.Code)
+	if err != nil {
+		return promo, err
+	}
+	promo.Redemptions++
+
+	return promo, tx.Commit()
+}
+
+func (s *PromoServiceImpl) ReleaseRedemption(purchaseID string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var code string
+	err = tx.QueryRow("DELETE FROM promo_redemptions WHERE purchase_id=$1 RETURNING code", purchaseID).Scan(&code)
+	if errors.Is(err, sql.ErrNoRows) {
+		// No code was applied to this order
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE promo_codes SET redemptions=redemptions-1 WHERE code=$1", code)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
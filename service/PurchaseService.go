@@ -0,0 +1,67 @@
+package service
+
+import (
+	"dating-app/models"
+	"errors"
+	"time"
+)
+
+var (
+	ErrProductNotFound         = errors.New("product not found")
+	ErrPurchaseNotFound        = errors.New("purchase not found")
+	ErrInvalidStatusTransition = errors.New("invalid purchase status transition")
+	ErrRefundExceedsAmount     = errors.New("refunds exceed the amount paid")
+)
+
+// PurchaseService interface
+type PurchaseService interface {
+	ListProducts() ([]models.Product, error)
+	// GetProduct also returns inactive products, so orders placed before a
+	// product was retired can still be fulfilled
+	GetProduct(sku string) (models.Product, error)
+	GetProductByStoreID(store, storeProductID string) (models.Product, error)
+	// CreatePurchase places a pending order for a product, discounted by promo
+	// if one is given
+	CreatePurchase(userID string, product models.Product, promo *models.PromoCode) (models.Purchase, error)
+	GetPurchase(purchaseID string) (models.Purchase, error)
+	// UpdatePurchaseStatus moves a purchase to a new status and records the
+	// transition, failing with ErrInvalidStatusTransition if it isn't allowed
+	UpdatePurchaseStatus(purchaseID, status string) error
+	// FulfilPurchase marks a pending purchase paid and grants its entitlements,
+	// starting or extending the subscription of a timed product, in one
+	// transaction. A purchase that is no longer pending is left alone and
+	// fulfilled is false, so a payment delivered twice is only granted once.
+	FulfilPurchase(purchaseID string, now time.Time) (fulfilled bool, err error)
+	// RefundPurchase applies a refund to a paid purchase in one transaction:
+	// it adds the amount to the purchase's refunded total, takes back what the
+	// refunded share granted, moves the purchase to refunded once nothing is
+	// left of its amount and writes the audit entry. A refund already applied
+	// is left alone and applied is false. Purchases that aren't paid fail with
+	// ErrInvalidStatusTransition, and refunds beyond the amount paid with
+	// ErrRefundExceedsAmount.
+	RefundPurchase(refund models.Refund, now time.Time) (applied bool, err error)
+	SetPaymentIntent(purchaseID, intentID string) error
+	GetPurchaseByPaymentIntent(intentID string) (models.Purchase, error)
+	ListPurchases(userID string) ([]models.Purchase, error)
+	// RecordStorePurchase records a paid app store transaction once. If the
+	// transaction was already recorded, the existing purchase is returned with
+	// created set to false.
+	RecordStorePurchase(userID string, product models.Product, transaction models.StoreTransaction) (purchase models.Purchase, created bool, err error)
+	GetPurchaseByStoreTransaction(store, transactionID string) (models.Purchase, error)
+}
+
+// purchaseTransitions lists the statuses each purchase status may move to
+var purchaseTransitions = map[string][]string{
+	models.PurchaseStatusPending: {models.PurchaseStatusPaid, models.PurchaseStatusFailed},
+	models.PurchaseStatusPaid:    {models.PurchaseStatusRefunded},
+}
+
+// CanTransitionPurchase reports whether a purchase may move from one status to another
+func CanTransitionPurchase(from, to string) bool {
+	for _, status := range purchaseTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,359 @@
+package service
+
+import (
+	"database/sql"
+	"dating-app/models"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PurchaseServiceImpl struct implementing PurchaseService
+type PurchaseServiceImpl struct {
+	DB *sql.DB
+}
+
+// Purchases of erased accounts are kept without a user, read as an empty UserID
+const purchaseColumns = "id, COALESCE(user_id::text, ''), sku, amount, currency, status, COALESCE(payment_intent_id, ''), COALESCE(store, ''), COALESCE(store_transaction_id, ''), COALESCE(promo_code, ''), refunded_amount, created_at, updated_at"
+
+func scanPurchase(row rowScanner) (models.Purchase, error) {
+	var purchase models.Purchase
+	err := row.Scan(&purchase.ID, &purchase.UserID, &purchase.SKU, &purchase.Amount, &purchase.Currency, &purchase.Status,
+		&purchase.PaymentIntentID, &purchase.Store, &purchase.StoreTransactionID, &purchase.PromoCode, &purchase.RefundedAmount, &purchase.CreatedAt, &purchase.UpdatedAt)
+	return purchase, err
+}
+
+const productColumns = "sku, name, price, currency, entitlements, duration_days, quantity, active"
+
+func scanProduct(row rowScanner) (models.Product, error) {
+	var product models.Product
+	err := row.Scan(&product.SKU, &product.Name, &product.Price, &product.Currency, pq.Array(&product.Entitlements),
+		&product.DurationDays, &product.Quantity, &product.Active)
+	return product, err
+}
+
+func (s *PurchaseServiceImpl) ListProducts() ([]models.Product, error) {
+	rows, err := s.DB.Query("SELECT " + productColumns + " FROM products WHERE active ORDER BY price")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []models.Product{}
+	for rows.Next() {
+		product, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, rows.Err()
+}
+
+func (s *PurchaseServiceImpl) GetProduct(sku string) (models.Product, error) {
+	product, err := scanProduct(s.DB.QueryRow("SELECT "+productColumns+" FROM products WHERE sku=$1", sku))
+	if errors.Is(err, sql.ErrNoRows) {
+		return product, ErrProductNotFound
+	}
+	return product, err
+}
+
+func (s *PurchaseServiceImpl) GetProductByStoreID(store, storeProductID string) (models.Product, error) {
+	product, err := scanProduct(s.DB.QueryRow("SELECT "+productColumns+" FROM products JOIN store_products USING (sku) WHERE store=$1 AND store_product_id=$2", store, storeProductID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return product, ErrProductNotFound
+	}
+	return product, err
+}
+
+func (s *PurchaseServiceImpl) CreatePurchase(userID string, product models.Product, promo *models.PromoCode) (models.Purchase, error) {
+	purchase := models.Purchase{
+		UserID:   userID,
+		SKU:      product.SKU,
+		Amount:   DiscountedPrice(product.Price, promo),
+		Currency: product.Currency,
+		Status:   models.PurchaseStatusPending,
+	}
+	var promoCode interface{}
+	if promo != nil {
+		purchase.PromoCode = promo.Code
+		promoCode = promo.Code
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return purchase, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("INSERT INTO purchases (user_id, sku, amount, currency, status, promo_code) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at",
+		purchase.UserID, purchase.SKU, purchase.Amount, purchase.Currency, purchase.Status, promoCode).Scan(&purchase.ID, &purchase.CreatedAt, &purchase.UpdatedAt)
+	if err != nil {
+		return purchase, err
+	}
+
+	_, err = tx.Exec("INSERT INTO purchase_status_history (purchase_id, from_status, to_status) VALUES ($1, NULL, $2)", purchase.ID, purchase.Status)
+	if err != nil {
+		return purchase, err
+	}
+
+	return purchase, tx.Commit()
+}
+
+func (s *PurchaseServiceImpl) GetPurchase(purchaseID string) (models.Purchase, error) {
+	purchase, err := scanPurchase(s.DB.QueryRow("SELECT "+purchaseColumns+" FROM purchases WHERE id=$1", purchaseID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return purchase, ErrPurchaseNotFound
+	}
+	return purchase, err
+}
+
+func (s *PurchaseServiceImpl) UpdatePurchaseStatus(purchaseID, status string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The row lock keeps two concurrent updates from both applying
+	var current string
+	err = tx.QueryRow("SELECT status FROM purchases WHERE id=$1 FOR UPDATE", purchaseID).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrPurchaseNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !CanTransitionPurchase(current, status) {
+		return ErrInvalidStatusTransition
+	}
+
+	if err := setPurchaseStatus(tx, purchaseID, current, status); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PurchaseServiceImpl) FulfilPurchase(purchaseID string, now time.Time) (bool, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// The row lock makes concurrent deliveries of the payment wait, and see
+	// the purchase paid once they get it
+	purchase, err := scanPurchase(tx.QueryRow("SELECT "+purchaseColumns+" FROM purchases WHERE id=$1 FOR UPDATE", purchaseID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrPurchaseNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	if purchase.Status != models.PurchaseStatusPending {
+		return false, nil
+	}
+
+	// The buyer may have erased their account while the payment was in flight
+	if purchase.UserID != "" {
+		product, err := scanProduct(tx.QueryRow("SELECT "+productColumns+" FROM products WHERE sku=$1", purchase.SKU))
+		if err != nil {
+			return false, err
+		}
+		if err := GrantEntitlements(userEntitlements{tx}, purchase.UserID, product); err != nil {
+			return false, err
+		}
+		if product.DurationDays > 0 {
+			if _, err := activateSubscription(tx, purchase.UserID, product, now); err != nil {
+				return false, err
+			}
+		}
+	}
+	if err := setPurchaseStatus(tx, purchase.ID, purchase.Status, models.PurchaseStatusPaid); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// setPurchaseStatus moves a purchase locked by tx to a new status and records
+// the transition
+func setPurchaseStatus(tx *sql.Tx, purchaseID, from, to string) error {
+	if _, err := tx.Exec("UPDATE purchases SET status=$1, updated_at=NOW() WHERE id=$2", to, purchaseID); err != nil {
+		return err
+	}
+	_, err := tx.Exec("INSERT INTO purchase_status_history (purchase_id, from_status, to_status) VALUES ($1, $2, $3)", purchaseID, from, to)
+	return err
+}
+
+func (s *PurchaseServiceImpl) RefundPurchase(refund models.Refund, now time.Time) (bool, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	purchase, err := scanPurchase(tx.QueryRow("SELECT "+purchaseColumns+" FROM purchases WHERE id=$1 FOR UPDATE", refund.PurchaseID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrPurchaseNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	if purchase.Status != models.PurchaseStatusPaid {
+		return false, ErrInvalidStatusTransition
+	}
+
+	// Recording the refund's ID is what claims it
+	result, err := tx.Exec("INSERT INTO purchase_refunds (purchase_id, refund_id, amount, actor) VALUES ($1, $2, $3, $4) ON CONFLICT (purchase_id, refund_id) DO NOTHING",
+		purchase.ID, refund.RefundID, refund.Amount, refund.Actor)
+	if err != nil {
+		return false, err
+	}
+	if claimed, err := result.RowsAffected(); err != nil || claimed == 0 {
+		return false, err
+	}
+	refunded := purchase.RefundedAmount + refund.Amount
+	if refund.Amount < 0 || refunded > purchase.Amount {
+		return false, ErrRefundExceedsAmount
+	}
+
+	product, err := scanProduct(tx.QueryRow("SELECT "+productColumns+" FROM products WHERE sku=$1", purchase.SKU))
+	if err != nil {
+		return false, err
+	}
+	full := refunded >= purchase.Amount
+	if err := revokeRefunded(tx, purchase, product, refund.Amount, full, now); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec("UPDATE purchases SET refunded_amount=$1, updated_at=NOW() WHERE id=$2", refunded, purchase.ID); err != nil {
+		return false, err
+	}
+	if full {
+		if err := setPurchaseStatus(tx, purchase.ID, purchase.Status, models.PurchaseStatusRefunded); err != nil {
+			return false, err
+		}
+	}
+
+	details := map[string]string{
+		"userID":   purchase.UserID,
+		"sku":      purchase.SKU,
+		"amount":   strconv.FormatInt(refund.Amount, 10),
+		"currency": purchase.Currency,
+		"refundID": refund.RefundID,
+	}
+	if refund.Reason != "" {
+		details["reason"] = refund.Reason
+	}
+	err = recordAudit(tx, models.AuditEntry{
+		Actor:      refund.Actor,
+		Action:     models.AuditActionPurchaseRefunded,
+		TargetType: "purchase",
+		TargetID:   purchase.ID,
+		Details:    details,
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// revokeRefunded takes back what a refund of amount on purchase covers. For a
+// subscription product the refunded share of the period it paid for is taken
+// off the subscription, which only ends once no paid time is left. A one-off
+// product is revoked once the purchase is refunded in full; partial refunds of
+// it are a goodwill gesture and the buyer keeps it.
+func revokeRefunded(tx *sql.Tx, purchase models.Purchase, product models.Product, amount int64, full bool, now time.Time) error {
+	if purchase.UserID == "" {
+		// The account has been erased, and what it had with it
+		return nil
+	}
+	if product.DurationDays == 0 {
+		if !full {
+			return nil
+		}
+		return RevokeEntitlements(userEntitlements{tx}, purchase.UserID, product)
+	}
+
+	period := time.Duration(product.DurationDays) * 24 * time.Hour
+	if purchase.Amount > 0 {
+		period = time.Duration(float64(period) * float64(amount) / float64(purchase.Amount))
+	}
+	subscription, err := shortenSubscription(tx, purchase.UserID, period, now)
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		// The subscription has already ended and its entitlements with it
+		return nil
+	}
+	if err != nil || subscription.Status != models.SubscriptionStatusExpired {
+		return err
+	}
+	return RevokeEntitlements(userEntitlements{tx}, purchase.UserID, product)
+}
+
+func (s *PurchaseServiceImpl) SetPaymentIntent(purchaseID, intentID string) error {
+	_, err := s.DB.Exec("UPDATE purchases SET payment_intent_id=$1, updated_at=NOW() WHERE id=$2", intentID, purchaseID)
+	return err
+}
+
+func (s *PurchaseServiceImpl) GetPurchaseByPaymentIntent(intentID string) (models.Purchase, error) {
+	var purchase models.Purchase
+	purchase, err := scanPurchase(s.DB.QueryRow("SELECT "+purchaseColumns+" FROM purchases WHERE payment_intent_id=$1", intentID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return purchase, ErrPurchaseNotFound
+	}
+	return purchase, err
+}
+
+func (s *PurchaseServiceImpl) GetPurchaseByStoreTransaction(store, transactionID string) (models.Purchase, error) {
+	purchase, err := scanPurchase(s.DB.QueryRow("SELECT "+purchaseColumns+" FROM purchases WHERE store=$1 AND store_transaction_id=$2", store, transactionID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return purchase, ErrPurchaseNotFound
+	}
+	return purchase, err
+}
+
+func (s *PurchaseServiceImpl) RecordStorePurchase(userID string, product models.Product, transaction models.StoreTransaction) (models.Purchase, bool, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.Purchase{}, false, err
+	}
+	defer tx.Rollback()
+
+	// The store collected the payment, so the order is paid from the start
+	purchase, err := scanPurchase(tx.QueryRow("INSERT INTO purchases (user_id, sku, amount, currency, status, store, store_transaction_id, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (store, store_transaction_id) DO NOTHING RETURNING "+purchaseColumns,
+		userID, product.SKU, product.Price, product.Currency, models.PurchaseStatusPaid, transaction.Store, transaction.TransactionID, transaction.PurchasedAt))
+	if errors.Is(err, sql.ErrNoRows) {
+		purchase, err = scanPurchase(tx.QueryRow("SELECT "+purchaseColumns+" FROM purchases WHERE store=$1 AND store_transaction_id=$2", transaction.Store, transaction.TransactionID))
+		return purchase, false, err
+	}
+	if err != nil {
+		return purchase, false, err
+	}
+
+	_, err = tx.Exec("INSERT INTO purchase_status_history (purchase_id, from_status, to_status) VALUES ($1, NULL, $2)", purchase.ID, purchase.Status)
+	if err != nil {
+		return purchase, false, err
+	}
+
+	return purchase, true, tx.Commit()
+}
+
+func (s *PurchaseServiceImpl) ListPurchases(userID string) ([]models.Purchase, error) {
+	rows, err := s.DB.Query("SELECT "+purchaseColumns+" FROM purchases WHERE user_id=$1 ORDER BY created_at DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	purchases := []models.Purchase{}
+	for rows.Next() {
+		purchase, err := scanPurchase(rows)
+		if err != nil {
+			return nil, err
+		}
+		purchases = append(purchases, purchase)
+	}
+	return purchases, rows.Err()
+}
@@ -0,0 +1,22 @@
+package service
+
+import (
+	"context"
+	"dating-app/models"
+	"errors"
+)
+
+var (
+	// ErrDeviceUnregistered means the token is no longer valid, usually
+	// because the app was uninstalled; the device should be forgotten
+	ErrDeviceUnregistered = errors.New("device is no longer registered")
+	// ErrPushRejected means the provider refused the notification in a way
+	// retrying won't fix
+	ErrPushRejected = errors.New("push notification rejected")
+)
+
+// PushProvider sends push notifications to devices of one platform. Errors
+// other than ErrDeviceUnregistered and ErrPushRejected are retried.
+type PushProvider interface {
+	Send(ctx context.Context, token string, notification models.Notification) error
+}
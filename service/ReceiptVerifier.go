@@ -0,0 +1,27 @@
+package service
+
+import (
+	"context"
+	"dating-app/models"
+	"errors"
+	"net/http"
+)
+
+var (
+	ErrReceiptInvalid      = errors.New("receipt is invalid")
+	ErrInvalidNotification = errors.New("invalid store notification")
+	ErrStoreRequestFailed  = errors.New("app store request failed")
+)
+
+// maxNotificationSize bounds the body accepted from a store notification
+const maxNotificationSize = 1 << 20
+
+// ReceiptVerifier checks in-app purchases with an app store
+type ReceiptVerifier interface {
+	// Verify asks the store about a receipt sent by the app and returns the
+	// transaction it proves. productID is the store's product identifier.
+	Verify(ctx context.Context, productID, receipt string, subscription bool) (models.StoreTransaction, error)
+	// ParseNotification authenticates a server notification sent by the
+	// store and returns it in normalized form
+	ParseNotification(ctx context.Context, r *http.Request) (models.StoreNotification, error)
+}
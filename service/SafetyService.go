@@ -0,0 +1,52 @@
+package service
+
+import (
+	"dating-app/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrSelfTarget    = errors.New("users can't block or report themselves")
+	ErrInvalidReport = errors.New("invalid report")
+)
+
+// MaxReportDetailsRunes bounds the free text of a report
+const MaxReportDetailsRunes = 1000
+
+// SafetyService interface
+type SafetyService interface {
+	// Block hides the two users from each other's feeds, likes and chats and
+	// closes their match, which is returned if they had one
+	Block(userID, targetID string, now time.Time) (*models.Match, error)
+	// Unblock lifts a block. A match closed by the block stays closed.
+	Unblock(userID, targetID string) error
+	// Blocked reports whether either user has blocked the other
+	Blocked(userID, otherID string) (bool, error)
+	Report(userID, targetID, reason, details string, now time.Time) (models.Report, error)
+}
+
+// NormalizeReport checks a report's reason and returns its trimmed details.
+// Details are required when the reason is other.
+func NormalizeReport(reason, details string) (string, error) {
+	known := false
+	for _, r := range models.ReportReasons {
+		known = known || r == reason
+	}
+	if !known {
+		return "", fmt.Errorf("%w: reason must be one of %s", ErrInvalidReport, strings.Join(models.ReportReasons, ", "))
+	}
+
+	details = strings.TrimSpace(details)
+	if reason == models.ReportReasonOther && details == "" {
+		return "", fmt.Errorf("%w: details are required for reason other", ErrInvalidReport)
+	}
+	if utf8.RuneCountInString(details) > MaxReportDetailsRunes {
+		return "", fmt.Errorf("%w: details must be at most %d characters", ErrInvalidReport, MaxReportDetailsRunes)
+	}
+	return details, nil
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"database/sql"
+	"dating-app/models"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SafetyServiceImpl struct implementing SafetyService
+type SafetyServiceImpl struct {
+	DB *sql.DB
+}
+
+// notBlocked is an SQL condition that holds when neither of the users a and b
+// (columns or parameters) has blocked the other
+func notBlocked(a, b string) string {
+	return fmt.Sprintf(`NOT EXISTS (SELECT 1 FROM blocks bl
+		WHERE (bl.user_id=%[1]s AND bl.blocked_id=%[2]s) OR (bl.user_id=%[2]s AND bl.blocked_id=%[1]s))`, a, b)
+}
+
+// blocked reports whether either user has blocked the other
+func blocked(q rowQueryer, userID, otherID string) (bool, error) {
+	var isBlocked bool
+	err := q.QueryRow("SELECT NOT "+notBlocked("$1", "$2"), userID, otherID).Scan(&isBlocked)
+	return isBlocked, err
+}
+
+// userExists checks a user referenced in a request
+func userExists(q rowQueryer, userID string) error {
+	var exists bool
+	if err := q.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE id=$1)", userID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SafetyServiceImpl) Block(userID, targetID string, now time.Time) (*models.Match, error) {
+	if userID == targetID {
+		return nil, ErrSelfTarget
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := userExists(tx, targetID); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("INSERT INTO blocks (user_id, blocked_id, created_at) VALUES ($1, $2, $3) ON CONFLICT (user_id, blocked_id) DO NOTHING",
+		userID, targetID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	userA, userB := MatchPair(userID, targetID)
+	match, err := scanMatch(tx.QueryRow(`UPDATE matches SET unmatched_at=$3, unmatched_by=$4
+		WHERE user_a=$1 AND user_b=$2 AND unmatched_at IS NULL RETURNING `+matchColumns, userA, userB, now, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, tx.Commit()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &match, tx.Commit()
+}
+
+func (s *SafetyServiceImpl) Unblock(userID, targetID string) error {
+	_, err := s.DB.Exec("DELETE FROM blocks WHERE user_id=$1 AND blocked_id=$2", userID, targetID)
+	return err
+}
+
+func (s *SafetyServiceImpl) Blocked(userID, otherID string) (bool, error) {
+	return blocked(s.DB, userID, otherID)
+}
+
+func (s *SafetyServiceImpl) Report(userID, targetID, reason, details string, now time.Time) (models.Report, error) {
+	report := models.Report{ReporterID: userID, ReportedID: targetID, Reason: reason, Status: models.ReportStatusOpen, CreatedAt: now}
+	if userID == targetID {
+		return report, ErrSelfTarget
+	}
+	details, err := NormalizeReport(reason, details)
+	if err != nil {
+		return report, err
+	}
+	report.Details = details
+
+	if err := userExists(s.DB, targetID); err != nil {
+		return report, err
+	}
+	err = s.DB.QueryRow("INSERT INTO reports (reporter_id, reported_id, reason, details, status, created_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		userID, targetID, reason, details, report.Status, now).Scan(&report.ID)
+	return report, err
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"dating-app/models"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// StubReceiptVerifier is a local ReceiptVerifier for tests and development.
+// Only receipts registered with AddReceipt verify. Notifications are plain
+// StoreNotification JSON authenticated by the X-Stub-Token header.
+type StubReceiptVerifier struct {
+	Store string
+	Token string
+
+	mu       sync.Mutex
+	receipts map[string]models.StoreTransaction
+}
+
+// AddReceipt makes receipt verify as the given transaction
+func (v *StubReceiptVerifier) AddReceipt(receipt string, transaction models.StoreTransaction) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.receipts == nil {
+		v.receipts = make(map[string]models.StoreTransaction)
+	}
+	transaction.Store = v.Store
+	v.receipts[receipt] = transaction
+}
+
+func (v *StubReceiptVerifier) Verify(ctx context.Context, productID, receipt string, subscription bool) (models.StoreTransaction, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	transaction, ok := v.receipts[receipt]
+	if !ok || transaction.ProductID != productID {
+		return models.StoreTransaction{}, ErrReceiptInvalid
+	}
+	return transaction, nil
+}
+
+func (v *StubReceiptVerifier) ParseNotification(ctx context.Context, r *http.Request) (models.StoreNotification, error) {
+	var notification models.StoreNotification
+	if v.Token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Stub-Token")), []byte(v.Token)) != 1 {
+		return notification, ErrInvalidNotification
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxNotificationSize))
+	if err != nil {
+		return notification, err
+	}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return notification, ErrInvalidNotification
+	}
+	notification.Transaction.Store = v.Store
+	return notification, nil
+}
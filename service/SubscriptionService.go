@@ -0,0 +1,47 @@
+package service
+
+import (
+	"dating-app/models"
+	"errors"
+	"time"
+)
+
+var (
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrStoreManaged         = errors.New("subscription is managed by an app store")
+)
+
+const (
+	// GracePeriod is how long a user keeps a subscription's entitlements
+	// after its period ends without a successful renewal
+	GracePeriod = 3 * 24 * time.Hour
+	// RenewalRetryInterval is how long to wait between renewal attempts
+	RenewalRetryInterval = 24 * time.Hour
+)
+
+// SubscriptionService interface
+type SubscriptionService interface {
+	GetSubscription(userID string) (models.Subscription, error)
+	// Activate starts a subscription for a paid product, or extends the
+	// user's current one by another period
+	Activate(userID string, product models.Product, now time.Time) (models.Subscription, error)
+	// Extend adds free days to the user's current subscription, or starts a
+	// subscription for product that does not renew when they run out
+	Extend(userID string, product models.Product, days int, now time.Time) (models.Subscription, error)
+	// ActivateStore starts or updates the subscription backing an app store
+	// transaction, taking the period end from the store
+	ActivateStore(userID string, product models.Product, transaction models.StoreTransaction) (models.Subscription, error)
+	GetStoreSubscription(store, originalTransactionID string) (models.Subscription, error)
+	UpdateStoreSubscription(subscriptionID, status string, graceUntil *time.Time, autoRenew bool) error
+	Cancel(userID string) (models.Subscription, error)
+	Resume(userID string) (models.Subscription, error)
+	// DueForRenewal claims up to limit subscriptions to renew, moving them
+	// into grace and deferring their next attempt by RenewalRetryInterval
+	DueForRenewal(now time.Time, limit int) ([]models.Subscription, error)
+	Lapsed(now time.Time) ([]models.Subscription, error)
+	// ExpireLapsed expires a subscription listed by Lapsed and revokes its
+	// entitlements in one transaction, unless it was renewed in the meantime.
+	// It reports whether the subscription was expired.
+	ExpireLapsed(subscriptionID string, now time.Time) (bool, error)
+	MarkExpired(subscriptionID string) error
+}
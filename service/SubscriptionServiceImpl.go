@@ -0,0 +1,283 @@
+package service
+
+import (
+	"database/sql"
+	"dating-app/models"
+	"errors"
+	"time"
+)
+
+// SubscriptionServiceImpl struct implementing SubscriptionService
+type SubscriptionServiceImpl struct {
+	DB *sql.DB
+}
+
+const subscriptionColumns = "id, user_id, sku, status, auto_renew, current_period_start, current_period_end, grace_until, canceled_at, COALESCE(store, '')"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSubscription(row rowScanner) (models.Subscription, error) {
+	var subscription models.Subscription
+	var graceUntil, canceledAt sql.NullTime
+	err := row.Scan(&subscription.ID, &subscription.UserID, &subscription.SKU, &subscription.Status, &subscription.AutoRenew,
+		&subscription.CurrentPeriodStart, &subscription.CurrentPeriodEnd, &graceUntil, &canceledAt, &subscription.Store)
+	if graceUntil.Valid {
+		subscription.GraceUntil = &graceUntil.Time
+	}
+	if canceledAt.Valid {
+		subscription.CanceledAt = &canceledAt.Time
+	}
+	return subscription, err
+}
+
+func (s *SubscriptionServiceImpl) querySubscriptions(query string, args ...interface{}) ([]models.Subscription, error) {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []models.Subscription{}
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+func (s *SubscriptionServiceImpl) GetSubscription(userID string) (models.Subscription, error) {
+	subscription, err := scanSubscription(s.DB.QueryRow("SELECT "+subscriptionColumns+" FROM subscriptions WHERE user_id=$1 AND status IN ('active', 'grace')", userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return subscription, ErrSubscriptionNotFound
+	}
+	return subscription, err
+}
+
+func (s *SubscriptionServiceImpl) Activate(userID string, product models.Product, now time.Time) (models.Subscription, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	defer tx.Rollback()
+
+	subscription, err := activateSubscription(tx, userID, product, now)
+	if err != nil {
+		return subscription, err
+	}
+	return subscription, tx.Commit()
+}
+
+// activateSubscription is Activate within tx, which the purchase ledger shares
+// with the payment it records
+func activateSubscription(tx *sql.Tx, userID string, product models.Product, now time.Time) (models.Subscription, error) {
+	subscription, err := scanSubscription(tx.QueryRow("SELECT "+subscriptionColumns+" FROM subscriptions WHERE user_id=$1 AND status IN ('active', 'grace') FOR UPDATE", userID))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		end := now.AddDate(0, 0, product.DurationDays)
+		subscription, err = scanSubscription(tx.QueryRow("INSERT INTO subscriptions (user_id, sku, status, auto_renew, current_period_start, current_period_end) VALUES ($1, $2, $3, true, $4, $5) RETURNING "+subscriptionColumns,
+			userID, product.SKU, models.SubscriptionStatusActive, now, end))
+	case err == nil:
+		// Paying early extends from the end of the current period; paying
+		// during grace starts a fresh period now
+		start := subscription.CurrentPeriodEnd
+		if now.After(start) {
+			start = now
+		}
+		end := start.AddDate(0, 0, product.DurationDays)
+		subscription, err = scanSubscription(tx.QueryRow("UPDATE subscriptions SET sku=$1, status=$2, current_period_start=$3, current_period_end=$4, grace_until=NULL, next_renewal_at=NULL, updated_at=NOW() WHERE id=$5 RETURNING "+subscriptionColumns,
+			product.SKU, models.SubscriptionStatusActive, start, end, subscription.ID))
+	}
+	return subscription, err
+}
+
+func (s *SubscriptionServiceImpl) Extend(userID string, product models.Product, days int, now time.Time) (models.Subscription, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	defer tx.Rollback()
+
+	subscription, err := scanSubscription(tx.QueryRow("SELECT "+subscriptionColumns+" FROM subscriptions WHERE user_id=$1 AND status IN ('active', 'grace') FOR UPDATE", userID))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// Nobody has agreed to pay for it, so a gifted subscription never renews
+		subscription, err = scanSubscription(tx.QueryRow("INSERT INTO subscriptions (user_id, sku, status, auto_renew, current_period_start, current_period_end) VALUES ($1, $2, $3, false, $4, $5) RETURNING "+subscriptionColumns,
+			userID, product.SKU, models.SubscriptionStatusActive, now, now.AddDate(0, 0, days)))
+	case err == nil:
+		end := subscription.CurrentPeriodEnd
+		if now.After(end) {
+			end = now
+		}
+		subscription, err = scanSubscription(tx.QueryRow("UPDATE subscriptions SET status=$1, current_period_end=$2, grace_until=NULL, next_renewal_at=NULL, updated_at=NOW() WHERE id=$3 RETURNING "+subscriptionColumns,
+			models.SubscriptionStatusActive, end.AddDate(0, 0, days), subscription.ID))
+	}
+	if err != nil {
+		return subscription, err
+	}
+
+	return subscription, tx.Commit()
+}
+
+func (s *SubscriptionServiceImpl) ActivateStore(userID string, product models.Product, transaction models.StoreTransaction) (models.Subscription, error) {
+	end := transaction.PurchasedAt.AddDate(0, 0, product.DurationDays)
+	if transaction.ExpiresAt != nil {
+		end = *transaction.ExpiresAt
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	defer tx.Rollback()
+
+	subscription, err := scanSubscription(tx.QueryRow("SELECT "+subscriptionColumns+" FROM subscriptions WHERE user_id=$1 AND status IN ('active', 'grace') FOR UPDATE", userID))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		subscription, err = scanSubscription(tx.QueryRow("INSERT INTO subscriptions (user_id, sku, status, auto_renew, current_period_start, current_period_end, store, store_original_transaction_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING "+subscriptionColumns,
+			userID, product.SKU, models.SubscriptionStatusActive, transaction.AutoRenew, transaction.PurchasedAt, end, transaction.Store, transaction.OriginalTransactionID))
+	case err == nil:
+		// Store notifications can arrive out of order; never shorten a period
+		if end.Before(subscription.CurrentPeriodEnd) {
+			end = subscription.CurrentPeriodEnd
+		}
+		subscription, err = scanSubscription(tx.QueryRow("UPDATE subscriptions SET sku=$1, status=$2, auto_renew=$3, current_period_start=$4, current_period_end=$5, store=$6, store_original_transaction_id=$7, grace_until=NULL, next_renewal_at=NULL, updated_at=NOW() WHERE id=$8 RETURNING "+subscriptionColumns,
+			product.SKU, models.SubscriptionStatusActive, transaction.AutoRenew, transaction.PurchasedAt, end, transaction.Store, transaction.OriginalTransactionID, subscription.ID))
+	}
+	if err != nil {
+		return subscription, err
+	}
+
+	return subscription, tx.Commit()
+}
+
+// shortenSubscription takes time off the user's current subscription within
+// tx, e.g. for a refunded period, expiring it if nothing is left
+func shortenSubscription(tx *sql.Tx, userID string, by time.Duration, now time.Time) (models.Subscription, error) {
+	subscription, err := scanSubscription(tx.QueryRow("SELECT "+subscriptionColumns+" FROM subscriptions WHERE user_id=$1 AND status IN ('active', 'grace') FOR UPDATE", userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return subscription, ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return subscription, err
+	}
+
+	status := subscription.Status
+	end := subscription.CurrentPeriodEnd.Add(-by)
+	if !end.After(now) {
+		status, end = models.SubscriptionStatusExpired, now
+	}
+	return scanSubscription(tx.QueryRow("UPDATE subscriptions SET status=$1, current_period_end=$2, updated_at=NOW() WHERE id=$3 RETURNING "+subscriptionColumns,
+		status, end, subscription.ID))
+}
+
+func (s *SubscriptionServiceImpl) GetStoreSubscription(store, originalTransactionID string) (models.Subscription, error) {
+	subscription, err := scanSubscription(s.DB.QueryRow("SELECT "+subscriptionColumns+" FROM subscriptions WHERE store=$1 AND store_original_transaction_id=$2 ORDER BY created_at DESC LIMIT 1", store, originalTransactionID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return subscription, ErrSubscriptionNotFound
+	}
+	return subscription, err
+}
+
+func (s *SubscriptionServiceImpl) UpdateStoreSubscription(subscriptionID, status string, graceUntil *time.Time, autoRenew bool) error {
+	_, err := s.DB.Exec("UPDATE subscriptions SET status=$1, grace_until=$2, auto_renew=$3, updated_at=NOW() WHERE id=$4", status, graceUntil, autoRenew, subscriptionID)
+	return err
+}
+
+// Cancel turns off auto-renew; the subscription stays active until the end of
+// the current period and then lapses
+func (s *SubscriptionServiceImpl) Cancel(userID string) (models.Subscription, error) {
+	return s.setAutoRenew(userID, "UPDATE subscriptions SET auto_renew=false, canceled_at=NOW(), updated_at=NOW() WHERE user_id=$1 AND status IN ('active', 'grace') AND store IS NULL RETURNING "+subscriptionColumns)
+}
+
+func (s *SubscriptionServiceImpl) Resume(userID string) (models.Subscription, error) {
+	return s.setAutoRenew(userID, "UPDATE subscriptions SET auto_renew=true, canceled_at=NULL, updated_at=NOW() WHERE user_id=$1 AND status IN ('active', 'grace') AND store IS NULL RETURNING "+subscriptionColumns)
+}
+
+// setAutoRenew runs a Cancel or Resume update. App store subscriptions can
+// only be changed in the store, so they are reported as ErrStoreManaged.
+func (s *SubscriptionServiceImpl) setAutoRenew(userID, query string) (models.Subscription, error) {
+	subscription, err := scanSubscription(s.DB.QueryRow(query, userID))
+	if !errors.Is(err, sql.ErrNoRows) {
+		return subscription, err
+	}
+
+	subscription, err = s.GetSubscription(userID)
+	if err == nil {
+		return subscription, ErrStoreManaged
+	}
+	return subscription, err
+}
+
+func (s *SubscriptionServiceImpl) DueForRenewal(now time.Time, limit int) ([]models.Subscription, error) {
+	// As with exports, the next attempt is the lease: other workers skip a
+	// claimed subscription, and it is retried if this one dies while
+	// charging. Grace starts with the first attempt and isn't moved by retries.
+	return s.querySubscriptions(`UPDATE subscriptions
+		SET status=$3, grace_until=COALESCE(grace_until, current_period_end + $4 * INTERVAL '1 second'), next_renewal_at=$5, updated_at=NOW()
+		WHERE id IN (
+			SELECT id FROM subscriptions
+			WHERE store IS NULL AND status IN ('active', 'grace') AND auto_renew AND current_period_end <= $1
+				AND (next_renewal_at IS NULL OR next_renewal_at <= $1) AND (grace_until IS NULL OR grace_until > $1)
+			ORDER BY current_period_end LIMIT $2 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+subscriptionColumns,
+		now, limit, models.SubscriptionStatusGrace, int64(GracePeriod/time.Second), now.Add(RenewalRetryInterval))
+}
+
+// Lapsed lists subscriptions whose entitlements should end. Store-managed
+// subscriptions normally end through a store notification, but also lapse
+// GracePeriod after their period end in case that notification was missed.
+func (s *SubscriptionServiceImpl) Lapsed(now time.Time) ([]models.Subscription, error) {
+	return s.querySubscriptions("SELECT "+subscriptionColumns+" FROM subscriptions WHERE status IN ('active', 'grace') AND ((NOT auto_renew AND current_period_end <= $1) OR grace_until <= $1 OR (store IS NOT NULL AND current_period_end <= $2))", now, now.Add(-GracePeriod))
+}
+
+func (s *SubscriptionServiceImpl) ExpireLapsed(subscriptionID string, now time.Time) (bool, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// The row lock makes a renewal being paid for wait, or has this wait for
+	// it and then see the new period
+	subscription, err := scanSubscription(tx.QueryRow("SELECT "+subscriptionColumns+" FROM subscriptions WHERE id=$1 FOR UPDATE", subscriptionID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil || !subscriptionLapsed(subscription, now) {
+		return false, err
+	}
+
+	product, err := scanProduct(tx.QueryRow("SELECT "+productColumns+" FROM products WHERE sku=$1", subscription.SKU))
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("UPDATE subscriptions SET status=$1, updated_at=NOW() WHERE id=$2", models.SubscriptionStatusExpired, subscription.ID); err != nil {
+		return false, err
+	}
+	if err := RevokeEntitlements(userEntitlements{tx}, subscription.UserID, product); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// subscriptionLapsed is the condition of Lapsed for a single subscription
+func subscriptionLapsed(subscription models.Subscription, now time.Time) bool {
+	if subscription.Status != models.SubscriptionStatusActive && subscription.Status != models.SubscriptionStatusGrace {
+		return false
+	}
+	ended := !subscription.CurrentPeriodEnd.After(now)
+	return (!subscription.AutoRenew && ended) ||
+		(subscription.GraceUntil != nil && !subscription.GraceUntil.After(now)) ||
+		(subscription.Store != "" && !subscription.CurrentPeriodEnd.After(now.Add(-GracePeriod)))
+}
+
+func (s *SubscriptionServiceImpl) MarkExpired(subscriptionID string) error {
+	_, err := s.DB.Exec("UPDATE subscriptions SET status=$1, updated_at=NOW() WHERE id=$2", models.SubscriptionStatusExpired, subscriptionID)
+	return err
+}
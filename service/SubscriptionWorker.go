@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"dating-app/models"
+	"log"
+	"time"
+)
+
+// renewalBatchSize bounds how many subscriptions one run of the worker charges
+const renewalBatchSize = 100
+
+// SubscriptionWorker periodically renews subscriptions whose period has
+// ended and expires those that lapsed, revoking their entitlements.
+// Renewals are charged off-session; the payments webhook extends the
+// subscription once the gateway reports the payment as succeeded.
+type SubscriptionWorker struct {
+	Subscriptions SubscriptionService
+	Purchases     PurchaseService
+	Gateway       PaymentGateway
+	Interval      time.Duration
+}
+
+// Run processes subscriptions every Interval until ctx is done
+func (w *SubscriptionWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.RunOnce(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce attempts due renewals and expires lapsed subscriptions as of now
+func (w *SubscriptionWorker) RunOnce(now time.Time) {
+	// Claimed subscriptions are in grace, so they aren't picked up again
+	// while the payment result is in flight
+	due, err := w.Subscriptions.DueForRenewal(now, renewalBatchSize)
+	if err != nil {
+		log.Printf("subscription worker: listing renewals: %v", err)
+	}
+	for _, subscription := range due {
+		if err := w.renew(subscription.UserID, subscription.SKU); err != nil {
+			log.Printf("subscription worker: renewing %s: %v", subscription.ID, err)
+		}
+	}
+
+	lapsed, err := w.Subscriptions.Lapsed(now)
+	if err != nil {
+		log.Printf("subscription worker: listing lapsed subscriptions: %v", err)
+	}
+	for _, subscription := range lapsed {
+		// Renewals paid since the listing are checked for under a lock
+		if _, err := w.Subscriptions.ExpireLapsed(subscription.ID, now); err != nil {
+			log.Printf("subscription worker: expiring %s: %v", subscription.ID, err)
+		}
+	}
+}
+
+// renew places a renewal order and charges the customer for it
+func (w *SubscriptionWorker) renew(userID, sku string) error {
+	product, err := w.Purchases.GetProduct(sku)
+	if err != nil {
+		return err
+	}
+
+	purchase, err := w.Purchases.CreatePurchase(userID, product, nil)
+	if err != nil {
+		return err
+	}
+
+	intent, err := w.Gateway.CreateIntent(purchase.Amount, purchase.Currency, map[string]string{"purchaseID": purchase.ID})
+	if err != nil {
+		// No payment will ever settle the order, so it mustn't stay pending
+		if failErr := w.Purchases.UpdatePurchaseStatus(purchase.ID, models.PurchaseStatusFailed); failErr != nil {
+			log.Printf("subscription worker: failing purchase %s: %v", purchase.ID, failErr)
+		}
+		return err
+	}
+
+	if err := w.Purchases.SetPaymentIntent(purchase.ID, intent.ID); err != nil {
+		return err
+	}
+
+	return w.Gateway.Capture(intent.ID)
+}
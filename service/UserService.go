@@ -2,15 +2,91 @@ package service
 
 import (
 	"dating-app/models"
+	"errors"
+	"time"
+)
+
+var (
+	ErrDailySwipeLimit   = errors.New("daily swipe limit reached")
+	ErrAlreadySwiped     = errors.New("already swiped on this profile today")
+	ErrBlocked           = errors.New("user is blocked")
+	ErrNoBoostCredits    = errors.New("no boosts left")
+	ErrBoostActive       = errors.New("a boost is already active")
+	ErrInvalidVisibility = errors.New("visibility must be everyone, liked_only or hidden")
+	ErrPremiumRequired   = errors.New("premium is required")
+	// ErrDeletionNotScheduled is returned when cancelling, or carrying out, a
+	// deletion the user hasn't asked for or has cancelled
+	ErrDeletionNotScheduled = errors.New("account deletion is not scheduled")
+)
+
+const (
+	// DailySwipeQuota is how many swipes a user gets per rolling day before
+	// extra swipes are drawn down
+	DailySwipeQuota = 10
+	// BoostDuration is how long a boost ranks its owner first in feeds
+	BoostDuration = 30 * time.Minute
+	// OnlineWindow is how recently a user must have been seen to be online.
+	// Open real-time connections refresh it every PresenceHeartbeat.
+	OnlineWindow      = 2 * time.Minute
+	PresenceHeartbeat = time.Minute
+	// RecentlyActiveWindow is how recently an offline user must have been seen
+	// to count as recently active
+	RecentlyActiveWindow = 24 * time.Hour
+	// DeletionCoolingOff is how long after asking for it an account is
+	// erased; until then the user can change their mind
+	DeletionCoolingOff = 14 * 24 * time.Hour
 )
 
 // UserService interface
 type UserService interface {
 	Signup(user models.User) error
 	Login(username string) (*models.User, error)
-	Swipe(userID, targetID, action string) error
+	// Swipe records a swipe and returns the match it made, if any. Swipes
+	// between users who blocked one another are rejected.
+	Swipe(userID, targetID, action string) (*models.Match, error)
+	SwipeBatch(userID string, swipes []models.BatchSwipe) ([]models.BatchSwipeResult, error)
 	PurchasePremium(userID string) error
-	RemoveSwipeQuota(userID string) error
-	AddVerifiedLabel(userID string) error
+	RevokePremium(userID string) error
+	// AddExtraSwipes credits swipes that are used once the daily quota is
+	// exhausted. A negative count takes back unused swipes.
+	AddExtraSwipes(userID string, count int) error
+	// AddBoostCredits credits boosts; a negative count takes back unused ones
+	AddBoostCredits(userID string, count int) error
+	// ActivateBoost spends a boost credit to start a boost at now
+	ActivateBoost(userID string, now time.Time) (models.Boost, error)
+	GetBalances(userID string, now time.Time) (models.Balances, error)
+	GetVisibility(userID string) (string, error)
+	// SetVisibility changes who can see the user. Incognito (liked_only)
+	// needs premium, and losing premium makes the user visible again.
+	SetVisibility(userID, visibility string) error
+	// RecordSeen marks the user as seen at now and reports whether they share
+	// their presence
+	RecordSeen(userID string, now time.Time) (bool, error)
+	GetPresenceVisible(userID string) (bool, error)
+	SetPresenceVisible(userID string, visible bool) error
 	ValidateUser(username, password string) (models.User, error)
+	// ScheduleDeletion schedules the user's account to be erased at deleteAt
+	// and returns when it will be; asking again keeps the earlier date.
+	// Accounts due for deletion are hidden from other users.
+	ScheduleDeletion(userID string, deleteAt time.Time) (time.Time, error)
+	CancelDeletion(userID string) error
+	// DueDeletions returns up to limit users whose deletion is due as of now
+	DueDeletions(now time.Time, limit int) ([]string, error)
+	// Delete erases a user whose deletion is due as of now. Their personal
+	// rows are deleted, while purchases, promo redemptions and the reports
+	// they filed are kept without the link to them. It returns the blob keys
+	// of the user's files, which the caller deletes.
+	Delete(userID string, now time.Time) ([]string, error)
+}
+
+// PresenceStatus is the presence of a user last seen at lastSeen
+func PresenceStatus(lastSeen, now time.Time) string {
+	switch since := now.Sub(lastSeen); {
+	case since < OnlineWindow:
+		return models.PresenceOnline
+	case since < RecentlyActiveWindow:
+		return models.PresenceRecentlyActive
+	default:
+		return models.PresenceOffline
+	}
 }
@@ -3,9 +3,11 @@ package service
 import (
 	"database/sql"
 	"dating-app/models"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -28,57 +30,350 @@ func (s *UserServiceImpl) Login(username string) (*models.User, error) {
 	return &user, nil
 }
 
-func (s *UserServiceImpl) Swipe(userID, targetID, action string) error {
+func (s *UserServiceImpl) Swipe(userID, targetID, action string) (*models.Match, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	match, err := swipe(tx, userID, targetID, action, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return match, tx.Commit()
+}
+
+// SwipeBatch replays swipes queued by an offline client in order. Each item
+// runs in its own transaction together with its idempotency key, so an item
+// is either fully applied or not at all, and a replayed key returns the
+// outcome stored the first time instead of counting against the quota again.
+func (s *UserServiceImpl) SwipeBatch(userID string, swipes []models.BatchSwipe) ([]models.BatchSwipeResult, error) {
+	results := make([]models.BatchSwipeResult, 0, len(swipes))
+	for _, item := range swipes {
+		result, err := s.swipeOnce(userID, item)
+		if err != nil {
+			result = models.BatchSwipeResult{
+				IdempotencyKey: item.IdempotencyKey,
+				Status:         models.SwipeStatusFailed,
+				Error:          "Database error",
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (s *UserServiceImpl) swipeOnce(userID string, item models.BatchSwipe) (models.BatchSwipeResult, error) {
+	result := models.BatchSwipeResult{IdempotencyKey: item.IdempotencyKey}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	// Claim the key first; a concurrent replay of the same key blocks here
+	// until this transaction finishes and then sees the stored outcome.
+	res, err := tx.Exec("INSERT INTO swipe_batch_keys (user_id, idempotency_key, status) VALUES ($1, $2, $3) ON CONFLICT (user_id, idempotency_key) DO NOTHING",
+		userID, item.IdempotencyKey, models.SwipeStatusRecorded)
+	if err != nil {
+		return result, err
+	}
+	if claimed, err := res.RowsAffected(); err != nil {
+		return result, err
+	} else if claimed == 0 {
+		var errMsg sql.NullString
+		err := tx.QueryRow("SELECT status, error FROM swipe_batch_keys WHERE user_id=$1 AND idempotency_key=$2", userID, item.IdempotencyKey).Scan(&result.Status, &errMsg)
+		if err != nil {
+			return result, err
+		}
+		result.Error = errMsg.String
+		result.Replayed = true
+		return result, nil
+	}
+
+	// Clients may have a skewed clock; never record a swipe in the future
+	at := item.Timestamp
+	if at.IsZero() || at.After(time.Now()) {
+		at = time.Now()
+	}
+
+	result.Status = models.SwipeStatusRecorded
+	match, err := swipe(tx, userID, item.TargetID, item.Action, at)
+	result.Match = match
+	if err != nil {
+		if !errors.Is(err, ErrDailySwipeLimit) && !errors.Is(err, ErrAlreadySwiped) && !errors.Is(err, ErrBlocked) {
+			return result, err
+		}
+		// Rejections are final, so store them and make replays agree
+		result.Status = models.SwipeStatusRejected
+		result.Error = err.Error()
+		_, err = tx.Exec("UPDATE swipe_batch_keys SET status=$1, error=$2 WHERE user_id=$3 AND idempotency_key=$4",
+			result.Status, result.Error, userID, item.IdempotencyKey)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, tx.Commit()
+}
+
+// swipe applies the quota rules and records a swipe made at the given time,
+// returning the match it made, if any. The user row is locked so concurrent
+// swipes cannot both pass the quota check.
+func swipe(tx *sql.Tx, userID, targetID, action string, at time.Time) (*models.Match, error) {
 	var user models.User
-	err := s.DB.QueryRow("SELECT swipes, last_swipe FROM users WHERE id=$1", userID).Scan(&user.Swipes, &user.LastSwipe)
+	err := tx.QueryRow("SELECT swipes, extra_swipes, last_swipe FROM users WHERE id=$1 FOR UPDATE", userID).Scan(&user.Swipes, &user.ExtraSwipes, &user.LastSwipe)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Check if the user has swiped more than 10 times today
-	if user.Swipes >= 10 && user.LastSwipe.After(time.Now().AddDate(0, 0, -1)) {
-		return fmt.Errorf("daily swipe limit reached")
+	// Blocked users can't reach each other
+	isBlocked, err := blocked(tx, userID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if isBlocked {
+		return nil, ErrBlocked
+	}
+
+	// Check if the user has used up today's quota. Once it is used up, extra
+	// swipes are drawn down instead.
+	swipesToday := swipesInDay(user, at)
+	useExtra := false
+	if swipesToday >= DailySwipeQuota {
+		if user.ExtraSwipes == 0 {
+			return nil, ErrDailySwipeLimit
+		}
+		useExtra = true
 	}
 
-	// Check if the user has already swiped on the target profile today
+	// Check if the user has already swiped on the target profile that day
 	var count int
-	err = s.DB.QueryRow("SELECT COUNT(*) FROM swipes WHERE user_id=$1 AND target_id=$2 AND DATE(created_at)=CURRENT_DATE", userID, targetID).Scan(&count)
+	err = tx.QueryRow("SELECT COUNT(*) FROM swipes WHERE user_id=$1 AND target_id=$2 AND DATE(created_at)=DATE($3)", userID, targetID, at).Scan(&count)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if count > 0 {
-		return fmt.Errorf("already swiped on this profile today")
+		return nil, ErrAlreadySwiped
 	}
 
 	// Update the user's swipe count and last swipe time
-	_, err = s.DB.Exec("UPDATE users SET swipes=swipes+1, last_swipe=$1 WHERE id=$2", time.Now(), userID)
+	if useExtra {
+		_, err = tx.Exec("UPDATE users SET extra_swipes=extra_swipes-1, last_swipe=GREATEST(last_swipe, $1) WHERE id=$2", at, userID)
+	} else {
+		_, err = tx.Exec("UPDATE users SET swipes=$1, last_swipe=GREATEST(last_swipe, $2) WHERE id=$3", swipesToday+1, at, userID)
+	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Record the swipe action (left or right). Batched swipes carry the
+	// client's time, so when the server got them is kept apart for scoring.
+	_, err = tx.Exec("INSERT INTO swipes (user_id, target_id, action, created_at, received_at) VALUES ($1, $2, $3, $4, NOW())", userID, targetID, action, at)
+	if err != nil || action != "right" {
+		return nil, err
+	}
+
+	// A right swipe back makes a match, unless either user is shadow-banned:
+	// their swipes are recorded like any other but never reach anyone
+	var liked bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM swipes WHERE user_id=$1 AND target_id=$2 AND action='right')
+		AND NOT EXISTS (SELECT 1 FROM users WHERE id IN ($1, $2) AND shadow_banned)`, targetID, userID).Scan(&liked)
+	if err != nil || !liked {
+		return nil, err
+	}
+	userA, userB := MatchPair(userID, targetID)
+	match, err := scanMatch(tx.QueryRow("INSERT INTO matches (user_a, user_b, created_at) VALUES ($1, $2, $3) ON CONFLICT (user_a, user_b) DO NOTHING RETURNING "+matchColumns,
+		userA, userB, at))
+	if errors.Is(err, sql.ErrNoRows) {
+		// They had already matched
+		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+	return &match, nil
+}
+
+// swipesInDay returns how many swipes of the daily quota a user has made as
+// of the given time. The count starts over once a day has passed since the
+// last swipe.
+func swipesInDay(user models.User, at time.Time) int {
+	if !user.LastSwipe.After(at.AddDate(0, 0, -1)) {
+		return 0
+	}
+	return user.Swipes
+}
+
+// execer is a *sql.DB or a *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// userEntitlements is an EntitlementStore writing through db, so the purchase
+// ledger can grant and revoke in the transaction that changes the purchase
+type userEntitlements struct {
+	db execer
+}
 
-	// Record the swipe action (left or right)
-	_, err = s.DB.Exec("INSERT INTO swipes (user_id, target_id, action) VALUES ($1, $2, $3)", userID, targetID, action)
+func (u userEntitlements) PurchasePremium(userID string) error {
+	_, err := u.db.Exec("UPDATE users SET premium=true WHERE id=$1", userID)
 	return err
 }
 
-func (s *UserServiceImpl) PurchasePremium(userID string) error {
-	_, err := s.DB.Exec("UPDATE users SET premium=true WHERE id=$1", userID)
+func (u userEntitlements) RevokePremium(userID string) error {
+	// Incognito is a premium feature, so the user becomes visible again
+	_, err := u.db.Exec(`UPDATE users SET premium=false,
+		visibility=CASE WHEN visibility=$2 THEN $3 ELSE visibility END WHERE id=$1`,
+		userID, models.VisibilityLikedOnly, models.VisibilityEveryone)
 	return err
 }
 
-func (s *UserServiceImpl) RemoveSwipeQuota(userID string) error {
-	_, err := s.DB.Exec("UPDATE users SET swipes=0 WHERE id=$1", userID)
+func (u userEntitlements) AddExtraSwipes(userID string, count int) error {
+	_, err := u.db.Exec("UPDATE users SET extra_swipes=GREATEST(extra_swipes+$1, 0) WHERE id=$2", count, userID)
 	return err
 }
 
-func (s *UserServiceImpl) AddVerifiedLabel(userID string) error {
-	_, err := s.DB.Exec("UPDATE users SET verified=true WHERE id=$1", userID)
+func (u userEntitlements) AddBoostCredits(userID string, count int) error {
+	_, err := u.db.Exec("UPDATE users SET boost_credits=GREATEST(boost_credits+$1, 0) WHERE id=$2", count, userID)
+	return err
+}
+
+func (s *UserServiceImpl) PurchasePremium(userID string) error {
+	return userEntitlements{s.DB}.PurchasePremium(userID)
+}
+
+func (s *UserServiceImpl) RevokePremium(userID string) error {
+	return userEntitlements{s.DB}.RevokePremium(userID)
+}
+
+func (s *UserServiceImpl) AddExtraSwipes(userID string, count int) error {
+	return userEntitlements{s.DB}.AddExtraSwipes(userID, count)
+}
+
+func (s *UserServiceImpl) AddBoostCredits(userID string, count int) error {
+	return userEntitlements{s.DB}.AddBoostCredits(userID, count)
+}
+
+func (s *UserServiceImpl) ActivateBoost(userID string, now time.Time) (models.Boost, error) {
+	boost := models.Boost{UserID: userID, StartedAt: now, EndsAt: now.Add(BoostDuration)}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return boost, err
+	}
+	defer tx.Rollback()
+
+	// Lock the user so concurrent activations can't spend the same credit
+	var credits int
+	err = tx.QueryRow("SELECT boost_credits FROM users WHERE id=$1 FOR UPDATE", userID).Scan(&credits)
+	if err != nil {
+		return boost, err
+	}
+
+	var active bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM boosts WHERE user_id=$1 AND ends_at > $2)", userID, now).Scan(&active)
+	if err != nil {
+		return boost, err
+	}
+	if active {
+		return boost, ErrBoostActive
+	}
+	if credits == 0 {
+		return boost, ErrNoBoostCredits
+	}
+
+	_, err = tx.Exec("UPDATE users SET boost_credits=boost_credits-1 WHERE id=$1", userID)
+	if err != nil {
+		return boost, err
+	}
+
+	err = tx.QueryRow("INSERT INTO boosts (user_id, started_at, ends_at) VALUES ($1, $2, $3) RETURNING id", userID, boost.StartedAt, boost.EndsAt).Scan(&boost.ID)
+	if err != nil {
+		return boost, err
+	}
+
+	return boost, tx.Commit()
+}
+
+func (s *UserServiceImpl) GetBalances(userID string, now time.Time) (models.Balances, error) {
+	var balances models.Balances
+	var user models.User
+	var boostEnd sql.NullTime
+	err := s.DB.QueryRow("SELECT swipes, last_swipe, extra_swipes, boost_credits, (SELECT MAX(ends_at) FROM boosts WHERE user_id=users.id AND ends_at > $2) FROM users WHERE id=$1", userID, now).
+		Scan(&user.Swipes, &user.LastSwipe, &balances.ExtraSwipes, &balances.BoostCredits, &boostEnd)
+	if err != nil {
+		return balances, err
+	}
+
+	balances.SwipesLeftToday = DailySwipeQuota - swipesInDay(user, now)
+	if balances.SwipesLeftToday < 0 {
+		balances.SwipesLeftToday = 0
+	}
+	if boostEnd.Valid {
+		balances.BoostActiveUntil = &boostEnd.Time
+	}
+	return balances, nil
+}
+
+func (s *UserServiceImpl) GetVisibility(userID string) (string, error) {
+	var visibility string
+	err := s.DB.QueryRow("SELECT visibility FROM users WHERE id=$1", userID).Scan(&visibility)
+	return visibility, err
+}
+
+func (s *UserServiceImpl) SetVisibility(userID, visibility string) error {
+	switch visibility {
+	case models.VisibilityEveryone, models.VisibilityHidden:
+		_, err := s.DB.Exec("UPDATE users SET visibility=$1 WHERE id=$2", visibility, userID)
+		return err
+	case models.VisibilityLikedOnly:
+		// Checked in the same statement so a concurrent RevokePremium can't
+		// leave a non-premium user incognito
+		result, err := s.DB.Exec("UPDATE users SET visibility=$1 WHERE id=$2 AND premium", visibility, userID)
+		if err != nil {
+			return err
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return ErrPremiumRequired
+		}
+		return nil
+	default:
+		return ErrInvalidVisibility
+	}
+}
+
+func (s *UserServiceImpl) RecordSeen(userID string, now time.Time) (bool, error) {
+	var visible bool
+	err := s.DB.QueryRow("UPDATE users SET last_seen_at=GREATEST(last_seen_at, $1) WHERE id=$2 RETURNING show_presence", now, userID).Scan(&visible)
+	return visible, err
+}
+
+func (s *UserServiceImpl) GetPresenceVisible(userID string) (bool, error) {
+	var visible bool
+	err := s.DB.QueryRow("SELECT show_presence FROM users WHERE id=$1", userID).Scan(&visible)
+	return visible, err
+}
+
+func (s *UserServiceImpl) SetPresenceVisible(userID string, visible bool) error {
+	_, err := s.DB.Exec("UPDATE users SET show_presence=$1 WHERE id=$2", visible, userID)
 	return err
 }
 
 func (s *UserServiceImpl) ValidateUser(username, password string) (models.User, error) {
 	var user models.User
-	err := s.DB.QueryRow("SELECT id, password FROM users WHERE username=$1", username).Scan(&user.ID, &user.Password)
+	var suspendedUntil sql.NullTime
+	err := s.DB.QueryRow("SELECT id, password, roles, status, suspended_until, token_version FROM users WHERE username=$1", username).Scan(
+		&user.ID, &user.Password, pq.Array(&user.Roles), &user.Status, &suspendedUntil, &user.TokenVersion)
+	if suspendedUntil.Valid {
+		user.SuspendedUntil = &suspendedUntil.Time
+	}
 	if err != nil {
 		return user, fmt.Errorf("user not found")
 	}
@@ -90,3 +385,134 @@ func (s *UserServiceImpl) ValidateUser(username, password string) (models.User,
 
 	return user, nil
 }
+
+func (s *UserServiceImpl) ScheduleDeletion(userID string, deleteAt time.Time) (time.Time, error) {
+	err := s.DB.QueryRow("UPDATE users SET deletion_scheduled_at=COALESCE(deletion_scheduled_at, $1) WHERE id=$2 RETURNING deletion_scheduled_at",
+		deleteAt, userID).Scan(&deleteAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return deleteAt, ErrUserNotFound
+	}
+	return deleteAt, err
+}
+
+func (s *UserServiceImpl) CancelDeletion(userID string) error {
+	res, err := s.DB.Exec("UPDATE users SET deletion_scheduled_at=NULL WHERE id=$1 AND deletion_scheduled_at IS NOT NULL", userID)
+	if err != nil {
+		return err
+	}
+	if updated, err := res.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return ErrDeletionNotScheduled
+	}
+	return nil
+}
+
+func (s *UserServiceImpl) DueDeletions(now time.Time, limit int) ([]string, error) {
+	rows, err := s.DB.Query("SELECT id FROM users WHERE deletion_scheduled_at <= $1 ORDER BY deletion_scheduled_at LIMIT $2", now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := []string{}
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// erasure deletes or anonymizes a user's rows, children before parents. Each
+// statement takes the user ID as $1.
+var erasure = []string{
+	"DELETE FROM push_deliveries WHERE device_id IN (SELECT id FROM devices WHERE user_id=$1)",
+	"DELETE FROM devices WHERE user_id=$1",
+	"DELETE FROM notification_preferences WHERE user_id=$1",
+	"DELETE FROM messages WHERE match_id IN (SELECT id FROM matches WHERE $1 IN (user_a, user_b))",
+	"DELETE FROM matches WHERE $1 IN (user_a, user_b)",
+	"DELETE FROM swipes WHERE user_id=$1 OR target_id=$1",
+	"DELETE FROM swipe_batch_keys WHERE user_id=$1",
+	"DELETE FROM blocks WHERE user_id=$1 OR blocked_id=$1",
+	// Reports the user filed stay with the moderators
+	"DELETE FROM reports WHERE reported_id=$1",
+	"UPDATE reports SET reporter_id=NULL WHERE reporter_id=$1",
+	"DELETE FROM appeals WHERE user_id=$1",
+	"DELETE FROM exports WHERE user_id=$1",
+	"DELETE FROM verifications WHERE user_id=$1",
+	"DELETE FROM photos WHERE user_id=$1",
+	"DELETE FROM profile_prompts WHERE user_id=$1",
+	"DELETE FROM profiles WHERE user_id=$1",
+	"DELETE FROM boosts WHERE user_id=$1",
+	"DELETE FROM subscriptions WHERE user_id=$1",
+	// Purchases are kept for accounting, without the link to the user
+	"UPDATE purchases SET user_id=NULL WHERE user_id=$1",
+	"UPDATE promo_redemptions SET user_id=NULL WHERE user_id=$1",
+	// Idempotency scopes end with the user ID
+	"DELETE FROM idempotency_keys WHERE scope LIKE '% ' || $1",
+	"DELETE FROM users WHERE id=$1",
+}
+
+func (s *UserServiceImpl) Delete(userID string, now time.Time) ([]string, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// A cancellation racing the worker either lands first or waits for the
+	// erasure to finish
+	var deleteAt sql.NullTime
+	err = tx.QueryRow("SELECT deletion_scheduled_at FROM users WHERE id=$1 FOR UPDATE", userID).Scan(&deleteAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !deleteAt.Valid || deleteAt.Time.After(now) {
+		return nil, ErrDeletionNotScheduled
+	}
+
+	keys, err := userBlobKeys(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, statement := range erasure {
+		if _, err := tx.Exec(statement, userID); err != nil {
+			return nil, err
+		}
+	}
+	return keys, tx.Commit()
+}
+
+// userBlobKeys lists the blob keys of the user's photos, selfies and exports
+func userBlobKeys(tx *sql.Tx, userID string) ([]string, error) {
+	rows, err := tx.Query(`SELECT storage_key, TRUE FROM photos WHERE user_id=$1
+		UNION ALL SELECT selfie_key, FALSE FROM verifications WHERE user_id=$1 AND selfie_key <> ''
+		UNION ALL SELECT storage_key, FALSE FROM exports WHERE user_id=$1 AND storage_key <> ''`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		var photo bool
+		if err := rows.Scan(&key, &photo); err != nil {
+			return nil, err
+		}
+		if !photo {
+			keys = append(keys, key)
+			continue
+		}
+		for _, size := range photoSizes {
+			keys = append(keys, PhotoBlobKey(key, size.name))
+		}
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"crypto/rand"
+	"dating-app/models"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+)
+
+var (
+	ErrVerificationNotFound    = errors.New("verification not found")
+	ErrVerificationPending     = errors.New("a selfie is already awaiting review")
+	ErrNoVerificationChallenge = errors.New("no open verification challenge, request a new pose")
+	ErrVerificationNotPending  = errors.New("verification is not awaiting review")
+	ErrNoPhotos                = errors.New("add a photo before verifying")
+)
+
+const (
+	// VerificationChallengeTTL is how long a user has to take the selfie
+	// after being given a pose
+	VerificationChallengeTTL = 10 * time.Minute
+	// VerificationValidity is how long the verified badge lasts before the
+	// user has to verify again
+	VerificationValidity = 365 * 24 * time.Hour
+	// VerificationKeyPrefix starts the blob keys of selfies, which are only
+	// served to moderators
+	VerificationKeyPrefix = "verifications/"
+)
+
+// verificationPoses are the poses a selfie can be requested in. A pose picked
+// at random by the server shows the selfie was taken for this verification
+// rather than found elsewhere.
+var verificationPoses = map[string]string{
+	"thumbs_up":        "Give a thumbs up next to your face",
+	"peace_sign":       "Make a peace sign next to your face",
+	"hand_on_head":     "Put your hand flat on top of your head",
+	"touch_ear":        "Touch your left ear with your right hand",
+	"three_fingers":    "Hold up three fingers under your chin",
+	"palm_on_forehead": "Put your palm on your forehead",
+}
+
+// VerificationService interface
+type VerificationService interface {
+	// StartVerification gives the user a random pose to take a selfie in,
+	// replacing any challenge they have not answered yet
+	StartVerification(userID string, now time.Time) (models.Verification, error)
+	// SubmitSelfie answers the user's open challenge and queues the selfie for
+	// review together with the photos it is to be compared against
+	SubmitSelfie(userID string, selfie []byte, now time.Time) (models.Verification, error)
+	// GetVerification returns the user's latest verification
+	GetVerification(userID string) (models.Verification, error)
+	// ListPending returns the review queue, oldest submission first
+	ListPending() ([]models.Verification, error)
+	// GetPending returns a verification awaiting review, the only ones that
+	// still have a selfie
+	GetPending(verificationID string) (models.Verification, error)
+	// Approve and Reject decide a pending verification and audit the
+	// decision in the same transaction
+	Approve(verificationID, moderator string, now time.Time) (models.Verification, error)
+	Reject(verificationID, moderator, reason string, now time.Time) (models.Verification, error)
+	IsVerified(userID string, now time.Time) (bool, error)
+	// RevokeOnPhotoChange revokes the user's badge if MajorPhotoChange says
+	// their photos no longer show the person that was verified. It returns the
+	// revoked verification and whether a badge was revoked.
+	RevokeOnPhotoChange(userID string, photos []models.Photo, now time.Time) (models.Verification, bool, error)
+}
+
+// RandomPose picks a pose for a verification challenge
+func RandomPose() string {
+	poses := make([]string, 0, len(verificationPoses))
+	for pose := range verificationPoses {
+		poses = append(poses, pose)
+	}
+	sort.Strings(poses)
+
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(poses))))
+	if err != nil {
+		return poses[0]
+	}
+	return poses[n.Int64()]
+}
+
+// PoseInstruction describes a pose to the user
+func PoseInstruction(pose string) string {
+	return verificationPoses[pose]
+}
+
+// MajorPhotoChange tells whether the current photos have drifted too far from
+// the ones a verification was reviewed against: the main photo is one the
+// moderator never saw, or fewer than half of the reviewed photos are left.
+// Adding photos or reordering reviewed ones is not a major change.
+func MajorPhotoChange(reviewed []string, photos []models.Photo) bool {
+	if len(photos) == 0 {
+		return true
+	}
+
+	wasReviewed := make(map[string]bool, len(reviewed))
+	for _, id := range reviewed {
+		wasReviewed[id] = true
+	}
+	if !wasReviewed[photos[0].ID] {
+		return true
+	}
+
+	kept := 0
+	for _, photo := range photos {
+		if wasReviewed[photo.ID] {
+			kept++
+		}
+	}
+	return kept*2 < len(reviewed)
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"bytes"
+	"database/sql"
+	"dating-app/models"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// VerificationServiceImpl struct implementing VerificationService
+type VerificationServiceImpl struct {
+	DB    *sql.DB
+	Blobs BlobStore
+}
+
+const verificationColumns = "id, user_id, pose, status, selfie_key, photo_ids, rejection_reason, reviewed_by, challenge_expires_at, badge_expires_at, created_at, submitted_at, reviewed_at"
+
+func (s *VerificationServiceImpl) scanVerification(row rowScanner) (models.Verification, error) {
+	var verification models.Verification
+	var badgeExpiresAt, submittedAt, reviewedAt sql.NullTime
+	err := row.Scan(&verification.ID, &verification.UserID, &verification.Pose, &verification.Status, &verification.SelfieKey,
+		pq.Array(&verification.PhotoIDs), &verification.RejectionReason, &verification.ReviewedBy, &verification.ChallengeExpiresAt,
+		&badgeExpiresAt, &verification.CreatedAt, &submittedAt, &reviewedAt)
+	if badgeExpiresAt.Valid {
+		verification.BadgeExpiresAt = &badgeExpiresAt.Time
+	}
+	if submittedAt.Valid {
+		verification.SubmittedAt = &submittedAt.Time
+	}
+	if reviewedAt.Valid {
+		verification.ReviewedAt = &reviewedAt.Time
+	}
+	verification.PoseInstruction = PoseInstruction(verification.Pose)
+	if verification.SelfieKey != "" {
+		verification.SelfieURL = "/admin/verifications/" + verification.ID + "/selfie"
+	}
+	return verification, err
+}
+
+func (s *VerificationServiceImpl) StartVerification(userID string, now time.Time) (models.Verification, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.Verification{}, err
+	}
+	defer tx.Rollback()
+
+	// Lock the user so a challenge can't be issued while a selfie is being submitted
+	if _, err := tx.Exec("SELECT id FROM users WHERE id=$1 FOR UPDATE", userID); err != nil {
+		return models.Verification{}, err
+	}
+
+	var pending bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM verifications WHERE user_id=$1 AND status=$2)", userID, models.VerificationStatusPending).Scan(&pending)
+	if err != nil {
+		return models.Verification{}, err
+	}
+	if pending {
+		return models.Verification{}, ErrVerificationPending
+	}
+
+	_, err = tx.Exec("DELETE FROM verifications WHERE user_id=$1 AND status=$2", userID, models.VerificationStatusAwaitingSelfie)
+	if err != nil {
+		return models.Verification{}, err
+	}
+
+	verification, err := s.scanVerification(tx.QueryRow("INSERT INTO verifications (user_id, pose, status, challenge_expires_at, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING "+verificationColumns,
+		userID, RandomPose(), models.VerificationStatusAwaitingSelfie, now.Add(VerificationChallengeTTL), now))
+	if err != nil {
+		return verification, err
+	}
+	return verification, tx.Commit()
+}
+
+func (s *VerificationServiceImpl) SubmitSelfie(userID string, selfie []byte, now time.Time) (models.Verification, error) {
+	processed, err := ProcessPhoto(selfie)
+	if err != nil {
+		return models.Verification{}, err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.Verification{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT id FROM users WHERE id=$1 FOR UPDATE", userID); err != nil {
+		return models.Verification{}, err
+	}
+
+	var verificationID string
+	err = tx.QueryRow("SELECT id FROM verifications WHERE user_id=$1 AND status=$2 AND challenge_expires_at > $3",
+		userID, models.VerificationStatusAwaitingSelfie, now).Scan(&verificationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Verification{}, ErrNoVerificationChallenge
+	}
+	if err != nil {
+		return models.Verification{}, err
+	}
+
+	var photoIDs []string
+	err = tx.QueryRow("SELECT COALESCE(array_agg(id::text ORDER BY position), '{}') FROM photos WHERE user_id=$1", userID).Scan(pq.Array(&photoIDs))
+	if err != nil {
+		return models.Verification{}, err
+	}
+	if len(photoIDs) == 0 {
+		return models.Verification{}, ErrNoPhotos
+	}
+
+	selfieKey := VerificationKeyPrefix + randomHex(16) + ".jpg"
+	if err := s.Blobs.Put(selfieKey, "image/jpeg", bytes.NewReader(processed.Sizes[models.PhotoSizeLarge])); err != nil {
+		return models.Verification{}, err
+	}
+
+	verification, err := s.scanVerification(tx.QueryRow("UPDATE verifications SET status=$1, selfie_key=$2, photo_ids=$3, submitted_at=$4 WHERE id=$5 RETURNING "+verificationColumns,
+		models.VerificationStatusPending, selfieKey, pq.Array(photoIDs), now, verificationID))
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		s.deleteSelfie(selfieKey)
+	}
+	return verification, err
+}
+
+func (s *VerificationServiceImpl) GetVerification(userID string) (models.Verification, error) {
+	verification, err := s.scanVerification(s.DB.QueryRow("SELECT "+verificationColumns+" FROM verifications WHERE user_id=$1 ORDER BY created_at DESC LIMIT 1", userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return verification, ErrVerificationNotFound
+	}
+	return verification, err
+}
+
+func (s *VerificationServiceImpl) ListPending() ([]models.Verification, error) {
+	rows, err := s.DB.Query("SELECT "+verificationColumns+" FROM verifications WHERE status=$1 ORDER BY submitted_at", models.VerificationStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	verifications := []models.Verification{}
+	for rows.Next() {
+		verification, err := s.scanVerification(rows)
+		if err != nil {
+			return nil, err
+		}
+		verifications = append(verifications, verification)
+	}
+	return verifications, rows.Err()
+}
+
+func (s *VerificationServiceImpl) GetPending(verificationID string) (models.Verification, error) {
+	verification, err := s.scanVerification(s.DB.QueryRow("SELECT "+verificationColumns+" FROM verifications WHERE id=$1 AND status=$2",
+		verificationID, models.VerificationStatusPending))
+	if errors.Is(err, sql.ErrNoRows) {
+		return verification, ErrVerificationNotFound
+	}
+	return verification, err
+}
+
+func (s *VerificationServiceImpl) Approve(verificationID, moderator string, now time.Time) (models.Verification, error) {
+	return s.review(verificationID, models.VerificationStatusApproved, moderator, "", now)
+}
+
+func (s *VerificationServiceImpl) Reject(verificationID, moderator, reason string, now time.Time) (models.Verification, error) {
+	return s.review(verificationID, models.VerificationStatusRejected, moderator, reason, now)
+}
+
+// review records a moderator's decision on a pending verification. The selfie
+// is deleted once decided; only the outcome is kept.
+func (s *VerificationServiceImpl) review(verificationID, status, moderator, reason string, now time.Time) (models.Verification, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.Verification{}, err
+	}
+	defer tx.Rollback()
+
+	var current, selfieKey string
+	err = tx.QueryRow("SELECT status, selfie_key FROM verifications WHERE id=$1 FOR UPDATE", verificationID).Scan(&current, &selfieKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Verification{}, ErrVerificationNotFound
+	}
+	if err != nil {
+		return models.Verification{}, err
+	}
+	if current != models.VerificationStatusPending {
+		return models.Verification{}, ErrVerificationNotPending
+	}
+
+	var badgeExpiresAt interface{}
+	if status == models.VerificationStatusApproved {
+		badgeExpiresAt = now.Add(VerificationValidity)
+	}
+	verification, err := s.scanVerification(tx.QueryRow("UPDATE verifications SET status=$1, reviewed_by=$2, rejection_reason=$3, reviewed_at=$4, badge_expires_at=$5, selfie_key='' WHERE id=$6 RETURNING "+verificationColumns,
+		status, moderator, reason, now, badgeExpiresAt, verificationID))
+	if err != nil {
+		return verification, err
+	}
+
+	action := models.AuditActionVerificationApproved
+	details := map[string]string{"userID": verification.UserID}
+	if status == models.VerificationStatusRejected {
+		action = models.AuditActionVerificationRejected
+		details["reason"] = reason
+	}
+	err = recordAudit(tx, models.AuditEntry{
+		Actor:      moderator,
+		Action:     action,
+		TargetType: "verification",
+		TargetID:   verification.ID,
+		Details:    details,
+	})
+	if err != nil {
+		return verification, err
+	}
+	if err := tx.Commit(); err != nil {
+		return verification, err
+	}
+
+	s.deleteSelfie(selfieKey)
+	return verification, nil
+}
+
+func (s *VerificationServiceImpl) IsVerified(userID string, now time.Time) (bool, error) {
+	var verified bool
+	err := s.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM verifications WHERE user_id=$1 AND status=$2 AND badge_expires_at > $3)",
+		userID, models.VerificationStatusApproved, now).Scan(&verified)
+	return verified, err
+}
+
+func (s *VerificationServiceImpl) RevokeOnPhotoChange(userID string, photos []models.Photo, now time.Time) (models.Verification, bool, error) {
+	verification, err := s.scanVerification(s.DB.QueryRow("SELECT "+verificationColumns+" FROM verifications WHERE user_id=$1 AND status=$2 AND badge_expires_at > $3 ORDER BY reviewed_at DESC LIMIT 1",
+		userID, models.VerificationStatusApproved, now))
+	if errors.Is(err, sql.ErrNoRows) {
+		return verification, false, nil
+	}
+	if err != nil || !MajorPhotoChange(verification.PhotoIDs, photos) {
+		return verification, false, err
+	}
+
+	// Earlier badges vouched for the same or older photos, so they go too
+	_, err = s.DB.Exec("UPDATE verifications SET status=$1 WHERE user_id=$2 AND status=$3", models.VerificationStatusRevoked, userID, models.VerificationStatusApproved)
+	if err != nil {
+		return verification, false, err
+	}
+	verification.Status = models.VerificationStatusRevoked
+	return verification, true, nil
+}
+
+// deleteSelfie removes a selfie that is no longer needed. Failures are only
+// logged, like for photos.
+func (s *VerificationServiceImpl) deleteSelfie(key string) {
+	if key == "" {
+		return
+	}
+	if err := s.Blobs.Delete(key); err != nil {
+		log.Printf("failed to delete blob %s: %v", key, err)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// sqlScript is a database/sql driver for testing the SQL of the service
+// implementations without a database. Every statement, transaction boundaries
+// included, has to match the next expected one: the query must contain the
+// expected fragment and, when arguments are given, be run with them.
+type sqlScript struct {
+	t        *testing.T
+	mu       sync.Mutex
+	expected []*sqlStep
+}
+
+type sqlStep struct {
+	kind     string
+	fragment string
+	args     []interface{}
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+	err      error
+}
+
+// anyArg stands in for an argument whose value the test doesn't check
+type anyArgument struct{}
+
+var anyArg = anyArgument{}
+
+// newSQLScript returns a DB running against a script the test fills in. The
+// test fails if any expected statement is left over at the end.
+func newSQLScript(t *testing.T) (*sql.DB, *sqlScript) {
+	script := &sqlScript{t: t}
+	db := sql.OpenDB(script)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		db.Close()
+		script.mu.Lock()
+		defer script.mu.Unlock()
+		for _, step := range script.expected {
+			t.Errorf("expected %s %q was not run", step.kind, step.fragment)
+		}
+	})
+	return db, script
+}
+
+func (s *sqlScript) add(step *sqlStep) *sqlStep {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expected = append(s.expected, step)
+	return step
+}
+
+func (s *sqlScript) begin()    { s.add(&sqlStep{kind: "BEGIN"}) }
+func (s *sqlScript) commit()   { s.add(&sqlStep{kind: "COMMIT"}) }
+func (s *sqlScript) rollback() { s.add(&sqlStep{kind: "ROLLBACK"}) }
+
+// exec expects a statement that returns no rows
+func (s *sqlScript) exec(fragment string, affected int64, args ...interface{}) *sqlStep {
+	return s.add(&sqlStep{kind: "exec", fragment: fragment, affected: affected, args: args})
+}
+
+// query expects a statement returning rows with the given columns
+func (s *sqlScript) query(fragment string, columns []string, rows [][]driver.Value, args ...interface{}) *sqlStep {
+	return s.add(&sqlStep{kind: "query", fragment: fragment, columns: columns, rows: rows, args: args})
+}
+
+// fails makes the statement return err
+func (step *sqlStep) fails(err error) {
+	step.err = err
+}
+
+func (s *sqlScript) next(kind, query string, args []driver.NamedValue) (*sqlStep, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.expected) == 0 {
+		s.t.Errorf("unexpected %s %q", kind, query)
+		return nil, fmt.Errorf("unexpected %s", kind)
+	}
+	step := s.expected[0]
+	s.expected = s.expected[1:]
+
+	if kind != step.kind {
+		s.t.Errorf("got %s %q, want %s %q", kind, query, step.kind, step.fragment)
+		return nil, fmt.Errorf("unexpected %s", kind)
+	}
+	if !strings.Contains(strings.Join(strings.Fields(query), " "), strings.Join(strings.Fields(step.fragment), " ")) {
+		s.t.Errorf("got %s %q, want one containing %q", kind, query, step.fragment)
+		return nil, fmt.Errorf("unexpected query")
+	}
+	if step.args != nil {
+		got := make([]driver.Value, len(args))
+		for i, arg := range args {
+			got[i] = arg.Value
+		}
+		want := make([]driver.Value, len(step.args))
+		for i, arg := range step.args {
+			if arg == anyArg && i < len(got) {
+				want[i] = got[i]
+				continue
+			}
+			value, err := driver.DefaultParameterConverter.ConvertValue(arg)
+			if err != nil {
+				s.t.Fatal(err)
+			}
+			want[i] = value
+		}
+		if !reflect.DeepEqual(got, want) {
+			s.t.Errorf("%q run with %v, want %v", step.fragment, got, want)
+		}
+	}
+	return step, step.err
+}
+
+func (s *sqlScript) Connect(context.Context) (driver.Conn, error) { return &scriptConn{s}, nil }
+func (s *sqlScript) Driver() driver.Driver                        { return nil }
+
+type scriptConn struct {
+	script *sqlScript
+}
+
+func (c *scriptConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepared statements are not scripted")
+}
+
+func (c *scriptConn) Close() error { return nil }
+
+func (c *scriptConn) Begin() (driver.Tx, error) {
+	if _, err := c.script.next("BEGIN", "BEGIN", nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *scriptConn) Commit() error {
+	_, err := c.script.next("COMMIT", "COMMIT", nil)
+	return err
+}
+
+func (c *scriptConn) Rollback() error {
+	_, err := c.script.next("ROLLBACK", "ROLLBACK", nil)
+	return err
+}
+
+func (c *scriptConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	step, err := c.script.next("exec", query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(step.affected), nil
+}
+
+func (c *scriptConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	step, err := c.script.next("query", query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &scriptRows{columns: step.columns, rows: step.rows}, nil
+}
+
+// CheckNamedValue passes arguments such as pq arrays through the default
+// conversion
+func (c *scriptConn) CheckNamedValue(arg *driver.NamedValue) error {
+	value, err := driver.DefaultParameterConverter.ConvertValue(arg.Value)
+	arg.Value = value
+	return err
+}
+
+type scriptRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *scriptRows) Columns() []string { return r.columns }
+func (r *scriptRows) Close() error      { return nil }
+
+func (r *scriptRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
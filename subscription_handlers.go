@@ -0,0 +1,73 @@
+package main
+
+import (
+	"dating-app/models"
+	"dating-app/service"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// @Summary Current subscription
+// @Description Returns the authenticated user's active or in-grace subscription
+// @Tags Subscriptions
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} models.Subscription
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /subscription [get]
+func GetSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	subscription, err := subscriptionService.GetSubscription(authenticatedUserID(r))
+	writeSubscription(w, subscription, err)
+}
+
+// @Summary Cancel subscription
+// @Description Turns off auto-renew. The subscription stays active until the end of the current period.
+// @Tags Subscriptions
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} models.Subscription
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /subscription/cancel [post]
+func CancelSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	subscription, err := subscriptionService.Cancel(authenticatedUserID(r))
+	writeSubscription(w, subscription, err)
+}
+
+// @Summary Resume subscription
+// @Description Turns auto-renew back on for a subscription canceled at period end
+// @Tags Subscriptions
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} models.Subscription
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /subscription/resume [post]
+func ResumeSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	subscription, err := subscriptionService.Resume(authenticatedUserID(r))
+	writeSubscription(w, subscription, err)
+}
+
+func writeSubscription(w http.ResponseWriter, subscription models.Subscription, err error) {
+	if errors.Is(err, service.ErrSubscriptionNotFound) {
+		http.Error(w, `{"error": "No active subscription"}`, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, service.ErrStoreManaged) {
+		http.Error(w, `{"error": "Subscription is managed by the app store"}`, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(subscription)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dating-app/models"
+	"dating-app/service"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockSubscriptionService struct {
+	mock.Mock
+}
+
+func (m *MockSubscriptionService) GetSubscription(userID string) (models.Subscription, error) {
+	args := m.Called(userID)
+	return args.Get(0).(models.Subscription), args.Error(1)
+}
+
+func (m *MockSubscriptionService) Activate(userID string, product models.Product, now time.Time) (models.Subscription, error) {
+	args := m.Called(userID, product, now)
+	return args.Get(0).(models.Subscription), args.Error(1)
+}
+
+func (m *MockSubscriptionService) Extend(userID string, product models.Product, days int, now time.Time) (models.Subscription, error) {
+	args := m.Called(userID, product, days, now)
+	return args.Get(0).(models.Subscription), args.Error(1)
+}
+
+func (m *MockSubscriptionService) ActivateStore(userID string, product models.Product, transaction models.StoreTransaction) (models.Subscription, error) {
+	args := m.Called(userID, product, transaction)
+	return args.Get(0).(models.Subscription), args.Error(1)
+}
+
+func (m *MockSubscriptionService) GetStoreSubscription(store, originalTransactionID string) (models.Subscription, error) {
+	args := m.Called(store, originalTransactionID)
+	return args.Get(0).(models.Subscription), args.Error(1)
+}
+
+func (m *MockSubscriptionService) UpdateStoreSubscription(subscriptionID, status string, graceUntil *time.Time, autoRenew bool) error {
+	args := m.Called(subscriptionID, status, graceUntil, autoRenew)
+	return args.Error(0)
+}
+
+func (m *MockSubscriptionService) Cancel(userID string) (models.Subscription, error) {
+	args := m.Called(userID)
+	return args.Get(0).(models.Subscription), args.Error(1)
+}
+
+func (m *MockSubscriptionService) Resume(userID string) (models.Subscription, error) {
+	args := m.Called(userID)
+	return args.Get(0).(models.Subscription), args.Error(1)
+}
+
+func (m *MockSubscriptionService) DueForRenewal(now time.Time, limit int) ([]models.Subscription, error) {
+	args := m.Called(now, limit)
+	return args.Get(0).([]models.Subscription), args.Error(1)
+}
+
+func (m *MockSubscriptionService) Lapsed(now time.Time) ([]models.Subscription, error) {
+	args := m.Called(now)
+	return args.Get(0).([]models.Subscription), args.Error(1)
+}
+
+func (m *MockSubscriptionService) ExpireLapsed(subscriptionID string, now time.Time) (bool, error) {
+	args := m.Called(subscriptionID, now)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockSubscriptionService) MarkExpired(subscriptionID string) error {
+	args := m.Called(subscriptionID)
+	return args.Error(0)
+}
+
+func TestSubscriptionHandlers(t *testing.T) {
+	periodEnd := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	subscription := models.Subscription{ID: "sub1", UserID: "user1", SKU: "premium_monthly", Status: models.SubscriptionStatusActive, AutoRenew: true, CurrentPeriodEnd: periodEnd}
+	canceled := subscription
+	canceled.AutoRenew = false
+
+	tests := []struct {
+		name           string
+		method         string
+		handler        http.HandlerFunc
+		mockReturn     models.Subscription
+		mockErr        error
+		expectedStatus int
+	}{
+		{
+			name:           "Get current subscription",
+			method:         "GetSubscription",
+			handler:        GetSubscriptionHandler,
+			mockReturn:     subscription,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "No subscription",
+			method:         "GetSubscription",
+			handler:        GetSubscriptionHandler,
+			mockErr:        service.ErrSubscriptionNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Cancel at period end",
+			method:         "Cancel",
+			handler:        CancelSubscriptionHandler,
+			mockReturn:     canceled,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSubscriptionService := new(MockSubscriptionService)
+			subscriptionService = mockSubscriptionService
+			mockSubscriptionService.On(tt.method, "user1").Return(tt.mockReturn, tt.mockErr)
+
+			rr := httptest.NewRecorder()
+			handler := authenticate(tt.handler)
+			handler.ServeHTTP(rr, authorizedRequest(t, "GET", "/subscription", "user1", nil))
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			if tt.expectedStatus == http.StatusOK {
+				var responseBody models.Subscription
+				if err := json.NewDecoder(rr.Body).Decode(&responseBody); err != nil {
+					t.Fatal(err)
+				}
+				if responseBody.ID != tt.mockReturn.ID || responseBody.AutoRenew != tt.mockReturn.AutoRenew || !responseBody.CurrentPeriodEnd.Equal(periodEnd) {
+					t.Errorf("handler returned unexpected body: got %v want %v", responseBody, tt.mockReturn)
+				}
+			}
+
+			mockSubscriptionService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSubscriptionWorker(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	premium := models.Product{SKU: "premium_monthly", Price: 999, Currency: "USD", Entitlements: []string{models.EntitlementPremium}, DurationDays: 30}
+	due := models.Subscription{ID: "sub1", UserID: "user1", SKU: premium.SKU, Status: models.SubscriptionStatusActive, AutoRenew: true, CurrentPeriodEnd: now.Add(-time.Hour)}
+	lapsed := models.Subscription{ID: "sub2", UserID: "user2", SKU: premium.SKU, Status: models.SubscriptionStatusGrace, CurrentPeriodEnd: now.Add(-service.GracePeriod)}
+
+	mockPurchaseService := new(MockPurchaseService)
+	mockSubscriptionService := new(MockSubscriptionService)
+
+	mockSubscriptionService.On("DueForRenewal", now, mock.AnythingOfType("int")).Return([]models.Subscription{due}, nil)
+	mockPurchaseService.On("GetProduct", premium.SKU).Return(premium, nil)
+	mockPurchaseService.On("CreatePurchase", "user1", premium, (*models.PromoCode)(nil)).Return(models.Purchase{ID: "purchase1", UserID: "user1", Amount: 999, Currency: "USD"}, nil)
+	mockPurchaseService.On("SetPaymentIntent", "purchase1", mock.AnythingOfType("string")).Return(nil)
+
+	mockSubscriptionService.On("Lapsed", now).Return([]models.Subscription{lapsed}, nil)
+	mockSubscriptionService.On("ExpireLapsed", "sub2", now).Return(true, nil)
+
+	worker := &service.SubscriptionWorker{
+		Subscriptions: mockSubscriptionService,
+		Purchases:     mockPurchaseService,
+		Gateway:       &service.FakePaymentGateway{DeclineCaptures: true},
+	}
+	worker.RunOnce(now)
+
+	mockPurchaseService.AssertExpectations(t)
+	mockSubscriptionService.AssertExpectations(t)
+}
+
+func TestSubscriptionWorkerGatewayDown(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	premium := models.Product{SKU: "premium_monthly", Price: 999, Currency: "USD", Entitlements: []string{models.EntitlementPremium}, DurationDays: 30}
+	due := models.Subscription{ID: "sub1", UserID: "user1", SKU: premium.SKU, Status: models.SubscriptionStatusGrace, AutoRenew: true, CurrentPeriodEnd: now.Add(-time.Hour)}
+
+	mockPurchaseService := new(MockPurchaseService)
+	mockSubscriptionService := new(MockSubscriptionService)
+
+	mockSubscriptionService.On("DueForRenewal", now, mock.AnythingOfType("int")).Return([]models.Subscription{due}, nil)
+	mockPurchaseService.On("GetProduct", premium.SKU).Return(premium, nil)
+	mockPurchaseService.On("CreatePurchase", "user1", premium, (*models.PromoCode)(nil)).Return(models.Purchase{ID: "purchase1", UserID: "user1", Amount: 999, Currency: "USD"}, nil)
+	// The order can't be paid, so it isn't left pending
+	mockPurchaseService.On("UpdatePurchaseStatus", "purchase1", models.PurchaseStatusFailed).Return(nil)
+	mockSubscriptionService.On("Lapsed", now).Return([]models.Subscription{}, nil)
+
+	worker := &service.SubscriptionWorker{
+		Subscriptions: mockSubscriptionService,
+		Purchases:     mockPurchaseService,
+		Gateway:       &service.FakePaymentGateway{Unavailable: true},
+	}
+	worker.RunOnce(now)
+
+	mockPurchaseService.AssertExpectations(t)
+	mockSubscriptionService.AssertExpectations(t)
+}
+
+func TestDueForRenewal(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	subscriptionColumns := []string{"id", "user_id", "sku", "status", "auto_renew", "current_period_start", "current_period_end", "grace_until", "canceled_at", "store"}
+	graceUntil := now.Add(-time.Hour).Add(service.GracePeriod)
+
+	db, script := newSQLScript(t)
+	script.query("FOR UPDATE SKIP LOCKED", subscriptionColumns,
+		[][]driver.Value{{"sub1", "user1", "premium_monthly", models.SubscriptionStatusGrace, true, now.AddDate(0, 0, -30), now.Add(-time.Hour), graceUntil, nil, ""}},
+		now, 100, models.SubscriptionStatusGrace, int64(service.GracePeriod/time.Second), now.Add(service.RenewalRetryInterval))
+
+	subscriptions := &service.SubscriptionServiceImpl{DB: db}
+	due, err := subscriptions.DueForRenewal(now, 100)
+	if err != nil || len(due) != 1 || due[0].ID != "sub1" || due[0].Status != models.SubscriptionStatusGrace {
+		t.Errorf("DueForRenewal() = %+v, %v", due, err)
+	}
+}
+
+func TestExpireLapsed(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	subscriptionColumns := []string{"id", "user_id", "sku", "status", "auto_renew", "current_period_start", "current_period_end", "grace_until", "canceled_at", "store"}
+	premium := models.Product{SKU: "premium_monthly", Price: 999, Currency: "USD", Entitlements: []string{models.EntitlementPremium}, DurationDays: 30}
+	graceUntil := now.Add(-time.Minute)
+
+	tests := []struct {
+		name    string
+		row     []driver.Value
+		expired bool
+	}{
+		{
+			name:    "Grace period over",
+			row:     []driver.Value{"sub1", "user1", premium.SKU, models.SubscriptionStatusGrace, true, now.AddDate(0, 0, -33), now.Add(-service.GracePeriod), graceUntil, nil, ""},
+			expired: true,
+		},
+		{
+			// Lapsed listed it, then the retried renewal was paid before the lock was taken
+			name: "Renewed since it was listed",
+			row:  []driver.Value{"sub1", "user1", premium.SKU, models.SubscriptionStatusActive, true, now.Add(-time.Minute), now.AddDate(0, 0, 30), nil, nil, ""},
+		},
+		{
+			name: "Already expired",
+			row:  []driver.Value{"sub1", "user1", premium.SKU, models.SubscriptionStatusExpired, true, now.AddDate(0, 0, -33), now.Add(-service.GracePeriod), graceUntil, nil, ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, script := newSQLScript(t)
+			script.begin()
+			script.query("FROM subscriptions WHERE id=$1 FOR UPDATE", subscriptionColumns, [][]driver.Value{tt.row}, "sub1")
+			if tt.expired {
+				script.query("FROM products WHERE sku=$1", productRowColumns, [][]driver.Value{productRow(premium)}, premium.SKU)
+				script.exec("UPDATE subscriptions SET status=$1", 1, models.SubscriptionStatusExpired, "sub1")
+				script.exec("UPDATE users SET premium=false", 1, "user1", anyArg, anyArg)
+				script.commit()
+			} else {
+				script.rollback()
+			}
+
+			subscriptions := &service.SubscriptionServiceImpl{DB: db}
+			expired, err := subscriptions.ExpireLapsed("sub1", now)
+			if err != nil || expired != tt.expired {
+				t.Errorf("ExpireLapsed() = %v, %v, want %v", expired, err, tt.expired)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"dating-app/models"
+	"dating-app/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// @Summary Start verification
+// @Description Gives the authenticated user a random pose to take a verification selfie in. The selfie must be submitted within 10 minutes; asking again replaces the pose.
+// @Tags Verification
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 201 {object} models.Verification
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /verification/challenge [post]
+func StartVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	verification, err := verificationService.StartVerification(authenticatedUserID(r), time.Now())
+	if errors.Is(err, service.ErrVerificationPending) {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(verification)
+}
+
+// @Summary Submit verification selfie
+// @Description Submits a selfie taken in the pose from /verification/challenge as the "selfie" field of a multipart/form-data body. It is queued for a moderator to compare with the user's photos, so the user needs at least one photo. The same image rules as for photos apply.
+// @Tags Verification
+// @Accept  mpfd
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param selfie formData file true "Selfie"
+// @Success 202 {object} models.Verification
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /verification/selfie [post]
+func SubmitSelfieHandler(w http.ResponseWriter, r *http.Request) {
+	data, ok := readUpload(w, r, "selfie")
+	if !ok {
+		return
+	}
+
+	verification, err := verificationService.SubmitSelfie(authenticatedUserID(r), data, time.Now())
+	switch {
+	case errors.Is(err, service.ErrInvalidPhoto):
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrNoVerificationChallenge), errors.Is(err, service.ErrNoPhotos):
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(verification)
+}
+
+// @Summary Verification status
+// @Description Returns the authenticated user's latest verification: the open pose challenge, the selfie awaiting review, or the outcome. An approved verification carries the badge until badgeExpiresAt.
+// @Tags Verification
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} models.Verification
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /verification [get]
+func GetVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	verification, err := verificationService.GetVerification(authenticatedUserID(r))
+	if errors.Is(err, service.ErrVerificationNotFound) {
+		http.Error(w, `{"error": "No verification yet"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(verification)
+}
+
+// @Summary Verification review queue
+// @Description Returns the selfies awaiting review, oldest first, each with the requested pose and the user's photos it was submitted against
+// @Tags Verification
+// @Produce  json
+// @Param Authorization header string true "Bearer token of a moderator"
+// @Success 200 {object} map[string][]models.Verification
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/verifications [get]
+func ListVerificationsHandler(w http.ResponseWriter, r *http.Request) {
+	verifications, err := verificationService.ListPending()
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	for i, verification := range verifications {
+		photos, err := photoService.ListPhotos(verification.UserID)
+		if err != nil {
+			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+			return
+		}
+		verifications[i].Photos = submittedPhotos(verification, photos)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]models.Verification{"verifications": verifications})
+}
+
+// @Summary Verification selfie
+// @Description Returns the selfie of a verification awaiting review. Selfies are only served here, to moderators, and are not cached.
+// @Tags Verification
+// @Produce  image/jpeg
+// @Param Authorization header string true "Bearer token of a moderator"
+// @Param id path string true "Verification ID"
+// @Success 200 {file} file
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/verifications/{id}/selfie [get]
+func VerificationSelfieHandler(w http.ResponseWriter, r *http.Request) {
+	verification, err := verificationService.GetPending(mux.Vars(r)["id"])
+	if errors.Is(err, service.ErrVerificationNotFound) {
+		http.Error(w, `{"error": "Verification not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	selfie, err := blobStore.Get(verification.SelfieKey)
+	if errors.Is(err, service.ErrBlobNotFound) {
+		http.Error(w, `{"error": "Verification not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Storage error"}`, http.StatusInternalServerError)
+		return
+	}
+	defer selfie.Close()
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-store")
+	io.Copy(w, selfie)
+}
+
+// submittedPhotos picks the photos a selfie was submitted against that the
+// user still has
+func submittedPhotos(verification models.Verification, photos []models.Photo) []models.Photo {
+	submitted := make(map[string]bool, len(verification.PhotoIDs))
+	for _, id := range verification.PhotoIDs {
+		submitted[id] = true
+	}
+	kept := []models.Photo{}
+	for _, photo := range photos {
+		if submitted[photo.ID] {
+			kept = append(kept, photo)
+		}
+	}
+	return kept
+}
+
+// @Summary Review verification
+// @Description Approves or rejects a selfie awaiting review. Approval grants the verified badge for a year; a rejection needs a reason, which is shown to the user. The selfie is deleted either way.
+// @Tags Verification
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token of a moderator"
+// @Param Idempotency-Key header string false "Client-generated key that makes retries safe"
+// @Param id path string true "Verification ID"
+// @Param decision body string true "approve or reject"
+// @Param reason body string false "Reason for a rejection"
+// @Success 200 {object} models.Verification
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/verifications/{id}/review [post]
+func ReviewVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Decision string `json:"decision"`
+		Reason   string `json:"reason"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	now := time.Now()
+	var verification models.Verification
+	var err error
+	switch {
+	case request.Decision == "approve":
+		verification, err = verificationService.Approve(id, adminActor(r), now)
+	case request.Decision == "reject" && request.Reason != "":
+		verification, err = verificationService.Reject(id, adminActor(r), request.Reason, now)
+	default:
+		http.Error(w, `{"error": "decision must be approve, or reject with a reason"}`, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, service.ErrVerificationNotFound) {
+		http.Error(w, `{"error": "Verification not found"}`, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, service.ErrVerificationNotPending) {
+		http.Error(w, `{"error": "Verification has already been reviewed"}`, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// The photos may have changed while the selfie was in the queue
+	if verification.Status == models.VerificationStatusApproved {
+		revokeBadgeOnPhotoChange(verification.UserID)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(verification)
+}
+
+// revokeBadgeOnPhotoChange takes the verified badge away when the user's
+// photos no longer show the person a moderator compared the selfie with. It
+// runs once a photo change is saved, so failures are logged, not reported.
+func revokeBadgeOnPhotoChange(userID string) {
+	photos, err := photoService.ListPhotos(userID)
+	if err != nil {
+		log.Printf("failed to check the verified badge of %s: %v", userID, err)
+		return
+	}
+
+	verification, revoked, err := verificationService.RevokeOnPhotoChange(userID, photos, time.Now())
+	if err != nil {
+		log.Printf("failed to check the verified badge of %s: %v", userID, err)
+		return
+	}
+	if !revoked {
+		return
+	}
+
+	err = auditService.Record(models.AuditEntry{
+		Actor:      "system",
+		Action:     models.AuditActionVerificationRevoked,
+		TargetType: "verification",
+		TargetID:   verification.ID,
+		Details:    map[string]string{"userID": userID, "reason": "photos changed"},
+	})
+	if err != nil {
+		log.Printf("verification %s revoked but not audited: %v", verification.ID, err)
+	}
+}
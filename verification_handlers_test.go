@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dating-app/models"
+	"dating-app/service"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockVerificationService struct {
+	mock.Mock
+}
+
+func (m *MockVerificationService) StartVerification(userID string, now time.Time) (models.Verification, error) {
+	args := m.Called(userID, now)
+	return args.Get(0).(models.Verification), args.Error(1)
+}
+
+func (m *MockVerificationService) SubmitSelfie(userID string, selfie []byte, now time.Time) (models.Verification, error) {
+	args := m.Called(userID, selfie, now)
+	return args.Get(0).(models.Verification), args.Error(1)
+}
+
+func (m *MockVerificationService) GetVerification(userID string) (models.Verification, error) {
+	args := m.Called(userID)
+	return args.Get(0).(models.Verification), args.Error(1)
+}
+
+func (m *MockVerificationService) ListPending() ([]models.Verification, error) {
+	args := m.Called()
+	return args.Get(0).([]models.Verification), args.Error(1)
+}
+
+func (m *MockVerificationService) GetPending(verificationID string) (models.Verification, error) {
+	args := m.Called(verificationID)
+	return args.Get(0).(models.Verification), args.Error(1)
+}
+
+func (m *MockVerificationService) Approve(verificationID, moderator string, now time.Time) (models.Verification, error) {
+	args := m.Called(verificationID, moderator, now)
+	return args.Get(0).(models.Verification), args.Error(1)
+}
+
+func (m *MockVerificationService) Reject(verificationID, moderator, reason string, now time.Time) (models.Verification, error) {
+	args := m.Called(verificationID, moderator, reason, now)
+	return args.Get(0).(models.Verification), args.Error(1)
+}
+
+func (m *MockVerificationService) IsVerified(userID string, now time.Time) (bool, error) {
+	args := m.Called(userID, now)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockVerificationService) RevokeOnPhotoChange(userID string, photos []models.Photo, now time.Time) (models.Verification, bool, error) {
+	args := m.Called(userID, photos, now)
+	return args.Get(0).(models.Verification), args.Bool(1), args.Error(2)
+}
+
+func TestReviewVerificationHandler(t *testing.T) {
+	photos := []models.Photo{{ID: "1", UserID: "user1"}}
+	approved := models.Verification{ID: "v1", UserID: "user1", Status: models.VerificationStatusApproved, PhotoIDs: []string{"1"}}
+	rejected := models.Verification{ID: "v1", UserID: "user1", Status: models.VerificationStatusRejected, RejectionReason: "pose does not match"}
+
+	tests := []struct {
+		name           string
+		roles          []string
+		request        map[string]string
+		setup          func(*MockVerificationService, *MockPhotoService, *MockAuditService)
+		expectedStatus int
+	}{
+		{
+			name:    "Approval grants the badge",
+			roles:   []string{models.RoleModerator},
+			request: map[string]string{"decision": "approve"},
+			setup: func(verifications *MockVerificationService, photoService *MockPhotoService, audit *MockAuditService) {
+				verifications.On("Approve", "v1", "admin:staff1", mock.AnythingOfType("time.Time")).Return(approved, nil)
+				photoService.On("ListPhotos", "user1").Return(photos, nil)
+				verifications.On("RevokeOnPhotoChange", "user1", photos, mock.AnythingOfType("time.Time")).Return(approved, false, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:    "Rejection with a reason",
+			roles:   []string{models.RoleModerator},
+			request: map[string]string{"decision": "reject", "reason": "pose does not match"},
+			setup: func(verifications *MockVerificationService, photoService *MockPhotoService, audit *MockAuditService) {
+				verifications.On("Reject", "v1", "admin:staff1", "pose does not match", mock.AnythingOfType("time.Time")).Return(rejected, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Rejection without a reason",
+			roles:          []string{models.RoleModerator},
+			request:        map[string]string{"decision": "reject"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:    "Already reviewed",
+			roles:   []string{models.RoleModerator},
+			request: map[string]string{"decision": "approve"},
+			setup: func(verifications *MockVerificationService, photoService *MockPhotoService, audit *MockAuditService) {
+				verifications.On("Approve", "v1", "admin:staff1", mock.AnythingOfType("time.Time")).Return(models.Verification{}, service.ErrVerificationNotPending)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Not a moderator",
+			request:        map[string]string{"decision": "approve"},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockVerificationService := new(MockVerificationService)
+			verificationService = mockVerificationService
+			mockPhotoService := new(MockPhotoService)
+			photoService = mockPhotoService
+			mockAuditService := new(MockAuditService)
+			auditService = mockAuditService
+			if tt.setup != nil {
+				tt.setup(mockVerificationService, mockPhotoService, mockAuditService)
+			}
+
+			body, _ := json.Marshal(tt.request)
+			req := staffRequest(t, "POST", "/admin/verifications/v1/review", "staff1", tt.roles, body)
+			req = mux.SetURLVars(req, map[string]string{"id": "v1"})
+
+			rr := httptest.NewRecorder()
+			handler := authenticate(requireRole(models.RoleModerator, ReviewVerificationHandler))
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockVerificationService.AssertExpectations(t)
+			mockPhotoService.AssertExpectations(t)
+			mockAuditService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDeletePhotoRevokesBadge(t *testing.T) {
+	mockPhotoService := new(MockPhotoService)
+	photoService = mockPhotoService
+	mockVerificationService := new(MockVerificationService)
+	verificationService = mockVerificationService
+	mockAuditService := new(MockAuditService)
+	auditService = mockAuditService
+
+	remaining := []models.Photo{{ID: "3", UserID: "user1"}}
+	mockPhotoService.On("DeletePhoto", "user1", "1").Return(nil)
+	mockPhotoService.On("ListPhotos", "user1").Return(remaining, nil)
+	mockVerificationService.On("RevokeOnPhotoChange", "user1", remaining, mock.AnythingOfType("time.Time")).
+		Return(models.Verification{ID: "v1", UserID: "user1", Status: models.VerificationStatusRevoked}, true, nil)
+	mockAuditService.On("Record", mock.MatchedBy(func(entry models.AuditEntry) bool {
+		return entry.Actor == "system" && entry.Action == models.AuditActionVerificationRevoked && entry.TargetID == "v1"
+	})).Return(nil)
+
+	req := authorizedRequest(t, "DELETE", "/photos/1", "user1", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	rr := httptest.NewRecorder()
+	handler := authenticate(DeletePhotoHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	mockPhotoService.AssertExpectations(t)
+	mockVerificationService.AssertExpectations(t)
+	mockAuditService.AssertExpectations(t)
+}
+
+func TestMajorPhotoChange(t *testing.T) {
+	reviewed := []string{"1", "2", "3", "4"}
+
+	tests := []struct {
+		name     string
+		photos   []string
+		expected bool
+	}{
+		{name: "Unchanged", photos: []string{"1", "2", "3", "4"}, expected: false},
+		{name: "Reviewed photos reordered", photos: []string{"3", "1", "2", "4"}, expected: false},
+		{name: "Photos added after the main one", photos: []string{"1", "2", "3", "4", "5", "6"}, expected: false},
+		{name: "Half of the reviewed photos kept", photos: []string{"2", "4"}, expected: false},
+		{name: "New main photo", photos: []string{"5", "1", "2", "3", "4"}, expected: true},
+		{name: "Most reviewed photos deleted", photos: []string{"1", "5", "6"}, expected: true},
+		{name: "No photos left", photos: nil, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var photos []models.Photo
+			for _, id := range tt.photos {
+				photos = append(photos, models.Photo{ID: id})
+			}
+			if got := service.MajorPhotoChange(reviewed, photos); got != tt.expected {
+				t.Errorf("MajorPhotoChange returned %v want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestVerificationSelfieHandler(t *testing.T) {
+	blobStore = &service.LocalBlobStore{Dir: t.TempDir()}
+	if err := blobStore.Put("verifications/abc.jpg", "image/jpeg", strings.NewReader("selfie")); err != nil {
+		t.Fatal(err)
+	}
+	pending := models.Verification{ID: "v1", UserID: "user1", Status: models.VerificationStatusPending, SelfieKey: "verifications/abc.jpg"}
+
+	tests := []struct {
+		name           string
+		roles          []string
+		verification   models.Verification
+		serviceErr     error
+		expectedStatus int
+	}{
+		{"Moderator", []string{models.RoleModerator}, pending, nil, http.StatusOK},
+		{"Reviewed", []string{models.RoleModerator}, models.Verification{}, service.ErrVerificationNotFound, http.StatusNotFound},
+		{"Not staff", nil, pending, nil, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockVerificationService := new(MockVerificationService)
+			verificationService = mockVerificationService
+			if tt.roles != nil {
+				mockVerificationService.On("GetPending", "v1").Return(tt.verification, tt.serviceErr)
+			}
+
+			req := staffRequest(t, "GET", "/admin/verifications/v1/selfie", "staff1", tt.roles, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "v1"})
+
+			rr := httptest.NewRecorder()
+			handler := authenticate(requireRole(models.RoleModerator, VerificationSelfieHandler))
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			if tt.expectedStatus == http.StatusOK && (rr.Body.String() != "selfie" || rr.Header().Get("Cache-Control") != "no-store") {
+				t.Errorf("handler returned %q with Cache-Control %q", rr.Body.String(), rr.Header().Get("Cache-Control"))
+			}
+
+			mockVerificationService.AssertExpectations(t)
+		})
+	}
+
+	t.Run("Not served as media", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/media/verifications/abc.jpg", nil)
+		req = mux.SetURLVars(req, map[string]string{"key": "verifications/abc.jpg"})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(ServeBlobHandler).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+}
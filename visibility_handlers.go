@@ -0,0 +1,66 @@
+package main
+
+import (
+	"dating-app/service"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// @Summary Visibility
+// @Description Returns who can see the authenticated user: everyone, liked_only (incognito) or hidden
+// @Tags Visibility
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /settings/visibility [get]
+func GetVisibilityHandler(w http.ResponseWriter, r *http.Request) {
+	visibility, err := userService.GetVisibility(authenticatedUserID(r))
+	if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"visibility": visibility})
+}
+
+// @Summary Set visibility
+// @Description Changes who can see the authenticated user in feeds and in "who liked me". everyone shows the user to all; liked_only (incognito, premium only) shows them only to people they swiped right on; hidden pauses the profile. Incognito ends when premium does.
+// @Tags Visibility
+// @Accept  json
+// @Produce  json
+// @Param Authorization header string true "Bearer token"
+// @Param visibility body string true "everyone, liked_only or hidden"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /settings/visibility [put]
+func SetVisibilityHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Visibility string `json:"visibility"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	err := userService.SetVisibility(authenticatedUserID(r), request.Visibility)
+	switch {
+	case errors.Is(err, service.ErrInvalidVisibility):
+		http.Error(w, `{"error": "visibility must be everyone, liked_only or hidden"}`, http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrPremiumRequired):
+		http.Error(w, `{"error": "Incognito mode requires premium"}`, http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"visibility": request.Visibility})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"dating-app/models"
+	"dating-app/service"
+)
+
+func TestSetVisibilityHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		visibility     string
+		serviceErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "Pause profile",
+			visibility:     models.VisibilityHidden,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Incognito with premium",
+			visibility:     models.VisibilityLikedOnly,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Incognito without premium",
+			visibility:     models.VisibilityLikedOnly,
+			serviceErr:     service.ErrPremiumRequired,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Unknown visibility",
+			visibility:     "friends",
+			serviceErr:     service.ErrInvalidVisibility,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserService := new(MockUserService)
+			userService = mockUserService
+			mockUserService.On("SetVisibility", "user1", tt.visibility).Return(tt.serviceErr)
+
+			body, _ := json.Marshal(map[string]string{"visibility": tt.visibility})
+			rr := httptest.NewRecorder()
+			handler := authenticate(SetVisibilityHandler)
+			handler.ServeHTTP(rr, authorizedRequest(t, "PUT", "/settings/visibility", "user1", body))
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			mockUserService.AssertExpectations(t)
+		})
+	}
+}